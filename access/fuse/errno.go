@@ -0,0 +1,75 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"lifs_go/cas"
+	"sync/atomic"
+	"syscall"
+)
+
+// Stats are the counters of a filesystem since it was opened.
+type Stats struct {
+	Reads  uint64
+	Writes uint64
+	// ReadBytes and WriteBytes are the bytes returned by reads and
+	// taken by writes.
+	ReadBytes  uint64
+	WriteBytes uint64
+	// ShortReads counts reads that hit the end of the file before
+	// filling the buffer.
+	ShortReads uint64
+
+	// The errors returned, by cause. NotFound counts chunks missing
+	// from the store, reported as EIO; IOErrors the other causes of
+	// EIO.
+	NotFound    uint64
+	Interrupted uint64
+	NoSpace     uint64
+	IOErrors    uint64
+}
+
+type counters struct {
+	reads, writes         atomic.Uint64
+	readBytes, writeBytes atomic.Uint64
+	shortReads            atomic.Uint64
+
+	notFound, interrupted, noSpace, ioErrors atomic.Uint64
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		Reads:       c.reads.Load(),
+		Writes:      c.writes.Load(),
+		ReadBytes:   c.readBytes.Load(),
+		WriteBytes:  c.writeBytes.Load(),
+		ShortReads:  c.shortReads.Load(),
+		NotFound:    c.notFound.Load(),
+		Interrupted: c.interrupted.Load(),
+		NoSpace:     c.noSpace.Load(),
+		IOErrors:    c.ioErrors.Load(),
+	}
+}
+
+// errno returns the errno reporting err, and counts it. A chunk that is
+// missing is an I/O error of the filesystem, not a missing file, so it
+// is EIO rather than ENOENT.
+func (fs *filesystem) errno(err error) syscall.Errno {
+	var nf cas.NotFoundError
+	switch {
+	case err == nil:
+		return syscall.F_OK
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		fs.counters.interrupted.Add(1)
+		return syscall.EINTR
+	case errors.Is(err, syscall.ENOSPC):
+		fs.counters.noSpace.Add(1)
+		return syscall.ENOSPC
+	case errors.As(err, &nf):
+		fs.counters.notFound.Add(1)
+		return syscall.EIO
+	default:
+		fs.counters.ioErrors.Add(1)
+		return syscall.EIO
+	}
+}
@@ -2,46 +2,152 @@ package fuse
 
 import (
 	"context"
-	"fmt"
 	gofs "github.com/hanwen/go-fuse/v2/fs"
 	gofuse "github.com/hanwen/go-fuse/v2/fuse"
 	"io"
 	"lifs_go/cas/blobs"
-	"lifs_go/cas/store"
+	"lifs_go/cas/dirs"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// dirtyLimit bounds the unsaved data of each open file, so that large
+// writes do not keep the whole file in memory.
+const dirtyLimit = 64 << 20
+
 type File struct {
 	gofs.Inode
-	s    store.IF
+	fs     *filesystem
+	parent *Volume
+	// entry in the parent directory; its Manifest and Size are stale
+	// while dirty. Guarded by fs.mu.
+	entry dirs.Entry
+	// blob locks itself, so reads and writes do not take fs.mu
 	blob *blobs.Blob
+	// dirty is set by changes, and cleared when the file is saved
+	dirty atomic.Bool
+	// written is the time of the last change of the content in
+	// nanoseconds since the Unix epoch, zero when the entry has the
+	// mtime to keep
+	written atomic.Int64
 }
 
 func (f *File) Open(ctx context.Context, flags uint32) (fh gofs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	// the kernel truncates with Setattr first, unless it left O_TRUNC
+	// to the filesystem
+	if flags&syscall.O_TRUNC != 0 && f.blob.Size() > 0 {
+		if errno := f.truncate(ctx, 0); errno != syscall.F_OK {
+			return nil, 0, errno
+		}
+	}
 	return f, 0, syscall.F_OK
 }
 
 var _ gofs.NodeOpener = (*File)(nil)
 
-func (f *File) Getattr(ctx context.Context, f_ gofs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
-	fmt.Println("File.Getattr", f.blob.Size())
-	out.Mode = gofuse.S_IFREG
+// attr fills out with the attributes of the file. Must hold fs.mu.
+func (f *File) attr(out *gofuse.Attr) {
+	entryAttr(&f.entry, out)
 	out.Size = f.blob.Size()
+	if w := f.written.Load(); w != 0 {
+		t := time.Unix(0, w)
+		out.SetTimes(nil, &t, &t)
+	}
+}
+
+func (f *File) Getattr(ctx context.Context, f_ gofs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	f.attr(&out.Attr)
 	return syscall.F_OK
 }
 
 var _ gofs.NodeGetattrer = (*File)(nil)
 
+// truncate changes the size of the file.
+func (f *File) truncate(ctx context.Context, size uint64) syscall.Errno {
+	err := f.blob.Truncate(ctx, size)
+	f.modified()
+	return f.fs.errno(err)
+}
+
 func (f *File) Setattr(ctx context.Context, f_ gofs.FileHandle, in *gofuse.SetAttrIn, out *gofuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		if errno := f.truncate(ctx, size); errno != syscall.F_OK {
+			return errno
+		}
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if setAttr(&f.entry, in) {
+		if _, ok := in.GetMTime(); ok {
+			// keep the mtime set over that of earlier writes
+			f.written.Store(0)
+		}
+		f.dirty.Store(true)
+		f.parent.markDirty()
+	}
+	f.attr(&out.Attr)
 	return syscall.F_OK
 }
 
 var _ gofs.NodeSetattrer = (*File)(nil)
 
+// modified records a change of the content, and flags the file for
+// saving. It is called after the change, so that a save running
+// concurrently either includes the change or is followed by another.
+func (f *File) modified() {
+	f.written.Store(time.Now().UnixNano())
+	f.markDirty()
+}
+
+// markDirty flags the file and its directories for saving.
+func (f *File) markDirty() {
+	if f.dirty.Load() {
+		return
+	}
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	f.dirty.Store(true)
+	f.parent.markDirty()
+}
+
+// save saves the file if it changed, and reports whether it did. Must
+// hold fs.mu.
+func (f *File) save(ctx context.Context) (bool, error) {
+	// a write from here on marks the file again
+	if !f.dirty.Swap(false) {
+		return false, nil
+	}
+	w := f.written.Swap(0)
+	m, err := f.blob.Save(ctx)
+	if err != nil {
+		f.written.CompareAndSwap(0, w)
+		f.dirty.Store(true)
+		return false, err
+	}
+	f.entry.Manifest = *m
+	f.entry.Size = m.Size
+	if w != 0 {
+		t := time.Unix(0, w)
+		f.entry.Mtime = t
+		if t.After(f.entry.Ctime) {
+			f.entry.Ctime = t
+		}
+	}
+	return true, nil
+}
+
 func (f *File) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
 	n, err := f.blob.IO(ctx).WriteAt(data, off)
-	if err != nil {
-		return 0, syscall.EBADMSG
+	f.modified()
+	f.fs.counters.writes.Add(1)
+	f.fs.counters.writeBytes.Add(uint64(n))
+	// a short write is reported as such, the kernel sees the error
+	// when it writes the rest
+	if err != nil && n == 0 {
+		return 0, f.fs.errno(err)
 	}
 	return uint32(n), syscall.F_OK
 }
@@ -49,19 +155,45 @@ func (f *File) Write(ctx context.Context, data []byte, off int64) (written uint3
 var _ gofs.FileWriter = (*File)(nil)
 
 func (f *File) Read(ctx context.Context, dest []byte, off int64) (gofuse.ReadResult, syscall.Errno) {
-	_, err := f.blob.IO(ctx).ReadAt(dest, off)
+	n, err := f.blob.IO(ctx).ReadAt(dest, off)
+	f.fs.counters.reads.Add(1)
+	f.fs.counters.readBytes.Add(uint64(n))
 	if err != nil && err != io.EOF {
-		return nil, syscall.EBADMSG
+		return nil, f.fs.errno(err)
 	}
-	return gofuse.ReadResultData(dest), syscall.F_OK
+	if n < len(dest) {
+		f.fs.counters.shortReads.Add(1)
+	}
+	return gofuse.ReadResultData(dest[:n]), syscall.F_OK
 }
 
 var _ gofs.FileReader = (*File)(nil)
 
-func newFile(s store.IF, m *blobs.Manifest) *File {
-	b, _ := blobs.Open(s, m)
-	return &File{
-		s:    s,
-		blob: b,
+// Flush publishes the filesystem when a file is closed.
+func (f *File) Flush(ctx context.Context) syscall.Errno {
+	return f.fs.errno(f.fs.publish(ctx))
+}
+
+var _ gofs.FileFlusher = (*File)(nil)
+
+func (f *File) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return f.fs.errno(f.fs.publish(ctx))
+}
+
+var _ gofs.FileFsyncer = (*File)(nil)
+
+// newFile returns the node of a file entry. It fails if the manifest
+// of the entry is not usable.
+func newFile(fs *filesystem, parent *Volume, entry dirs.Entry) (*File, error) {
+	b, err := blobs.Open(fs.s, &entry.Manifest)
+	if err != nil {
+		return nil, err
 	}
+	b.SetDirtyLimit(dirtyLimit)
+	return &File{
+		fs:     fs,
+		parent: parent,
+		entry:  entry,
+		blob:   b,
+	}, nil
 }
@@ -1,17 +1,117 @@
 package fuse
 
 import (
+	"context"
+	"errors"
 	gofs "github.com/hanwen/go-fuse/v2/fs"
 	gofuse "github.com/hanwen/go-fuse/v2/fuse"
 	"lifs_go/access"
 	"lifs_go/cas/blobs"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
 	"lifs_go/cas/store"
+	"sync"
 )
 
+type Options struct {
+	// Refs makes the filesystem persistent: its tree is loaded from
+	// the tree ref Root, and published to it again on Flush, Fsync and
+	// unmount. It also adds a read-only .snapshots directory to the
+	// root holding the snapshots in the history of the snapshot ref
+	// Ref.
+	//
+	// Without Refs, the filesystem is gone at unmount.
+	Refs *snapshots.Refs
+	// Root defaults to "live".
+	Root string
+	// Ref defaults to "main".
+	Ref string
+}
+
 type Impl struct {
 	volume *Volume
 }
 
+// filesystem is the state shared by all nodes of a mount.
+type filesystem struct {
+	s store.IF
+	// the tree refs, when persistent
+	refs    *snapshots.Refs
+	rootRef string
+
+	// mu guards the directories, the entries of the nodes and the
+	// dirty flags
+	mu   sync.Mutex
+	root *Volume
+
+	// publishMu serializes publish, so that the root ref only moves
+	// forward; it is taken before mu
+	publishMu sync.Mutex
+	// the root directory as last published
+	published blobs.Manifest
+
+	counters counters
+}
+
+// publish saves every changed file and directory, and points the root
+// ref to the new tree. It does nothing for a filesystem that is not
+// persistent.
+//
+// Saving the changed nodes holds mu, blocking the other operations of
+// the mount meanwhile: it reads and updates the entries of the whole
+// tree, which must not change under it. Only the writes of the tree
+// and the ref are made without it.
+func (fs *filesystem) publish(ctx context.Context) error {
+	if fs.refs == nil {
+		return nil
+	}
+	fs.publishMu.Lock()
+	defer fs.publishMu.Unlock()
+	m, err := fs.saveRoot(ctx)
+	if err != nil {
+		return err
+	}
+	if m == fs.published {
+		return nil
+	}
+	tree, err := dirs.Open(fs.s, &m)
+	if err != nil {
+		return err
+	}
+	key, err := tree.Save(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fs.refs.Set(ctx, fs.rootRef, key); err != nil {
+		return err
+	}
+	fs.published = m
+	return nil
+}
+
+// saveRoot saves the changed nodes and returns the root directory.
+func (fs *filesystem) saveRoot(ctx context.Context) (blobs.Manifest, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.root.save(ctx); err != nil {
+		return blobs.Manifest{}, err
+	}
+	return fs.root.entry.Manifest, nil
+}
+
+// Publish saves all changes and publishes the new tree, as a Flush
+// does.
+func (i *Impl) Publish(ctx context.Context) error {
+	return i.volume.fs.publish(ctx)
+}
+
+func (i *Impl) Stats() Stats {
+	return i.volume.fs.counters.stats()
+}
+
+// Mount serves the filesystem on dir. The returned func unmounts it,
+// publishing the changes still unsaved; as it cannot report errors,
+// call Publish afterwards to check that they were.
 func (i *Impl) Mount(dir string) (func(), error) {
 	opts := gofs.Options{MountOptions: gofuse.MountOptions{Debug: false}}
 	c := make(chan *gofuse.Server, 1)
@@ -31,20 +131,69 @@ func (i *Impl) Mount(dir string) (func(), error) {
 	case server := <-c:
 		return func() {
 			_ = server.Unmount()
+			_ = i.Publish(context.Background())
 		}, nil
 	}
 }
 
-func open(store store.IF) *Volume {
-	return &Volume{
-		s:        store,
-		mkv:      make(map[string]*blobs.Manifest),
-		children: make(map[string]*gofs.Inode),
+// Open returns a filesystem keeping its files in store. A persistent
+// filesystem starts with the tree its root ref points to, or empty if
+// the ref is not set. Naming a ref of the wrong kind is an error.
+func Open(store store.IF, opts *Options) (*Impl, error) {
+	if opts == nil {
+		opts = &Options{}
 	}
+	root := dirs.Empty(store).RootEntry()
+	if opts.Refs != nil {
+		ctx := context.Background()
+		var nf snapshots.RefNotFoundError
+		if _, err := opts.Refs.Snapshots().Get(ctx, historyRef(opts)); err != nil && !errors.As(err, &nf) {
+			return nil, err
+		}
+		key, err := opts.Refs.Trees().Get(ctx, rootRef(opts))
+		switch {
+		case errors.As(err, &nf):
+		case err != nil:
+			return nil, err
+		default:
+			tree, err := dirs.Load(ctx, store, key)
+			if err != nil {
+				return nil, err
+			}
+			root.Manifest = *tree.Root()
+			root.Size = root.Manifest.Size
+		}
+	}
+	return newImpl(store, opts, root), nil
 }
 
-func New(store store.IF) access.IF {
-	return &Impl{
-		volume: open(store),
+func rootRef(opts *Options) string {
+	if opts.Root == "" {
+		return "live"
 	}
+	return opts.Root
+}
+
+func historyRef(opts *Options) string {
+	if opts.Ref == "" {
+		return "main"
+	}
+	return opts.Ref
+}
+
+// newImpl returns a filesystem with root as its root directory.
+func newImpl(store store.IF, opts *Options, root dirs.Entry) *Impl {
+	fs := &filesystem{s: store, published: root.Manifest}
+	fs.root = newVolume(fs, nil, root)
+	if opts.Refs != nil {
+		fs.refs = opts.Refs.Trees()
+		fs.rootRef = rootRef(opts)
+		fs.root.history = &snapshotsDir{fs: fs, refs: opts.Refs.Snapshots(), ref: historyRef(opts)}
+	}
+	return &Impl{volume: fs.root}
+}
+
+// New returns a filesystem keeping its files in store until unmount.
+func New(store store.IF) access.IF {
+	return newImpl(store, &Options{}, dirs.Empty(store).RootEntry())
 }
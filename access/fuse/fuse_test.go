@@ -2,12 +2,30 @@ package fuse_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
 	"io"
 	"lifs_go/access/fuse"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
+	"lifs_go/cas/store"
+	storekv "lifs_go/cas/store/kv"
 	"lifs_go/cas/store/mem"
+	kvmem "lifs_go/kv/mem"
 	"os"
 	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func MountInTemp(t *testing.T) (tmp string, cf func()) {
@@ -169,3 +187,787 @@ func TestReadAndWrite(t *testing.T) {
 		t.Fatalf("read content is not equal to write content")
 	}
 }
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	refs := snapshots.NewRefs(target)
+
+	// two snapshots of a tree
+	var parents []cas.Key
+	var times []time.Time
+	tree := dirs.Empty(chunkStore)
+	for i, content := range []string{"old", "new"} {
+		w, err := blobs.NewWriter(ctx, chunkStore, blobs.EmptyManifest(dirs.FileBlobType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		m := w.Manifest()
+		entry := dirs.Entry{Kind: dirs.KindFile, Mode: 0o644, Size: m.Size, Manifest: *m}
+		if tree, err = tree.Put(ctx, "/file", entry); err != nil {
+			t.Fatal(err)
+		}
+		s, err := snapshots.Take(ctx, tree, parents, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Time = time.Date(2026, 10, 1+i, 12, 0, 0, 0, time.UTC)
+		times = append(times, s.Time)
+		key, err := snapshots.Save(ctx, chunkStore, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parents = []cas.Key{key}
+	}
+	if err := refs.Set(ctx, "main", parents[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := fuse.Open(chunkStore, &fuse.Options{Refs: refs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, _ := os.MkdirTemp(os.TempDir(), "test-")
+	unmount, err := v.Mount(tmp)
+	if err != nil {
+		t.Fatalf("mount err: %v", err)
+	}
+	defer func() {
+		unmount()
+		_ = os.RemoveAll(tmp)
+	}()
+
+	snaps := path.Join(tmp, ".snapshots")
+	names, err := readDirNames(snaps)
+	if err != nil {
+		t.Fatalf("list snapshots error: %v", err)
+	}
+	want := []string{"2026-10-01T12:00:00Z", "2026-10-02T12:00:00Z"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("wrong snapshots: %v != %v", names, want)
+	}
+	for i, content := range []string{"old", "new"} {
+		data, err := os.ReadFile(path.Join(snaps, want[i], "file"))
+		if err != nil {
+			t.Fatalf("read snapshot error: %v", err)
+		}
+		if g, e := string(data), content; g != e {
+			t.Errorf("wrong content in snapshot %d: %q != %q", i, g, e)
+		}
+		stat, err := os.Stat(path.Join(snaps, want[i]))
+		if err != nil {
+			t.Fatalf("stat snapshot error: %v", err)
+		}
+		if g, e := stat.ModTime(), times[i]; !g.Equal(e) {
+			t.Errorf("wrong snapshot time: %v != %v", g, e)
+		}
+	}
+
+	// read-only
+	p := path.Join(snaps, want[0], "file")
+	if _, err := os.OpenFile(p, os.O_WRONLY, 0); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("open for write: expect EROFS, got %v", err)
+	}
+	if err := os.Remove(p); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("remove: expect EROFS, got %v", err)
+	}
+	if err := os.Mkdir(path.Join(snaps, want[0], "dir"), 0o755); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("mkdir: expect EROFS, got %v", err)
+	}
+	if err := os.WriteFile(path.Join(snaps, "new"), nil, 0o644); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("create: expect EROFS, got %v", err)
+	}
+
+	// hidden from the root
+	names, err = readDirNames(tmp)
+	if err != nil {
+		t.Fatalf("list root error: %v", err)
+	}
+	if len(names) > 0 {
+		t.Errorf("unexpected content in root: %v", names)
+	}
+}
+
+func readDirNames(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+func TestPersistent(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	refs := snapshots.NewRefs(target)
+	mount := func() (*fuse.Impl, string, func()) {
+		v, err := fuse.Open(chunkStore, &fuse.Options{Refs: refs})
+		if err != nil {
+			t.Fatalf("open err: %v", err)
+		}
+		tmp, _ := os.MkdirTemp(os.TempDir(), "test-")
+		unmount, err := v.Mount(tmp)
+		if err != nil {
+			t.Fatalf("mount err: %v", err)
+		}
+		return v, tmp, func() {
+			unmount()
+			_ = os.RemoveAll(tmp)
+		}
+	}
+
+	content := bytes.Repeat([]byte("persist"), 100000)
+	v, tmp, unmount := mount()
+	if err := os.MkdirAll(path.Join(tmp, "a", "b"), 0o750); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	if err := os.WriteFile(path.Join(tmp, "a", "b", "file"), content, 0o640); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	first, err := refs.Trees().Get(ctx, "live")
+	if err != nil {
+		t.Fatalf("no root published on close: %v", err)
+	}
+	// only published at unmount
+	if err := os.Mkdir(path.Join(tmp, "empty"), 0o700); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	unmount()
+	if err := v.Publish(ctx); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	if second, err := refs.Trees().Get(ctx, "live"); err != nil || second == first {
+		t.Errorf("no root published on unmount: %v", err)
+	}
+
+	_, tmp, unmount = mount()
+	defer unmount()
+	data, err := os.ReadFile(path.Join(tmp, "a", "b", "file"))
+	if err != nil {
+		t.Fatalf("read after remount error: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("wrong content after remount")
+	}
+	stat, err := os.Stat(path.Join(tmp, "a", "b", "file"))
+	if err != nil {
+		t.Fatalf("stat error: %v", err)
+	}
+	if g, e := stat.Mode().Perm(), os.FileMode(0o640); g != e {
+		t.Errorf("wrong mode after remount: %v != %v", g, e)
+	}
+	names, err := readDirNames(tmp)
+	if err != nil {
+		t.Fatalf("list root error: %v", err)
+	}
+	sort.Strings(names)
+	if g, e := names, []string{"a", "empty"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong root after remount: %v != %v", g, e)
+	}
+}
+
+func TestRefKinds(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	refs := snapshots.NewRefs(target)
+	key, err := dirs.Empty(chunkStore).Save(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := refs.Trees().Set(ctx, "live", key); err != nil {
+		t.Fatal(err)
+	}
+	if err := refs.Set(ctx, "main", key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fuse.Open(chunkStore, &fuse.Options{Refs: refs}); err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	var kind snapshots.RefKindError
+	if _, err := fuse.Open(chunkStore, &fuse.Options{Refs: refs, Root: "main"}); !errors.As(err, &kind) {
+		t.Errorf("snapshot ref opened as the tree: %v", err)
+	}
+	if _, err := fuse.Open(chunkStore, &fuse.Options{Refs: refs, Ref: "live"}); !errors.As(err, &kind) {
+		t.Errorf("tree ref opened as the history: %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tmp, cf := MountInTemp(t)
+	defer cf()
+
+	if err := os.MkdirAll(path.Join(tmp, "dir", "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	if err := os.WriteFile(path.Join(tmp, "dir", "file"), []byte("content"), 0o644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := syscall.Rmdir(path.Join(tmp, "dir")); err != syscall.ENOTEMPTY {
+		t.Errorf("rmdir of a full dir: %v != %v", err, syscall.ENOTEMPTY)
+	}
+	if err := syscall.Rmdir(path.Join(tmp, "dir", "file")); err != syscall.ENOTDIR {
+		t.Errorf("rmdir of a file: %v != %v", err, syscall.ENOTDIR)
+	}
+	if err := syscall.Unlink(path.Join(tmp, "dir", "sub")); err != syscall.EISDIR {
+		t.Errorf("unlink of a dir: %v != %v", err, syscall.EISDIR)
+	}
+	if err := syscall.Unlink(path.Join(tmp, "dir", "missing")); err != syscall.ENOENT {
+		t.Errorf("unlink of a missing file: %v != %v", err, syscall.ENOENT)
+	}
+
+	if err := os.Remove(path.Join(tmp, "dir", "file")); err != nil {
+		t.Fatalf("unlink error: %v", err)
+	}
+	if _, err := os.Stat(path.Join(tmp, "dir", "file")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file still there after unlink: %v", err)
+	}
+	if err := os.Remove(path.Join(tmp, "dir", "sub")); err != nil {
+		t.Fatalf("rmdir error: %v", err)
+	}
+	if err := os.Remove(path.Join(tmp, "dir")); err != nil {
+		t.Fatalf("rmdir of the emptied dir error: %v", err)
+	}
+	names, err := readDirNames(tmp)
+	if err != nil {
+		t.Fatalf("list root error: %v", err)
+	}
+	if len(names) > 0 {
+		t.Errorf("unexpected content in root: %v", names)
+	}
+
+	// the name can be used again
+	if err := os.WriteFile(path.Join(tmp, "dir"), []byte("again"), 0o644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if data, err := os.ReadFile(path.Join(tmp, "dir")); err != nil || string(data) != "again" {
+		t.Errorf("wrong content after reuse: %q, %v", data, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	refs := snapshots.NewRefs(target)
+	v, err := fuse.Open(chunkStore, &fuse.Options{Refs: refs})
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	tmp, _ := os.MkdirTemp(os.TempDir(), "test-")
+	unmount, err := v.Mount(tmp)
+	if err != nil {
+		t.Fatalf("mount err: %v", err)
+	}
+	defer func() {
+		unmount()
+		_ = os.RemoveAll(tmp)
+	}()
+
+	write := func(name, content string) {
+		if err := os.WriteFile(path.Join(tmp, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	check := func(name, content string) {
+		t.Helper()
+		data, err := os.ReadFile(path.Join(tmp, name))
+		if err != nil {
+			t.Errorf("read %s error: %v", name, err)
+			return
+		}
+		if g, e := string(data), content; g != e {
+			t.Errorf("wrong content of %s: %q != %q", name, g, e)
+		}
+	}
+	renameat2 := func(from, to string, flags uint) error {
+		return unix.Renameat2(unix.AT_FDCWD, path.Join(tmp, from), unix.AT_FDCWD, path.Join(tmp, to), flags)
+	}
+	for _, dir := range []string{"a", "b", "b/full"} {
+		if err := os.Mkdir(path.Join(tmp, dir), 0o755); err != nil {
+			t.Fatalf("mkdir error: %v", err)
+		}
+	}
+	write("a/one", "one")
+	write("a/two", "two")
+	write("b/full/x", "x")
+
+	// in the same directory, and across directories
+	if err := os.Rename(path.Join(tmp, "a", "one"), path.Join(tmp, "a", "first")); err != nil {
+		t.Fatalf("rename error: %v", err)
+	}
+	check("a/first", "one")
+	if err := os.Rename(path.Join(tmp, "a", "first"), path.Join(tmp, "b", "first")); err != nil {
+		t.Fatalf("rename across dirs error: %v", err)
+	}
+	check("b/first", "one")
+	if _, err := os.Stat(path.Join(tmp, "a", "first")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("source still there after rename: %v", err)
+	}
+
+	// replacing
+	if err := renameat2("a/two", "b/first", unix.RENAME_NOREPLACE); err != syscall.EEXIST {
+		t.Errorf("rename with RENAME_NOREPLACE: %v != %v", err, syscall.EEXIST)
+	}
+	if err := os.Rename(path.Join(tmp, "a", "two"), path.Join(tmp, "b", "first")); err != nil {
+		t.Fatalf("rename over a file error: %v", err)
+	}
+	check("b/first", "two")
+	// os.Rename refuses to replace directories itself
+	if err := renameat2("a", "b/full", 0); err != syscall.ENOTEMPTY {
+		t.Errorf("rename over a full dir: %v != %v", err, syscall.ENOTEMPTY)
+	}
+	if err := renameat2("b/first", "a", 0); err != syscall.EISDIR {
+		t.Errorf("rename of a file over a dir: %v != %v", err, syscall.EISDIR)
+	}
+	if err := renameat2("a", "b/first", 0); err != syscall.ENOTDIR {
+		t.Errorf("rename of a dir over a file: %v != %v", err, syscall.ENOTDIR)
+	}
+	if err := renameat2("a", "a/inside", 0); err != syscall.EINVAL {
+		t.Errorf("rename of a dir below itself: %v != %v", err, syscall.EINVAL)
+	}
+
+	// exchanging
+	if err := renameat2("b/first", "b/missing", unix.RENAME_EXCHANGE); err != syscall.ENOENT {
+		t.Errorf("exchange with a missing file: %v != %v", err, syscall.ENOENT)
+	}
+	if err := renameat2("b/first", "b/full", unix.RENAME_EXCHANGE); err != nil {
+		t.Fatalf("exchange error: %v", err)
+	}
+	check("b/full", "two")
+	check("b/first/x", "x")
+
+	// a dir moves with its content
+	if err := os.Rename(path.Join(tmp, "b"), path.Join(tmp, "a", "b")); err != nil {
+		t.Fatalf("rename of a dir error: %v", err)
+	}
+	check("a/b/first/x", "x")
+	write("a/b/first/y", "y")
+
+	if err := v.Publish(ctx); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	key, err := refs.Trees().Get(ctx, "live")
+	if err != nil {
+		t.Fatalf("no root published: %v", err)
+	}
+	tree, err := dirs.Load(ctx, chunkStore, key)
+	if err != nil {
+		t.Fatalf("load tree error: %v", err)
+	}
+	var paths []string
+	err = tree.Walk(ctx, func(p string, e dirs.Entry) error {
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk error: %v", err)
+	}
+	if g, e := paths, []string{"/a", "/a/b", "/a/b/first", "/a/b/first/x", "/a/b/first/y", "/a/b/full"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong tree published: %v != %v", g, e)
+	}
+}
+
+func TestSetattr(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	refs := snapshots.NewRefs(target)
+	v, err := fuse.Open(chunkStore, &fuse.Options{Refs: refs})
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	tmp, _ := os.MkdirTemp(os.TempDir(), "test-")
+	unmount, err := v.Mount(tmp)
+	if err != nil {
+		t.Fatalf("mount err: %v", err)
+	}
+	defer func() {
+		unmount()
+		_ = os.RemoveAll(tmp)
+	}()
+	file := path.Join(tmp, "dir", "file")
+	if err := os.Mkdir(path.Join(tmp, "dir"), 0o755); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	if err := os.WriteFile(file, []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	size := func(p string) int64 {
+		t.Helper()
+		stat, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("stat error: %v", err)
+		}
+		return stat.Size()
+	}
+
+	if err := os.Truncate(file, 4); err != nil {
+		t.Fatalf("truncate error: %v", err)
+	}
+	if g, e := size(file), int64(4); g != e {
+		t.Errorf("wrong size after shrink: %d != %d", g, e)
+	}
+	if err := os.Truncate(file, 1<<20); err != nil {
+		t.Fatalf("truncate error: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if g, e := len(data), 1<<20; g != e {
+		t.Errorf("wrong size after grow: %d != %d", g, e)
+	}
+	if !bytes.Equal(data[:4], []byte("0123")) || !bytes.Equal(data[4:], make([]byte, 1<<20-4)) {
+		t.Errorf("wrong content after grow")
+	}
+
+	fp, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatalf("open with O_TRUNC error: %v", err)
+	}
+	if _, err := fp.Write([]byte("new")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "new" {
+		t.Errorf("wrong content after O_TRUNC: %q, %v", data, err)
+	}
+
+	if err := syscall.Truncate(path.Join(tmp, "dir"), 0); err != syscall.EISDIR {
+		t.Errorf("truncate of a dir: %v != %v", err, syscall.EISDIR)
+	}
+	atime := time.Unix(1600000000, 0)
+	mtime := time.Unix(1700000000, 500)
+	for _, p := range []string{file, path.Join(tmp, "dir")} {
+		if err := os.Chmod(p, 0o600); err != nil {
+			t.Fatalf("chmod error: %v", err)
+		}
+		if err := os.Chtimes(p, atime, mtime); err != nil {
+			t.Fatalf("chtimes error: %v", err)
+		}
+		if os.Geteuid() == 0 {
+			if err := os.Chown(p, 1234, 5678); err != nil {
+				t.Fatalf("chown error: %v", err)
+			}
+		}
+		var stat syscall.Stat_t
+		if err := syscall.Stat(p, &stat); err != nil {
+			t.Fatalf("stat error: %v", err)
+		}
+		if g, e := stat.Mode&0o7777, uint32(0o600); g != e {
+			t.Errorf("wrong mode of %s: %o != %o", p, g, e)
+		}
+		if g, e := time.Unix(stat.Mtim.Unix()), mtime; !g.Equal(e) {
+			t.Errorf("wrong mtime of %s: %v != %v", p, g, e)
+		}
+		if g, e := time.Unix(stat.Atim.Unix()), atime; !g.Equal(e) {
+			t.Errorf("wrong atime of %s: %v != %v", p, g, e)
+		}
+		if g := time.Unix(stat.Ctim.Unix()); g.Before(mtime) {
+			t.Errorf("ctime of %s not updated: %v", p, g)
+		}
+		if os.Geteuid() == 0 && (stat.Uid != 1234 || stat.Gid != 5678) {
+			t.Errorf("wrong owner of %s: %d:%d", p, stat.Uid, stat.Gid)
+		}
+	}
+
+	if err := v.Publish(ctx); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	key, err := refs.Trees().Get(ctx, "live")
+	if err != nil {
+		t.Fatalf("no root published: %v", err)
+	}
+	tree, err := dirs.Load(ctx, chunkStore, key)
+	if err != nil {
+		t.Fatalf("load tree error: %v", err)
+	}
+	for _, p := range []string{"/dir/file", "/dir"} {
+		entry, err := tree.Lookup(ctx, p)
+		if err != nil {
+			t.Fatalf("lookup of %s error: %v", p, err)
+		}
+		if g, e := entry.Mode, uint32(0o600); g != e {
+			t.Errorf("wrong mode of %s published: %o != %o", p, g, e)
+		}
+		if !entry.Mtime.Equal(mtime) || !entry.Atime.Equal(atime) {
+			t.Errorf("wrong times of %s published: %v, %v", p, entry.Mtime, entry.Atime)
+		}
+		if os.Geteuid() == 0 && (entry.Uid != 1234 || entry.Gid != 5678) {
+			t.Errorf("wrong owner of %s published: %d:%d", p, entry.Uid, entry.Gid)
+		}
+	}
+	if entry, err := tree.Lookup(ctx, "/dir/file"); err != nil || entry.Size != 3 {
+		t.Errorf("wrong size published: %d, %v", entry.Size, err)
+	}
+}
+
+func TestReadBoundaries(t *testing.T) {
+	v, err := fuse.Open(mem.New(), nil)
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	tmp, _ := os.MkdirTemp(os.TempDir(), "test-")
+	unmount, err := v.Mount(tmp)
+	if err != nil {
+		t.Fatalf("mount err: %v", err)
+	}
+	defer func() {
+		unmount()
+		_ = os.RemoveAll(tmp)
+	}()
+
+	// files have chunks of 4 MiB
+	const chunk = 4 << 20
+	content := make([]byte, 2*chunk+123)
+	for i := range content {
+		content[i] = byte(i*7 + i>>20)
+	}
+	if err := os.WriteFile(path.Join(tmp, "file"), content, 0o644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	fp, err := os.Open(path.Join(tmp, "file"))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	defer fp.Close()
+	size := int64(len(content))
+	for _, c := range []struct {
+		off    int64
+		length int
+		n      int
+	}{
+		{0, 100, 100},
+		{chunk - 100, 200, 200},
+		{chunk - 1, chunk + 2, chunk + 2},
+		{2*chunk - 1, 2, 2},
+		{size - 10, 100, 10},
+		{size, 10, 0},
+		{size + 100, 10, 0},
+	} {
+		buf := make([]byte, c.length)
+		n, err := fp.ReadAt(buf, c.off)
+		if g, e := n, c.n; g != e {
+			t.Errorf("wrong length read at %d: %d != %d", c.off, g, e)
+		}
+		if n < c.length && err != io.EOF {
+			t.Errorf("short read at %d without EOF: %v", c.off, err)
+		}
+		if n > 0 && !bytes.Equal(buf[:n], content[c.off:c.off+int64(n)]) {
+			t.Errorf("wrong content read at %d", c.off)
+		}
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	stats := v.Stats()
+	if stats.Reads == 0 || stats.ReadBytes == 0 || stats.WriteBytes != uint64(size) {
+		t.Errorf("wrong counters: %+v", stats)
+	}
+	if stats.ShortReads == 0 {
+		t.Errorf("no short read counted: %+v", stats)
+	}
+}
+
+// failingStore fails reads of the stored chunks of files, and adds,
+// once broken.
+type failingStore struct {
+	store.IF
+	broken atomic.Bool
+	addErr error
+}
+
+func (s *failingStore) Get(ctx context.Context, key cas.Key, type_ string, level uint8) (*chunks.Chunk, error) {
+	if s.broken.Load() && type_ == dirs.FileBlobType && key != cas.Empty {
+		return nil, cas.NotFoundError{Type: type_, Level: level, Key: key}
+	}
+	return s.IF.Get(ctx, key, type_, level)
+}
+
+func (s *failingStore) Add(ctx context.Context, chunk *chunks.Chunk) (cas.Key, error) {
+	if s.broken.Load() {
+		return cas.Key{}, s.addErr
+	}
+	return s.IF.Add(ctx, chunk)
+}
+
+func TestErrors(t *testing.T) {
+	chunkStore := &failingStore{IF: mem.New(), addErr: fmt.Errorf("disk: %w", syscall.ENOSPC)}
+	v, err := fuse.Open(chunkStore, &fuse.Options{Refs: snapshots.NewRefs(kvmem.New())})
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	tmp, _ := os.MkdirTemp(os.TempDir(), "test-")
+	unmount, err := v.Mount(tmp)
+	if err != nil {
+		t.Fatalf("mount err: %v", err)
+	}
+	defer func() {
+		unmount()
+		_ = os.RemoveAll(tmp)
+	}()
+
+	if err := os.WriteFile(path.Join(tmp, "saved"), []byte("saved"), 0o644); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	chunkStore.broken.Store(true)
+
+	// the chunks of saved were dropped with the blob after saving
+	if _, err := os.ReadFile(path.Join(tmp, "saved")); !errors.Is(err, syscall.EIO) {
+		t.Errorf("read of a missing chunk: %v", err)
+	}
+	fp, err := os.Create(path.Join(tmp, "unsaved"))
+	if err != nil {
+		t.Fatalf("create error: %v", err)
+	}
+	if _, err := fp.Write([]byte("unsaved")); err != nil {
+		fp.Close()
+		t.Fatalf("write error: %v", err)
+	}
+	if err := fp.Close(); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("close with a full store: %v", err)
+	}
+
+	stats := v.Stats()
+	if stats.NotFound == 0 || stats.NoSpace == 0 {
+		t.Errorf("errors not counted: %+v", stats)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	target := kvmem.New()
+	v, err := fuse.Open(storekv.New(target), &fuse.Options{Refs: snapshots.NewRefs(target)})
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	tmp, _ := os.MkdirTemp(os.TempDir(), "test-")
+	unmount, err := v.Mount(tmp)
+	if err != nil {
+		t.Fatalf("mount err: %v", err)
+	}
+	defer func() {
+		unmount()
+		_ = os.RemoveAll(tmp)
+	}()
+
+	// regions cross the 4 MiB chunks of files
+	const writers = 8
+	const region = 1536 << 10
+	const piece = 64 << 10
+	want := make([]byte, writers*region)
+	for i := range want {
+		want[i] = byte(i/region + 1)
+	}
+	// Nothing is opened after a close: go-fuse reuses the slots of
+	// closed handles, which the race detector cannot tell apart from
+	// a race on the slot, as the kernel orders the requests.
+	name := path.Join(tmp, "file")
+	check, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("create error: %v", err)
+	}
+	defer check.Close()
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			fp, err := os.OpenFile(name, os.O_WRONLY, 0)
+			if err != nil {
+				t.Errorf("open error: %v", err)
+				return
+			}
+			defer fp.Close()
+			// back to front, so that the file grows concurrently
+			start := w * region
+			for off := start + region - piece; off >= start; off -= piece {
+				if _, err := fp.WriteAt(want[off:off+piece], int64(off)); err != nil {
+					t.Errorf("write error: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	stop := make(chan struct{})
+	var others sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		others.Add(1)
+		go func(r int) {
+			defer others.Done()
+			fp, err := os.Open(name)
+			if err != nil {
+				t.Errorf("open error: %v", err)
+				return
+			}
+			defer fp.Close()
+			buf := make([]byte, 3*piece)
+			for off := r * piece; ; off = (off + 5*piece) % len(want) {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				n, err := fp.ReadAt(buf, int64(off))
+				if err != nil && err != io.EOF {
+					t.Errorf("read error: %v", err)
+					return
+				}
+				// each byte is zero or written
+				for i, b := range buf[:n] {
+					if b != 0 && b != want[off+i] {
+						t.Errorf("wrong byte at %d: %d", off+i, b)
+						return
+					}
+				}
+			}
+		}(r)
+	}
+	others.Add(1)
+	go func() {
+		defer others.Done()
+		fp, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			t.Errorf("open error: %v", err)
+			return
+		}
+		defer fp.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := fp.Sync(); err != nil {
+				t.Errorf("fsync error: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	others.Wait()
+
+	got := make([]byte, len(want)+1)
+	n, err := check.ReadAt(got, 0)
+	if err != io.EOF {
+		t.Fatalf("read error: %v", err)
+	}
+	if got = got[:n]; !bytes.Equal(got, want) {
+		t.Errorf("wrong content after concurrent writes: %d bytes", len(got))
+	}
+	if err := v.Publish(context.Background()); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	gofs "github.com/hanwen/go-fuse/v2/fs"
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+	"io"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
+	"sync"
+	"syscall"
+)
+
+// snapshotsName is the name of the directory of snapshots in the root.
+// It is not listed, like .snapshot directories of NAS filers, but can
+// be entered.
+const snapshotsName = ".snapshots"
+
+// snapshotTimeFormat names the directories under .snapshots.
+const snapshotTimeFormat = "2006-01-02T15:04:05Z"
+
+// readOnly fails every change to a node with EROFS.
+type readOnly struct{}
+
+func (readOnly) Setattr(ctx context.Context, f gofs.FileHandle, in *gofuse.SetAttrIn, out *gofuse.AttrOut) syscall.Errno {
+	return syscall.EROFS
+}
+
+func (readOnly) Create(ctx context.Context, name string, flags uint32, mode uint32, out *gofuse.EntryOut) (
+	*gofs.Inode, gofs.FileHandle, uint32, syscall.Errno) {
+	return nil, nil, 0, syscall.EROFS
+}
+
+func (readOnly) Mkdir(ctx context.Context, name string, mode uint32, out *gofuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	return nil, syscall.EROFS
+}
+
+func (readOnly) Mknod(ctx context.Context, name string, mode uint32, dev uint32, out *gofuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	return nil, syscall.EROFS
+}
+
+func (readOnly) Link(ctx context.Context, target gofs.InodeEmbedder, name string, out *gofuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	return nil, syscall.EROFS
+}
+
+func (readOnly) Symlink(ctx context.Context, target, name string, out *gofuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	return nil, syscall.EROFS
+}
+
+func (readOnly) Unlink(ctx context.Context, name string) syscall.Errno {
+	return syscall.EROFS
+}
+
+func (readOnly) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return syscall.EROFS
+}
+
+func (readOnly) Rename(ctx context.Context, name string, newParent gofs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	return syscall.EROFS
+}
+
+var _ gofs.NodeSetattrer = readOnly{}
+var _ gofs.NodeCreater = readOnly{}
+var _ gofs.NodeMkdirer = readOnly{}
+var _ gofs.NodeMknoder = readOnly{}
+var _ gofs.NodeLinker = readOnly{}
+var _ gofs.NodeSymlinker = readOnly{}
+var _ gofs.NodeUnlinker = readOnly{}
+var _ gofs.NodeRmdirer = readOnly{}
+var _ gofs.NodeRenamer = readOnly{}
+
+// snapshotEntry is a directory of .snapshots.
+type snapshotEntry struct {
+	name string
+	key  cas.Key
+	s    *snapshots.Snapshot
+}
+
+// snapshotsDir lists the snapshots in the history of a ref, oldest
+// first, each named by the time it was taken.
+type snapshotsDir struct {
+	gofs.Inode
+	readOnly
+	fs   *filesystem
+	refs *snapshots.Refs
+	ref  string
+
+	mu sync.Mutex
+	// the history of head, which only needs loading again when the
+	// ref moves
+	head    cas.Key
+	entries []snapshotEntry
+}
+
+func (d *snapshotsDir) list(ctx context.Context) ([]snapshotEntry, error) {
+	head, err := d.refs.Get(ctx, d.ref)
+	var nf snapshots.RefNotFoundError
+	if errors.As(err, &nf) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if head == d.head && d.entries != nil {
+		return d.entries, nil
+	}
+	var entries []snapshotEntry
+	err = snapshots.History(ctx, d.fs.s, head, func(key cas.Key, s *snapshots.Snapshot) error {
+		entries = append(entries, snapshotEntry{key: key, s: s})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// History is newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	taken := make(map[string]bool, len(entries))
+	for i := range entries {
+		name := entries[i].s.Time.UTC().Format(snapshotTimeFormat)
+		if taken[name] {
+			// taken in the same second
+			name += "~" + entries[i].key.String()[:12]
+		}
+		taken[name] = true
+		entries[i].name = name
+	}
+	d.head, d.entries = head, entries
+	return entries, nil
+}
+
+func (d *snapshotsDir) Getattr(ctx context.Context, f gofs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	out.Mode = gofuse.S_IFDIR | 0o555
+	return syscall.F_OK
+}
+
+var _ gofs.NodeGetattrer = (*snapshotsDir)(nil)
+
+func (d *snapshotsDir) Readdir(ctx context.Context) (gofs.DirStream, syscall.Errno) {
+	entries, err := d.list(ctx)
+	if err != nil {
+		return nil, d.fs.errno(err)
+	}
+	out := make([]gofuse.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, gofuse.DirEntry{Name: e.name, Mode: gofuse.S_IFDIR})
+	}
+	return gofs.NewListDirStream(out), syscall.F_OK
+}
+
+var _ gofs.NodeReaddirer = (*snapshotsDir)(nil)
+
+func (d *snapshotsDir) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	entries, err := d.list(ctx)
+	if err != nil {
+		return nil, d.fs.errno(err)
+	}
+	for _, e := range entries {
+		if e.name != name {
+			continue
+		}
+		tree, err := e.s.Tree(ctx, d.fs.s)
+		if err != nil {
+			return nil, d.fs.errno(err)
+		}
+		root := tree.RootEntry()
+		root.Mtime = e.s.Time
+		return newReadOnlyNode(ctx, &d.Inode, d.fs, root, out), syscall.F_OK
+	}
+	return nil, syscall.ENOENT
+}
+
+var _ gofs.NodeLookuper = (*snapshotsDir)(nil)
+
+// newReadOnlyNode returns a child of parent for the entry e of a
+// snapshot.
+func newReadOnlyNode(ctx context.Context, parent *gofs.Inode, fs *filesystem, e dirs.Entry, out *gofuse.EntryOut) *gofs.Inode {
+	entryAttr(&e, &out.Attr)
+	if e.IsDir() {
+		return parent.NewInode(ctx, &roDir{fs: fs, e: e}, gofs.StableAttr{Mode: gofuse.S_IFDIR})
+	}
+	return parent.NewInode(ctx, &roFile{fs: fs, e: e}, gofs.StableAttr{Mode: gofuse.S_IFREG})
+}
+
+// entryAttr fills out with the attributes of e. Times not tracked are
+// reported as the mtime.
+func entryAttr(e *dirs.Entry, out *gofuse.Attr) {
+	out.Mode = gofuse.S_IFREG
+	if e.IsDir() {
+		out.Mode = gofuse.S_IFDIR
+	}
+	out.Mode |= e.Mode & 0o7777
+	out.Size = e.Size
+	out.Owner = gofuse.Owner{Uid: e.Uid, Gid: e.Gid}
+	if e.Mtime.IsZero() {
+		return
+	}
+	atime, ctime := e.Atime, e.Ctime
+	if atime.IsZero() {
+		atime = e.Mtime
+	}
+	if ctime.IsZero() {
+		ctime = e.Mtime
+	}
+	out.SetTimes(&atime, &e.Mtime, &ctime)
+}
+
+// roDir is a directory of a snapshot. Its blob is only read when it is
+// listed or a child is looked up.
+type roDir struct {
+	gofs.Inode
+	readOnly
+	fs *filesystem
+	e  dirs.Entry
+}
+
+func (d *roDir) Getattr(ctx context.Context, f gofs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	entryAttr(&d.e, &out.Attr)
+	return syscall.F_OK
+}
+
+var _ gofs.NodeGetattrer = (*roDir)(nil)
+
+func (d *roDir) Readdir(ctx context.Context) (gofs.DirStream, syscall.Errno) {
+	dir, err := dirs.ReadDir(ctx, d.fs.s, &d.e.Manifest)
+	if err != nil {
+		return nil, d.fs.errno(err)
+	}
+	entries := dir.Entries()
+	out := make([]gofuse.DirEntry, 0, len(entries))
+	for i := range entries {
+		var attr gofuse.Attr
+		entryAttr(&entries[i], &attr)
+		out = append(out, gofuse.DirEntry{Name: entries[i].Name, Mode: attr.Mode})
+	}
+	return gofs.NewListDirStream(out), syscall.F_OK
+}
+
+var _ gofs.NodeReaddirer = (*roDir)(nil)
+
+func (d *roDir) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	dir, err := dirs.ReadDir(ctx, d.fs.s, &d.e.Manifest)
+	if err != nil {
+		return nil, d.fs.errno(err)
+	}
+	e, ok := dir.Lookup(name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return newReadOnlyNode(ctx, &d.Inode, d.fs, e, out), syscall.F_OK
+}
+
+var _ gofs.NodeLookuper = (*roDir)(nil)
+
+// roFile is a file of a snapshot.
+type roFile struct {
+	gofs.Inode
+	readOnly
+	fs *filesystem
+	e  dirs.Entry
+}
+
+// roHandle is an open roFile.
+type roHandle struct {
+	blob *blobs.Blob
+}
+
+func (f *roFile) Getattr(ctx context.Context, fh gofs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	entryAttr(&f.e, &out.Attr)
+	return syscall.F_OK
+}
+
+var _ gofs.NodeGetattrer = (*roFile)(nil)
+
+func (f *roFile) Open(ctx context.Context, flags uint32) (gofs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	blob, err := blobs.Open(f.fs.s, &f.e.Manifest)
+	if err != nil {
+		return nil, 0, f.fs.errno(err)
+	}
+	// the content never changes
+	return &roHandle{blob: blob}, gofuse.FOPEN_KEEP_CACHE, syscall.F_OK
+}
+
+var _ gofs.NodeOpener = (*roFile)(nil)
+
+func (f *roFile) Read(ctx context.Context, fh gofs.FileHandle, dest []byte, off int64) (gofuse.ReadResult, syscall.Errno) {
+	h, ok := fh.(*roHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	n, err := h.blob.IO(ctx).ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, f.fs.errno(err)
+	}
+	return gofuse.ReadResultData(dest[:n]), syscall.F_OK
+}
+
+var _ gofs.NodeReader = (*roFile)(nil)
+
+func (f *roFile) Write(ctx context.Context, fh gofs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+	return 0, syscall.EROFS
+}
+
+var _ gofs.NodeWriter = (*roFile)(nil)
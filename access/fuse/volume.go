@@ -5,85 +5,538 @@ import (
 	gofs "github.com/hanwen/go-fuse/v2/fs"
 	gofuse "github.com/hanwen/go-fuse/v2/fuse"
 	"lifs_go/cas/blobs"
-	"lifs_go/cas/store"
+	"lifs_go/cas/dirs"
 	"syscall"
+	"time"
 )
 
+// Volume is a directory. Its entries are read from its directory blob
+// when first needed; changes are kept in memory and written as a new
+// blob when the filesystem publishes.
 type Volume struct {
 	gofs.Inode
-	s        store.IF
-	mkv      map[string]*blobs.Manifest
+	fs *filesystem
+	// parent is nil for the root
+	parent *Volume
+	// entry in the parent directory; its Manifest is stale while
+	// dirty
+	entry dirs.Entry
+	// dir is nil until loaded
+	dir *dirs.Dir
+	// dirty is set when the directory or anything below it changed
+	// since it was last saved
+	dirty    bool
 	children map[string]*gofs.Inode
+	// history is the .snapshots directory, only set in the root
+	history      *snapshotsDir
+	historyInode *gofs.Inode
 }
 
-func (v *Volume) getChild(ctx context.Context, name string, f func() gofs.InodeEmbedder) *gofs.Inode {
-	node, ok := v.children[name]
-	if ok {
-		return node
+func newVolume(fs *filesystem, parent *Volume, entry dirs.Entry) *Volume {
+	return &Volume{
+		fs:       fs,
+		parent:   parent,
+		entry:    entry,
+		children: make(map[string]*gofs.Inode),
+	}
+}
+
+// load reads the entries of the directory. Must hold fs.mu.
+func (v *Volume) load(ctx context.Context) (*dirs.Dir, error) {
+	if v.dir != nil {
+		return v.dir, nil
+	}
+	d, err := dirs.ReadDir(ctx, v.fs.s, &v.entry.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	v.dir = d
+	return d, nil
+}
+
+// markDirty flags v and its ancestors for saving. Must hold fs.mu.
+func (v *Volume) markDirty() {
+	for d := v; d != nil && !d.dirty; d = d.parent {
+		d.dirty = true
+	}
+}
+
+// save writes the changed files and directories below v, and then v
+// itself. Must hold fs.mu.
+func (v *Volume) save(ctx context.Context) error {
+	if !v.dirty {
+		return nil
+	}
+	if _, err := v.load(ctx); err != nil {
+		return err
+	}
+	for name, node := range v.children {
+		var e dirs.Entry
+		switch child := node.Operations().(type) {
+		case *Volume:
+			if !child.dirty {
+				continue
+			}
+			if err := child.save(ctx); err != nil {
+				return err
+			}
+			e = child.entry
+		case *File:
+			saved, err := child.save(ctx)
+			if err != nil {
+				return err
+			}
+			if !saved {
+				continue
+			}
+			e = child.entry
+		default:
+			continue
+		}
+		e.Name = name
+		if err := v.dir.Set(e); err != nil {
+			return err
+		}
 	}
-	if f == nil {
-		panic("try to get a not exist child but not provide make function.")
+	m, err := dirs.WriteDir(ctx, v.fs.s, v.dir)
+	if err != nil {
+		return err
 	}
-	ie := f()
-	var attr uint32
-	switch ie.(type) {
+	v.entry.Manifest = *m
+	v.entry.Size = m.Size
+	v.dirty = false
+	return nil
+}
+
+// child returns the node of the entry called name, creating it if
+// needed. Must hold fs.mu.
+func (v *Volume) child(ctx context.Context, e dirs.Entry) (*gofs.Inode, error) {
+	if node, ok := v.children[e.Name]; ok {
+		return node, nil
+	}
+	var node *gofs.Inode
+	if e.IsDir() {
+		node = v.NewInode(ctx, newVolume(v.fs, v, e), gofs.StableAttr{Mode: gofuse.S_IFDIR})
+	} else {
+		f, err := newFile(v.fs, v, e)
+		if err != nil {
+			return nil, err
+		}
+		node = v.NewInode(ctx, f, gofs.StableAttr{Mode: gofuse.S_IFREG})
+	}
+	v.children[e.Name] = node
+	return node, nil
+}
+
+// add creates the entry e, failing if the name is taken. Must hold
+// fs.mu.
+func (v *Volume) add(ctx context.Context, e dirs.Entry) (*gofs.Inode, syscall.Errno) {
+	d, err := v.load(ctx)
+	if err != nil {
+		return nil, v.fs.errno(err)
+	}
+	if _, ok := d.Lookup(e.Name); ok {
+		return nil, syscall.EEXIST
+	}
+	if err := d.Set(e); err != nil {
+		return nil, syscall.EINVAL
+	}
+	node, err := v.child(ctx, e)
+	if err != nil {
+		d.Remove(e.Name)
+		return nil, v.fs.errno(err)
+	}
+	v.markDirty()
+	return node, syscall.F_OK
+}
+
+// attr fills out with the attributes of the directory. Must hold
+// fs.mu.
+func (v *Volume) attr(out *gofuse.Attr) {
+	entryAttr(&v.entry, out)
+}
+
+// nodeAttr fills out with the attributes of a child node. Must hold
+// fs.mu.
+func nodeAttr(node *gofs.Inode, out *gofuse.Attr) {
+	switch n := node.Operations().(type) {
 	case *Volume:
-		attr = gofuse.S_IFDIR
+		n.attr(out)
 	case *File:
-		attr = gofuse.S_IFREG
+		n.attr(out)
 	}
-	node = v.NewInode(ctx, f(), gofs.StableAttr{Mode: attr})
-	v.children[name] = node
-	return node
 }
 
 func (v *Volume) Getattr(ctx context.Context, f gofs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
-	out.Mode = gofuse.S_IFDIR
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	v.attr(&out.Attr)
 	return syscall.F_OK
 }
 
 var _ gofs.NodeGetattrer = (*Volume)(nil)
 
-func (v *Volume) Create(ctx context.Context, name string, flags uint32, mode uint32, _ *gofuse.EntryOut) (
+// setAttr changes the attributes of e other than the size, as given by
+// in, and reports whether any changed. The ctime is updated unless in
+// sets it.
+func setAttr(e *dirs.Entry, in *gofuse.SetAttrIn) bool {
+	changed := false
+	if mode, ok := in.GetMode(); ok {
+		e.Mode = mode & 0o7777
+		changed = true
+	}
+	if uid, ok := in.GetUID(); ok {
+		e.Uid = uid
+		changed = true
+	}
+	if gid, ok := in.GetGID(); ok {
+		e.Gid = gid
+		changed = true
+	}
+	if atime, ok := in.GetATime(); ok {
+		e.Atime = atime
+		changed = true
+	}
+	if mtime, ok := in.GetMTime(); ok {
+		e.Mtime = mtime
+		changed = true
+	}
+	if ctime, ok := in.GetCTime(); ok {
+		e.Ctime = ctime
+		changed = true
+	} else if changed {
+		e.Ctime = time.Now()
+	}
+	return changed
+}
+
+// Setattr changes the mode, owner and times of the directory. Those of
+// the root are not kept by the tree, and are lost on unmount.
+func (v *Volume) Setattr(ctx context.Context, f gofs.FileHandle, in *gofuse.SetAttrIn, out *gofuse.AttrOut) syscall.Errno {
+	if _, ok := in.GetSize(); ok {
+		return syscall.EISDIR
+	}
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	if setAttr(&v.entry, in) {
+		v.markDirty()
+	}
+	v.attr(&out.Attr)
+	return syscall.F_OK
+}
+
+var _ gofs.NodeSetattrer = (*Volume)(nil)
+
+// newEntry returns the entry of a new file or directory, owned by the
+// caller.
+func newEntry(ctx context.Context, name string, kind dirs.Kind, mode uint32, m *blobs.Manifest) dirs.Entry {
+	now := time.Now()
+	e := dirs.Entry{
+		Name:     name,
+		Kind:     kind,
+		Mode:     mode & 0o7777,
+		Mtime:    now,
+		Atime:    now,
+		Ctime:    now,
+		Manifest: *m,
+	}
+	if caller, ok := gofuse.FromContext(ctx); ok {
+		e.Uid, e.Gid = caller.Uid, caller.Gid
+	}
+	return e
+}
+
+// reserved reports whether name is the .snapshots directory.
+func (v *Volume) reserved(name string) bool {
+	return v.history != nil && name == snapshotsName
+}
+
+func (v *Volume) Create(ctx context.Context, name string, flags uint32, mode uint32, out *gofuse.EntryOut) (
 	node *gofs.Inode, fh gofs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
-	inode := v.getChild(ctx, name, func() gofs.InodeEmbedder {
-		m := blobs.EmptyManifest("file")
-		v.mkv[name] = m
-		return newFile(v.s, m)
-	})
-	return inode, inode.Operations(), flags, syscall.F_OK
+	if v.reserved(name) {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	inode, errno := v.add(ctx, newEntry(ctx, name, dirs.KindFile, mode, blobs.EmptyManifest(dirs.FileBlobType)))
+	if errno != syscall.F_OK {
+		return nil, nil, 0, errno
+	}
+	nodeAttr(inode, &out.Attr)
+	return inode, inode.Operations(), 0, syscall.F_OK
 }
 
 var _ gofs.NodeCreater = (*Volume)(nil)
 
 func (v *Volume) Mkdir(ctx context.Context, name string, mode uint32, out *gofuse.EntryOut) (
 	*gofs.Inode, syscall.Errno) {
-	return v.getChild(ctx, name, func() gofs.InodeEmbedder {
-		return open(v.s)
-	}), syscall.F_OK
+	if v.reserved(name) {
+		return nil, syscall.EEXIST
+	}
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	inode, errno := v.add(ctx, newEntry(ctx, name, dirs.KindDir, mode, dirs.EmptyManifest()))
+	if errno != syscall.F_OK {
+		return nil, errno
+	}
+	nodeAttr(inode, &out.Attr)
+	return inode, syscall.F_OK
 }
 
 var _ gofs.NodeMkdirer = (*Volume)(nil)
 
 func (v *Volume) Readdir(ctx context.Context) (gofs.DirStream, syscall.Errno) {
-	entries := make([]gofuse.DirEntry, 0)
-	for name, _ := range v.children {
-		entries = append(entries, gofuse.DirEntry{Name: name})
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	d, err := v.load(ctx)
+	if err != nil {
+		return nil, v.fs.errno(err)
 	}
-	res := gofs.NewListDirStream(entries)
-	return res, syscall.F_OK
+	list := d.Entries()
+	entries := make([]gofuse.DirEntry, 0, len(list))
+	for _, e := range list {
+		mode := uint32(gofuse.S_IFREG)
+		if e.IsDir() {
+			mode = gofuse.S_IFDIR
+		}
+		entries = append(entries, gofuse.DirEntry{Name: e.Name, Mode: mode})
+	}
+	return gofs.NewListDirStream(entries), syscall.F_OK
 }
 
 var _ gofs.NodeReaddirer = (*Volume)(nil)
 
 func (v *Volume) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (
 	*gofs.Inode, syscall.Errno) {
-	// for file
-	_, ok := v.children[name]
-	if ok {
-		return v.getChild(ctx, name, nil), syscall.F_OK
+	if v.reserved(name) {
+		v.fs.mu.Lock()
+		defer v.fs.mu.Unlock()
+		if v.historyInode == nil {
+			v.historyInode = v.NewInode(ctx, v.history, gofs.StableAttr{Mode: gofuse.S_IFDIR})
+		}
+		return v.historyInode, syscall.F_OK
+	}
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	d, err := v.load(ctx)
+	if err != nil {
+		return nil, v.fs.errno(err)
+	}
+	e, ok := d.Lookup(name)
+	if !ok {
+		return nil, syscall.ENOENT
 	}
-	return nil, syscall.ENOENT
+	node, err := v.child(ctx, e)
+	if err != nil {
+		return nil, v.fs.errno(err)
+	}
+	nodeAttr(node, &out.Attr)
+	return node, syscall.F_OK
 }
 
 var _ gofs.NodeLookuper = (*Volume)(nil)
+
+// empty reports whether the directory e of v has no entries. Must hold
+// fs.mu.
+func (v *Volume) empty(ctx context.Context, e dirs.Entry) (bool, error) {
+	if node, ok := v.children[e.Name]; ok {
+		if child, ok := node.Operations().(*Volume); ok {
+			d, err := child.load(ctx)
+			if err != nil {
+				return false, err
+			}
+			return d.Len() == 0, nil
+		}
+	}
+	d, err := dirs.ReadDir(ctx, v.fs.s, &e.Manifest)
+	if err != nil {
+		return false, err
+	}
+	return d.Len() == 0, nil
+}
+
+// remove deletes the entry called name, which must be a directory or
+// not as dir says. Must hold fs.mu.
+func (v *Volume) remove(ctx context.Context, name string, dir bool) syscall.Errno {
+	d, err := v.load(ctx)
+	if err != nil {
+		return v.fs.errno(err)
+	}
+	e, ok := d.Lookup(name)
+	switch {
+	case !ok:
+		return syscall.ENOENT
+	case dir && !e.IsDir():
+		return syscall.ENOTDIR
+	case !dir && e.IsDir():
+		return syscall.EISDIR
+	case dir:
+		empty, err := v.empty(ctx, e)
+		if err != nil {
+			return v.fs.errno(err)
+		}
+		if !empty {
+			return syscall.ENOTEMPTY
+		}
+	}
+	d.Remove(name)
+	// an open file keeps working, but is not saved anymore
+	delete(v.children, name)
+	v.markDirty()
+	return syscall.F_OK
+}
+
+func (v *Volume) Unlink(ctx context.Context, name string) syscall.Errno {
+	if v.reserved(name) {
+		return syscall.EROFS
+	}
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	return v.remove(ctx, name, false)
+}
+
+var _ gofs.NodeUnlinker = (*Volume)(nil)
+
+func (v *Volume) Rmdir(ctx context.Context, name string) syscall.Errno {
+	if v.reserved(name) {
+		return syscall.EROFS
+	}
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	return v.remove(ctx, name, true)
+}
+
+var _ gofs.NodeRmdirer = (*Volume)(nil)
+
+// adopt makes node the child of v called name. Must hold fs.mu.
+func (v *Volume) adopt(name string, node *gofs.Inode) {
+	switch n := node.Operations().(type) {
+	case *Volume:
+		n.parent = v
+		n.entry.Name = name
+	case *File:
+		n.parent = v
+		n.entry.Name = name
+	}
+	v.children[name] = node
+}
+
+// within reports whether v is the directory node, or below it. Must
+// hold fs.mu.
+func (v *Volume) within(node *gofs.Inode) bool {
+	for d := v; d != nil; d = d.parent {
+		if &d.Inode == node {
+			return true
+		}
+	}
+	return false
+}
+
+// renameNoReplace is the RENAME_NOREPLACE flag of renameat2(2), which
+// go-fuse does not define.
+const renameNoReplace = 0x1
+
+func (v *Volume) Rename(ctx context.Context, name string, newParent gofs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	dst, ok := newParent.(*Volume)
+	if !ok {
+		// .snapshots and the snapshots below it
+		return syscall.EROFS
+	}
+	if flags&^(renameNoReplace|gofs.RENAME_EXCHANGE) != 0 ||
+		flags&renameNoReplace != 0 && flags&gofs.RENAME_EXCHANGE != 0 {
+		return syscall.EINVAL
+	}
+	if v.reserved(name) || dst.reserved(newName) {
+		return syscall.EROFS
+	}
+	v.fs.mu.Lock()
+	defer v.fs.mu.Unlock()
+	src, err := v.load(ctx)
+	if err != nil {
+		return v.fs.errno(err)
+	}
+	d, err := dst.load(ctx)
+	if err != nil {
+		return v.fs.errno(err)
+	}
+	e, ok := src.Lookup(name)
+	if !ok {
+		return syscall.ENOENT
+	}
+	old, exists := d.Lookup(newName)
+	if v == dst && name == newName {
+		if flags&renameNoReplace != 0 {
+			return syscall.EEXIST
+		}
+		return syscall.F_OK
+	}
+	// a directory cannot move below itself
+	if node, ok := v.children[name]; ok && e.IsDir() && dst.within(node) {
+		return syscall.EINVAL
+	}
+
+	if flags&gofs.RENAME_EXCHANGE != 0 {
+		if !exists {
+			return syscall.ENOENT
+		}
+		if node, ok := dst.children[newName]; ok && old.IsDir() && v.within(node) {
+			return syscall.EINVAL
+		}
+		e.Name, old.Name = newName, name
+		if err := src.Set(old); err != nil {
+			return syscall.EINVAL
+		}
+		if err := d.Set(e); err != nil {
+			return syscall.EINVAL
+		}
+		a, aok := v.children[name]
+		b, bok := dst.children[newName]
+		delete(v.children, name)
+		delete(dst.children, newName)
+		if aok {
+			dst.adopt(newName, a)
+		}
+		if bok {
+			v.adopt(name, b)
+		}
+		v.markDirty()
+		dst.markDirty()
+		return syscall.F_OK
+	}
+
+	if exists {
+		switch {
+		case flags&renameNoReplace != 0:
+			return syscall.EEXIST
+		case e.IsDir() && !old.IsDir():
+			return syscall.ENOTDIR
+		case !e.IsDir() && old.IsDir():
+			return syscall.EISDIR
+		case old.IsDir():
+			empty, err := dst.empty(ctx, old)
+			if err != nil {
+				return v.fs.errno(err)
+			}
+			if !empty {
+				return syscall.ENOTEMPTY
+			}
+		}
+	}
+	e.Name = newName
+	if err := d.Set(e); err != nil {
+		return syscall.EINVAL
+	}
+	src.Remove(name)
+	node, ok := v.children[name]
+	delete(v.children, name)
+	// a file replaced is dropped like an unlinked one
+	delete(dst.children, newName)
+	if ok {
+		dst.adopt(newName, node)
+	}
+	v.markDirty()
+	dst.markDirty()
+	return syscall.F_OK
+}
+
+var _ gofs.NodeRenamer = (*Volume)(nil)
@@ -0,0 +1,78 @@
+package blobs
+
+import (
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+)
+
+// writeBatch is the number of chunks a Writer or CDCWriter adds to the
+// store at once.
+const writeBatch = 64
+
+// pending buffers the chunks of a writer until they are added with
+// store.AddBatch. Their keys are known at once: every store keys a
+// chunk by chunks.Hash.
+type pending struct {
+	store  store.IF
+	chunks []*chunks.Chunk
+}
+
+func (p *pending) add(ctx context.Context, chunk *chunks.Chunk) (cas.Key, error) {
+	key := chunks.Hash(chunk)
+	p.chunks = append(p.chunks, chunk)
+	if len(p.chunks) >= writeBatch {
+		return key, p.flush(ctx)
+	}
+	return key, nil
+}
+
+func (p *pending) flush(ctx context.Context) error {
+	if len(p.chunks) == 0 {
+		return nil
+	}
+	_, err := store.AddBatch(ctx, p.store, p.chunks)
+	// the store may keep the buffers, so never reuse the slice
+	p.chunks = nil
+	return err
+}
+
+// saving collects the modified chunks of a Blob being saved, so they
+// are added to the store in one store.AddBatch. Pointer chunks are
+// patched in place with the keys of their saved children; if adding
+// fails, the patches are undone so the stash is left as it was.
+type saving struct {
+	chunks []*chunks.Chunk
+	// private are the stash keys of chunks, to drop once stored
+	private []cas.Key
+	patches []patch
+}
+
+type patch struct {
+	slot []byte
+	old  []byte
+}
+
+func (s *saving) patch(slot []byte, key cas.Key) {
+	s.patches = append(s.patches, patch{slot: slot, old: append([]byte(nil), slot...)})
+	copy(slot, key.Bytes())
+}
+
+// commit adds the collected chunks to the store and drops them from
+// the stash.
+func (blob *Blob) commit(ctx context.Context, s *saving) error {
+	if len(s.chunks) == 0 {
+		return nil
+	}
+	if _, err := store.AddBatch(ctx, blob.store, s.chunks); err != nil {
+		for i := len(s.patches) - 1; i >= 0; i-- {
+			copy(s.patches[i].slot, s.patches[i].old)
+		}
+		return err
+	}
+	for _, key := range s.private {
+		blob.stash.Drop(key)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"lifs_go/cas/chunks/stash"
 	"lifs_go/cas/store"
 	"math"
+	"sync"
 )
 
 var (
@@ -22,10 +23,19 @@ const (
 
 const MinChunkSize = 4096
 
+// Blob is safe for concurrent use. Reads run in parallel, while
+// writes, Truncate and Save are serialized and exclude the reads.
 type Blob struct {
+	// mu guards the fields below and the stash. Reads only hold it to
+	// walk the tree and to copy from modified leaves: stored leaves
+	// never change, and are fetched and copied without it.
+	mu    sync.RWMutex
+	store store.IF
 	stash *stash.Stash
 	m     Manifest
 	depth uint8
+	// dirtyLimit bounds the stash, see SetDirtyLimit
+	dirtyLimit int64
 }
 
 // Open returns a new Blob, using the given chunk store and manifest.
@@ -39,20 +49,19 @@ type Blob struct {
 func Open(chunkStore store.IF, manifest *Manifest) (*Blob, error) {
 	// make a copy so caller can't mutate it
 	m := *manifest
-	if m.Type == "" {
-		return nil, ErrMissingType
-	}
-	if m.ChunkSize < MinChunkSize {
-		return nil, SmallChunkSizeError{m.ChunkSize}
-	}
-	if m.Fanout < 2 {
-		return nil, SmallFanoutError{m.Fanout}
+	if err := m.Validate(); err != nil {
+		return nil, err
 	}
 	blob := &Blob{
+		store: chunkStore,
 		stash: stash.New(chunkStore),
 		m:     m,
 		depth: 0,
 	}
+	if m.Chunking.IsContentDefined() {
+		blob.depth = m.Depth
+		return blob, nil
+	}
 	blob.depth = blob.computeLevel(blob.m.Size)
 	return blob, nil
 }
@@ -61,6 +70,11 @@ func Open(chunkStore store.IF, manifest *Manifest) (*Blob, error) {
 // the old size, data past that point is lost. If the new size is
 // greater than the old size, the new part is full of zeroes.
 func (blob *Blob) Truncate(ctx context.Context, size uint64) error {
+	blob.mu.Lock()
+	defer blob.mu.Unlock()
+	if blob.m.Chunking.IsContentDefined() {
+		return ErrContentDefined
+	}
 	switch {
 	case size == 0:
 		// special case shrink to nothing
@@ -164,6 +178,13 @@ func (blob *Blob) Truncate(ctx context.Context, size uint64) error {
 // Save persists the Blob into the Store and returns a new Manifest
 // that can be passed to Open later.
 func (blob *Blob) Save(ctx context.Context) (*Manifest, error) {
+	blob.mu.Lock()
+	defer blob.mu.Unlock()
+	if blob.m.Chunking.IsContentDefined() {
+		// never modified
+		m := blob.m
+		return &m, nil
+	}
 	// make sure the tree is optimal depth, as later we rely purely on
 	// size to compute depth; this might happen because of errors on a
 	// write/truncate path
@@ -180,10 +201,14 @@ func (blob *Blob) Save(ctx context.Context) (*Manifest, error) {
 			return nil, err
 		}
 	}
-	k, err := blob.saveChunk(ctx, blob.m.Root, blob.depth)
+	var s saving
+	k, err := blob.saveChunk(ctx, blob.m.Root, blob.depth, &s)
 	if err != nil {
 		return nil, err
 	}
+	if err := blob.commit(ctx, &s); err != nil {
+		return nil, err
+	}
 	blob.m.Root = k
 	// make a copy to return
 	m := blob.m
@@ -192,6 +217,8 @@ func (blob *Blob) Save(ctx context.Context) (*Manifest, error) {
 
 // Size returns the current byte size of the Blob.
 func (blob *Blob) Size() uint64 {
+	blob.mu.RLock()
+	defer blob.mu.RUnlock()
 	return blob.m.Size
 }
 
@@ -273,6 +300,16 @@ func (blob *Blob) chunkSizeForLevel(level uint8) uint32 {
 // It may be a Private or a Normal chunk. For writable Chunks, call
 // lookupForWrite instead.
 func (blob *Blob) lookup(ctx context.Context, off uint64) (*chunks.Chunk, error) {
+	key, err := blob.lookupKey(ctx, off)
+	if err != nil {
+		return nil, err
+	}
+	return blob.stash.Get(ctx, key, blob.m.Type, 0)
+}
+
+// lookupKey returns the key of the data chunk for the given global
+// byte offset, reading only pointer chunks.
+func (blob *Blob) lookupKey(ctx context.Context, off uint64) (cas.Key, error) {
 	globalIdx := uint32(off / uint64(blob.m.ChunkSize))
 	localIds := localChunkIndexes(blob.m.Fanout, globalIdx)
 	level := blob.depth
@@ -288,7 +325,7 @@ func (blob *Blob) lookup(ctx context.Context, off uint64) (*chunks.Chunk, error)
 
 		chunk, err := blob.stash.Get(ctx, ptrKey, blob.m.Type, level)
 		if err != nil {
-			return nil, err
+			return cas.Key{}, err
 		}
 
 		keyOffset := int64(idx) * cas.KeySize
@@ -297,9 +334,7 @@ func (blob *Blob) lookup(ctx context.Context, off uint64) (*chunks.Chunk, error)
 		keyBuf := safeSlice(chunk.Buf, int(keyOffset), int(keyOffset+cas.KeySize))
 		ptrKey = cas.NewKeyPrivate(keyBuf)
 	}
-
-	chunk, err := blob.stash.Get(ctx, ptrKey, blob.m.Type, 0)
-	return chunk, err
+	return ptrKey, nil
 }
 
 // lookupForWrite fetches the data chunk for the given offset and
@@ -458,7 +493,9 @@ func zeroSlice(p []byte) {
 	}
 }
 
-func (blob *Blob) saveChunk(ctx context.Context, key cas.Key, level uint8) (cas.Key, error) {
+// saveChunk adds the modified chunks below and including key to s, and
+// returns the key key will have once s is committed.
+func (blob *Blob) saveChunk(ctx context.Context, key cas.Key, level uint8, s *saving) (cas.Key, error) {
 	if !key.IsPrivate() {
 		// already saved
 		return key, nil
@@ -476,14 +513,19 @@ func (blob *Blob) saveChunk(ctx context.Context, key cas.Key, level uint8) (cas.
 				return key, fmt.Errorf("invalid stored key: key @%d in %v is %v", off, key, chunk.Buf[off:off+cas.KeySize])
 			}
 			// recurses at most `level` deep
-			saved, err := blob.saveChunk(ctx, cur, level-1)
+			saved, err := blob.saveChunk(ctx, cur, level-1, s)
 			if err != nil {
 				return key, err
 			}
-			copy(chunk.Buf[off:off+cas.KeySize], saved.Bytes())
+			if saved != cur {
+				s.patch(chunk.Buf[off:off+cas.KeySize], saved)
+			}
 		}
 	}
 
-	chunk.Buf = trim(chunk.Buf)
-	return blob.stash.Save(ctx, key)
+	// the local chunk keeps its full buffer until it is dropped
+	saved := chunks.MakeChunk(chunk.Type, chunk.Level, trim(chunk.Buf))
+	s.chunks = append(s.chunks, saved)
+	s.private = append(s.private, key)
+	return chunks.Hash(saved), nil
 }
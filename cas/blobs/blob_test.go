@@ -7,8 +7,10 @@ import (
 	"io"
 	"lifs_go/cas"
 	"lifs_go/cas/blobs"
+	"lifs_go/cas/chunks"
 	"lifs_go/cas/store"
 	"lifs_go/cas/store/mem"
+	"sync"
 	"testing"
 )
 
@@ -442,6 +444,78 @@ func TestWriteAndSaveLarge(t *testing.T) {
 	}
 }
 
+// batchStore adds chunks only in batches, and fails them while fail
+// is set.
+type batchStore struct {
+	store.IF
+	fail    bool
+	batches int
+}
+
+var errBatch = errors.New("batch failed")
+
+func (b *batchStore) Add(ctx context.Context, chunk *chunks.Chunk) (cas.Key, error) {
+	panic("Add called on a BatchAdder")
+}
+
+func (b *batchStore) AddBatch(ctx context.Context, chunks_ []*chunks.Chunk) ([]cas.Key, error) {
+	if b.fail {
+		return nil, errBatch
+	}
+	b.batches++
+	return store.AddBatch(ctx, b.IF, chunks_)
+}
+
+func TestSaveBatch(t *testing.T) {
+	const chunkSize = 4096
+	chunkStore := &batchStore{IF: mem.New(), fail: true}
+	blob, err := blobs.Open(chunkStore, &blobs.Manifest{
+		Type:      "footype",
+		ChunkSize: chunkSize,
+		Fanout:    2,
+	})
+	if err != nil {
+		t.Fatalf("cannot open blob: %v", err)
+	}
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*chunkSize/16)
+	if _, err := blob.IO(ctx).WriteAt(data, 0); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	if _, err := blob.Save(ctx); !errors.Is(err, errBatch) {
+		t.Fatalf("expected the batch error from Save: %v", err)
+	}
+	// a failed save leaves the blob as it was
+	buf := make([]byte, len(data))
+	if _, err := blob.IO(ctx).ReadAt(buf, 0); err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("failed Save changed the data")
+	}
+
+	chunkStore.fail = false
+	saved, err := blob.Save(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error from Save: %v", err)
+	}
+	if g, e := chunkStore.batches, 1; g != e {
+		t.Errorf("Save used wrong number of batches: %d != %d", g, e)
+	}
+	reopened, err := blobs.Open(chunkStore, saved)
+	if err != nil {
+		t.Fatalf("cannot open saved blob: %v", err)
+	}
+	buf = make([]byte, len(data))
+	if _, err := reopened.IO(ctx).ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("saved blob has wrong data")
+	}
+}
+
 func TestWriteTruncateZero(t *testing.T) {
 	const chunkSize = 4096
 	const fanout = 64
@@ -664,6 +738,100 @@ func TestWriteTruncateGrow(t *testing.T) {
 	}
 }
 
+func TestConcurrentIO(t *testing.T) {
+	ctx := context.Background()
+	blob, err := blobs.Open(mem.New(), &blobs.Manifest{
+		Type:      "footype",
+		ChunkSize: blobs.MinChunkSize,
+		Fanout:    2,
+	})
+	if err != nil {
+		t.Fatalf("cannot open blob: %v", err)
+	}
+	blob.SetDirtyLimit(4 * blobs.MinChunkSize)
+
+	const writers = 8
+	const region = 5 * blobs.MinChunkSize
+	const piece = 1000
+	want := make([]byte, writers*region)
+	for i := range want {
+		want[i] = byte(i/region + 1)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			start := w * region
+			for off := start; off < start+region; off += piece {
+				end := min(off+piece, start+region)
+				if _, err := blob.IO(ctx).WriteAt(want[off:end], int64(off)); err != nil {
+					t.Errorf("write error: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			buf := make([]byte, 3*piece)
+			for off := r * piece; ; off = (off + 7*piece) % len(want) {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				n, err := blob.IO(ctx).ReadAt(buf, int64(off))
+				if err != nil && err != io.EOF {
+					t.Errorf("read error: %v", err)
+					return
+				}
+				// each byte is zero or written
+				for i, b := range buf[:n] {
+					if b != 0 && b != want[off+i] {
+						t.Errorf("wrong byte at %d: %d", off+i, b)
+						return
+					}
+				}
+			}
+		}(r)
+	}
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := blob.Save(ctx); err != nil {
+				t.Errorf("save error: %v", err)
+				return
+			}
+			_ = blob.Size()
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	if g, e := blob.Size(), uint64(len(want)); g != e {
+		t.Fatalf("wrong size: %d != %d", g, e)
+	}
+	got := make([]byte, len(want))
+	if _, err := blob.IO(ctx).ReadAt(got, 0); err != nil && err != io.EOF {
+		t.Fatalf("read error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("wrong content after concurrent writes")
+	}
+}
+
 func BenchmarkWriteSmall(b *testing.B) {
 	blob := emptyBlob(b, mem.New())
 	ctx := context.Background()
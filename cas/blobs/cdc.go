@@ -0,0 +1,344 @@
+package blobs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+	"math/bits"
+	"sort"
+)
+
+// Content-defined blobs are built once, front to back, by a
+// CDCWriter, and are read only afterwards.
+//
+// Leaves are cut by FastCDC: a gear hash rolls over the data and a
+// leaf ends where its top bits are all zero, with a stricter mask
+// before Avg and a looser one after it to keep sizes near Avg.
+//
+// A pointer chunk is a list of entries
+//
+//	key | end
+//
+// where end is the big endian uint64 offset just past the child,
+// counted from the start of the pointer chunk's own span. Offsets
+// relative to the subtree keep identical subtrees identical wherever
+// they are in the blob, and the ends are sorted, so ReadAt binary
+// searches each level. Pointer chunks end after a child whose key
+// hits a content-defined condition too, so they resynchronize after
+// an insert just like the leaves.
+const (
+	// MinCDCChunkSize is the smallest allowed Chunking.Min.
+	MinCDCChunkSize = 256
+
+	cdcEntrySize = cas.KeySize + 8
+)
+
+var (
+	ErrContentDefined = errors.New("content-defined blobs are read-only, write them with a CDCWriter")
+)
+
+// gear maps bytes to random values for the rolling hash. It defines
+// where chunks are cut, so it must never change.
+var gear = func() (g [256]uint64) {
+	// splitmix64 with a fixed seed
+	x := uint64(0x6c696673)
+	for i := range g {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		g[i] = z ^ (z >> 31)
+	}
+	return g
+}()
+
+func validChunking(c Chunking) bool {
+	switch c.Algorithm {
+	case ChunkingFixed:
+		return true
+	case ChunkingFastCDC:
+		return MinCDCChunkSize <= c.Min && c.Min <= c.Avg && c.Avg <= c.Max
+	}
+	return false
+}
+
+type chunker struct {
+	min, avg, max int
+	// masks of the top bits of the hash, stricter before avg
+	maskS, maskL uint64
+}
+
+func newChunker(c Chunking) *chunker {
+	b := bits.Len32(c.Avg) - 1
+	return &chunker{
+		min:   int(c.Min),
+		avg:   int(c.Avg),
+		max:   int(c.Max),
+		maskS: ^uint64(0) << (64 - min(b+2, 63)),
+		maskL: ^uint64(0) << (64 - max(b-2, 1)),
+	}
+}
+
+// cut returns the length of the first chunk of data. Unless data is
+// at least max long, a result of len(data) means no cut point was
+// found.
+func (c *chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+	n = min(n, c.max)
+	normal := min(c.avg, n)
+	var h uint64
+	i := c.min
+	for ; i < normal; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+type cdcEntry struct {
+	key  cas.Key
+	size uint64
+}
+
+// CDCWriter streams data into a new content-defined blob.
+type CDCWriter struct {
+	ctx     context.Context
+	pending pending
+	m       Manifest
+	chunker *chunker
+	buf     []byte
+	// levels[i] are the pending entries pointing to chunks of level i;
+	// closed[i] is set once they make a complete pointer chunk
+	levels [][]cdcEntry
+	closed []bool
+	err    error
+}
+
+var _ io.Writer = (*CDCWriter)(nil)
+
+// NewCDCWriter returns a writer for a new blob described by manifest,
+// which must use content-defined chunking. Its Root and Size are
+// ignored.
+func NewCDCWriter(ctx context.Context, chunkStore store.IF, manifest *Manifest) (*CDCWriter, error) {
+	m := *manifest
+	if m.Type == "" {
+		return nil, ErrMissingType
+	}
+	if !m.Chunking.IsContentDefined() || !validChunking(m.Chunking) {
+		return nil, BadChunkingError{m.Chunking}
+	}
+	if m.Fanout < 2 {
+		return nil, SmallFanoutError{m.Fanout}
+	}
+	m.Root = cas.Empty
+	m.Size = 0
+	m.Depth = 0
+	return &CDCWriter{
+		ctx:     ctx,
+		pending: pending{store: chunkStore},
+		m:       m,
+		chunker: newChunker(m.Chunking),
+	}, nil
+}
+
+func (w *CDCWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= w.chunker.max {
+		n := w.chunker.cut(w.buf)
+		if err := w.emitLeaf(w.buf[:n]); err != nil {
+			w.err = err
+			return 0, err
+		}
+		w.buf = w.buf[n:]
+	}
+	// keep the buffer from creeping along its backing array forever
+	if cap(w.buf) > 2*w.chunker.max && len(w.buf) < w.chunker.max {
+		w.buf = append([]byte(nil), w.buf...)
+	}
+	w.m.Size += uint64(len(p))
+	return len(p), nil
+}
+
+func (w *CDCWriter) emitLeaf(data []byte) error {
+	// the store may keep the buffer
+	leaf := chunks.MakeChunk(w.m.Type, 0, append([]byte(nil), data...))
+	key, err := w.pending.add(w.ctx, leaf)
+	if err != nil {
+		return err
+	}
+	return w.push(0, cdcEntry{key: key, size: uint64(len(data))})
+}
+
+// endsGroup reports whether a pointer chunk ends after the entry with
+// this key; on average every Fanout/2 entries.
+func (w *CDCWriter) endsGroup(key cas.Key) bool {
+	avg := uint64(max(w.m.Fanout/2, 1))
+	return binary.BigEndian.Uint64(key.Bytes()[:8])%avg == 0
+}
+
+func (w *CDCWriter) push(level int, e cdcEntry) error {
+	for len(w.levels) <= level {
+		w.levels = append(w.levels, nil)
+		w.closed = append(w.closed, false)
+	}
+	// a complete group is only written once another entry follows it,
+	// so the last group of a level is left for Save to decide on
+	if w.closed[level] {
+		if err := w.flush(level); err != nil {
+			return err
+		}
+	}
+	w.levels[level] = append(w.levels[level], e)
+	w.closed[level] = len(w.levels[level]) >= int(w.m.Fanout) || w.endsGroup(e.key)
+	return nil
+}
+
+// flush turns the pending entries of level into a pointer chunk and
+// pushes that one level up.
+func (w *CDCWriter) flush(level int) error {
+	entries := w.levels[level]
+	w.levels[level] = nil
+	w.closed[level] = false
+	buf := make([]byte, 0, len(entries)*cdcEntrySize)
+	var end uint64
+	for _, e := range entries {
+		end += e.size
+		buf = append(buf, e.key.Bytes()...)
+		buf = binary.BigEndian.AppendUint64(buf, end)
+	}
+	key, err := w.pending.add(w.ctx, chunks.MakeChunk(w.m.Type, uint8(level+1), buf))
+	if err != nil {
+		return err
+	}
+	return w.push(level+1, cdcEntry{key: key, size: end})
+}
+
+// Save writes the remaining data and the pointer chunks above it, and
+// returns the manifest of the blob. The writer must not be used
+// afterwards.
+func (w *CDCWriter) Save() (*Manifest, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	for len(w.buf) > 0 {
+		n := w.chunker.cut(w.buf)
+		if err := w.emitLeaf(w.buf[:n]); err != nil {
+			return nil, err
+		}
+		w.buf = w.buf[n:]
+	}
+	for level := 0; level < len(w.levels); level++ {
+		entries := w.levels[level]
+		if level == len(w.levels)-1 && len(entries) == 1 {
+			if level > 255 {
+				return nil, fmt.Errorf("content-defined blob too deep: %d", level)
+			}
+			w.m.Root = entries[0].key
+			w.m.Depth = uint8(level)
+			break
+		}
+		if len(entries) > 0 {
+			if err := w.flush(level); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := w.pending.flush(w.ctx); err != nil {
+		return nil, err
+	}
+	w.err = errors.New("CDCWriter already saved")
+	m := w.m
+	return &m, nil
+}
+
+// cdcChild finds the child of a pointer chunk containing offset off,
+// relative to the chunk's span, and returns its key and the offset of
+// its start.
+func cdcChild(buf []byte, off uint64) (cas.Key, uint64, error) {
+	n := len(buf) / cdcEntrySize
+	end := func(i int) uint64 {
+		return binary.BigEndian.Uint64(buf[i*cdcEntrySize+cas.KeySize:])
+	}
+	i := sort.Search(n, func(i int) bool { return end(i) > off })
+	if i == n {
+		return cas.Invalid, 0, fmt.Errorf("offset %d beyond pointer chunk of %d entries", off, n)
+	}
+	var start uint64
+	if i > 0 {
+		start = end(i - 1)
+	}
+	key := cas.NewKeyPrivate(buf[i*cdcEntrySize : i*cdcEntrySize+cas.KeySize])
+	if key.IsPrivate() || key.IsReserved() {
+		return cas.Invalid, 0, fmt.Errorf("invalid stored key in pointer chunk: %v", key)
+	}
+	return key, start, nil
+}
+
+// lookupCDC returns the leaf containing off and the offset of its
+// start, walking down from the root in O(depth * log(fanout)).
+func (blob *Blob) lookupCDC(ctx context.Context, off uint64) (*chunks.Chunk, uint64, error) {
+	key := blob.m.Root
+	var base uint64
+	for level := blob.depth; level > 0; level-- {
+		chunk, err := blob.stash.Get(ctx, key, blob.m.Type, level)
+		if err != nil {
+			return nil, 0, err
+		}
+		child, start, err := cdcChild(chunk.Buf, off-base)
+		if err != nil {
+			return nil, 0, err
+		}
+		key = child
+		base += start
+	}
+	chunk, err := blob.stash.Get(ctx, key, blob.m.Type, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return chunk, base, nil
+}
+
+func (blob *Blob) readAtCDC(ctx context.Context, p []byte, off uint64) (n int, err error) {
+	for len(p) > 0 {
+		if off >= blob.m.Size {
+			return n, io.EOF
+		}
+		if uint64(len(p)) > blob.m.Size-off {
+			p = p[:int(blob.m.Size-off)]
+		}
+		leaf, start, err := blob.lookupCDC(ctx, off)
+		if err != nil {
+			return n, err
+		}
+		if off-start >= uint64(len(leaf.Buf)) {
+			return n, fmt.Errorf("leaf at %d is short: %d bytes", start, len(leaf.Buf))
+		}
+		copied := copy(p, leaf.Buf[off-start:])
+		n += copied
+		p = p[copied:]
+		off += uint64(copied)
+	}
+	if off >= blob.m.Size {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,214 @@
+package blobs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+	"lifs_go/cas/store/mem"
+	"math/rand"
+	"testing"
+)
+
+// recording remembers the keys and sizes of the chunks added to it.
+type recording struct {
+	store.IF
+	leaves map[cas.Key]int
+	ptrs   int
+}
+
+func newRecording() *recording {
+	return &recording{IF: mem.New(), leaves: make(map[cas.Key]int)}
+}
+
+func (r *recording) Add(ctx context.Context, c *chunks.Chunk) (cas.Key, error) {
+	key, err := r.IF.Add(ctx, c)
+	if err == nil {
+		if c.Level == 0 {
+			r.leaves[key] = len(c.Buf)
+		} else {
+			r.ptrs++
+		}
+	}
+	return key, err
+}
+
+func smallCDCManifest() *blobs.Manifest {
+	return &blobs.Manifest{
+		Type:   "footype",
+		Fanout: 4,
+		Chunking: blobs.Chunking{
+			Algorithm: blobs.ChunkingFastCDC,
+			Min:       1024,
+			Avg:       4096,
+			Max:       16384,
+		},
+	}
+}
+
+func writeCDC(t *testing.T, chunkStore store.IF, m *blobs.Manifest, data []byte) *blobs.Manifest {
+	t.Helper()
+	w, err := blobs.NewCDCWriter(context.Background(), chunkStore, m)
+	if err != nil {
+		t.Fatalf("NewCDCWriter fail: %v", err)
+	}
+	// uneven writes, so cut points do not depend on write sizes
+	rnd := rand.New(rand.NewSource(int64(len(data))))
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 1+rnd.Intn(10000))
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write fail: %v", err)
+		}
+		rest = rest[n:]
+	}
+	saved, err := w.Save()
+	if err != nil {
+		t.Fatalf("Save fail: %v", err)
+	}
+	return saved
+}
+
+func randomData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestCDCWriteAndRead(t *testing.T) {
+	chunkStore := newRecording()
+	ctx := context.Background()
+	data := randomData(1<<20+123, 1)
+	saved := writeCDC(t, chunkStore, smallCDCManifest(), data)
+	if g, e := saved.Size, uint64(len(data)); g != e {
+		t.Errorf("wrong size: %d != %d", g, e)
+	}
+	if saved.Depth < 2 {
+		t.Errorf("expected a multi-level tree: depth %d", saved.Depth)
+	}
+
+	short := 0
+	for _, size := range chunkStore.leaves {
+		if size > 16384 {
+			t.Errorf("leaf larger than Max: %d", size)
+		}
+		if size < 1024 {
+			short++
+		}
+	}
+	// only the last leaf may be short
+	if short > 1 {
+		t.Errorf("%d leaves smaller than Min", short)
+	}
+
+	blob, err := blobs.Open(chunkStore, saved)
+	if err != nil {
+		t.Fatalf("Open fail: %v", err)
+	}
+	all := make([]byte, len(data)+1)
+	n, err := blob.IO(ctx).ReadAt(all, 0)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected read EOF: %v", err)
+	}
+	if !bytes.Equal(all[:n], data) {
+		t.Fatalf("read data differs")
+	}
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		off := rnd.Intn(len(data))
+		buf := make([]byte, rnd.Intn(40000))
+		n, err := blob.IO(ctx).ReadAt(buf, int64(off))
+		if err != nil && !errors.Is(err, io.EOF) {
+			t.Fatalf("ReadAt fail: %v", err)
+		}
+		if !bytes.Equal(buf[:n], data[off:off+n]) {
+			t.Fatalf("ReadAt %d gave wrong data", off)
+		}
+		if n < len(buf) && off+n != len(data) {
+			t.Fatalf("short ReadAt at %d: %d", off, n)
+		}
+	}
+}
+
+func TestCDCDedup(t *testing.T) {
+	data := randomData(1<<20, 3)
+	edited := append(append(append([]byte(nil), data[:1000]...), 'x'), data[1000:]...)
+
+	first, second := newRecording(), newRecording()
+	writeCDC(t, first, smallCDCManifest(), data)
+	writeCDC(t, second, smallCDCManifest(), edited)
+	shared := 0
+	for key := range second.leaves {
+		if _, ok := first.leaves[key]; ok {
+			shared++
+		}
+	}
+	if shared < len(second.leaves)-3 {
+		t.Errorf("insert changed too many leaves: %d of %d shared", shared, len(second.leaves))
+	}
+}
+
+func TestCDCDeterministic(t *testing.T) {
+	data := randomData(300000, 4)
+	a := writeCDC(t, mem.New(), smallCDCManifest(), data)
+	b := writeCDC(t, mem.New(), smallCDCManifest(), data)
+	if a.Root != b.Root {
+		t.Errorf("same data gave different roots: %v != %v", a.Root, b.Root)
+	}
+}
+
+func TestCDCEmptyAndSmall(t *testing.T) {
+	ctx := context.Background()
+	for _, data := range [][]byte{nil, []byte("tiny")} {
+		chunkStore := mem.New()
+		saved := writeCDC(t, chunkStore, smallCDCManifest(), data)
+		if g, e := saved.Depth, uint8(0); g != e {
+			t.Errorf("wrong depth: %d != %d", g, e)
+		}
+		blob, err := blobs.Open(chunkStore, saved)
+		if err != nil {
+			t.Fatalf("Open fail: %v", err)
+		}
+		buf := make([]byte, 10)
+		n, err := blob.IO(ctx).ReadAt(buf, 0)
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("expected read EOF: %v", err)
+		}
+		if !bytes.Equal(buf[:n], data) {
+			t.Errorf("read wrong data: %q != %q", buf[:n], data)
+		}
+	}
+}
+
+func TestCDCReadOnly(t *testing.T) {
+	chunkStore := mem.New()
+	ctx := context.Background()
+	saved := writeCDC(t, chunkStore, smallCDCManifest(), []byte("data"))
+	blob, err := blobs.Open(chunkStore, saved)
+	if err != nil {
+		t.Fatalf("Open fail: %v", err)
+	}
+	if _, err := blob.IO(ctx).WriteAt([]byte("x"), 0); !errors.Is(err, blobs.ErrContentDefined) {
+		t.Errorf("WriteAt should fail: %v", err)
+	}
+	if err := blob.Truncate(ctx, 0); !errors.Is(err, blobs.ErrContentDefined) {
+		t.Errorf("Truncate should fail: %v", err)
+	}
+}
+
+func TestCDCBadChunking(t *testing.T) {
+	m := smallCDCManifest()
+	m.Chunking.Avg = m.Chunking.Max * 2
+	_, err := blobs.NewCDCWriter(context.Background(), mem.New(), m)
+	var bad blobs.BadChunkingError
+	if !errors.As(err, &bad) {
+		t.Errorf("expected BadChunkingError: %v", err)
+	}
+	m.Chunking.Algorithm = "other"
+	if _, err := blobs.Open(mem.New(), m); !errors.As(err, &bad) {
+		t.Errorf("expected BadChunkingError: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"lifs_go/cas"
+)
+
+// SetDirtyLimit bounds the memory held by modified chunks that are not
+// saved yet. Once writes take more than limit bytes, the modified
+// leaves are added to the store early, and the pointer chunks above
+// them refer to the stored keys from then on; writing such a leaf
+// again reads it back from the store. Zero or less means no limit,
+// which is the default.
+//
+// Early saved leaves that are modified or cut off again before Save
+// are left in the store, unreferenced.
+func (blob *Blob) SetDirtyLimit(limit int64) {
+	blob.mu.Lock()
+	defer blob.mu.Unlock()
+	blob.dirtyLimit = limit
+}
+
+// Dirty returns the number of bytes held by modified chunks that are
+// not saved yet.
+func (blob *Blob) Dirty() int64 {
+	blob.mu.RLock()
+	defer blob.mu.RUnlock()
+	return blob.stash.Dirty()
+}
+
+// limitDirty saves modified chunks if over the dirty limit, except the
+// path to the leaf at offset keep.
+func (blob *Blob) limitDirty(ctx context.Context, keep uint64) error {
+	if blob.dirtyLimit <= 0 || blob.stash.Dirty() <= blob.dirtyLimit {
+		return nil
+	}
+	if blob.depth == 0 || !blob.m.Root.IsPrivate() {
+		// only the leaf being written is dirty
+		return nil
+	}
+	localIds := localChunkIndexes(blob.m.Fanout, uint32(keep/uint64(blob.m.ChunkSize)))
+	var s saving
+	if err := blob.saveExcept(ctx, blob.m.Root, blob.depth, localIds, &s); err != nil {
+		return err
+	}
+	return blob.commit(ctx, &s)
+}
+
+// saveExcept saves the Private children of the Private pointer chunk
+// key, except the one on the path given by localIds, which it
+// recurses into.
+func (blob *Blob) saveExcept(ctx context.Context, key cas.Key, level uint8, localIds []uint32, s *saving) error {
+	chunk, err := blob.stash.Get(ctx, key, blob.m.Type, level)
+	if err != nil {
+		return err
+	}
+	var keepIdx uint32
+	if int(level)-1 < len(localIds) {
+		keepIdx = localIds[level-1]
+	}
+	for idx := uint32(0); (idx+1)*cas.KeySize <= uint32(len(chunk.Buf)); idx++ {
+		keyBuf := chunk.Buf[idx*cas.KeySize : (idx+1)*cas.KeySize]
+		child := cas.NewKeyPrivate(keyBuf)
+		if child.IsReserved() {
+			return fmt.Errorf("invalid stored key: key @%d in %v is %v", idx*cas.KeySize, key, keyBuf)
+		}
+		if !child.IsPrivate() {
+			continue
+		}
+		if idx == keepIdx {
+			if level > 1 {
+				if err := blob.saveExcept(ctx, child, level-1, localIds, s); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// saves the whole subtree
+		saved, err := blob.saveChunk(ctx, child, level-1, s)
+		if err != nil {
+			return err
+		}
+		s.patch(keyBuf, saved)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package blobs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store/mem"
+	"testing"
+)
+
+type writeOp struct {
+	data []byte
+	off  int64
+}
+
+// writeBlob applies ops to a new blob with the given dirty limit and
+// returns the saved manifest and the highest Dirty seen.
+func writeBlob(t *testing.T, limit int64, ops []writeOp) (*blobs.Manifest, int64) {
+	t.Helper()
+	ctx := context.Background()
+	blob, err := blobs.Open(mem.New(), &blobs.Manifest{
+		Type:      "footype",
+		ChunkSize: blobs.MinChunkSize,
+		Fanout:    4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob.SetDirtyLimit(limit)
+	var peak int64
+	for _, op := range ops {
+		if _, err := blob.IO(ctx).WriteAt(op.data, op.off); err != nil {
+			t.Fatalf("WriteAt fail: %v", err)
+		}
+		peak = max(peak, blob.Dirty())
+	}
+	saved, err := blob.Save(ctx)
+	if err != nil {
+		t.Fatalf("Save fail: %v", err)
+	}
+	if g, e := blob.Dirty(), int64(0); g != e {
+		t.Errorf("dirty after Save: %d != %d", g, e)
+	}
+	return saved, peak
+}
+
+func TestDirtyLimit(t *testing.T) {
+	const leaves = 100
+	data := randomData(leaves*blobs.MinChunkSize, 12)
+	var ops []writeOp
+	for i := 0; i < leaves; i++ {
+		// uneven writes, crossing leaves
+		off := i * blobs.MinChunkSize
+		ops = append(ops, writeOp{data[off : off+100], int64(off)})
+		ops = append(ops, writeOp{data[off+100 : off+blobs.MinChunkSize], int64(off + 100)})
+	}
+	// rewrite leaves that were saved early, and one partly
+	ops = append(ops, writeOp{[]byte("rewritten"), 10})
+	ops = append(ops, writeOp{[]byte("again"), 5*blobs.MinChunkSize - 2})
+	ops = append(ops, writeOp{bytes.Repeat([]byte("x"), blobs.MinChunkSize), 40 * blobs.MinChunkSize})
+
+	want, unlimited := writeBlob(t, 0, ops)
+	const limit = 4 * blobs.MinChunkSize
+	got, peak := writeBlob(t, limit, ops)
+	if g, e := got.Root, want.Root; g != e {
+		t.Errorf("dirty limit changed the blob: %v != %v", &g, &e)
+	}
+	if unlimited < leaves*blobs.MinChunkSize {
+		t.Errorf("unlimited blob should keep all leaves: %d", unlimited)
+	}
+	// the limit may be exceeded by one leaf and the pointer chunks of
+	// its path
+	if slack := int64(blobs.MinChunkSize + 4*4*cas.KeySize); peak > limit+slack {
+		t.Errorf("dirty limit not kept: %d > %d", peak, limit+slack)
+	}
+}
+
+func TestDirtyLimitTruncate(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := mem.New()
+	blob, err := blobs.Open(chunkStore, &blobs.Manifest{
+		Type:      "footype",
+		ChunkSize: blobs.MinChunkSize,
+		Fanout:    4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob.SetDirtyLimit(blobs.MinChunkSize)
+	data := randomData(30*blobs.MinChunkSize, 13)
+	if _, err := blob.IO(ctx).WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	// cut into early saved leaves, then grow over them again
+	if err := blob.Truncate(ctx, 3*blobs.MinChunkSize+10); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.Truncate(ctx, 20*blobs.MinChunkSize); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := blob.Save(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err = blobs.Open(chunkStore, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, saved.Size)
+	if _, err := blob.IO(ctx).ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+	want := make([]byte, 20*blobs.MinChunkSize)
+	copy(want, data[:3*blobs.MinChunkSize+10])
+	if !bytes.Equal(buf, want) {
+		t.Errorf("wrong data after truncate")
+	}
+}
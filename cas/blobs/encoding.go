@@ -0,0 +1,284 @@
+package blobs
+
+import (
+	"context"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+)
+
+// The binary form of a manifest is
+//
+//	magic "lm" | version | type | root | size | chunk size | fanout | field*
+//
+// where type is a uvarint length and the bytes, root is the 64 bytes
+// of the key, and the numbers are uvarints. Fields other than those
+// every manifest has follow as
+//
+//	tag | length | payload
+//
+// with tag and length uvarints, in increasing tag order and only when
+// not at their zero value, so that equal manifests encode to equal
+// bytes. Readers skip fields with an even tag they do not know; odd
+// tags mark fields that change how the blob is read, and a reader that
+// does not know one must fail.
+//
+// Tags:
+//
+//	1: chunking, as algorithm | min | avg | max | depth
+//
+// Future fields, such as compression or encryption parameters of the
+// chunks, get the next free tag of the right parity.
+const (
+	manifestVersion = 1
+
+	tagChunking = 1
+)
+
+var manifestMagic = [2]byte{'l', 'm'}
+
+// ManifestType is the chunk type of manifests saved with SaveManifest.
+const ManifestType = "manifest"
+
+var _ encoding.BinaryMarshaler = (*Manifest)(nil)
+var _ encoding.BinaryUnmarshaler = (*Manifest)(nil)
+var _ json.Marshaler = (*Manifest)(nil)
+var _ json.Unmarshaler = (*Manifest)(nil)
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// MarshalBinary encodes a valid manifest in the binary form.
+func (m *Manifest) MarshalBinary() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	b := append(manifestMagic[:], manifestVersion)
+	b = appendString(b, m.Type)
+	b = append(b, m.Root.Bytes()...)
+	b = binary.AppendUvarint(b, m.Size)
+	b = binary.AppendUvarint(b, uint64(m.ChunkSize))
+	b = binary.AppendUvarint(b, uint64(m.Fanout))
+
+	if m.Chunking.IsContentDefined() {
+		var f []byte
+		f = appendString(f, m.Chunking.Algorithm)
+		f = binary.AppendUvarint(f, uint64(m.Chunking.Min))
+		f = binary.AppendUvarint(f, uint64(m.Chunking.Avg))
+		f = binary.AppendUvarint(f, uint64(m.Chunking.Max))
+		f = append(f, m.Depth)
+		b = binary.AppendUvarint(b, tagChunking)
+		b = binary.AppendUvarint(b, uint64(len(f)))
+		b = append(b, f...)
+	}
+	return b, nil
+}
+
+// manifestDecoder reads the binary form, remembering the first error.
+type manifestDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *manifestDecoder) fail(reason string) {
+	if d.err == nil {
+		d.err = BadManifestError{Reason: reason}
+	}
+}
+
+func (d *manifestDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("truncated number")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *manifestDecoder) uint32() uint32 {
+	v := d.uvarint()
+	if v > 1<<32-1 {
+		d.fail("number out of range")
+	}
+	return uint32(v)
+}
+
+func (d *manifestDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.fail("truncated")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *manifestDecoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+// UnmarshalBinary decodes the binary form and validates the result.
+func (m *Manifest) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || [2]byte(data[:2]) != manifestMagic {
+		return BadManifestError{Reason: "not a manifest"}
+	}
+	if data[2] != manifestVersion {
+		return BadManifestError{Reason: fmt.Sprintf("unknown version %d", data[2])}
+	}
+	d := &manifestDecoder{buf: data[3:]}
+	var n Manifest
+	n.Type = d.string()
+	if root := d.bytes(cas.KeySize); d.err == nil {
+		n.Root = cas.NewKey(root)
+		if n.Root == cas.Invalid {
+			d.fail("invalid root key")
+		}
+	}
+	n.Size = d.uvarint()
+	n.ChunkSize = d.uint32()
+	n.Fanout = d.uint32()
+
+	lastTag := uint64(0)
+	for d.err == nil && len(d.buf) > 0 {
+		tag := d.uvarint()
+		field := &manifestDecoder{buf: d.bytes(d.uvarint())}
+		if d.err != nil {
+			break
+		}
+		if tag <= lastTag {
+			d.fail("fields out of order")
+			break
+		}
+		lastTag = tag
+		switch tag {
+		case tagChunking:
+			n.Chunking.Algorithm = field.string()
+			n.Chunking.Min = field.uint32()
+			n.Chunking.Avg = field.uint32()
+			n.Chunking.Max = field.uint32()
+			if depth := field.bytes(1); field.err == nil {
+				n.Depth = depth[0]
+			}
+			if field.err != nil {
+				d.err = field.err
+			}
+		default:
+			if tag%2 == 1 {
+				d.fail(fmt.Sprintf("unknown required field %d", tag))
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if err := n.Validate(); err != nil {
+		return err
+	}
+	*m = n
+	return nil
+}
+
+// manifestJSON is the JSON form of a manifest, for people to read.
+type manifestJSON struct {
+	Version   int       `json:"version"`
+	Type      string    `json:"type"`
+	Root      string    `json:"root"`
+	Size      uint64    `json:"size"`
+	ChunkSize uint32    `json:"chunk_size,omitempty"`
+	Fanout    uint32    `json:"fanout"`
+	Chunking  *Chunking `json:"chunking,omitempty"`
+	Depth     uint8     `json:"depth,omitempty"`
+}
+
+// MarshalJSON encodes a valid manifest as JSON, with the root key in
+// hex.
+func (m *Manifest) MarshalJSON() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	j := manifestJSON{
+		Version:   manifestVersion,
+		Type:      m.Type,
+		Root:      m.Root.String(),
+		Size:      m.Size,
+		ChunkSize: m.ChunkSize,
+		Fanout:    m.Fanout,
+	}
+	if m.Chunking.IsContentDefined() {
+		c := m.Chunking
+		j.Chunking = &c
+		j.Depth = m.Depth
+	}
+	return json.Marshal(&j)
+}
+
+// UnmarshalJSON decodes the JSON form and validates the result.
+func (m *Manifest) UnmarshalJSON(data []byte) error {
+	var j manifestJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Version != manifestVersion {
+		return BadManifestError{Reason: fmt.Sprintf("unknown version %d", j.Version)}
+	}
+	root, err := hex.DecodeString(j.Root)
+	if err != nil || len(root) != cas.KeySize {
+		return BadManifestError{Reason: fmt.Sprintf("bad root key %q", j.Root)}
+	}
+	n := Manifest{
+		Type:      j.Type,
+		Root:      cas.NewKey(root),
+		Size:      j.Size,
+		ChunkSize: j.ChunkSize,
+		Fanout:    j.Fanout,
+		Depth:     j.Depth,
+	}
+	if n.Root == cas.Invalid {
+		return BadManifestError{Reason: "invalid root key"}
+	}
+	if j.Chunking != nil {
+		n.Chunking = *j.Chunking
+	}
+	if err := n.Validate(); err != nil {
+		return err
+	}
+	*m = n
+	return nil
+}
+
+// SaveManifest adds the binary form of m to the store as a chunk of
+// type ManifestType, and returns its key.
+func SaveManifest(ctx context.Context, chunkStore store.IF, m *Manifest) (cas.Key, error) {
+	buf, err := m.MarshalBinary()
+	if err != nil {
+		return cas.Invalid, err
+	}
+	return chunkStore.Add(ctx, chunks.MakeChunk(ManifestType, 0, buf))
+}
+
+// LoadManifest reads a manifest saved with SaveManifest.
+func LoadManifest(ctx context.Context, chunkStore store.IF, key cas.Key) (*Manifest, error) {
+	chunk, err := chunkStore.Get(ctx, key, ManifestType, 0)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := m.UnmarshalBinary(chunk.Buf); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
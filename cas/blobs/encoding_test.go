@@ -0,0 +1,150 @@
+package blobs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store/mem"
+	"reflect"
+	"testing"
+)
+
+func testManifests() []*blobs.Manifest {
+	cdc := blobs.EmptyCDCManifest("cdctype")
+	cdc.Root = cas.NewKey(bytes.Repeat([]byte("borketyBorkBORK!"), 4))
+	cdc.Size = 1 << 40
+	cdc.Depth = 3
+	fixed := blobs.EmptyManifest("footype")
+	fixed.Root = cas.NewKey(bytes.Repeat([]byte("0123456789abcdef"), 4))
+	fixed.Size = 12345
+	return []*blobs.Manifest{blobs.EmptyManifest("empty"), fixed, cdc}
+}
+
+func TestManifestBinary(t *testing.T) {
+	for _, m := range testManifests() {
+		buf, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary fail: %v", err)
+		}
+		again, err := m.MarshalBinary()
+		if err != nil || !bytes.Equal(buf, again) {
+			t.Errorf("encoding is not stable: %x != %x", buf, again)
+		}
+		var got blobs.Manifest
+		if err := got.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("UnmarshalBinary fail: %v", err)
+		}
+		if !reflect.DeepEqual(&got, m) {
+			t.Errorf("binary round trip changed manifest: %+v != %+v", got, *m)
+		}
+		// every cut is detected
+		for i := 0; i < len(buf); i++ {
+			var cut blobs.Manifest
+			if err := cut.UnmarshalBinary(buf[:i]); err == nil {
+				t.Errorf("truncated manifest decoded: %d of %d bytes", i, len(buf))
+			}
+		}
+	}
+}
+
+func TestManifestFields(t *testing.T) {
+	m := blobs.EmptyManifest("footype")
+	buf, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got blobs.Manifest
+	// an unknown optional field is skipped
+	if err := got.UnmarshalBinary(append(append([]byte(nil), buf...), 2, 3, 'a', 'b', 'c')); err != nil {
+		t.Errorf("unknown optional field should be skipped: %v", err)
+	}
+	// an unknown required field is not
+	err = got.UnmarshalBinary(append(append([]byte(nil), buf...), 3, 1, 'a'))
+	var bad blobs.BadManifestError
+	if !errors.As(err, &bad) {
+		t.Errorf("expected BadManifestError: %v", err)
+	}
+	// neither is a newer version
+	newer := append([]byte(nil), buf...)
+	newer[2]++
+	if err := got.UnmarshalBinary(newer); !errors.As(err, &bad) {
+		t.Errorf("expected BadManifestError: %v", err)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	m := blobs.EmptyManifest("footype")
+	m.Fanout = 1
+	var fanout blobs.SmallFanoutError
+	if _, err := m.MarshalBinary(); !errors.As(err, &fanout) {
+		t.Errorf("expected SmallFanoutError: %v", err)
+	}
+
+	// decoding checks too
+	m = blobs.EmptyManifest("footype")
+	buf, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := append([]byte(nil), buf...)
+	// chunk size follows magic, version, type, root and size
+	i := 3 + 1 + len("footype") + cas.KeySize + 1
+	n := len(binary.AppendUvarint(nil, uint64(m.ChunkSize)))
+	small = append(append(small[:i:i], 10), buf[i+n:]...)
+	var got blobs.Manifest
+	var size blobs.SmallChunkSizeError
+	if err := got.UnmarshalBinary(small); !errors.As(err, &size) {
+		t.Errorf("expected SmallChunkSizeError: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"version":1,"type":"x","root":"`+cas.Empty.String()+`","fanout":1,"chunk_size":4096}`), &got); !errors.As(err, &fanout) {
+		t.Errorf("expected SmallFanoutError: %v", err)
+	}
+}
+
+func TestManifestJSON(t *testing.T) {
+	for _, m := range testManifests() {
+		buf, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("json.Marshal fail: %v", err)
+		}
+		var got blobs.Manifest
+		if err := json.Unmarshal(buf, &got); err != nil {
+			t.Fatalf("json.Unmarshal fail: %v: %s", err, buf)
+		}
+		if !reflect.DeepEqual(&got, m) {
+			t.Errorf("JSON round trip changed manifest: %+v != %+v", got, *m)
+		}
+	}
+	buf, err := json.Marshal(testManifests()[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(buf), `{"version":1,"type":"footype","root":"`+
+		"30313233343536373839616263646566303132333435363738396162636465663031323334353637383961626364656630313233343536373839616263646566"+
+		`","size":12345,"chunk_size":4194304,"fanout":64}`; g != e {
+		t.Errorf("wrong JSON:\n%s\n%s", g, e)
+	}
+}
+
+func TestSaveManifest(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := mem.New()
+	for _, m := range testManifests() {
+		key, err := blobs.SaveManifest(ctx, chunkStore, m)
+		if err != nil {
+			t.Fatalf("SaveManifest fail: %v", err)
+		}
+		got, err := blobs.LoadManifest(ctx, chunkStore, key)
+		if err != nil {
+			t.Fatalf("LoadManifest fail: %v", err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("stored manifest changed: %+v != %+v", *got, *m)
+		}
+	}
+}
@@ -25,3 +25,28 @@ var _ error = SmallFanoutError{}
 func (s SmallFanoutError) Error() string {
 	return fmt.Sprintf("[ErrBlob] Fanout is too small: %d", s.Given)
 }
+
+// BadChunkingError is the error returned from Open if the manifest
+// has an unknown chunking algorithm or inconsistent chunk sizes.
+type BadChunkingError struct {
+	Given Chunking
+}
+
+var _ error = BadChunkingError{}
+
+func (b BadChunkingError) Error() string {
+	return fmt.Sprintf("[ErrBlob] bad chunking: %+v", b.Given)
+}
+
+// BadManifestError is the error returned when decoding a manifest
+// that is malformed, or uses a version or field this code does not
+// know.
+type BadManifestError struct {
+	Reason string
+}
+
+var _ error = BadManifestError{}
+
+func (b BadManifestError) Error() string {
+	return fmt.Sprintf("[ErrBlob] bad manifest: %s", b.Reason)
+}
@@ -25,45 +25,70 @@ func (bio *IO) ReadAt(p []byte, off int64) (n int, err error) {
 	if off < 0 {
 		return 0, errors.New("negative offset is not possible")
 	}
+	if bio.blob.m.Chunking.IsContentDefined() {
+		// never modified, so there is nothing to lock
+		return bio.blob.readAtCDC(bio.ctx, p, uint64(off))
+	}
 	{
 		off := uint64(off)
 		for {
-			if off >= bio.blob.m.Size {
-				return n, io.EOF
-			}
-
-			// avoid reading past EOF
-			if uint64(len(p)) > bio.blob.m.Size-off {
-				p = p[:int(bio.blob.m.Size-off)]
-			}
-
-			if len(p) == 0 {
-				break
-			}
-
-			chunk, err := bio.blob.lookup(bio.ctx, off)
+			copied, err := bio.blob.readLeaf(bio.ctx, p, off)
+			n += copied
 			if err != nil {
 				return n, err
 			}
-
-			loff := uint32(off % uint64(bio.blob.m.ChunkSize))
-			var copied int
-			// TODO ugly int conversion
-			if int(loff) <= len(chunk.Buf) {
-				copied = copy(p, chunk.Buf[loff:])
-			}
-			for len(p) > copied && loff+uint32(copied) < bio.blob.m.ChunkSize {
-				// handle case where chunk has been zero trimmed
-				p[copied] = '\x00'
-				copied++
-			}
-			n += copied
 			p = p[copied:]
 			off += uint64(copied)
+			if len(p) == 0 {
+				break
+			}
 		}
+	}
+	return n, nil
+}
 
+// readLeaf copies to p from the leaf holding off, up to the end of the
+// leaf or of the blob, and returns the count; past the end it returns
+// io.EOF. Private leaves are written in place, so they are copied under
+// the read lock; stored leaves are fetched and copied after letting go
+// of it.
+func (blob *Blob) readLeaf(ctx context.Context, p []byte, off uint64) (int, error) {
+	blob.mu.RLock()
+	if off >= blob.m.Size {
+		blob.mu.RUnlock()
+		return 0, io.EOF
+	}
+	// avoid reading past EOF
+	if uint64(len(p)) > blob.m.Size-off {
+		p = p[:int(blob.m.Size-off)]
+	}
+	key, err := blob.lookupKey(ctx, off)
+	if err != nil {
+		blob.mu.RUnlock()
+		return 0, err
+	}
+	if key.IsPrivate() {
+		defer blob.mu.RUnlock()
+	} else {
+		blob.mu.RUnlock()
+	}
+	chunk, err := blob.stash.Get(ctx, key, blob.m.Type, 0)
+	if err != nil {
+		return 0, err
 	}
-	return n, err
+
+	loff := uint32(off % uint64(blob.m.ChunkSize))
+	var copied int
+	// TODO ugly int conversion
+	if int(loff) <= len(chunk.Buf) {
+		copied = copy(p, chunk.Buf[loff:])
+	}
+	for len(p) > copied && loff+uint32(copied) < blob.m.ChunkSize {
+		// handle case where chunk has been zero trimmed
+		p[copied] = '\x00'
+		copied++
+	}
+	return copied, nil
 }
 
 // WriteAt writes data to the given offset. See io.WriterAt.
@@ -71,6 +96,11 @@ func (bio *IO) WriteAt(p []byte, off int64) (n int, err error) {
 	if off < 0 {
 		return 0, errors.New("negative offset is not possible")
 	}
+	if bio.blob.m.Chunking.IsContentDefined() {
+		return 0, ErrContentDefined
+	}
+	bio.blob.mu.Lock()
+	defer bio.blob.mu.Unlock()
 	{
 		off := uint64(off)
 		for len(p) > 0 {
@@ -92,6 +122,11 @@ func (bio *IO) WriteAt(p []byte, off int64) (n int, err error) {
 			if off > bio.blob.m.Size {
 				bio.blob.m.Size = off
 			}
+
+			// keep the leaf just written, it is likely written again
+			if err := bio.blob.limitDirty(bio.ctx, off-1); err != nil {
+				return n, err
+			}
 		}
 	}
 	return n, nil
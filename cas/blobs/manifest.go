@@ -2,14 +2,62 @@ package blobs
 
 import "lifs_go/cas"
 
+const (
+	// ChunkingFixed splits blobs at multiples of ChunkSize. It is the
+	// zero value, so manifests from before Chunking existed keep it.
+	ChunkingFixed = ""
+	// ChunkingFastCDC splits blobs where a rolling hash of the content
+	// says so, so that an insert only changes the chunks around it.
+	ChunkingFastCDC = "fastcdc"
+)
+
+// Chunking selects how a blob is split into leaf chunks.
+type Chunking struct {
+	Algorithm string `json:"algorithm"`
+	// Bounds of the leaf sizes for content-defined chunking; the
+	// sizes average around Avg. Must satisfy
+	// MinCDCChunkSize <= Min <= Avg <= Max.
+	Min uint32 `json:"min"`
+	Avg uint32 `json:"avg"`
+	Max uint32 `json:"max"`
+}
+
+// IsContentDefined reports whether leaves have variable sizes.
+func (c Chunking) IsContentDefined() bool {
+	return c.Algorithm == ChunkingFastCDC
+}
+
 type Manifest struct {
 	Type string
 	Root cas.Key
 	Size uint64
-	// Must be >= MinChunkSize.
+	// Must be >= MinChunkSize. Unused for content-defined blobs.
 	ChunkSize uint32
-	// Must be >= 2.
+	// Must be >= 2. For content-defined blobs, the maximum number of
+	// entries in a pointer chunk.
 	Fanout uint32
+	// Chunking is the layout of the blob.
+	Chunking Chunking
+	// Depth is the level of Root for content-defined blobs. Fixed
+	// size blobs derive it from Size.
+	Depth uint8
+}
+
+// Validate checks that the fields of m are usable by Open.
+func (m *Manifest) Validate() error {
+	if m.Type == "" {
+		return ErrMissingType
+	}
+	if !validChunking(m.Chunking) {
+		return BadChunkingError{m.Chunking}
+	}
+	if m.ChunkSize < MinChunkSize && !m.Chunking.IsContentDefined() {
+		return SmallChunkSizeError{m.ChunkSize}
+	}
+	if m.Fanout < 2 {
+		return SmallFanoutError{m.Fanout}
+	}
+	return nil
 }
 
 // EmptyManifest returns an empty manifest of the given type with the
@@ -24,3 +72,20 @@ func EmptyManifest(type_ string) *Manifest {
 		Fanout:    64,
 	}
 }
+
+// EmptyCDCManifest returns an empty manifest of the given type for a
+// content-defined blob with the default tuning parameters.
+func EmptyCDCManifest(type_ string) *Manifest {
+	const kB = 1024
+
+	return &Manifest{
+		Type:   type_,
+		Fanout: 64,
+		Chunking: Chunking{
+			Algorithm: ChunkingFastCDC,
+			Min:       16 * kB,
+			Avg:       64 * kB,
+			Max:       256 * kB,
+		},
+	}
+}
@@ -0,0 +1,274 @@
+package blobs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DefaultReadAhead is the number of leaves a Reader fetches ahead of
+// the one being read, unless told otherwise.
+const DefaultReadAhead = 4
+
+// Reader reads a blob front to back, fetching the next leaves
+// concurrently while the current one is consumed. Seeking drops the
+// leaves fetched ahead.
+//
+// The blob must not be modified while a Reader is in use. Close the
+// Reader to stop fetches that are still running.
+type Reader struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	blob      *Blob
+	off       uint64
+	readAhead int
+	cur       *leaf
+	// ahead are the fetches of the leaves after cur, by their start
+	ahead map[uint64]*fetch
+}
+
+var _ io.ReadSeekCloser = (*Reader)(nil)
+var _ io.WriterTo = (*Reader)(nil)
+
+// leaf is a leaf chunk and the span of the blob it covers. Buf may be
+// shorter than the span if the chunk was zero trimmed.
+type leaf struct {
+	buf    []byte
+	start  uint64
+	length uint64
+}
+
+type fetch struct {
+	done chan struct{}
+	leaf *leaf
+	err  error
+}
+
+// NewReader returns a Reader of blob that fetches readAhead leaves
+// ahead; zero means DefaultReadAhead, and a negative number disables
+// read-ahead.
+func NewReader(ctx context.Context, blob *Blob, readAhead int) *Reader {
+	switch {
+	case readAhead == 0:
+		readAhead = DefaultReadAhead
+	case readAhead < 0:
+		readAhead = 0
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Reader{
+		ctx:       ctx,
+		cancel:    cancel,
+		blob:      blob,
+		readAhead: readAhead,
+		ahead:     make(map[uint64]*fetch),
+	}
+}
+
+// leafAt fetches the leaf containing off, which must be within the
+// blob.
+func (blob *Blob) leafAt(ctx context.Context, off uint64) (*leaf, error) {
+	if blob.m.Chunking.IsContentDefined() {
+		chunk, start, err := blob.lookupCDC(ctx, off)
+		if err != nil {
+			return nil, err
+		}
+		return &leaf{buf: chunk.Buf, start: start, length: uint64(len(chunk.Buf))}, nil
+	}
+	chunk, err := blob.lookup(ctx, off)
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(blob.m.ChunkSize)
+	start := off - off%size
+	return &leaf{buf: chunk.Buf, start: start, length: min(size, blob.m.Size-start)}, nil
+}
+
+// nextStarts returns the starts of up to n leaves following the leaf
+// l. For content-defined blobs, only the leaves under the same pointer
+// chunk are known without further reads.
+func (blob *Blob) nextStarts(ctx context.Context, l *leaf, n int) ([]uint64, error) {
+	var starts []uint64
+	if !blob.m.Chunking.IsContentDefined() {
+		for next := l.start + l.length; len(starts) < n && next < blob.m.Size; next += uint64(blob.m.ChunkSize) {
+			starts = append(starts, next)
+		}
+		return starts, nil
+	}
+	if blob.depth == 0 || n == 0 {
+		return nil, nil
+	}
+
+	// find the pointer chunk above l
+	key := blob.m.Root
+	var base uint64
+	for level := blob.depth; level > 1; level-- {
+		chunk, err := blob.stash.Get(ctx, key, blob.m.Type, level)
+		if err != nil {
+			return nil, err
+		}
+		child, start, err := cdcChild(chunk.Buf, l.start-base)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+		base += start
+	}
+	chunk, err := blob.stash.Get(ctx, key, blob.m.Type, 1)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+cdcEntrySize <= len(chunk.Buf) && len(starts) < n; i += cdcEntrySize {
+		// each end is the start of the next leaf
+		end := base + binary.BigEndian.Uint64(chunk.Buf[i+cdcEntrySize-8:])
+		if end >= l.start+l.length && end < blob.m.Size {
+			starts = append(starts, end)
+		}
+	}
+	return starts, nil
+}
+
+func (r *Reader) startFetch(start uint64) *fetch {
+	f := &fetch{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		f.leaf, f.err = r.blob.leafAt(r.ctx, start)
+	}()
+	return f
+}
+
+// leaf returns the leaf containing r.off, which must be within the
+// blob, and keeps the read-ahead going.
+func (r *Reader) leaf() (*leaf, error) {
+	if r.cur != nil && r.off >= r.cur.start && r.off < r.cur.start+r.cur.length {
+		return r.cur, nil
+	}
+	if f, ok := r.ahead[r.off]; ok {
+		delete(r.ahead, r.off)
+		select {
+		case <-f.done:
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		r.cur = f.leaf
+	} else {
+		l, err := r.blob.leafAt(r.ctx, r.off)
+		if err != nil {
+			return nil, err
+		}
+		r.cur = l
+	}
+
+	if r.readAhead == 0 {
+		return r.cur, nil
+	}
+	starts, err := r.blob.nextStarts(r.ctx, r.cur, r.readAhead)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[uint64]*fetch, len(starts))
+	for _, start := range starts {
+		f, ok := r.ahead[start]
+		if !ok {
+			f = r.startFetch(start)
+		}
+		keep[start] = f
+	}
+	// the rest were fetched for before a seek; their results are
+	// dropped
+	r.ahead = keep
+	return r.cur, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.off >= r.blob.m.Size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	l, err := r.leaf()
+	if err != nil {
+		return 0, err
+	}
+	n := l.copy(p, r.off)
+	r.off += uint64(n)
+	return n, nil
+}
+
+// copy copies the data of l from blob offset off on into p.
+func (l *leaf) copy(p []byte, off uint64) int {
+	rel := off - l.start
+	n := int(min(uint64(len(p)), l.length-rel))
+	p = p[:n]
+	copied := 0
+	if rel < uint64(len(l.buf)) {
+		copied = copy(p, l.buf[rel:])
+	}
+	// the trimmed zeroes
+	clear(p[copied:])
+	return n
+}
+
+// WriteTo writes the rest of the blob to w. See io.WriterTo.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	var zeroes []byte
+	for r.off < r.blob.m.Size {
+		l, err := r.leaf()
+		if err != nil {
+			return written, err
+		}
+		rel := r.off - l.start
+		var p []byte
+		if rel < uint64(len(l.buf)) {
+			p = l.buf[rel:min(uint64(len(l.buf)), l.length)]
+		} else {
+			if zeroes == nil {
+				zeroes = make([]byte, r.blob.chunkSizeForLevel(0))
+			}
+			p = zeroes[:l.length-rel]
+		}
+		n, err := w.Write(p)
+		written += int64(n)
+		r.off += uint64(n)
+		if err != nil {
+			return written, err
+		}
+		if n < len(p) {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// Seek sets the offset of the next Read. See io.Seeker.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = int64(r.off) + offset
+	case io.SeekEnd:
+		abs = int64(r.blob.m.Size) + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative offset is not possible")
+	}
+	r.off = uint64(abs)
+	return abs, nil
+}
+
+// Close stops the fetches running ahead. The Reader must not be used
+// afterwards.
+func (r *Reader) Close() error {
+	r.cancel()
+	r.ahead = nil
+	return nil
+}
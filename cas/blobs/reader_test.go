@@ -0,0 +1,185 @@
+package blobs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+	"lifs_go/cas/store/mem"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowStore delays leaf reads and records the highest number of them
+// running at once.
+type slowStore struct {
+	store.IF
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (s *slowStore) Get(ctx context.Context, key cas.Key, type_ string, level uint8) (*chunks.Chunk, error) {
+	if level == 0 {
+		s.mu.Lock()
+		s.running++
+		s.peak = max(s.peak, s.running)
+		s.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		defer func() {
+			s.mu.Lock()
+			s.running--
+			s.mu.Unlock()
+		}()
+	}
+	return s.IF.Get(ctx, key, type_, level)
+}
+
+func writeFixed(t *testing.T, chunkStore store.IF, data []byte) *blobs.Manifest {
+	t.Helper()
+	w, err := blobs.NewWriter(context.Background(), chunkStore, &blobs.Manifest{
+		Type:      "footype",
+		ChunkSize: blobs.MinChunkSize,
+		Fanout:    4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.Manifest()
+}
+
+func TestReader(t *testing.T) {
+	ctx := context.Background()
+	data := randomData(40*blobs.MinChunkSize+123, 9)
+	// a trimmed leaf and a hole
+	clear(data[3*blobs.MinChunkSize-100 : 5*blobs.MinChunkSize])
+	fixedStore := mem.New()
+	fixed := writeFixed(t, fixedStore, data)
+	cdcStore := mem.New()
+	cdc := writeCDC(t, cdcStore, smallCDCManifest(), data)
+
+	for _, c := range []struct {
+		name  string
+		store store.IF
+		m     *blobs.Manifest
+	}{
+		{"fixed", fixedStore, fixed},
+		{"cdc", cdcStore, cdc},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			for _, readAhead := range []int{-1, 0, 8} {
+				blob, err := blobs.Open(c.store, c.m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := blobs.NewReader(ctx, blob, readAhead)
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("ReadAll fail: %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("read ahead %d: read data differs", readAhead)
+				}
+
+				rnd := rand.New(rand.NewSource(10))
+				for i := 0; i < 50; i++ {
+					off := rnd.Intn(len(data))
+					if _, err := r.Seek(int64(off), io.SeekStart); err != nil {
+						t.Fatal(err)
+					}
+					buf := make([]byte, rnd.Intn(3*blobs.MinChunkSize))
+					n, err := io.ReadFull(r, buf)
+					if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+						t.Fatalf("ReadFull fail: %v", err)
+					}
+					if !bytes.Equal(buf[:n], data[off:off+n]) {
+						t.Fatalf("read at %d differs", off)
+					}
+				}
+
+				pos, err := r.Seek(-100, io.SeekEnd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var out bytes.Buffer
+				n, err := r.WriteTo(&out)
+				if err != nil {
+					t.Fatalf("WriteTo fail: %v", err)
+				}
+				if n != 100 || !bytes.Equal(out.Bytes(), data[pos:]) {
+					t.Errorf("WriteTo wrote wrong data: %d", n)
+				}
+				if _, err := r.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+					t.Errorf("expected EOF: %v", err)
+				}
+				if err := r.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := mem.New()
+	blob, err := blobs.Open(chunkStore, writeFixed(t, chunkStore, []byte("0123456789")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := blobs.NewReader(ctx, blob, 0)
+	defer r.Close()
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("negative seek should fail")
+	}
+	if pos, err := r.Seek(4, io.SeekStart); err != nil || pos != 4 {
+		t.Fatalf("Seek fail: %d %v", pos, err)
+	}
+	if pos, err := r.Seek(2, io.SeekCurrent); err != nil || pos != 6 {
+		t.Fatalf("Seek fail: %d %v", pos, err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || string(rest) != "6789" {
+		t.Errorf("wrong data after seek: %q %v", rest, err)
+	}
+	if _, err := r.Seek(20, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF past the end: %v", err)
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	ctx := context.Background()
+	slow := &slowStore{IF: mem.New()}
+	data := randomData(20*blobs.MinChunkSize, 11)
+	blob, err := blobs.Open(slow, writeFixed(t, slow, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := blobs.NewReader(ctx, blob, 4)
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read data differs")
+	}
+	if slow.peak < 2 {
+		t.Errorf("leaves were not fetched concurrently: peak %d", slow.peak)
+	}
+}
@@ -0,0 +1,154 @@
+package blobs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/store"
+	"math"
+)
+
+// SkipChildren is returned by a WalkFunc to not descend into the
+// children of the pointer chunk it was called for.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is called by Walk for every chunk of a blob, pointer chunks
+// before the chunks they point to.
+type WalkFunc func(key cas.Key, level uint8) error
+
+// Walk calls fn for the stored chunks of the blob described by
+// manifest, in depth-first order. Empty chunks, which are never
+// stored, are skipped. Both fixed size and content-defined blobs are
+// understood.
+//
+// Walking stops at the first error, which is returned; a missing
+// pointer chunk is an error too, so a nil result means every chunk
+// was visited.
+func Walk(ctx context.Context, chunkStore store.IF, manifest *Manifest, fn WalkFunc) error {
+	blob, err := Open(chunkStore, manifest)
+	if err != nil {
+		return err
+	}
+	return blob.walk(ctx, blob.m.Root, blob.depth, fn)
+}
+
+func (blob *Blob) walk(ctx context.Context, key cas.Key, level uint8, fn WalkFunc) error {
+	if key == cas.Empty {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := fn(key, level)
+	if errors.Is(err, SkipChildren) {
+		return nil
+	}
+	if err != nil || level == 0 {
+		return err
+	}
+
+	chunk, err := blob.stash.Get(ctx, key, blob.m.Type, level)
+	if err != nil {
+		return err
+	}
+	for _, child := range blob.childKeys(chunk.Buf) {
+		if child.IsPrivate() || child.IsReserved() {
+			return fmt.Errorf("invalid stored key in pointer chunk %v: %v", &key, &child)
+		}
+		if err := blob.walk(ctx, child, level-1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// childKeys returns the keys stored in a pointer chunk.
+func (blob *Blob) childKeys(buf []byte) []cas.Key {
+	var keys []cas.Key
+	if blob.m.Chunking.IsContentDefined() {
+		for off := 0; off+cdcEntrySize <= len(buf); off += cdcEntrySize {
+			keys = append(keys, cas.NewKeyPrivate(buf[off:off+cas.KeySize]))
+		}
+		return keys
+	}
+	// zero trimming may have cut the last key short
+	for off := 0; off < len(buf); off += cas.KeySize {
+		keys = append(keys, cas.NewKeyPrivate(safeSlice(buf, off, off+cas.KeySize)))
+	}
+	return keys
+}
+
+// LeafFunc is called by Leaves for every leaf of a blob, with the byte
+// range [start, end) of the blob it holds.
+type LeafFunc func(key cas.Key, start, end uint64) error
+
+// Leaves calls fn for the leaves of the blob described by manifest, in
+// order of offset. Unstored runs of zeros of fixed size blobs, which
+// have the Empty key, are given as one range, which may span many
+// leaves. Only pointer chunks are read.
+func Leaves(ctx context.Context, chunkStore store.IF, manifest *Manifest, fn LeafFunc) error {
+	blob, err := Open(chunkStore, manifest)
+	if err != nil {
+		return err
+	}
+	if blob.m.Size == 0 {
+		return nil
+	}
+	return blob.leaves(ctx, blob.m.Root, blob.depth, 0, blob.m.Size, fn)
+}
+
+// span returns the number of bytes under a fixed size chunk of the
+// given level, saturating on overflow.
+func (blob *Blob) span(level uint8) uint64 {
+	s := uint64(blob.m.ChunkSize)
+	for ; level > 0; level-- {
+		if s > math.MaxUint64/uint64(blob.m.Fanout) {
+			return math.MaxUint64
+		}
+		s *= uint64(blob.m.Fanout)
+	}
+	return s
+}
+
+func (blob *Blob) leaves(ctx context.Context, key cas.Key, level uint8, start, end uint64, fn LeafFunc) error {
+	if key == cas.Empty || level == 0 {
+		return fn(key, start, end)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	chunk, err := blob.stash.Get(ctx, key, blob.m.Type, level)
+	if err != nil {
+		return err
+	}
+	buf := chunk.Buf
+	if blob.m.Chunking.IsContentDefined() {
+		childStart := start
+		for off := 0; off+cdcEntrySize <= len(buf); off += cdcEntrySize {
+			child := cas.NewKeyPrivate(buf[off : off+cas.KeySize])
+			childEnd := min(start+binary.BigEndian.Uint64(buf[off+cas.KeySize:]), end)
+			if err := blob.leaves(ctx, child, level-1, childStart, childEnd, fn); err != nil {
+				return err
+			}
+			childStart = childEnd
+		}
+		return nil
+	}
+	span := blob.span(level - 1)
+	childStart := start
+	for i := uint32(0); i < blob.m.Fanout && childStart < end; i++ {
+		// zero trimming may have cut the last keys short
+		child := cas.NewKeyPrivate(safeSlice(buf, int(i)*cas.KeySize, int(i+1)*cas.KeySize))
+		childEnd := end
+		if end-childStart > span {
+			childEnd = childStart + span
+		}
+		if err := blob.leaves(ctx, child, level-1, childStart, childEnd, fn); err != nil {
+			return err
+		}
+		childStart = childEnd
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package blobs_test
+
+import (
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store/mem"
+	"testing"
+)
+
+func TestLeaves(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := mem.New()
+	data := randomData(100000, 1)
+	// a run of zeros over several fixed leaves
+	for i := 20000; i < 60000; i++ {
+		data[i] = 0
+	}
+	for _, m := range []*blobs.Manifest{
+		writeFixed(t, chunkStore, data),
+		writeCDC(t, chunkStore, smallCDCManifest(), data),
+	} {
+		var next uint64
+		leaves, holes := 0, 0
+		err := blobs.Leaves(ctx, chunkStore, m, func(key cas.Key, start, end uint64) error {
+			if start != next || end <= start {
+				t.Errorf("bad leaf range [%d, %d) after %d", start, end, next)
+			}
+			next = end
+			leaves++
+			if key == cas.Empty {
+				holes++
+				for i := start; i < end; i++ {
+					if data[i] != 0 {
+						t.Fatalf("hole [%d, %d) over data at %d", start, end, i)
+					}
+				}
+				return nil
+			}
+			chunk, err := chunkStore.Get(ctx, key, m.Type, 0)
+			if err != nil {
+				t.Fatalf("Get leaf fail: %v", err)
+			}
+			buf := make([]byte, end-start)
+			copy(buf, chunk.Buf)
+			if string(buf) != string(data[start:end]) {
+				t.Errorf("wrong data of leaf [%d, %d)", start, end)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Leaves fail: %v", err)
+		}
+		if g, e := next, m.Size; g != e {
+			t.Errorf("leaves end at %d != %d", g, e)
+		}
+		if leaves < 2 {
+			t.Errorf("expected several leaves, got %d", leaves)
+		}
+		if !m.Chunking.IsContentDefined() && holes == 0 {
+			t.Errorf("expected holes in the fixed size blob")
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+)
+
+var errWriterClosed = errors.New("blob Writer already closed")
+
+// Writer streams data into a new blob. Unlike writing through IO,
+// complete chunks are added to the store in small batches, so memory
+// use stays at one batch plus one pointer chunk per level, whatever
+// the size of the blob.
+//
+// It writes the same chunks as IO and Save would for the same data,
+// so the resulting blobs deduplicate with each other. Content-defined
+// manifests are written with a CDCWriter.
+type Writer struct {
+	ctx     context.Context
+	pending pending
+	m       Manifest
+	cdc     *CDCWriter
+	leaf    []byte
+	// levels[i] are the keys of the complete chunks of level i that
+	// are not in a pointer chunk yet
+	levels [][]byte
+	saved  *Manifest
+	err    error
+}
+
+var _ io.WriteCloser = (*Writer)(nil)
+var _ io.ReaderFrom = (*Writer)(nil)
+
+// NewWriter returns a Writer of a new blob described by manifest. Its
+// Root and Size are ignored.
+func NewWriter(ctx context.Context, chunkStore store.IF, manifest *Manifest) (*Writer, error) {
+	if manifest.Chunking.IsContentDefined() {
+		cdc, err := NewCDCWriter(ctx, chunkStore, manifest)
+		if err != nil {
+			return nil, err
+		}
+		return &Writer{cdc: cdc}, nil
+	}
+	empty := *manifest
+	empty.Root = cas.Empty
+	empty.Size = 0
+	// for the validation
+	if _, err := Open(chunkStore, &empty); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		ctx:     ctx,
+		pending: pending{store: chunkStore},
+		m:       empty,
+		leaf:    make([]byte, 0, empty.ChunkSize),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.cdc != nil {
+		return w.cdc.Write(p)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		copied := copy(w.leaf[len(w.leaf):cap(w.leaf)], p)
+		w.leaf = w.leaf[:len(w.leaf)+copied]
+		p = p[copied:]
+		n += copied
+		w.m.Size += uint64(copied)
+		if err := w.leafDone(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom writes all data from r until EOF. See io.ReaderFrom.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.cdc != nil {
+		return io.Copy(w.cdc, r)
+	}
+	var total int64
+	for {
+		if w.err != nil {
+			return total, w.err
+		}
+		// read straight into the leaf
+		n, err := r.Read(w.leaf[len(w.leaf):cap(w.leaf)])
+		w.leaf = w.leaf[:len(w.leaf)+n]
+		w.m.Size += uint64(n)
+		total += int64(n)
+		if lerr := w.leafDone(); lerr != nil {
+			return total, lerr
+		}
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// leafDone adds the leaf if it is full.
+func (w *Writer) leafDone() error {
+	if len(w.leaf) < cap(w.leaf) {
+		return nil
+	}
+	if err := w.addLeaf(); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *Writer) addLeaf() error {
+	// the store may keep the buffer
+	buf := append([]byte(nil), trim(w.leaf)...)
+	w.leaf = w.leaf[:0]
+	key, err := w.pending.add(w.ctx, chunks.MakeChunk(w.m.Type, 0, buf))
+	if err != nil {
+		return err
+	}
+	return w.push(0, key)
+}
+
+func (w *Writer) push(level uint8, key cas.Key) error {
+	for len(w.levels) <= int(level) {
+		w.levels = append(w.levels, nil)
+	}
+	w.levels[level] = append(w.levels[level], key.Bytes()...)
+	if len(w.levels[level]) < int(w.m.Fanout)*cas.KeySize {
+		return nil
+	}
+	return w.flush(level)
+}
+
+// flush adds a pointer chunk of the pending keys of level.
+func (w *Writer) flush(level uint8) error {
+	buf := trim(w.levels[level])
+	w.levels[level] = nil
+	key, err := w.pending.add(w.ctx, chunks.MakeChunk(w.m.Type, level+1, buf))
+	if err != nil {
+		return err
+	}
+	return w.push(level+1, key)
+}
+
+// Close adds the last chunks of the blob. Its manifest is returned by
+// Manifest afterwards.
+func (w *Writer) Close() error {
+	if w.saved != nil {
+		return nil
+	}
+	if w.cdc != nil {
+		m, err := w.cdc.Save()
+		if err != nil {
+			return err
+		}
+		w.saved = m
+		return nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.leaf) > 0 {
+		if err := w.addLeaf(); err != nil {
+			w.err = err
+			return err
+		}
+	}
+
+	// the depth is fixed by the size, so even a single key below it
+	// gets a pointer chunk
+	depth := (&Blob{m: w.m}).computeLevel(w.m.Size)
+	for level := uint8(0); level < depth; level++ {
+		if int(level) < len(w.levels) && len(w.levels[level]) > 0 {
+			if err := w.flush(level); err != nil {
+				w.err = err
+				return err
+			}
+		}
+	}
+	if err := w.pending.flush(w.ctx); err != nil {
+		w.err = err
+		return err
+	}
+	w.m.Root = cas.Empty
+	if int(depth) < len(w.levels) && len(w.levels[depth]) > 0 {
+		w.m.Root = cas.NewKey(w.levels[depth][:cas.KeySize])
+	}
+	w.saved = &w.m
+	w.err = errWriterClosed
+	return nil
+}
+
+// Manifest returns the manifest of the blob written, or nil if the
+// Writer has not been closed successfully.
+func (w *Writer) Manifest() *Manifest {
+	if w.saved == nil {
+		return nil
+	}
+	m := *w.saved
+	return &m
+}
@@ -0,0 +1,180 @@
+package blobs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store/mem"
+	"math/rand"
+	"testing"
+)
+
+// oneByteReader hands out data in small reads, like a slow network.
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), 7)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWriterMatchesSave(t *testing.T) {
+	ctx := context.Background()
+	data := randomData(5*blobs.MinChunkSize+100, 5)
+	// holes of zeroes are trimmed or not stored at all
+	clear(data[blobs.MinChunkSize : 2*blobs.MinChunkSize])
+	clear(data[len(data)-50:])
+	for _, size := range []int{0, 10, blobs.MinChunkSize, 2 * blobs.MinChunkSize, len(data)} {
+		m := &blobs.Manifest{Type: "footype", ChunkSize: blobs.MinChunkSize, Fanout: 2}
+
+		chunkStore := mem.New()
+		blob, err := blobs.Open(chunkStore, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := blob.IO(ctx).WriteAt(data[:size], 0); err != nil {
+			t.Fatalf("WriteAt fail: %v", err)
+		}
+		want, err := blob.Save(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, readFrom := range []bool{false, true} {
+			w, err := blobs.NewWriter(ctx, chunkStore, m)
+			if err != nil {
+				t.Fatalf("NewWriter fail: %v", err)
+			}
+			if readFrom {
+				_, err = w.ReadFrom(&oneByteReader{data: data[:size]})
+			} else {
+				_, err = w.Write(data[:size])
+			}
+			if err != nil {
+				t.Fatalf("write fail: %v", err)
+			}
+			if w.Manifest() != nil {
+				t.Errorf("manifest before Close")
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close fail: %v", err)
+			}
+			got := w.Manifest()
+			if g, e := got.Size, want.Size; g != e {
+				t.Errorf("size %d: wrong size: %d != %d", size, g, e)
+			}
+			if g, e := got.Root, want.Root; g != e {
+				t.Errorf("size %d: root differs from Save: %v != %v", size, &g, &e)
+			}
+		}
+	}
+}
+
+func TestWriterLarge(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := mem.New()
+	data := randomData(100*blobs.MinChunkSize+1, 6)
+	m := &blobs.Manifest{Type: "footype", ChunkSize: blobs.MinChunkSize, Fanout: 3}
+	w, err := blobs.NewWriter(ctx, chunkStore, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(7))
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), rnd.Intn(3*blobs.MinChunkSize))
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatalf("Write fail: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("more")); err == nil {
+		t.Errorf("Write after Close should fail")
+	}
+
+	blob, err := blobs.Open(chunkStore, w.Manifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := blob.IO(ctx).ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadAt fail: %v", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("read data differs")
+	}
+}
+
+func TestWriterContentDefined(t *testing.T) {
+	ctx := context.Background()
+	data := randomData(200000, 8)
+	want := writeCDC(t, mem.New(), smallCDCManifest(), data)
+
+	chunkStore := mem.New()
+	w, err := blobs.NewWriter(ctx, chunkStore, smallCDCManifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if g, e := w.Manifest().Root, want.Root; g != e {
+		t.Errorf("root differs from CDCWriter: %v != %v", &g, &e)
+	}
+}
+
+func TestWriterBadManifest(t *testing.T) {
+	_, err := blobs.NewWriter(context.Background(), mem.New(), &blobs.Manifest{
+		Type:      "footype",
+		ChunkSize: 10,
+		Fanout:    2,
+	})
+	var small blobs.SmallChunkSizeError
+	if !errors.As(err, &small) {
+		t.Errorf("expected SmallChunkSizeError: %v", err)
+	}
+}
+
+func TestWriterBatches(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := &batchStore{IF: mem.New()}
+	m := &blobs.Manifest{Type: "footype", ChunkSize: blobs.MinChunkSize, Fanout: 2}
+	// over 100 leaves and as many pointer chunks
+	data := randomData(100*blobs.MinChunkSize+1, 9)
+	w, err := blobs.NewWriter(ctx, chunkStore, m)
+	if err != nil {
+		t.Fatalf("NewWriter fail: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write fail: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close fail: %v", err)
+	}
+	if g := chunkStore.batches; g < 2 || g > 5 {
+		t.Errorf("Writer used wrong number of batches: %d", g)
+	}
+	blob, err := blobs.Open(chunkStore, w.Manifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := blob.IO(ctx).ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadAt fail: %v", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("written blob has wrong data")
+	}
+}
@@ -14,6 +14,8 @@ type Stash struct {
 	chunks store.IF
 	ids    idpool.Pool
 	local  map[uint64]*chunks.Chunk
+	// dirty is the sum of the buffer capacities of the local chunks
+	dirty int64
 }
 
 // Get returns a chunk either from the local stash(for Private keys),
@@ -41,6 +43,9 @@ func (s *Stash) Get(ctx context.Context, key cas.Key, typ string, level uint8) (
 }
 
 func (s *Stash) drop(priv uint64) {
+	if chunk, ok := s.local[priv]; ok {
+		s.dirty -= int64(cap(chunk.Buf))
+	}
 	s.ids.Put(priv)
 	delete(s.local, priv)
 }
@@ -90,6 +95,7 @@ func (s *Stash) Clone(ctx context.Context, key cas.Key, typ string, level uint8,
 	priv = s.ids.Get()
 	privKey := cas.NewKeyPrivateNum(priv)
 	s.local[priv] = chunk
+	s.dirty += int64(cap(chunk.Buf))
 	return privKey, chunk, nil
 }
 
@@ -122,6 +128,13 @@ func (s *Stash) Save(ctx context.Context, key cas.Key) (cas.Key, error) {
 func (s *Stash) Clear() {
 	s.ids = idpool.Pool{}
 	s.local = make(map[uint64]*chunks.Chunk)
+	s.dirty = 0
+}
+
+// Dirty returns the number of bytes held by the Private chunks in
+// this Stash.
+func (s *Stash) Dirty() int64 {
+	return s.dirty
 }
 
 // New creates a new Stash.
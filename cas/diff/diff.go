@@ -0,0 +1,289 @@
+// Package diff compares directory trees.
+//
+// Trees that share content share chunks, so most of a comparison is
+// settled by comparing keys: a directory or file whose manifest is the
+// same in both trees is skipped without reading it.
+package diff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
+	"lifs_go/cas/store"
+	"sort"
+)
+
+type Kind uint8
+
+const (
+	Added Kind = iota + 1
+	Removed
+	Modified
+	// Renamed is an entry removed from one path and added at another
+	// with the same content.
+	Renamed
+)
+
+var kindNames = map[Kind]string{
+	Added:    "added",
+	Removed:  "removed",
+	Modified: "modified",
+	Renamed:  "renamed",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Range is the byte range [Start, End) of a file.
+type Range struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// Change is a difference between two trees. A directory added or
+// removed is one change; the entries below it are not listed.
+type Change struct {
+	Kind Kind   `json:"kind"`
+	Path string `json:"path"`
+	// OldPath is the path a Renamed entry had in the old tree.
+	OldPath string `json:"old_path,omitempty"`
+	// Old and New are the entry in the old and the new tree; Old is
+	// nil for Added, New for Removed.
+	Old *dirs.Entry `json:"-"`
+	New *dirs.Entry `json:"-"`
+	// Ranges are the byte ranges of a Modified file that are stored
+	// differently in the new tree, with Options.Ranges. Bytes cut
+	// from the end of the file are not in any range; compare the
+	// sizes.
+	Ranges []Range `json:"ranges,omitempty"`
+}
+
+type Options struct {
+	// Ranges computes the Ranges of modified files, by comparing the
+	// leaf chunks of both versions. Only pointer chunks are read.
+	Ranges bool
+}
+
+// Resolve returns the manifest of the root directory of a tree given
+// by the key of a snapshot, or the key of a tree saved by
+// dirs.Tree.Save.
+func Resolve(ctx context.Context, chunkStore store.IF, key cas.Key) (*blobs.Manifest, error) {
+	s, err := snapshots.Load(ctx, chunkStore, key)
+	var nf cas.NotFoundError
+	if errors.As(err, &nf) {
+		tree, err := dirs.Load(ctx, chunkStore, key)
+		if err != nil {
+			return nil, err
+		}
+		return tree.Root(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tree, err := s.Tree(ctx, chunkStore)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Root(), nil
+}
+
+// Trees returns the changes from the tree with root directory a to the
+// tree with root directory b, sorted by path.
+func Trees(ctx context.Context, chunkStore store.IF, a, b *blobs.Manifest, opts *Options) ([]Change, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	d := &differ{ctx: ctx, store: chunkStore, opts: opts}
+	if err := d.dir("/", a, b); err != nil {
+		return nil, err
+	}
+	changes := renames(d.changes)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+type differ struct {
+	ctx     context.Context
+	store   store.IF
+	opts    *Options
+	changes []Change
+}
+
+func childPath(dir, name string) string {
+	if dir == "/" {
+		return "/" + name
+	}
+	return dir + "/" + name
+}
+
+func (d *differ) dir(p string, a, b *blobs.Manifest) error {
+	if *a == *b {
+		return nil
+	}
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	da, err := dirs.ReadDir(d.ctx, d.store, a)
+	if err != nil {
+		return err
+	}
+	db, err := dirs.ReadDir(d.ctx, d.store, b)
+	if err != nil {
+		return err
+	}
+	ea, eb := da.Entries(), db.Entries()
+	for len(ea) > 0 || len(eb) > 0 {
+		switch {
+		case len(eb) == 0 || len(ea) > 0 && ea[0].Name < eb[0].Name:
+			d.changes = append(d.changes, Change{Kind: Removed, Path: childPath(p, ea[0].Name), Old: &ea[0]})
+			ea = ea[1:]
+		case len(ea) == 0 || eb[0].Name < ea[0].Name:
+			d.changes = append(d.changes, Change{Kind: Added, Path: childPath(p, eb[0].Name), New: &eb[0]})
+			eb = eb[1:]
+		default:
+			if err := d.entry(childPath(p, ea[0].Name), &ea[0], &eb[0]); err != nil {
+				return err
+			}
+			ea, eb = ea[1:], eb[1:]
+		}
+	}
+	return nil
+}
+
+// entry compares the entries of the same path in both trees.
+func (d *differ) entry(p string, a, b *dirs.Entry) error {
+	switch {
+	case a.Kind != b.Kind:
+		d.changes = append(d.changes,
+			Change{Kind: Removed, Path: p, Old: a},
+			Change{Kind: Added, Path: p, New: b})
+	case a.IsDir():
+		return d.dir(p, &a.Manifest, &b.Manifest)
+	case a.Manifest != b.Manifest || a.Mode != b.Mode:
+		c := Change{Kind: Modified, Path: p, Old: a, New: b}
+		if d.opts.Ranges && a.Manifest != b.Manifest {
+			var err error
+			if c.Ranges, err = Ranges(d.ctx, d.store, &a.Manifest, &b.Manifest); err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+		}
+		d.changes = append(d.changes, c)
+	}
+	return nil
+}
+
+// renames pairs removed and added entries with the same content into
+// Renamed changes. Empty files are never paired, as they all have the
+// same content.
+func renames(changes []Change) []Change {
+	removed := make(map[blobs.Manifest][]int)
+	for i, c := range changes {
+		if c.Kind == Removed && c.Old.Manifest.Size > 0 {
+			removed[c.Old.Manifest] = append(removed[c.Old.Manifest], i)
+		}
+	}
+	paired := make(map[int]bool)
+	for i := range changes {
+		c := &changes[i]
+		if c.Kind != Added {
+			continue
+		}
+		candidates := removed[c.New.Manifest]
+		for len(candidates) > 0 && changes[candidates[0]].Old.Kind != c.New.Kind {
+			candidates = candidates[1:]
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		old := candidates[0]
+		removed[c.New.Manifest] = candidates[1:]
+		paired[old] = true
+		c.Kind = Renamed
+		c.OldPath = changes[old].Path
+		c.Old = changes[old].Old
+	}
+	out := changes[:0]
+	for i, c := range changes {
+		if !paired[i] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// span is a leaf of a blob.
+type span struct {
+	key        cas.Key
+	start, end uint64
+}
+
+// Ranges returns the byte ranges of the blob of b that are not stored
+// as in the blob of a. Fixed size blobs with the same chunk size are
+// compared leaf by leaf at the same offsets; otherwise, as when an
+// insert shifted the content of a content-defined blob, a leaf of b is
+// unchanged if a has it anywhere.
+func Ranges(ctx context.Context, chunkStore store.IF, a, b *blobs.Manifest) ([]Range, error) {
+	positional := !a.Chunking.IsContentDefined() && !b.Chunking.IsContentDefined() && a.ChunkSize == b.ChunkSize
+	var old []span
+	keys := make(map[cas.Key]bool)
+	err := blobs.Leaves(ctx, chunkStore, a, func(key cas.Key, start, end uint64) error {
+		if positional {
+			old = append(old, span{key, start, end})
+		} else {
+			keys[key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out []Range
+	err = blobs.Leaves(ctx, chunkStore, b, func(key cas.Key, start, end uint64) error {
+		same := keys[key]
+		if positional {
+			same = sameAt(old, span{key, start, end})
+		}
+		if same {
+			return nil
+		}
+		if n := len(out); n > 0 && out[n-1].End == start {
+			out[n-1].End = end
+		} else {
+			out = append(out, Range{start, end})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sameAt reports whether the leaves in old, sorted by offset, hold
+// the same as leaf s. Unstored runs of zeros may be split differently.
+func sameAt(old []span, s span) bool {
+	i := sort.Search(len(old), func(i int) bool { return old[i].end > s.start })
+	if s.key != cas.Empty {
+		return i < len(old) && old[i] == s
+	}
+	covered := s.start
+	for ; i < len(old) && old[i].start < s.end; i++ {
+		if old[i].key != cas.Empty {
+			return false
+		}
+		covered = old[i].end
+	}
+	return covered >= s.end
+}
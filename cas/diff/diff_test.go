@@ -0,0 +1,239 @@
+package diff_test
+
+import (
+	"bytes"
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/diff"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
+	"lifs_go/cas/store"
+	"lifs_go/cas/store/mem"
+	"reflect"
+	"testing"
+)
+
+// countingStore counts the directory chunks read.
+type countingStore struct {
+	store.IF
+	dirReads int
+}
+
+func (s *countingStore) Get(ctx context.Context, key cas.Key, type_ string, level uint8) (*chunks.Chunk, error) {
+	if type_ == dirs.BlobType {
+		s.dirReads++
+	}
+	return s.IF.Get(ctx, key, type_, level)
+}
+
+func writeFile(t *testing.T, chunkStore store.IF, data []byte) dirs.Entry {
+	t.Helper()
+	w, err := blobs.NewWriter(context.Background(), chunkStore, &blobs.Manifest{
+		Type:      dirs.FileBlobType,
+		ChunkSize: blobs.MinChunkSize,
+		Fanout:    4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m := w.Manifest()
+	return dirs.Entry{Kind: dirs.KindFile, Mode: 0o644, Size: m.Size, Manifest: *m}
+}
+
+func put(t *testing.T, tree *dirs.Tree, p string, e dirs.Entry) *dirs.Tree {
+	t.Helper()
+	tree, err := tree.Put(context.Background(), p, e)
+	if err != nil {
+		t.Fatalf("Put %s fail: %v", p, err)
+	}
+	return tree
+}
+
+type change struct {
+	Kind    diff.Kind
+	Path    string
+	OldPath string
+}
+
+func summary(changes []diff.Change) []change {
+	var out []change
+	for _, c := range changes {
+		out = append(out, change{c.Kind, c.Path, c.OldPath})
+	}
+	return out
+}
+
+func TestTrees(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := &countingStore{IF: mem.New()}
+	dir := dirs.Entry{Kind: dirs.KindDir, Mode: 0o755, Manifest: *dirs.EmptyManifest()}
+	big := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+	a := dirs.Empty(chunkStore)
+	a = put(t, a, "/same", dir)
+	for i := 0; i < 10; i++ {
+		a = put(t, a, "/same/"+string(rune('a'+i)), writeFile(t, chunkStore, []byte{byte(i)}))
+	}
+	a = put(t, a, "/gone", writeFile(t, chunkStore, []byte("gone")))
+	a = put(t, a, "/big", writeFile(t, chunkStore, big))
+	a = put(t, a, "/moved", writeFile(t, chunkStore, []byte("moving")))
+	a = put(t, a, "/kind", writeFile(t, chunkStore, []byte("file")))
+	a = put(t, a, "/mode", writeFile(t, chunkStore, []byte("mode")))
+
+	b := a
+	var err error
+	if b, err = b.Remove(ctx, "/gone"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = b.Remove(ctx, "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = b.Remove(ctx, "/kind"); err != nil {
+		t.Fatal(err)
+	}
+	b = put(t, b, "/kind", dir)
+	b = put(t, b, "/sub", dir)
+	b = put(t, b, "/sub/moved", writeFile(t, chunkStore, []byte("moving")))
+	b = put(t, b, "/new", writeFile(t, chunkStore, nil))
+	changed := append([]byte(nil), big...)
+	copy(changed[5000:], "changed")
+	b = put(t, b, "/big", writeFile(t, chunkStore, changed))
+	mode, err := b.Lookup(ctx, "/mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode.Mode = 0o600
+	b = put(t, b, "/mode", mode)
+
+	chunkStore.dirReads = 0
+	changes, err := diff.Trees(ctx, chunkStore, a.Root(), b.Root(), &diff.Options{Ranges: true})
+	if err != nil {
+		t.Fatalf("Trees fail: %v", err)
+	}
+	want := []change{
+		{diff.Modified, "/big", ""},
+		{diff.Removed, "/gone", ""},
+		{diff.Removed, "/kind", ""},
+		{diff.Added, "/kind", ""},
+		{diff.Modified, "/mode", ""},
+		// only /sub is listed, so its file is no rename
+		{diff.Removed, "/moved", ""},
+		{diff.Added, "/new", ""},
+		{diff.Added, "/sub", ""},
+	}
+	if g := summary(changes); !reflect.DeepEqual(g, want) {
+		t.Errorf("wrong changes:\n%v\n!=\n%v", g, want)
+	}
+	if g, e := changes[0].Ranges, []diff.Range{{4096, 8192}}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong ranges: %v != %v", g, e)
+	}
+	if changes[4].Ranges != nil {
+		t.Errorf("ranges for a mode change: %v", changes[4].Ranges)
+	}
+	// the roots only, /same is skipped
+	if g, e := chunkStore.dirReads, 2; g != e {
+		t.Errorf("wrong number of directories read: %d != %d", g, e)
+	}
+
+	// a rename into a new directory
+	b, err = b.Remove(ctx, "/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = put(t, b, "/renamed", writeFile(t, chunkStore, []byte("moving")))
+	changes, err = diff.Trees(ctx, chunkStore, a.Root(), b.Root(), nil)
+	if err != nil {
+		t.Fatalf("Trees fail: %v", err)
+	}
+	found := false
+	for _, c := range changes {
+		if c.Kind == diff.Renamed {
+			found = true
+			if g, e := (change{c.Kind, c.Path, c.OldPath}), (change{diff.Renamed, "/renamed", "/moved"}); g != e {
+				t.Errorf("wrong rename: %v != %v", g, e)
+			}
+		}
+		if c.Ranges != nil {
+			t.Errorf("ranges without Options.Ranges: %v", c)
+		}
+	}
+	if !found {
+		t.Errorf("rename not found: %v", summary(changes))
+	}
+
+	// nothing changed
+	changes, err = diff.Trees(ctx, chunkStore, a.Root(), a.Root(), nil)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("changes in the same tree: %v, %v", changes, err)
+	}
+}
+
+func TestRangesContentDefined(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := mem.New()
+	m := blobs.EmptyCDCManifest(dirs.FileBlobType)
+	m.Chunking.Min, m.Chunking.Avg, m.Chunking.Max = 1024, 4096, 16384
+
+	data := make([]byte, 200000)
+	for i := range data {
+		data[i] = byte(i * 7919 >> 5)
+	}
+	write := func(data []byte) *blobs.Manifest {
+		w, err := blobs.NewWriter(ctx, chunkStore, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return w.Manifest()
+	}
+	// an insert shifts everything after it
+	inserted := append(append(append([]byte(nil), data[:100000]...), "inserted"...), data[100000:]...)
+	ranges, err := diff.Ranges(ctx, chunkStore, write(data), write(inserted))
+	if err != nil {
+		t.Fatalf("Ranges fail: %v", err)
+	}
+	if len(ranges) != 1 || ranges[0].Start > 100000 || ranges[0].End < 100008 {
+		t.Fatalf("wrong ranges: %v", ranges)
+	}
+	if n := ranges[0].End - ranges[0].Start; n > 3*16384 {
+		t.Errorf("insert changed %d bytes", n)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	chunkStore := mem.New()
+	tree := put(t, dirs.Empty(chunkStore), "/f", writeFile(t, chunkStore, []byte("f")))
+	treeKey, err := tree.Save(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := snapshots.Take(ctx, tree, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapKey, err := snapshots.Save(ctx, chunkStore, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []cas.Key{treeKey, snapKey} {
+		m, err := diff.Resolve(ctx, chunkStore, key)
+		if err != nil {
+			t.Fatalf("Resolve fail: %v", err)
+		}
+		if g, e := *m, *tree.Root(); g != e {
+			t.Errorf("wrong root: %+v != %+v", g, e)
+		}
+	}
+}
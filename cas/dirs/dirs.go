@@ -0,0 +1,424 @@
+// Package dirs stores directory trees in the CAS.
+//
+// A directory is a blob of type "dir" holding its entries sorted by
+// name. Each entry carries the manifest of its child, so a directory
+// addresses its whole subtree, and saving the manifest of the root
+// directory as a chunk addresses a whole file tree with one cas.Key.
+// Trees are never modified in place: an update writes new directories
+// along the changed path and shares everything else.
+package dirs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlobType is the type of the blobs holding directories.
+const BlobType = "dir"
+
+// The data of a directory blob is
+//
+//	magic "ld" | version | count | entry*
+//
+// where count is the number of entries as a uvarint, and each entry is a uvarint length followed by
+//
+//	name | kind | mode | mtime | size | manifest | field*
+//
+// where name and manifest are a uvarint length and the bytes, the
+// manifest in its binary form, kind is a byte, mtime is a varint of
+// nanoseconds since the Unix epoch, and the other numbers are
+// uvarints. As in manifests, optional fields follow as tag, length and
+// payload, in increasing tag order; unknown even tags are skipped and
+// unknown odd tags are an error. Fields are only written when not at
+// their zero value.
+//
+// Tags:
+//
+//	2: owner, as uid | gid
+//	4: atime, as a varint of nanoseconds since the Unix epoch
+//	6: ctime, as atime
+//
+// An empty blob is an empty directory.
+const (
+	dirVersion = 1
+
+	tagOwner = 2
+	tagAtime = 4
+	tagCtime = 6
+)
+
+var dirMagic = [2]byte{'l', 'd'}
+
+type Kind uint8
+
+const (
+	KindFile Kind = 1
+	KindDir  Kind = 2
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindFile:
+		return "file"
+	case KindDir:
+		return "dir"
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+// Entry is a named child of a directory.
+type Entry struct {
+	Name string
+	Kind Kind
+	// Mode holds the permission bits.
+	Mode uint32
+	// Uid and Gid own the entry.
+	Uid, Gid uint32
+	Mtime    time.Time
+	// Atime and Ctime are the last access and change of the entry, as
+	// far as they are tracked; zero when not.
+	Atime, Ctime time.Time
+	// Size is the size of the file, or of the directory blob.
+	Size uint64
+	// Manifest is the blob of the file, or of the directory.
+	Manifest blobs.Manifest
+}
+
+// IsDir reports whether e is a directory.
+func (e *Entry) IsDir() bool {
+	return e.Kind == KindDir
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// EmptyManifest returns the manifest of an empty directory; it is
+// valid without storing anything. Directories use smaller chunks than
+// the default, as most are small and rewritten often.
+func EmptyManifest() *blobs.Manifest {
+	m := blobs.EmptyManifest(BlobType)
+	m.ChunkSize = 64 * 1024
+	return m
+}
+
+// Dir is a directory in memory. Changes to it are stored by WriteDir.
+//
+// The zero value is an empty directory ready to use.
+type Dir struct {
+	// sorted by name
+	entries []Entry
+}
+
+func (d *Dir) search(name string) (int, bool) {
+	i := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].Name >= name })
+	return i, i < len(d.entries) && d.entries[i].Name == name
+}
+
+// Lookup returns the entry with the given name.
+func (d *Dir) Lookup(name string) (Entry, bool) {
+	i, ok := d.search(name)
+	if !ok {
+		return Entry{}, false
+	}
+	return d.entries[i], true
+}
+
+// Entries returns a copy of the entries, sorted by name.
+func (d *Dir) Entries() []Entry {
+	return append([]Entry(nil), d.entries...)
+}
+
+func (d *Dir) Len() int {
+	return len(d.entries)
+}
+
+// Set adds e, replacing an entry of the same name.
+func (d *Dir) Set(e Entry) error {
+	if !validName(e.Name) {
+		return BadNameError{e.Name}
+	}
+	i, ok := d.search(e.Name)
+	if ok {
+		d.entries[i] = e
+		return nil
+	}
+	d.entries = append(d.entries, Entry{})
+	copy(d.entries[i+1:], d.entries[i:])
+	d.entries[i] = e
+	return nil
+}
+
+// Remove deletes the entry with the given name, and reports whether
+// there was one.
+func (d *Dir) Remove(name string) bool {
+	i, ok := d.search(name)
+	if ok {
+		d.entries = append(d.entries[:i], d.entries[i+1:]...)
+	}
+	return ok
+}
+
+func appendBytes(b []byte, p []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+// MarshalBinary encodes the directory as stored in its blob.
+func (d *Dir) MarshalBinary() ([]byte, error) {
+	if len(d.entries) == 0 {
+		return nil, nil
+	}
+	b := append(dirMagic[:], dirVersion)
+	b = binary.AppendUvarint(b, uint64(len(d.entries)))
+	var rec []byte
+	for i := range d.entries {
+		e := &d.entries[i]
+		m, err := e.Manifest.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", e.Name, err)
+		}
+		rec = appendBytes(rec[:0], []byte(e.Name))
+		rec = append(rec, byte(e.Kind))
+		rec = binary.AppendUvarint(rec, uint64(e.Mode))
+		rec = binary.AppendVarint(rec, e.Mtime.UnixNano())
+		rec = binary.AppendUvarint(rec, e.Size)
+		rec = appendBytes(rec, m)
+		rec = e.appendFields(rec)
+		b = appendBytes(b, rec)
+	}
+	return b, nil
+}
+
+func appendField(b []byte, tag uint64, f []byte) []byte {
+	b = binary.AppendUvarint(b, tag)
+	return appendBytes(b, f)
+}
+
+// appendFields appends the optional fields of e.
+func (e *Entry) appendFields(b []byte) []byte {
+	if e.Uid != 0 || e.Gid != 0 {
+		f := binary.AppendUvarint(nil, uint64(e.Uid))
+		f = binary.AppendUvarint(f, uint64(e.Gid))
+		b = appendField(b, tagOwner, f)
+	}
+	if !e.Atime.IsZero() {
+		b = appendField(b, tagAtime, binary.AppendVarint(nil, e.Atime.UnixNano()))
+	}
+	if !e.Ctime.IsZero() {
+		b = appendField(b, tagCtime, binary.AppendVarint(nil, e.Ctime.UnixNano()))
+	}
+	return b
+}
+
+// field reads the optional field tag of an entry into e, and reports
+// whether it is known.
+func (e *Entry) field(tag uint64, f *dirDecoder) bool {
+	switch tag {
+	case tagOwner:
+		e.Uid = uint32(f.uvarint())
+		e.Gid = uint32(f.uvarint())
+	case tagAtime:
+		e.Atime = time.Unix(0, f.varint())
+	case tagCtime:
+		e.Ctime = time.Unix(0, f.varint())
+	default:
+		return false
+	}
+	return true
+}
+
+// dirDecoder reads the binary form, remembering the first error.
+type dirDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *dirDecoder) fail(reason string) {
+	if d.err == nil {
+		d.err = CorruptDirError{Reason: reason}
+	}
+}
+
+func (d *dirDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("truncated number")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *dirDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail("truncated number")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *dirDecoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.fail("truncated")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *dirDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.fail("truncated")
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+// fields reads the optional fields at the end of a record.
+func (d *dirDecoder) fields(fn func(tag uint64, field *dirDecoder) bool) {
+	lastTag := uint64(0)
+	for d.err == nil && len(d.buf) > 0 {
+		tag := d.uvarint()
+		field := &dirDecoder{buf: d.bytes()}
+		if d.err != nil {
+			return
+		}
+		if tag <= lastTag {
+			d.fail("fields out of order")
+			return
+		}
+		lastTag = tag
+		if fn(tag, field) {
+			if field.err != nil {
+				d.err = field.err
+			}
+			continue
+		}
+		if tag%2 == 1 {
+			d.fail(fmt.Sprintf("unknown required field %d", tag))
+		}
+	}
+}
+
+func (d *Dir) UnmarshalBinary(data []byte) error {
+	var n Dir
+	if len(data) == 0 {
+		*d = n
+		return nil
+	}
+	if len(data) < 3 || [2]byte(data[:2]) != dirMagic {
+		return CorruptDirError{Reason: "not a directory"}
+	}
+	if data[2] != dirVersion {
+		return CorruptDirError{Reason: fmt.Sprintf("unknown version %d", data[2])}
+	}
+	dec := &dirDecoder{buf: data[3:]}
+	count := dec.uvarint()
+	if count > uint64(len(dec.buf)) {
+		dec.fail("truncated")
+	}
+	for i := uint64(0); dec.err == nil && i < count; i++ {
+		rec := &dirDecoder{buf: dec.bytes()}
+		if dec.err != nil {
+			break
+		}
+		var e Entry
+		e.Name = string(rec.bytes())
+		e.Kind = Kind(rec.byte())
+		e.Mode = uint32(rec.uvarint())
+		e.Mtime = time.Unix(0, rec.varint())
+		e.Size = rec.uvarint()
+		m := rec.bytes()
+		rec.fields(e.field)
+		if rec.err != nil {
+			return rec.err
+		}
+		if err := e.Manifest.UnmarshalBinary(m); err != nil {
+			return fmt.Errorf("entry %q: %w", e.Name, err)
+		}
+		if !validName(e.Name) {
+			return CorruptDirError{Reason: fmt.Sprintf("bad name %q", e.Name)}
+		}
+		if len(n.entries) > 0 && n.entries[len(n.entries)-1].Name >= e.Name {
+			return CorruptDirError{Reason: fmt.Sprintf("entry %q out of order", e.Name)}
+		}
+		n.entries = append(n.entries, e)
+	}
+	if dec.err == nil && len(dec.buf) > 0 {
+		dec.fail("trailing data")
+	}
+	if dec.err != nil {
+		return dec.err
+	}
+	*d = n
+	return nil
+}
+
+// ReadDir reads the directory stored in the blob of manifest m.
+func ReadDir(ctx context.Context, chunkStore store.IF, m *blobs.Manifest) (*Dir, error) {
+	if m.Type != BlobType {
+		return nil, CorruptDirError{Reason: fmt.Sprintf("blob type %q is not a directory", m.Type)}
+	}
+	blob, err := blobs.Open(chunkStore, m)
+	if err != nil {
+		return nil, err
+	}
+	r := blobs.NewReader(ctx, blob, 0)
+	defer r.Close()
+	var buf bytes.Buffer
+	buf.Grow(int(min(m.Size, 1<<30)))
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	var d Dir
+	if err := d.UnmarshalBinary(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// WriteDir stores d as a new blob and returns its manifest.
+func WriteDir(ctx context.Context, chunkStore store.IF, d *Dir) (*blobs.Manifest, error) {
+	data, err := d.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	w, err := blobs.NewWriter(ctx, chunkStore, EmptyManifest())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Manifest(), nil
+}
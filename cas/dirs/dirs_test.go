@@ -0,0 +1,165 @@
+package dirs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/store/mem"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func fileEntry(name string, size uint64) dirs.Entry {
+	m := blobs.EmptyManifest("file")
+	m.Size = size
+	return dirs.Entry{
+		Name:     name,
+		Kind:     dirs.KindFile,
+		Mode:     0o644,
+		Mtime:    time.Unix(1700000000, 123456789),
+		Size:     size,
+		Manifest: *m,
+	}
+}
+
+func names(entries []dirs.Entry) []string {
+	var n []string
+	for _, e := range entries {
+		n = append(n, e.Name)
+	}
+	return n
+}
+
+func TestDirSorted(t *testing.T) {
+	var d dirs.Dir
+	for _, name := range []string{"b", "c", "a", "b"} {
+		if err := d.Set(fileEntry(name, 1)); err != nil {
+			t.Fatalf("Set fail: %v", err)
+		}
+	}
+	if g, e := names(d.Entries()), []string{"a", "b", "c"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong entries: %q != %q", g, e)
+	}
+	if _, ok := d.Lookup("b"); !ok {
+		t.Errorf("b not found")
+	}
+	if g, e := d.Remove("b"), true; g != e {
+		t.Errorf("Remove of b: %v != %v", g, e)
+	}
+	if g, e := d.Remove("b"), false; g != e {
+		t.Errorf("second Remove of b: %v != %v", g, e)
+	}
+	if _, ok := d.Lookup("b"); ok {
+		t.Errorf("removed b found")
+	}
+	if g, e := d.Len(), 2; g != e {
+		t.Errorf("wrong length: %d != %d", g, e)
+	}
+}
+
+func TestBadName(t *testing.T) {
+	var d dirs.Dir
+	for _, name := range []string{"", ".", "..", "a/b", "a\x00b"} {
+		err := d.Set(fileEntry(name, 1))
+		var bad dirs.BadNameError
+		if !errors.As(err, &bad) {
+			t.Errorf("Set of %q: wrong error %v", name, err)
+		}
+	}
+	if g, e := d.Len(), 0; g != e {
+		t.Errorf("wrong length: %d != %d", g, e)
+	}
+}
+
+func TestDirRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+
+	var d dirs.Dir
+	hello := fileEntry("hello.txt", 5)
+	hello.Uid, hello.Gid = 1000, 100
+	hello.Atime = time.Unix(1700000100, 0)
+	hello.Ctime = time.Unix(1700000050, 1)
+	d.Set(hello)
+	d.Set(fileEntry("zero", 0))
+	sub := dirs.Entry{
+		Name:     "sub",
+		Kind:     dirs.KindDir,
+		Mode:     0o755,
+		Mtime:    time.Unix(0, -1),
+		Manifest: *dirs.EmptyManifest(),
+	}
+	d.Set(sub)
+
+	m, err := dirs.WriteDir(ctx, store, &d)
+	if err != nil {
+		t.Fatalf("WriteDir fail: %v", err)
+	}
+	if g, e := m.Type, dirs.BlobType; g != e {
+		t.Errorf("wrong type: %q != %q", g, e)
+	}
+	got, err := dirs.ReadDir(ctx, store, m)
+	if err != nil {
+		t.Fatalf("ReadDir fail: %v", err)
+	}
+	if !reflect.DeepEqual(got.Entries(), d.Entries()) {
+		t.Errorf("round trip changed entries:\n%+v\n!=\n%+v", got.Entries(), d.Entries())
+	}
+
+	// same entries, same blob
+	again, err := dirs.WriteDir(ctx, store, got)
+	if err != nil {
+		t.Fatalf("WriteDir fail: %v", err)
+	}
+	if g, e := again.Root, m.Root; g != e {
+		t.Errorf("rewrite changed root: %v != %v", g, e)
+	}
+}
+
+func TestEmptyDir(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+
+	m, err := dirs.WriteDir(ctx, store, &dirs.Dir{})
+	if err != nil {
+		t.Fatalf("WriteDir fail: %v", err)
+	}
+	if g, e := *m, *dirs.EmptyManifest(); g != e {
+		t.Errorf("empty dir is not the empty manifest: %+v != %+v", g, e)
+	}
+	d, err := dirs.ReadDir(ctx, store, dirs.EmptyManifest())
+	if err != nil {
+		t.Fatalf("ReadDir fail: %v", err)
+	}
+	if g, e := d.Len(), 0; g != e {
+		t.Errorf("wrong length: %d != %d", g, e)
+	}
+}
+
+func TestCorruptDir(t *testing.T) {
+	var d dirs.Dir
+	d.Set(fileEntry("a", 1))
+	d.Set(fileEntry("b", 2))
+	buf, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary fail: %v", err)
+	}
+	for i := 1; i < len(buf); i++ {
+		var cut dirs.Dir
+		if err := cut.UnmarshalBinary(buf[:i]); err == nil {
+			t.Errorf("truncated directory decoded: %d of %d bytes", i, len(buf))
+		}
+	}
+
+	// entries out of order
+	swapped := bytes.Replace(buf, []byte("\x01a\x01"), []byte("\x01c\x01"), 1)
+	var bad dirs.Dir
+	err = bad.UnmarshalBinary(swapped)
+	var corrupt dirs.CorruptDirError
+	if !errors.As(err, &corrupt) {
+		t.Errorf("wrong error for unsorted entries: %v", err)
+	}
+}
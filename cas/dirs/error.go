@@ -0,0 +1,50 @@
+package dirs
+
+import "fmt"
+
+// NotFoundError is returned for a path that does not exist in a Tree.
+type NotFoundError struct {
+	Path string
+}
+
+var _ error = NotFoundError{}
+
+func (n NotFoundError) Error() string {
+	return fmt.Sprintf("[ErrDirs] Not found: %s", n.Path)
+}
+
+// NotDirError is returned when a path goes through an entry that is
+// not a directory.
+type NotDirError struct {
+	Path string
+}
+
+var _ error = NotDirError{}
+
+func (n NotDirError) Error() string {
+	return fmt.Sprintf("[ErrDirs] Not a directory: %s", n.Path)
+}
+
+// BadNameError is returned for an entry name that is empty, "." or
+// "..", or contains a slash or a zero byte.
+type BadNameError struct {
+	Name string
+}
+
+var _ error = BadNameError{}
+
+func (b BadNameError) Error() string {
+	return fmt.Sprintf("[ErrDirs] bad name: %q", b.Name)
+}
+
+// CorruptDirError is returned when the data of a directory blob
+// cannot be decoded.
+type CorruptDirError struct {
+	Reason string
+}
+
+var _ error = CorruptDirError{}
+
+func (c CorruptDirError) Error() string {
+	return fmt.Sprintf("[ErrDirs] corrupt directory: %s", c.Reason)
+}
@@ -0,0 +1,46 @@
+package dirs
+
+import (
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/gc"
+)
+
+// MarkTree marks for gc every chunk of the tree saved under key: the
+// manifest of its root directory, and the blobs of all directories and
+// files below. Directories marked before, as part of another tree, are
+// not read again.
+func MarkTree(ctx context.Context, m *gc.Marker, key cas.Key) error {
+	if !m.Chunk(key, blobs.ManifestType, 0) {
+		return nil
+	}
+	root, err := blobs.LoadManifest(ctx, m.Store(), key)
+	if err != nil {
+		return err
+	}
+	return markDir(ctx, m, root)
+}
+
+func markDir(ctx context.Context, m *gc.Marker, manifest *blobs.Manifest) error {
+	isNew, err := m.Blob(ctx, manifest)
+	if err != nil || !isNew {
+		return err
+	}
+	d, err := ReadDir(ctx, m.Store(), manifest)
+	if err != nil {
+		return err
+	}
+	for i := range d.entries {
+		e := &d.entries[i]
+		if e.IsDir() {
+			err = markDir(ctx, m, &e.Manifest)
+		} else {
+			_, err = m.Blob(ctx, &e.Manifest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package dirs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store"
+	"os"
+	"path/filepath"
+)
+
+// FileBlobType is the type of the blobs holding files.
+const FileBlobType = "file"
+
+// ImportOptions tune Import.
+type ImportOptions struct {
+	// ContentDefined stores files as content-defined blobs. An
+	// insertion into a file then only changes the chunks around it,
+	// so a file edited between imports still shares the rest of its
+	// chunks with the earlier import.
+	ContentDefined bool
+}
+
+// Import stores the local directory dir and everything below it, and
+// returns the tree. Only regular files and directories are stored;
+// other kinds of files, such as symlinks, are skipped. opts may be
+// nil.
+func Import(ctx context.Context, chunkStore store.IF, dir string, opts *ImportOptions) (*Tree, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	m, err := importDir(ctx, chunkStore, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tree{store: chunkStore, root: *m}, nil
+}
+
+func importDir(ctx context.Context, chunkStore store.IF, dir string, opts *ImportOptions) (*blobs.Manifest, error) {
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var d Dir
+	for _, de := range list {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p := filepath.Join(dir, de.Name())
+		info, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{
+			Name:  de.Name(),
+			Mode:  uint32(info.Mode().Perm()),
+			Mtime: info.ModTime(),
+		}
+		var m *blobs.Manifest
+		switch {
+		case info.IsDir():
+			e.Kind = KindDir
+			m, err = importDir(ctx, chunkStore, p, opts)
+		case info.Mode().IsRegular():
+			e.Kind = KindFile
+			m, err = importFile(ctx, chunkStore, p, opts)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		e.Manifest = *m
+		e.Size = m.Size
+		if err := d.Set(e); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return WriteDir(ctx, chunkStore, &d)
+}
+
+func importFile(ctx context.Context, chunkStore store.IF, name string, opts *ImportOptions) (*blobs.Manifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := blobs.EmptyManifest(FileBlobType)
+	if opts.ContentDefined {
+		m = blobs.EmptyCDCManifest(FileBlobType)
+	}
+	w, err := blobs.NewWriter(ctx, chunkStore, m)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.ReadFrom(f); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Manifest(), nil
+}
+
+// Export writes the tree into the local directory dir, which is
+// created if needed. Files already there with the names of entries are
+// replaced; others are left alone.
+func (t *Tree) Export(ctx context.Context, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return t.exportDir(ctx, &t.root, dir)
+}
+
+func (t *Tree) exportDir(ctx context.Context, m *blobs.Manifest, dir string) error {
+	d, err := ReadDir(ctx, t.store, m)
+	if err != nil {
+		return err
+	}
+	for _, e := range d.entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p := filepath.Join(dir, e.Name)
+		if e.IsDir() {
+			if err := os.Mkdir(p, 0o700); err != nil && !os.IsExist(err) {
+				return err
+			}
+			if err := t.exportDir(ctx, &e.Manifest, p); err != nil {
+				return err
+			}
+		} else if err := t.exportFile(ctx, &e, p); err != nil {
+			return err
+		}
+		// after the contents, which change the mtime of a directory
+		if err := os.Chmod(p, fs.FileMode(e.Mode).Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(p, e.Mtime, e.Mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tree) exportFile(ctx context.Context, e *Entry, name string) error {
+	blob, err := blobs.Open(t.store, &e.Manifest)
+	if err != nil {
+		return err
+	}
+	// a file of the same name may be read-only
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	r := blobs.NewReader(ctx, blob, 0)
+	defer r.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,149 @@
+package dirs_test
+
+import (
+	"bytes"
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/store/mem"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportExport(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+	src := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+
+	big := bytes.Repeat([]byte("0123456789"), 10000)
+	files := map[string][]byte{
+		"a.txt":       []byte("hello"),
+		"empty":       nil,
+		"sub/big.bin": big,
+		"sub/deep/x":  []byte("x"),
+	}
+	for name, data := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := dirs.Import(ctx, store, src, nil)
+	if err != nil {
+		t.Fatalf("Import fail: %v", err)
+	}
+	e, err := tree.Lookup(ctx, "sub/big.bin")
+	if err != nil {
+		t.Fatalf("Lookup fail: %v", err)
+	}
+	if g, ex := e.Size, uint64(len(big)); g != ex {
+		t.Errorf("wrong size: %d != %d", g, ex)
+	}
+	if g, ex := e.Mode, uint32(0o640); g != ex {
+		t.Errorf("wrong mode: %o != %o", g, ex)
+	}
+	if _, err := tree.Lookup(ctx, "link"); err == nil {
+		t.Errorf("symlink imported")
+	}
+
+	dst := filepath.Join(t.TempDir(), "out")
+	if err := tree.Export(ctx, dst); err != nil {
+		t.Fatalf("Export fail: %v", err)
+	}
+	for name, data := range files {
+		p := filepath.Join(dst, name)
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Errorf("read %s fail: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("wrong content of %s", name)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, ex := info.Mode().Perm(), os.FileMode(0o640); g != ex {
+			t.Errorf("wrong mode of %s: %v != %v", name, g, ex)
+		}
+		if g, ex := info.ModTime(), mtime; !g.Equal(ex) {
+			t.Errorf("wrong mtime of %s: %v != %v", name, g, ex)
+		}
+	}
+
+	// importing the export gives the same tree
+	again, err := dirs.Import(ctx, store, dst, nil)
+	if err != nil {
+		t.Fatalf("Import fail: %v", err)
+	}
+	if g, ex := again.Root().Root, tree.Root().Root; g != ex {
+		t.Errorf("export changed the tree: %v != %v", g, ex)
+	}
+}
+
+func TestImportContentDefined(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+	src := t.TempDir()
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	p := filepath.Join(src, "file")
+
+	leaves := func() map[cas.Key]bool {
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tree, err := dirs.Import(ctx, store, src, &dirs.ImportOptions{ContentDefined: true})
+		if err != nil {
+			t.Fatalf("Import fail: %v", err)
+		}
+		e, err := tree.Lookup(ctx, "file")
+		if err != nil {
+			t.Fatalf("Lookup fail: %v", err)
+		}
+		if !e.Manifest.Chunking.IsContentDefined() {
+			t.Fatalf("file not content-defined: %+v", e.Manifest)
+		}
+		keys := make(map[cas.Key]bool)
+		err = blobs.Walk(ctx, store, &e.Manifest, func(key cas.Key, level uint8) error {
+			if level == 0 {
+				keys[key] = true
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk fail: %v", err)
+		}
+		return keys
+	}
+	before := leaves()
+	data = append([]byte("inserted"), data...)
+	after := leaves()
+
+	shared := 0
+	for key := range after {
+		if before[key] {
+			shared++
+		}
+	}
+	// only the leaves around the insertion change
+	if shared < len(before)-2 {
+		t.Errorf("insertion changed too many chunks: %d of %d shared", shared, len(before))
+	}
+}
@@ -0,0 +1,219 @@
+package dirs
+
+import (
+	"context"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store"
+	"path"
+	"strings"
+)
+
+// Tree is a directory tree addressed by the manifest of its root
+// directory. A Tree is never modified: Put and Remove return a new Tree
+// sharing every directory off the changed path with the old one.
+type Tree struct {
+	store store.IF
+	root  blobs.Manifest
+}
+
+// Empty returns a tree with nothing in its root directory.
+func Empty(chunkStore store.IF) *Tree {
+	return &Tree{store: chunkStore, root: *EmptyManifest()}
+}
+
+// Open returns the tree whose root directory is the blob of manifest
+// root.
+func Open(chunkStore store.IF, root *blobs.Manifest) (*Tree, error) {
+	if err := root.Validate(); err != nil {
+		return nil, err
+	}
+	if root.Type != BlobType {
+		return nil, CorruptDirError{Reason: fmt.Sprintf("blob type %q is not a directory", root.Type)}
+	}
+	return &Tree{store: chunkStore, root: *root}, nil
+}
+
+// Load opens the tree whose root manifest was saved under key by Save.
+func Load(ctx context.Context, chunkStore store.IF, key cas.Key) (*Tree, error) {
+	m, err := blobs.LoadManifest(ctx, chunkStore, key)
+	if err != nil {
+		return nil, err
+	}
+	return Open(chunkStore, m)
+}
+
+// Save stores the manifest of the root directory, and returns the key
+// addressing the whole tree.
+func (t *Tree) Save(ctx context.Context) (cas.Key, error) {
+	return blobs.SaveManifest(ctx, t.store, &t.root)
+}
+
+// Root returns the manifest of the root directory.
+func (t *Tree) Root() *blobs.Manifest {
+	m := t.root
+	return &m
+}
+
+// RootEntry returns an entry describing the root directory. It has no
+// name.
+func (t *Tree) RootEntry() Entry {
+	return Entry{
+		Kind:     KindDir,
+		Mode:     0o755,
+		Size:     t.root.Size,
+		Manifest: t.root,
+	}
+}
+
+// split cleans p, which is relative to the root of the tree whether or
+// not it starts with a slash, and returns its names.
+func split(p string) []string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+func joinPath(names []string) string {
+	return "/" + strings.Join(names, "/")
+}
+
+// child returns the entry called names[i] in d.
+func child(d *Dir, names []string, i int) (Entry, error) {
+	e, ok := d.Lookup(names[i])
+	if !ok {
+		return Entry{}, NotFoundError{joinPath(names[:i+1])}
+	}
+	if i < len(names)-1 && !e.IsDir() {
+		return Entry{}, NotDirError{joinPath(names[:i+1])}
+	}
+	return e, nil
+}
+
+// Lookup returns the entry at path p.
+func (t *Tree) Lookup(ctx context.Context, p string) (Entry, error) {
+	names := split(p)
+	e := t.RootEntry()
+	for i := range names {
+		d, err := ReadDir(ctx, t.store, &e.Manifest)
+		if err != nil {
+			return Entry{}, err
+		}
+		if e, err = child(d, names, i); err != nil {
+			return Entry{}, err
+		}
+	}
+	return e, nil
+}
+
+// ReadDir returns the directory at path p.
+func (t *Tree) ReadDir(ctx context.Context, p string) (*Dir, error) {
+	e, err := t.Lookup(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsDir() {
+		return nil, NotDirError{path.Clean("/" + p)}
+	}
+	return ReadDir(ctx, t.store, &e.Manifest)
+}
+
+// List returns the entries of the directory at path p, sorted by name.
+func (t *Tree) List(ctx context.Context, p string) ([]Entry, error) {
+	d, err := t.ReadDir(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return d.entries, nil
+}
+
+// update rewrites the directories from dir m down to the parent of
+// names[len(names)-1], applying fn to that parent, and returns the
+// manifest of the new directory m.
+func (t *Tree) update(ctx context.Context, m *blobs.Manifest, names []string, i int, fn func(d *Dir, name string) error) (*blobs.Manifest, error) {
+	d, err := ReadDir(ctx, t.store, m)
+	if err != nil {
+		return nil, err
+	}
+	if i == len(names)-1 {
+		if err := fn(d, names[i]); err != nil {
+			return nil, err
+		}
+	} else {
+		e, err := child(d, names, i)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := t.update(ctx, &e.Manifest, names, i+1, fn)
+		if err != nil {
+			return nil, err
+		}
+		e.Manifest = *sub
+		e.Size = sub.Size
+		if err := d.Set(e); err != nil {
+			return nil, err
+		}
+	}
+	return WriteDir(ctx, t.store, d)
+}
+
+func (t *Tree) updated(ctx context.Context, p string, fn func(d *Dir, name string) error) (*Tree, error) {
+	names := split(p)
+	if len(names) == 0 {
+		return nil, BadNameError{p}
+	}
+	root, err := t.update(ctx, &t.root, names, 0, fn)
+	if err != nil {
+		return nil, err
+	}
+	return &Tree{store: t.store, root: *root}, nil
+}
+
+// Put returns a tree with e at path p, replacing any entry there. The
+// parent of p must be a directory; the name of e is taken from p.
+func (t *Tree) Put(ctx context.Context, p string, e Entry) (*Tree, error) {
+	return t.updated(ctx, p, func(d *Dir, name string) error {
+		e.Name = name
+		return d.Set(e)
+	})
+}
+
+// Remove returns a tree without the entry at path p, and for a
+// directory, everything below it.
+func (t *Tree) Remove(ctx context.Context, p string) (*Tree, error) {
+	return t.updated(ctx, p, func(d *Dir, name string) error {
+		if !d.Remove(name) {
+			return NotFoundError{path.Clean("/" + p)}
+		}
+		return nil
+	})
+}
+
+// Walk calls fn for every entry of the tree, parents before their
+// children and siblings in name order, with the path of the entry. It
+// stops at the first error.
+func (t *Tree) Walk(ctx context.Context, fn func(p string, e Entry) error) error {
+	return t.walk(ctx, "/", &t.root, fn)
+}
+
+func (t *Tree) walk(ctx context.Context, dir string, m *blobs.Manifest, fn func(p string, e Entry) error) error {
+	d, err := ReadDir(ctx, t.store, m)
+	if err != nil {
+		return err
+	}
+	for _, e := range d.entries {
+		p := path.Join(dir, e.Name)
+		if err := fn(p, e); err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if err := t.walk(ctx, p, &e.Manifest, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
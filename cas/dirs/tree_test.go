@@ -0,0 +1,158 @@
+package dirs_test
+
+import (
+	"context"
+	"errors"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/store/mem"
+	"reflect"
+	"testing"
+)
+
+func dirEntry() dirs.Entry {
+	return dirs.Entry{Kind: dirs.KindDir, Mode: 0o755, Manifest: *dirs.EmptyManifest()}
+}
+
+func TestTreeCopyOnWrite(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+
+	empty := dirs.Empty(store)
+	t1, err := empty.Put(ctx, "/a", dirEntry())
+	if err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	t2, err := t1.Put(ctx, "/a/b", fileEntry("", 3))
+	if err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+
+	// older trees are unchanged
+	if list, err := t1.List(ctx, "a"); err != nil || len(list) != 0 {
+		t.Errorf("old tree changed: %v, %v", list, err)
+	}
+	if list, err := empty.List(ctx, "/"); err != nil || len(list) != 0 {
+		t.Errorf("empty tree changed: %v, %v", list, err)
+	}
+
+	e, err := t2.Lookup(ctx, "/a/b")
+	if err != nil {
+		t.Fatalf("Lookup fail: %v", err)
+	}
+	if g, ex := e.Name, "b"; g != ex {
+		t.Errorf("wrong name: %q != %q", g, ex)
+	}
+	if g, ex := e.Size, uint64(3); g != ex {
+		t.Errorf("wrong size: %d != %d", g, ex)
+	}
+	a, err := t2.Lookup(ctx, "a/")
+	if err != nil {
+		t.Fatalf("Lookup fail: %v", err)
+	}
+	if g, ex := a.Size, a.Manifest.Size; g != ex || g == 0 {
+		t.Errorf("wrong directory size: %d != %d", g, ex)
+	}
+
+	// same content, same key
+	t3, err := empty.Put(ctx, "/a", a)
+	if err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	k2, err := t2.Save(ctx)
+	if err != nil {
+		t.Fatalf("Save fail: %v", err)
+	}
+	k3, err := t3.Save(ctx)
+	if err != nil {
+		t.Fatalf("Save fail: %v", err)
+	}
+	if k2 != k3 {
+		t.Errorf("equal trees have different keys: %v != %v", k2, k3)
+	}
+
+	loaded, err := dirs.Load(ctx, store, k2)
+	if err != nil {
+		t.Fatalf("Load fail: %v", err)
+	}
+	if g, ex := *loaded.Root(), *t2.Root(); g != ex {
+		t.Errorf("wrong root: %+v != %+v", g, ex)
+	}
+}
+
+func TestTreeRemove(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+
+	tree := dirs.Empty(store)
+	var err error
+	for _, step := range []struct {
+		path string
+		e    dirs.Entry
+	}{
+		{"/a", dirEntry()},
+		{"/a/b", dirEntry()},
+		{"/a/b/c", fileEntry("", 1)},
+		{"/a/d", fileEntry("", 2)},
+	} {
+		if tree, err = tree.Put(ctx, step.path, step.e); err != nil {
+			t.Fatalf("Put %s fail: %v", step.path, err)
+		}
+	}
+
+	removed, err := tree.Remove(ctx, "/a/b")
+	if err != nil {
+		t.Fatalf("Remove fail: %v", err)
+	}
+	var walked []string
+	err = removed.Walk(ctx, func(p string, e dirs.Entry) error {
+		walked = append(walked, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk fail: %v", err)
+	}
+	if g, e := walked, []string{"/a", "/a/d"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong tree after Remove: %q != %q", g, e)
+	}
+
+	walked = nil
+	tree.Walk(ctx, func(p string, e dirs.Entry) error {
+		walked = append(walked, p)
+		return nil
+	})
+	if g, e := walked, []string{"/a", "/a/b", "/a/b/c", "/a/d"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong tree: %q != %q", g, e)
+	}
+}
+
+func TestTreeErrors(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+
+	tree, err := dirs.Empty(store).Put(ctx, "/f", fileEntry("", 1))
+	if err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+
+	var notFound dirs.NotFoundError
+	if _, err := tree.Lookup(ctx, "/missing/x"); !errors.As(err, &notFound) || notFound.Path != "/missing" {
+		t.Errorf("wrong error: %v", err)
+	}
+	if _, err := tree.Remove(ctx, "/missing"); !errors.As(err, &notFound) {
+		t.Errorf("wrong error: %v", err)
+	}
+	var notDir dirs.NotDirError
+	if _, err := tree.Lookup(ctx, "/f/x"); !errors.As(err, &notDir) || notDir.Path != "/f" {
+		t.Errorf("wrong error: %v", err)
+	}
+	if _, err := tree.Put(ctx, "/f/x", fileEntry("", 1)); !errors.As(err, &notDir) {
+		t.Errorf("wrong error: %v", err)
+	}
+	if _, err := tree.List(ctx, "/f"); !errors.As(err, &notDir) {
+		t.Errorf("wrong error: %v", err)
+	}
+	var badName dirs.BadNameError
+	if _, err := tree.Put(ctx, "/", dirEntry()); !errors.As(err, &badName) {
+		t.Errorf("wrong error: %v", err)
+	}
+}
@@ -13,3 +13,19 @@ var _ error = NotFoundError{}
 func (n NotFoundError) Error() string {
 	return fmt.Sprintf("[ErrCAS] Not found: %q@%d %s", n.Type, n.Level, n.Key)
 }
+
+// CorruptChunkError is returned when the data stored for a chunk does
+// not hash to its key.
+type CorruptChunkError struct {
+	Type  string
+	Level uint8
+	Key   Key
+	// Actual is the key the data hashes to.
+	Actual Key
+}
+
+var _ error = CorruptChunkError{}
+
+func (c CorruptChunkError) Error() string {
+	return fmt.Sprintf("[ErrCAS] Corrupt chunk: %q@%d %s hashes to %s", c.Type, c.Level, &c.Key, &c.Actual)
+}
@@ -0,0 +1,11 @@
+package gc
+
+// NoRootsError is returned by a run that found no root: every chunk
+// would be garbage, which is far more likely a mistake than intended.
+type NoRootsError struct{}
+
+var _ error = NoRootsError{}
+
+func (NoRootsError) Error() string {
+	return "[ErrGC] no roots, refusing to delete every chunk"
+}
@@ -0,0 +1,295 @@
+// Package gc deletes the chunks no blob refers to any more.
+//
+// A run marks every chunk reachable from a set of root manifests, and
+// from the Roots found in the store itself, then sweeps the chunks of
+// a snapshot of the kv store that were not marked. A run without any
+// root would delete everything, so it is refused. Chunks written after
+// the snapshot was taken are never swept, so writers may keep adding
+// chunks during a run.
+//
+// A chunk that is unreachable from the roots is not necessarily
+// garbage: a writer may have stored it but not yet published the
+// manifest referring to it, or may have re-added an existing chunk to
+// reuse it. With a Grace period, a run only records when it first saw
+// each unreachable chunk, and a later run deletes it once it has been
+// unreachable for that long.
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/store"
+	storekv "lifs_go/cas/store/kv"
+	"lifs_go/kv"
+	"time"
+)
+
+// batchSize is the number of deletes and marker updates written at
+// once.
+const batchSize = 1000
+
+// pendingPrefix starts the kv keys recording when an unreachable chunk
+// was first seen, followed by the kv key of the chunk. It has the
+// prefix of special cas keys, which are never stored as chunks.
+var pendingPrefix = append(make([]byte, cas.SpecialPrefixSize), "gc-pending:"...)
+
+type Options struct {
+	// DryRun reports what a run would delete without changing the
+	// store.
+	DryRun bool
+	// Grace is how long a chunk must have been unreachable, as seen
+	// by earlier runs, before it is deleted. Zero deletes unreachable
+	// chunks at once.
+	Grace time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// OnGarbage, if set, is called for every chunk deleted, or in a
+	// dry run every chunk that would be.
+	OnGarbage func(key cas.Key, type_ string, level uint8)
+	// Roots are marked along with the root manifests.
+	Roots []Roots
+}
+
+// Roots finds roots kept in the store, such as refs, and marks the
+// chunks reachable from them.
+type Roots interface {
+	// MarkRoots marks the chunks reachable from the roots, and
+	// returns how many roots there were.
+	MarkRoots(ctx context.Context, m *Marker) (int, error)
+}
+
+// Marker records the chunks reachable from the roots of a run.
+type Marker struct {
+	store  store.IF
+	marked map[string]struct{}
+}
+
+// Store returns the chunk store being collected.
+func (m *Marker) Store() store.IF {
+	return m.store
+}
+
+// Chunk marks a single chunk, and reports whether it was not marked
+// before.
+func (m *Marker) Chunk(key cas.Key, type_ string, level uint8) bool {
+	k := string(storekv.MakeKey(key, type_, level))
+	if _, ok := m.marked[k]; ok {
+		return false
+	}
+	m.marked[k] = struct{}{}
+	return true
+}
+
+// Blob marks every chunk of the blob described by manifest, and
+// reports whether its root chunk was not marked before. An empty blob
+// has no chunks, so it is never new.
+func (m *Marker) Blob(ctx context.Context, manifest *blobs.Manifest) (bool, error) {
+	type_ := manifest.Type
+	isNew := false
+	err := blobs.Walk(ctx, m.store, manifest, func(key cas.Key, level uint8) error {
+		if !m.Chunk(key, type_, level) {
+			// shared with a blob marked before
+			return blobs.SkipChildren
+		}
+		if key == manifest.Root {
+			isNew = true
+		}
+		return nil
+	})
+	return isNew, err
+}
+
+// Report sums up a run.
+type Report struct {
+	DryRun bool
+	Roots  int
+	// Reachable is the number of distinct chunks marked from the
+	// roots.
+	Reachable int
+	// Scanned is the number of chunks found in the store.
+	Scanned int
+	// Garbage is the number of chunks deleted, or in a dry run the
+	// number that would be.
+	Garbage int
+	// Pending is the number of unreachable chunks kept because of the
+	// grace period.
+	Pending int
+	// Foreign is the number of kv keys that do not name a chunk; they
+	// are left alone.
+	Foreign int
+}
+
+// Run collects the garbage of the chunk store kept in target, keeping
+// every chunk of the blobs described by roots and of opts.Roots. If
+// marking fails, for example because a pointer chunk is missing,
+// nothing is deleted. Unless it is a dry run, a run finding no root at
+// all fails with a NoRootsError.
+func Run(ctx context.Context, target kv.IF, roots []*blobs.Manifest, opts *Options) (*Report, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	report := &Report{DryRun: opts.DryRun, Roots: len(roots)}
+
+	// anything written from here on is out of reach of the sweep
+	snap, err := target.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	marked, n, err := mark(ctx, target, roots, opts.Roots)
+	if err != nil {
+		return nil, err
+	}
+	report.Roots += n
+	report.Reachable = len(marked)
+	if report.Roots == 0 && !opts.DryRun {
+		return nil, NoRootsError{}
+	}
+
+	s := &sweeper{
+		ctx:     ctx,
+		target:  target,
+		snap:    snap,
+		opts:    opts,
+		now:     now(),
+		report:  report,
+		marked:  marked,
+		markers: make(map[string]time.Time),
+	}
+	if err := snap.Iterate(ctx, kv.All, s.visit); err != nil {
+		return nil, err
+	}
+	// markers of chunks that are gone for other reasons
+	for k := range s.markers {
+		s.delete(pendingKey([]byte(k)))
+	}
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// mark returns the kv keys of all chunks reachable from roots and
+// from sources, and the number of roots the sources found.
+func mark(ctx context.Context, target kv.IF, roots []*blobs.Manifest, sources []Roots) (map[string]struct{}, int, error) {
+	m := &Marker{store: storekv.New(target), marked: make(map[string]struct{})}
+	for _, root := range roots {
+		if _, err := m.Blob(ctx, root); err != nil {
+			return nil, 0, err
+		}
+	}
+	found := 0
+	for _, source := range sources {
+		n, err := source.MarkRoots(ctx, m)
+		if err != nil {
+			return nil, 0, err
+		}
+		found += n
+	}
+	return m.marked, found, nil
+}
+
+func pendingKey(chunkKey []byte) []byte {
+	k := make([]byte, 0, len(pendingPrefix)+len(chunkKey))
+	k = append(k, pendingPrefix...)
+	return append(k, chunkKey...)
+}
+
+type sweeper struct {
+	ctx    context.Context
+	target kv.IF
+	snap   kv.Snapshot
+	opts   *Options
+	now    time.Time
+	report *Report
+	marked map[string]struct{}
+	// markers holds the pending markers not yet matched with a chunk
+	markers map[string]time.Time
+	batch   kv.Batch
+}
+
+func (s *sweeper) visit(k []byte) error {
+	if bytes.HasPrefix(k, pendingPrefix) {
+		// markers sort before all chunks, as their prefix is zeroes
+		v, err := s.snap.Get(s.ctx, k)
+		if err != nil {
+			return err
+		}
+		if len(v) != 8 {
+			return fmt.Errorf("bad gc pending marker %x: %x", k, v)
+		}
+		s.markers[string(k[len(pendingPrefix):])] = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+		return nil
+	}
+
+	key, type_, level, ok := storekv.ParseKey(k)
+	if !ok {
+		s.report.Foreign++
+		return nil
+	}
+	s.report.Scanned++
+	seen, hasMarker := s.markers[string(k)]
+	delete(s.markers, string(k))
+	if _, ok := s.marked[string(k)]; ok {
+		if hasMarker {
+			// reachable again
+			s.delete(pendingKey(k))
+		}
+		return s.flushFull()
+	}
+
+	switch {
+	case s.opts.Grace <= 0, hasMarker && s.now.Sub(seen) >= s.opts.Grace:
+		s.report.Garbage++
+		if s.opts.OnGarbage != nil {
+			s.opts.OnGarbage(key, type_, level)
+		}
+		s.delete(append([]byte(nil), k...))
+		if hasMarker {
+			s.delete(pendingKey(k))
+		}
+	case hasMarker:
+		s.report.Pending++
+	default:
+		s.report.Pending++
+		if !s.opts.DryRun {
+			var v [8]byte
+			binary.BigEndian.PutUint64(v[:], uint64(s.now.UnixNano()))
+			s.batch.Put(pendingKey(k), v[:])
+		}
+	}
+	return s.flushFull()
+}
+
+func (s *sweeper) delete(k []byte) {
+	if !s.opts.DryRun {
+		s.batch.Delete(k)
+	}
+}
+
+func (s *sweeper) flushFull() error {
+	if s.batch.Len() < batchSize {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *sweeper) flush() error {
+	if s.batch.Len() == 0 {
+		return nil
+	}
+	if err := s.target.Write(s.ctx, &s.batch); err != nil {
+		return err
+	}
+	s.batch.Reset()
+	return nil
+}
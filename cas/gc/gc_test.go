@@ -0,0 +1,287 @@
+package gc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/gc"
+	"lifs_go/cas/store"
+	storekv "lifs_go/cas/store/kv"
+	"lifs_go/kv"
+	kvmem "lifs_go/kv/mem"
+	"testing"
+	"time"
+)
+
+func smallManifest() *blobs.Manifest {
+	return &blobs.Manifest{
+		Type:      "footype",
+		ChunkSize: blobs.MinChunkSize,
+		Fanout:    2,
+	}
+}
+
+func write(t *testing.T, chunkStore store.IF, m *blobs.Manifest, data []byte, off int64) *blobs.Manifest {
+	t.Helper()
+	ctx := context.Background()
+	blob, err := blobs.Open(chunkStore, m)
+	if err != nil {
+		t.Fatalf("Open fail: %v", err)
+	}
+	if _, err := blob.IO(ctx).WriteAt(data, off); err != nil {
+		t.Fatalf("WriteAt fail: %v", err)
+	}
+	saved, err := blob.Save(ctx)
+	if err != nil {
+		t.Fatalf("Save fail: %v", err)
+	}
+	return saved
+}
+
+func read(t *testing.T, chunkStore store.IF, m *blobs.Manifest) []byte {
+	t.Helper()
+	blob, err := blobs.Open(chunkStore, m)
+	if err != nil {
+		t.Fatalf("Open fail: %v", err)
+	}
+	buf := make([]byte, m.Size)
+	if _, err := blob.IO(context.Background()).ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadAt fail: %v", err)
+	}
+	return buf
+}
+
+func count(t *testing.T, target kv.IF) int {
+	t.Helper()
+	n := 0
+	err := target.Iterate(context.Background(), kv.All, func(key []byte) error {
+		if _, _, _, ok := storekv.ParseKey(key); ok {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate fail: %v", err)
+	}
+	return n
+}
+
+func run(t *testing.T, target kv.IF, roots []*blobs.Manifest, opts *gc.Options) *gc.Report {
+	t.Helper()
+	report, err := gc.Run(context.Background(), target, roots, opts)
+	if err != nil {
+		t.Fatalf("gc.Run fail: %v", err)
+	}
+	return report
+}
+
+func TestSweep(t *testing.T) {
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	old := write(t, chunkStore, smallManifest(), data, 0)
+	latest := write(t, chunkStore, old, []byte("changed"), 5000)
+	want := read(t, chunkStore, latest)
+	before := count(t, target)
+
+	// a foreign key must survive
+	ctx := context.Background()
+	if err := target.Put(ctx, []byte("foreign"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	var garbage int
+	report := run(t, target, []*blobs.Manifest{latest}, &gc.Options{
+		DryRun:    true,
+		OnGarbage: func(cas.Key, string, uint8) { garbage++ },
+	})
+	if report.Garbage == 0 || report.Garbage != garbage {
+		t.Errorf("dry run found no garbage: %+v, %d", report, garbage)
+	}
+	if g, e := count(t, target), before; g != e {
+		t.Errorf("dry run deleted chunks: %d != %d", g, e)
+	}
+	if g, e := report.Reachable+report.Garbage, before; g != e {
+		t.Errorf("reachable and garbage do not add up: %d != %d", g, e)
+	}
+
+	swept := run(t, target, []*blobs.Manifest{latest}, nil)
+	if g, e := swept.Garbage, report.Garbage; g != e {
+		t.Errorf("sweep differs from dry run: %d != %d", g, e)
+	}
+	if g, e := swept.Foreign, 1; g != e {
+		t.Errorf("wrong foreign count: %d != %d", g, e)
+	}
+	if g, e := count(t, target), report.Reachable; g != e {
+		t.Errorf("wrong chunks left: %d != %d", g, e)
+	}
+	if !bytes.Equal(read(t, chunkStore, latest), want) {
+		t.Errorf("latest blob changed by gc")
+	}
+	if ok, err := target.Has(ctx, []byte("foreign")); err != nil || !ok {
+		t.Errorf("foreign key was swept: %v %v", ok, err)
+	}
+
+	// reading the old blob hits a deleted chunk now
+	blob, err := blobs.Open(chunkStore, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = blob.IO(ctx).ReadAt(make([]byte, old.Size), 0)
+	var nf cas.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Errorf("old blob should be gone: %v", err)
+	}
+}
+
+func TestSharedChunks(t *testing.T) {
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	a := write(t, chunkStore, smallManifest(), data, 0)
+	b := write(t, chunkStore, a, []byte("changed"), 5000)
+	cdc := &blobs.Manifest{
+		Type:     "footype",
+		Fanout:   4,
+		Chunking: blobs.Chunking{Algorithm: blobs.ChunkingFastCDC, Min: 256, Avg: 1024, Max: 4096},
+	}
+	w, err := blobs.NewCDCWriter(context.Background(), chunkStore, cdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := count(t, target)
+
+	report := run(t, target, []*blobs.Manifest{a, b, c}, nil)
+	if g, e := report.Garbage, 0; g != e {
+		t.Errorf("chunks of roots were swept: %d", g)
+	}
+	if g, e := count(t, target), before; g != e {
+		t.Errorf("chunks of roots were swept: %d != %d", g, e)
+	}
+	for _, m := range []*blobs.Manifest{a, c} {
+		if !bytes.Equal(read(t, chunkStore, m), data) {
+			t.Errorf("blob changed by gc")
+		}
+	}
+}
+
+func TestGrace(t *testing.T) {
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	old := write(t, chunkStore, smallManifest(), data, 0)
+	latest := write(t, chunkStore, old, []byte("changed"), 5000)
+	before := count(t, target)
+
+	now := time.Unix(1000000, 0)
+	opts := &gc.Options{
+		Grace: time.Hour,
+		Now:   func() time.Time { return now },
+	}
+	first := run(t, target, []*blobs.Manifest{latest}, opts)
+	if first.Garbage != 0 || first.Pending == 0 {
+		t.Errorf("first run should only mark pending chunks: %+v", first)
+	}
+	if g, e := count(t, target), before; g != e {
+		t.Errorf("chunks deleted within grace: %d != %d", g, e)
+	}
+
+	now = now.Add(30 * time.Minute)
+	second := run(t, target, []*blobs.Manifest{latest}, opts)
+	if second.Garbage != 0 || second.Pending != first.Pending {
+		t.Errorf("second run is still within grace: %+v", second)
+	}
+
+	// the old blob is a root again: its chunks are no longer pending
+	now = now.Add(time.Hour)
+	third := run(t, target, []*blobs.Manifest{latest, old}, opts)
+	if third.Garbage != 0 || third.Pending != 0 {
+		t.Errorf("old blob is reachable again: %+v", third)
+	}
+	fourth := run(t, target, []*blobs.Manifest{latest}, opts)
+	if fourth.Garbage != 0 || fourth.Pending != first.Pending {
+		t.Errorf("grace should start over: %+v", fourth)
+	}
+
+	now = now.Add(time.Hour)
+	fifth := run(t, target, []*blobs.Manifest{latest}, opts)
+	if fifth.Garbage != first.Pending || fifth.Pending != 0 {
+		t.Errorf("grace is over: %+v", fifth)
+	}
+	if g, e := count(t, target), fifth.Reachable; g != e {
+		t.Errorf("wrong chunks left: %d != %d", g, e)
+	}
+	// no markers are left behind
+	n := 0
+	err := target.Iterate(context.Background(), kv.All, func([]byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := n, fifth.Reachable; g != e {
+		t.Errorf("markers left in store: %d != %d", g, e)
+	}
+}
+
+func TestMissingChunk(t *testing.T) {
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	old := write(t, chunkStore, smallManifest(), data, 0)
+	latest := write(t, chunkStore, old, []byte("changed"), 5000)
+	ctx := context.Background()
+	var rootKey []byte
+	err := target.Iterate(ctx, kv.All, func(k []byte) error {
+		if key, _, _, ok := storekv.ParseKey(k); ok && key == latest.Root {
+			rootKey = append([]byte(nil), k...)
+		}
+		return nil
+	})
+	if err != nil || rootKey == nil {
+		t.Fatalf("root chunk not found: %v", err)
+	}
+	if err := target.Delete(ctx, rootKey); err != nil {
+		t.Fatal(err)
+	}
+	before := count(t, target)
+
+	_, err = gc.Run(ctx, target, []*blobs.Manifest{latest}, nil)
+	var nf cas.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("expected NotFoundError: %v", err)
+	}
+	if g, e := count(t, target), before; g != e {
+		t.Errorf("failed run deleted chunks: %d != %d", g, e)
+	}
+}
+
+func TestNoRoots(t *testing.T) {
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	write(t, chunkStore, smallManifest(), bytes.Repeat([]byte("0123456789abcdef"), 1000), 0)
+	before := count(t, target)
+
+	var nr gc.NoRootsError
+	if _, err := gc.Run(context.Background(), target, nil, nil); !errors.As(err, &nr) {
+		t.Fatalf("expected NoRootsError: %v", err)
+	}
+	if g, e := count(t, target), before; g != e {
+		t.Errorf("run without roots deleted chunks: %d != %d", g, e)
+	}
+	report := run(t, target, nil, &gc.Options{DryRun: true})
+	if g, e := report.Garbage, before; g != e {
+		t.Errorf("wrong dry run garbage: %d != %d", g, e)
+	}
+}
@@ -97,6 +97,22 @@ func (k *Key) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+var _ encoding.TextMarshaler = (*Key)(nil)
+var _ encoding.TextUnmarshaler = (*Key)(nil)
+
+// MarshalText encodes the key in hex, like String.
+func (k *Key) MarshalText() (text []byte, err error) {
+	return []byte(k.String()), nil
+}
+
+func (k *Key) UnmarshalText(text []byte) error {
+	data := make([]byte, hex.DecodedLen(len(text)))
+	if _, err := hex.Decode(data, text); err != nil {
+		return err
+	}
+	return k.UnmarshalBinary(data)
+}
+
 func newKey(b []byte) Key {
 	k := Key{}
 	n := copy(k.object[:], b)
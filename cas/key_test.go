@@ -203,3 +203,25 @@ func TestKeyMarshalBinary(t *testing.T) {
 		t.Errorf("unexpected marshaled data: %q != %q", g, e)
 	}
 }
+
+func TestKeyText(t *testing.T) {
+	buf := bytes.Repeat([]byte("borketyBorkBORK!"), 4)
+	k := cas.NewKey(buf)
+	text, err := k.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(text), hex.EncodeToString(buf); g != e {
+		t.Errorf("bad text: %q != %q", g, e)
+	}
+	var k2 cas.Key
+	if err := k2.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if g, e := k2, k; g != e {
+		t.Errorf("text round trip changed key: %v != %v", g, e)
+	}
+	if err := k2.UnmarshalText([]byte("zz")); err == nil {
+		t.Errorf("unmarshal should have failed: %v", k2)
+	}
+}
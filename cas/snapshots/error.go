@@ -0,0 +1,53 @@
+package snapshots
+
+import "fmt"
+
+// RefNotFoundError is returned for a ref that is not set.
+type RefNotFoundError struct {
+	Name string
+}
+
+var _ error = RefNotFoundError{}
+
+func (r RefNotFoundError) Error() string {
+	return fmt.Sprintf("[ErrSnapshot] no such ref: %s", r.Name)
+}
+
+// BadRefNameError is returned for a ref name that is empty or contains
+// a zero byte or white space.
+type BadRefNameError struct {
+	Name string
+}
+
+var _ error = BadRefNameError{}
+
+func (b BadRefNameError) Error() string {
+	return fmt.Sprintf("[ErrSnapshot] bad ref name: %q", b.Name)
+}
+
+// RefKindError is returned for a ref looked up as one kind that is
+// only set as another, such as the tree of a filesystem taken for a
+// snapshot.
+type RefKindError struct {
+	Name string
+	Want RefKind
+	Kind RefKind
+}
+
+var _ error = RefKindError{}
+
+func (r RefKindError) Error() string {
+	return fmt.Sprintf("[ErrSnapshot] ref %s is a %s ref, not a %s ref", r.Name, r.Kind.noun(), r.Want.noun())
+}
+
+// BadSnapshotError is returned when decoding a snapshot that is
+// malformed, or uses a version or field this code does not know.
+type BadSnapshotError struct {
+	Reason string
+}
+
+var _ error = BadSnapshotError{}
+
+func (b BadSnapshotError) Error() string {
+	return fmt.Sprintf("[ErrSnapshot] bad snapshot: %s", b.Reason)
+}
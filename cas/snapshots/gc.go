@@ -0,0 +1,53 @@
+package snapshots
+
+import (
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/gc"
+)
+
+var _ gc.Roots = (*Refs)(nil)
+
+// MarkRoots marks for gc the chunks reachable from the refs: the tree
+// of a tree ref, or every snapshot in the history of a snapshot ref
+// with its tree. Each ref is a root.
+func (r *Refs) MarkRoots(ctx context.Context, m *gc.Marker) (int, error) {
+	refs, err := r.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, ref := range refs {
+		if r.kind == TreeRef {
+			err = dirs.MarkTree(ctx, m, ref.Key)
+		} else {
+			err = markHistory(ctx, m, ref.Key)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(refs), nil
+}
+
+// markHistory marks the snapshot key and its ancestors. The history
+// shared with a snapshot marked before is not loaded again.
+func markHistory(ctx context.Context, m *gc.Marker, key cas.Key) error {
+	todo := []cas.Key{key}
+	for len(todo) > 0 {
+		key := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if !m.Chunk(key, ChunkType, 0) {
+			continue
+		}
+		s, err := Load(ctx, m.Store(), key)
+		if err != nil {
+			return err
+		}
+		if err := dirs.MarkTree(ctx, m, s.Root); err != nil {
+			return err
+		}
+		todo = append(todo, s.Parents...)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package snapshots_test
+
+import (
+	"bytes"
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/gc"
+	"lifs_go/cas/snapshots"
+	storekv "lifs_go/cas/store/kv"
+	kvmem "lifs_go/kv/mem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarkRoots(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	refs := snapshots.NewRefs(target)
+
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := bytes.Repeat([]byte("old content "), 100000)
+	if err := os.WriteFile(filepath.Join(src, "a", "b", "file"), old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var parents []cas.Key
+	for _, content := range [][]byte{old, []byte("new content")} {
+		if err := os.WriteFile(filepath.Join(src, "top"), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tree, err := dirs.Import(ctx, chunkStore, src, nil)
+		if err != nil {
+			t.Fatalf("Import fail: %v", err)
+		}
+		s, err := snapshots.Take(ctx, tree, parents, "")
+		if err != nil {
+			t.Fatalf("Take fail: %v", err)
+		}
+		key, err := snapshots.Save(ctx, chunkStore, s)
+		if err != nil {
+			t.Fatalf("Save fail: %v", err)
+		}
+		parents = []cas.Key{key}
+	}
+	if err := refs.Set(ctx, "main", parents[0]); err != nil {
+		t.Fatalf("Set fail: %v", err)
+	}
+	// garbage
+	w, err := blobs.NewWriter(ctx, chunkStore, blobs.EmptyManifest(dirs.FileBlobType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("unreferenced"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := gc.Run(ctx, target, nil, &gc.Options{Roots: []gc.Roots{refs}})
+	if err != nil {
+		t.Fatalf("gc.Run fail: %v", err)
+	}
+	if report.Roots != 1 || report.Garbage != 1 {
+		t.Errorf("wrong report: %+v", report)
+	}
+
+	// both snapshots survived
+	key := parents[0]
+	for _, top := range [][]byte{[]byte("new content"), old} {
+		s, err := snapshots.Load(ctx, chunkStore, key)
+		if err != nil {
+			t.Fatalf("Load fail: %v", err)
+		}
+		tree, err := s.Tree(ctx, chunkStore)
+		if err != nil {
+			t.Fatalf("Tree fail: %v", err)
+		}
+		dst := filepath.Join(t.TempDir(), "out")
+		if err := tree.Export(ctx, dst); err != nil {
+			t.Fatalf("Export fail: %v", err)
+		}
+		for name, want := range map[string][]byte{"top": top, "a/b/file": old} {
+			data, err := os.ReadFile(filepath.Join(dst, name))
+			if err != nil {
+				t.Fatalf("read after gc: %v", err)
+			}
+			if !bytes.Equal(data, want) {
+				t.Errorf("wrong content of %s after gc", name)
+			}
+		}
+		if len(s.Parents) > 0 {
+			key = s.Parents[0]
+		}
+	}
+}
+
+func TestMarkTreeRoots(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	chunkStore := storekv.New(target)
+	trees := snapshots.NewRefs(target).Trees()
+
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("live content "), 100000)
+	if err := os.WriteFile(filepath.Join(src, "a", "file"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := dirs.Import(ctx, chunkStore, src, nil)
+	if err != nil {
+		t.Fatalf("Import fail: %v", err)
+	}
+	key, err := tree.Save(ctx)
+	if err != nil {
+		t.Fatalf("Save fail: %v", err)
+	}
+	if err := trees.Set(ctx, "live", key); err != nil {
+		t.Fatalf("Set fail: %v", err)
+	}
+
+	report, err := gc.Run(ctx, target, nil, &gc.Options{Roots: []gc.Roots{trees}})
+	if err != nil {
+		t.Fatalf("gc.Run fail: %v", err)
+	}
+	if report.Roots != 1 || report.Garbage != 0 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	tree, err = dirs.Load(ctx, chunkStore, key)
+	if err != nil {
+		t.Fatalf("Load fail: %v", err)
+	}
+	dst := filepath.Join(t.TempDir(), "out")
+	if err := tree.Export(ctx, dst); err != nil {
+		t.Fatalf("Export fail: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "a", "file"))
+	if err != nil {
+		t.Fatalf("read after gc: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("wrong content after gc")
+	}
+}
@@ -0,0 +1,185 @@
+package snapshots
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/cas"
+	"lifs_go/kv"
+	"strings"
+	"unicode"
+)
+
+// RefPrefix starts the kv keys of refs, followed by their kind, a
+// slash and the name of the ref. Like every key starting with
+// cas.SpecialPrefixSize zero bytes, it is never the key of a chunk.
+var RefPrefix = append(make([]byte, cas.SpecialPrefixSize), "ref:"...)
+
+// RefKind tells what a ref points to. The kinds are kept apart, and a
+// name is only ever set as one of them, so a snapshot ref and a tree
+// ref never overwrite each other.
+type RefKind string
+
+const (
+	// SnapshotRef points to a snapshot, as saved by Save.
+	SnapshotRef RefKind = "snapshots"
+	// TreeRef points to a tree, as saved by dirs.Tree.Save.
+	TreeRef RefKind = "trees"
+)
+
+// noun names one ref of the kind in messages.
+func (k RefKind) noun() string {
+	return strings.TrimSuffix(string(k), "s")
+}
+
+// Ref is a named key.
+type Ref struct {
+	Name string
+	Key  cas.Key
+}
+
+// Refs keeps the refs of one kind in a kv store, usually the one
+// holding the chunks.
+type Refs struct {
+	kv   kv.IF
+	kind RefKind
+}
+
+// NewRefs returns the snapshot refs kept in kv.
+func NewRefs(kv kv.IF) *Refs {
+	return &Refs{kv: kv, kind: SnapshotRef}
+}
+
+// Kind returns the kind of the refs.
+func (r *Refs) Kind() RefKind {
+	return r.kind
+}
+
+// Snapshots returns the snapshot refs kept in the same kv store.
+func (r *Refs) Snapshots() *Refs {
+	return &Refs{kv: r.kv, kind: SnapshotRef}
+}
+
+// Trees returns the tree refs kept in the same kv store.
+func (r *Refs) Trees() *Refs {
+	return &Refs{kv: r.kv, kind: TreeRef}
+}
+
+// other returns the refs of the other kind.
+func (r *Refs) other() *Refs {
+	if r.kind == TreeRef {
+		return r.Snapshots()
+	}
+	return r.Trees()
+}
+
+func (r *Refs) prefix() []byte {
+	return append(append(append([]byte(nil), RefPrefix...), r.kind...), '/')
+}
+
+func validRefName(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, func(r rune) bool {
+		return r == 0 || unicode.IsSpace(r)
+	})
+}
+
+func (r *Refs) refKey(name string) ([]byte, error) {
+	if !validRefName(name) {
+		return nil, BadRefNameError{name}
+	}
+	return append(r.prefix(), name...), nil
+}
+
+// Get returns the key the ref points to. A ref only set as the other
+// kind is reported with a RefKindError.
+func (r *Refs) Get(ctx context.Context, name string) (cas.Key, error) {
+	k, err := r.refKey(name)
+	if err != nil {
+		return cas.Invalid, err
+	}
+	v, err := r.kv.Get(ctx, k)
+	var nf kv.NotFoundError
+	if errors.As(err, &nf) {
+		other := r.other()
+		ok, err := other.has(ctx, name)
+		if err != nil {
+			return cas.Invalid, err
+		}
+		if ok {
+			return cas.Invalid, RefKindError{Name: name, Want: r.kind, Kind: other.kind}
+		}
+		return cas.Invalid, RefNotFoundError{name}
+	}
+	if err != nil {
+		return cas.Invalid, err
+	}
+	key := cas.NewKey(v)
+	if len(v) != cas.KeySize || key == cas.Invalid {
+		return cas.Invalid, BadSnapshotError{Reason: "ref " + name + " holds an invalid key"}
+	}
+	return key, nil
+}
+
+func (r *Refs) has(ctx context.Context, name string) (bool, error) {
+	k, err := r.refKey(name)
+	if err != nil {
+		return false, err
+	}
+	return r.kv.Has(ctx, k)
+}
+
+// Set points the ref to key, creating it if needed. A name already
+// set as the other kind is not taken over; it fails with a
+// RefKindError.
+func (r *Refs) Set(ctx context.Context, name string, key cas.Key) error {
+	k, err := r.refKey(name)
+	if err != nil {
+		return err
+	}
+	other := r.other()
+	ok, err := other.has(ctx, name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return RefKindError{Name: name, Want: r.kind, Kind: other.kind}
+	}
+	return r.kv.Put(ctx, k, key.Bytes())
+}
+
+// Delete removes the ref. Deleting a ref that is not set is not an
+// error.
+func (r *Refs) Delete(ctx context.Context, name string) error {
+	k, err := r.refKey(name)
+	if err != nil {
+		return err
+	}
+	return r.kv.Delete(ctx, k)
+}
+
+// List returns all refs of the kind, sorted by name.
+func (r *Refs) List(ctx context.Context) ([]Ref, error) {
+	prefix := r.prefix()
+	var names []string
+	err := r.kv.Iterate(ctx, kv.Prefix(prefix), func(key []byte) error {
+		names = append(names, string(bytes.TrimPrefix(key, prefix)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]Ref, 0, len(names))
+	for _, name := range names {
+		key, err := r.Get(ctx, name)
+		var nf RefNotFoundError
+		if errors.As(err, &nf) {
+			// deleted since
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, Ref{Name: name, Key: key})
+	}
+	return refs, nil
+}
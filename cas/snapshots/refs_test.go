@@ -0,0 +1,93 @@
+package snapshots_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/cas"
+	"lifs_go/cas/snapshots"
+	kvmem "lifs_go/kv/mem"
+	"reflect"
+	"testing"
+)
+
+func TestRefs(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	refs := snapshots.NewRefs(target)
+	k1 := cas.NewKey(bytes.Repeat([]byte("0123456789abcdef"), 4))
+	k2 := cas.NewKey(bytes.Repeat([]byte("borketyBorkBORK!"), 4))
+
+	var nf snapshots.RefNotFoundError
+	if _, err := refs.Get(ctx, "main"); !errors.As(err, &nf) {
+		t.Errorf("wrong error for missing ref: %v", err)
+	}
+	if err := refs.Set(ctx, "main", k1); err != nil {
+		t.Fatalf("Set fail: %v", err)
+	}
+	if err := refs.Set(ctx, "main", k2); err != nil {
+		t.Fatalf("Set fail: %v", err)
+	}
+	if err := refs.Set(ctx, "build/old", k1); err != nil {
+		t.Fatalf("Set fail: %v", err)
+	}
+	if g, err := refs.Get(ctx, "main"); err != nil || g != k2 {
+		t.Errorf("wrong ref: %v, %v", g, err)
+	}
+	list, err := refs.List(ctx)
+	if err != nil {
+		t.Fatalf("List fail: %v", err)
+	}
+	if g, e := list, []snapshots.Ref{{"build/old", k1}, {"main", k2}}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong refs: %v != %v", g, e)
+	}
+
+	if err := refs.Delete(ctx, "main"); err != nil {
+		t.Fatalf("Delete fail: %v", err)
+	}
+	if _, err := refs.Get(ctx, "main"); !errors.As(err, &nf) {
+		t.Errorf("wrong error for deleted ref: %v", err)
+	}
+
+	var bad snapshots.BadRefNameError
+	for _, name := range []string{"", "a b", "a\x00"} {
+		if err := refs.Set(ctx, name, k1); !errors.As(err, &bad) {
+			t.Errorf("Set of %q: wrong error %v", name, err)
+		}
+	}
+}
+
+func TestRefKinds(t *testing.T) {
+	ctx := context.Background()
+	target := kvmem.New()
+	refs := snapshots.NewRefs(target)
+	trees := refs.Trees()
+	k1 := cas.NewKey(bytes.Repeat([]byte("0123456789abcdef"), 4))
+	k2 := cas.NewKey(bytes.Repeat([]byte("borketyBorkBORK!"), 4))
+
+	if err := refs.Set(ctx, "main", k1); err != nil {
+		t.Fatalf("Set fail: %v", err)
+	}
+	if err := trees.Set(ctx, "live", k2); err != nil {
+		t.Fatalf("Set fail: %v", err)
+	}
+	var kind snapshots.RefKindError
+	if err := trees.Set(ctx, "main", k2); !errors.As(err, &kind) {
+		t.Errorf("tree ref took over a snapshot ref: %v", err)
+	}
+	if _, err := refs.Get(ctx, "live"); !errors.As(err, &kind) || kind.Kind != snapshots.TreeRef {
+		t.Errorf("wrong error for a tree ref: %v", err)
+	}
+	if g, err := refs.Get(ctx, "main"); err != nil || g != k1 {
+		t.Errorf("wrong ref: %v, %v", g, err)
+	}
+	for _, r := range []*snapshots.Refs{refs, trees} {
+		list, err := r.List(ctx)
+		if err != nil {
+			t.Fatalf("List fail: %v", err)
+		}
+		if len(list) != 1 {
+			t.Errorf("%s refs: wrong list %v", r.Kind(), list)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package snapshots records the history of directory trees.
+//
+// A snapshot is a small chunk naming the key of a saved dirs.Tree, the
+// snapshots it was derived from, and when, where and why it was taken.
+// Snapshots share every unchanged chunk with their parents, so keeping
+// many of them is cheap. Refs give names to snapshot keys, such as the
+// latest snapshot of a volume.
+package snapshots
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/store"
+	"os"
+	"time"
+)
+
+// ChunkType is the type of the chunks holding snapshots.
+const ChunkType = "snapshot"
+
+// The binary form of a snapshot is
+//
+//	magic "ls" | version | root | count | parent* | time | host | message | field*
+//
+// where root and the parents are the 64 bytes of their keys, count is
+// the number of parents as a uvarint, time is a varint of nanoseconds
+// since the Unix epoch, and host and message are a uvarint length and
+// the bytes. Optional fields follow as in manifests: tag, length and
+// payload, in increasing tag order; unknown even tags are skipped and
+// unknown odd tags are an error.
+const snapshotVersion = 1
+
+var snapshotMagic = [2]byte{'l', 's'}
+
+// Snapshot is a tree at a point of its history.
+type Snapshot struct {
+	// Root is the key of the tree, as returned by dirs.Tree.Save.
+	Root cas.Key
+	// Parents are the keys of the snapshots this one was derived
+	// from, usually one, none for the first snapshot.
+	Parents []cas.Key
+	Time    time.Time
+	Host    string
+	Message string
+}
+
+// Take saves tree and returns a snapshot of it, taken now on this
+// host. The snapshot itself is not saved yet.
+func Take(ctx context.Context, tree *dirs.Tree, parents []cas.Key, message string) (*Snapshot, error) {
+	root, err := tree.Save(ctx)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Root:    root,
+		Parents: append([]cas.Key(nil), parents...),
+		Time:    time.Now(),
+		Host:    host,
+		Message: message,
+	}, nil
+}
+
+// Tree opens the tree of the snapshot.
+func (s *Snapshot) Tree(ctx context.Context, chunkStore store.IF) (*dirs.Tree, error) {
+	return dirs.Load(ctx, chunkStore, s.Root)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	if s.Root == cas.Invalid {
+		return nil, BadSnapshotError{Reason: "invalid root key"}
+	}
+	b := append(snapshotMagic[:], snapshotVersion)
+	b = append(b, s.Root.Bytes()...)
+	b = binary.AppendUvarint(b, uint64(len(s.Parents)))
+	for _, p := range s.Parents {
+		b = append(b, p.Bytes()...)
+	}
+	b = binary.AppendVarint(b, s.Time.UnixNano())
+	b = appendString(b, s.Host)
+	b = appendString(b, s.Message)
+	return b, nil
+}
+
+// snapshotDecoder reads the binary form, remembering the first error.
+type snapshotDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *snapshotDecoder) fail(reason string) {
+	if d.err == nil {
+		d.err = BadSnapshotError{Reason: reason}
+	}
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("truncated number")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail("truncated number")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *snapshotDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.fail("truncated")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *snapshotDecoder) key() cas.Key {
+	b := d.bytes(cas.KeySize)
+	if d.err != nil {
+		return cas.Invalid
+	}
+	k := cas.NewKey(b)
+	if k == cas.Invalid {
+		d.fail("invalid key")
+	}
+	return k
+}
+
+func (d *snapshotDecoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || [2]byte(data[:2]) != snapshotMagic {
+		return BadSnapshotError{Reason: "not a snapshot"}
+	}
+	if data[2] != snapshotVersion {
+		return BadSnapshotError{Reason: fmt.Sprintf("unknown version %d", data[2])}
+	}
+	d := &snapshotDecoder{buf: data[3:]}
+	var n Snapshot
+	n.Root = d.key()
+	count := d.uvarint()
+	if count > uint64(len(d.buf))/cas.KeySize {
+		d.fail("truncated")
+	}
+	for i := uint64(0); d.err == nil && i < count; i++ {
+		n.Parents = append(n.Parents, d.key())
+	}
+	n.Time = time.Unix(0, d.varint())
+	n.Host = d.string()
+	n.Message = d.string()
+
+	lastTag := uint64(0)
+	for d.err == nil && len(d.buf) > 0 {
+		tag := d.uvarint()
+		d.bytes(d.uvarint())
+		if d.err != nil {
+			break
+		}
+		if tag <= lastTag {
+			d.fail("fields out of order")
+			break
+		}
+		lastTag = tag
+		if tag%2 == 1 {
+			d.fail(fmt.Sprintf("unknown required field %d", tag))
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	*s = n
+	return nil
+}
+
+// Save adds the snapshot to the store as a chunk of type ChunkType, and
+// returns its key.
+func Save(ctx context.Context, chunkStore store.IF, s *Snapshot) (cas.Key, error) {
+	buf, err := s.MarshalBinary()
+	if err != nil {
+		return cas.Invalid, err
+	}
+	return chunkStore.Add(ctx, chunks.MakeChunk(ChunkType, 0, buf))
+}
+
+// Load reads a snapshot saved with Save.
+func Load(ctx context.Context, chunkStore store.IF, key cas.Key) (*Snapshot, error) {
+	chunk, err := chunkStore.Get(ctx, key, ChunkType, 0)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := s.UnmarshalBinary(chunk.Buf); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// historyItem is a snapshot waiting to be visited by History.
+type historyItem struct {
+	key cas.Key
+	s   *Snapshot
+}
+
+// historyQueue orders snapshots newest first.
+type historyQueue []historyItem
+
+func (q historyQueue) Len() int           { return len(q) }
+func (q historyQueue) Less(i, j int) bool { return q[i].s.Time.After(q[j].s.Time) }
+func (q historyQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *historyQueue) Push(x any)        { *q = append(*q, x.(historyItem)) }
+func (q *historyQueue) Pop() any {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+// History calls fn for the snapshot with the given key and all its
+// ancestors, each once, newest first. It stops at the first error.
+func History(ctx context.Context, chunkStore store.IF, key cas.Key, fn func(key cas.Key, s *Snapshot) error) error {
+	seen := map[cas.Key]bool{key: true}
+	s, err := Load(ctx, chunkStore, key)
+	if err != nil {
+		return err
+	}
+	q := &historyQueue{{key, s}}
+	for q.Len() > 0 {
+		it := heap.Pop(q).(historyItem)
+		if err := fn(it.key, it.s); err != nil {
+			return err
+		}
+		for _, p := range it.s.Parents {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			ps, err := Load(ctx, chunkStore, p)
+			if err != nil {
+				return err
+			}
+			heap.Push(q, historyItem{p, ps})
+		}
+	}
+	return nil
+}
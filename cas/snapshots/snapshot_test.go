@@ -0,0 +1,102 @@
+package snapshots_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/cas"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
+	"lifs_go/cas/store/mem"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testSnapshot() *snapshots.Snapshot {
+	return &snapshots.Snapshot{
+		Root:    cas.NewKey(bytes.Repeat([]byte("0123456789abcdef"), 4)),
+		Parents: []cas.Key{cas.NewKey(bytes.Repeat([]byte("borketyBorkBORK!"), 4))},
+		Time:    time.Unix(1700000000, 42),
+		Host:    "builder",
+		Message: "nightly",
+	}
+}
+
+func TestSnapshotBinary(t *testing.T) {
+	s := testSnapshot()
+	buf, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary fail: %v", err)
+	}
+	var got snapshots.Snapshot
+	if err := got.UnmarshalBinary(buf); err != nil {
+		t.Fatalf("UnmarshalBinary fail: %v", err)
+	}
+	if !reflect.DeepEqual(&got, s) {
+		t.Errorf("round trip changed snapshot: %+v != %+v", got, *s)
+	}
+	for i := 0; i < len(buf); i++ {
+		var cut snapshots.Snapshot
+		err := cut.UnmarshalBinary(buf[:i])
+		var bad snapshots.BadSnapshotError
+		if !errors.As(err, &bad) {
+			t.Errorf("truncated snapshot: %d of %d bytes: wrong error %v", i, len(buf), err)
+		}
+	}
+
+	// optional fields: unknown even tags are skipped, odd ones fail
+	if err := got.UnmarshalBinary(append(buf[:len(buf):len(buf)], 2, 1, 0)); err != nil {
+		t.Errorf("unknown optional field not skipped: %v", err)
+	}
+	if err := got.UnmarshalBinary(append(buf[:len(buf):len(buf)], 3, 1, 0)); err == nil {
+		t.Errorf("unknown required field accepted")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+	tree := dirs.Empty(store)
+
+	save := func(when int64, parents ...cas.Key) cas.Key {
+		s, err := snapshots.Take(ctx, tree, parents, "")
+		if err != nil {
+			t.Fatalf("Take fail: %v", err)
+		}
+		s.Time = time.Unix(when, 0)
+		key, err := snapshots.Save(ctx, store, s)
+		if err != nil {
+			t.Fatalf("Save fail: %v", err)
+		}
+		return key
+	}
+	// a <- b <- d, a <- c <- d
+	a := save(1)
+	b := save(2, a)
+	c := save(3, a)
+	d := save(4, b, c)
+
+	loaded, err := snapshots.Load(ctx, store, d)
+	if err != nil {
+		t.Fatalf("Load fail: %v", err)
+	}
+	if g, e := loaded.Parents, []cas.Key{b, c}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong parents: %v != %v", g, e)
+	}
+	if _, err := loaded.Tree(ctx, store); err != nil {
+		t.Errorf("Tree fail: %v", err)
+	}
+
+	var got []cas.Key
+	err = snapshots.History(ctx, store, d, func(key cas.Key, s *snapshots.Snapshot) error {
+		got = append(got, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("History fail: %v", err)
+	}
+	if g, e := got, []cas.Key{d, c, b, a}; !reflect.DeepEqual(g, e) {
+		t.Errorf("wrong history: %v != %v", g, e)
+	}
+}
@@ -0,0 +1,148 @@
+// Package cache is a store.IF that keeps recently read chunks of
+// another store.IF in memory.
+package cache
+
+import (
+	"context"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+	"sync/atomic"
+)
+
+// Options sets the cache budgets. Pointer chunks are small and read on
+// every lookup, so they get their own budget that streaming through
+// large leaves cannot evict. Zero fields take their defaults, negative
+// ones disable caching of that level.
+type Options struct {
+	// LeafBytes bounds the cached level 0 chunks. Defaults to 64 MiB.
+	LeafBytes int64
+	// PointerBytes bounds the cached chunks of level 1 and up.
+	// Defaults to 16 MiB.
+	PointerBytes int64
+}
+
+// Stats are the counters of a cache since it was created.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	// Shared counts misses that waited for the fetch of a concurrent
+	// miss instead of fetching again; they are included in Misses.
+	Shared    uint64
+	Evictions uint64
+	// bytes currently held, including per entry overhead
+	LeafBytes    int64
+	PointerBytes int64
+}
+
+// Impl is safe for concurrent use.
+//
+// Cached chunks share their Buf between callers, so like the chunks
+// of any store it must not be modified; copy it first, as
+// stash.Stash.Clone does.
+type Impl struct {
+	inner    store.IF
+	leaves   *lru
+	pointers *lru
+	group    group
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	shared atomic.Uint64
+}
+
+var _ store.BatchAdder = (*Impl)(nil)
+
+func (c *Impl) lru(level uint8) *lru {
+	if level == 0 {
+		return c.leaves
+	}
+	return c.pointers
+}
+
+func (c *Impl) Get(ctx context.Context, key_ cas.Key, type_ string, level uint8) (*chunks.Chunk, error) {
+	l := c.lru(level)
+	if l == nil || key_.IsSpecial() {
+		return c.inner.Get(ctx, key_, type_, level)
+	}
+	k := key{key: key_, typ: type_, level: level}
+	if buf, ok := l.get(k); ok {
+		c.hits.Add(1)
+		return chunks.MakeChunk(type_, level, buf), nil
+	}
+	c.misses.Add(1)
+	buf, shared, err := c.group.do(ctx, k, func(ctx context.Context) ([]byte, error) {
+		// a fetch that finished since the check above
+		if buf, ok := l.get(k); ok {
+			return buf, nil
+		}
+		chunk, err := c.inner.Get(ctx, key_, type_, level)
+		if err != nil {
+			return nil, err
+		}
+		l.add(k, chunk.Buf)
+		return chunk.Buf, nil
+	})
+	if shared {
+		c.shared.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a fresh Chunk every time, callers may change its fields
+	return chunks.MakeChunk(type_, level, buf), nil
+}
+
+// Add passes through; chunks are cached once they are read.
+func (c *Impl) Add(ctx context.Context, chunk *chunks.Chunk) (cas.Key, error) {
+	return c.inner.Add(ctx, chunk)
+}
+
+// AddBatch passes through, see store.AddBatch.
+func (c *Impl) AddBatch(ctx context.Context, chunks_ []*chunks.Chunk) ([]cas.Key, error) {
+	return store.AddBatch(ctx, c.inner, chunks_)
+}
+
+func (c *Impl) Stats() Stats {
+	s := Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Shared: c.shared.Load(),
+	}
+	if c.leaves != nil {
+		size, evictions := c.leaves.stats()
+		s.LeafBytes = size
+		s.Evictions += evictions
+	}
+	if c.pointers != nil {
+		size, evictions := c.pointers.stats()
+		s.PointerBytes = size
+		s.Evictions += evictions
+	}
+	return s
+}
+
+// Wrap returns a cache in front of inner.
+func Wrap(inner store.IF, opts *Options) *Impl {
+	if opts == nil {
+		opts = &Options{}
+	}
+	c := &Impl{inner: inner}
+	switch {
+	case opts.LeafBytes == 0:
+		c.leaves = newLRU(64 << 20)
+	case opts.LeafBytes > 0:
+		c.leaves = newLRU(opts.LeafBytes)
+	}
+	switch {
+	case opts.PointerBytes == 0:
+		c.pointers = newLRU(16 << 20)
+	case opts.PointerBytes > 0:
+		c.pointers = newLRU(opts.PointerBytes)
+	}
+	return c
+}
+
+func New(inner store.IF) store.IF {
+	return Wrap(inner, nil)
+}
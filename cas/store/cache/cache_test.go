@@ -0,0 +1,174 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+	"lifs_go/cas/store/cache"
+	"lifs_go/cas/store/mem"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counting counts the Gets that reach the wrapped store, optionally
+// holding each one until release is closed.
+type counting struct {
+	store.IF
+	gets    atomic.Int64
+	release chan struct{}
+}
+
+func (c *counting) Get(ctx context.Context, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
+	c.gets.Add(1)
+	if c.release != nil {
+		<-c.release
+	}
+	return c.IF.Get(ctx, key, typ, level)
+}
+
+func add(t *testing.T, s store.IF, level uint8, data []byte) cas.Key {
+	t.Helper()
+	key, err := s.Add(context.Background(), chunks.MakeChunk("blob", level, data))
+	if err != nil {
+		t.Fatalf("Add fail: %v", err)
+	}
+	return key
+}
+
+func TestHitMiss(t *testing.T) {
+	inner := &counting{IF: mem.New()}
+	c := cache.Wrap(inner, nil)
+	ctx := context.Background()
+	key := add(t, c, 1, []byte("pointer"))
+	for i := 0; i < 3; i++ {
+		chunk, err := c.Get(ctx, key, "blob", 1)
+		if err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+		if g, e := string(chunk.Buf), "pointer"; g != e {
+			t.Errorf("Get gave wrong content: %q != %q", g, e)
+		}
+		// callers may replace the fields of the returned chunk
+		chunk.Buf = nil
+	}
+	if g, e := inner.gets.Load(), int64(1); g != e {
+		t.Errorf("wrong number of fetches: %d != %d", g, e)
+	}
+	s := c.Stats()
+	if s.Hits != 2 || s.Misses != 1 {
+		t.Errorf("wrong stats: %+v", s)
+	}
+	if s.PointerBytes == 0 || s.LeafBytes != 0 {
+		t.Errorf("chunk accounted to wrong level: %+v", s)
+	}
+}
+
+func TestNotFoundNotCached(t *testing.T) {
+	inner := &counting{IF: mem.New()}
+	c := cache.Wrap(inner, nil)
+	ctx := context.Background()
+	key := chunks.Hash(chunks.MakeChunk("blob", 0, []byte("missing")))
+	for i := 0; i < 2; i++ {
+		_, err := c.Get(ctx, key, "blob", 0)
+		var nf cas.NotFoundError
+		if !errors.As(err, &nf) {
+			t.Fatalf("Get should be NotFoundError: %T: %v", err, err)
+		}
+	}
+	if g, e := inner.gets.Load(), int64(2); g != e {
+		t.Errorf("misses should not be cached: %d != %d", g, e)
+	}
+	// and chunks added later are found
+	add(t, c, 0, []byte("missing"))
+	if _, err := c.Get(ctx, key, "blob", 0); err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+}
+
+// TestSeparateBudgets streams leaves through a small leaf budget; the
+// pointer chunk must survive it.
+func TestSeparateBudgets(t *testing.T) {
+	inner := &counting{IF: mem.New()}
+	c := cache.Wrap(inner, &cache.Options{LeafBytes: 4096, PointerBytes: 4096})
+	ctx := context.Background()
+	ptr := add(t, c, 1, []byte("pointer"))
+	if _, err := c.Get(ctx, ptr, "blob", 1); err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		key := add(t, c, 0, bytes.Repeat([]byte{byte(i + 1)}, 1000))
+		if _, err := c.Get(ctx, key, "blob", 0); err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+	}
+	s := c.Stats()
+	if s.LeafBytes > 4096 {
+		t.Errorf("leaf budget exceeded: %d", s.LeafBytes)
+	}
+	if s.Evictions == 0 {
+		t.Errorf("leaves should have been evicted: %+v", s)
+	}
+	before := inner.gets.Load()
+	if _, err := c.Get(ctx, ptr, "blob", 1); err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	if inner.gets.Load() != before {
+		t.Errorf("pointer chunk was evicted by leaves")
+	}
+}
+
+func TestDisabledLevel(t *testing.T) {
+	inner := &counting{IF: mem.New()}
+	c := cache.Wrap(inner, &cache.Options{LeafBytes: -1})
+	ctx := context.Background()
+	key := add(t, c, 0, []byte("leaf"))
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(ctx, key, "blob", 0); err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+	}
+	if g, e := inner.gets.Load(), int64(2); g != e {
+		t.Errorf("leaves should not be cached: %d != %d", g, e)
+	}
+}
+
+func TestSingleflight(t *testing.T) {
+	inner := &counting{IF: mem.New(), release: make(chan struct{})}
+	c := cache.Wrap(inner, nil)
+	ctx := context.Background()
+	key := add(t, c, 0, []byte("leaf"))
+
+	const N = 10
+	var wg sync.WaitGroup
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunk, err := c.Get(ctx, key, "blob", 0)
+			if err != nil {
+				t.Errorf("Get fail: %v", err)
+				return
+			}
+			if g, e := string(chunk.Buf), "leaf"; g != e {
+				t.Errorf("Get gave wrong content: %q != %q", g, e)
+			}
+		}()
+	}
+	// let the goroutines pile up behind the first fetch
+	for c.Stats().Misses < N {
+		time.Sleep(time.Millisecond)
+	}
+	close(inner.release)
+	wg.Wait()
+	if g, e := inner.gets.Load(), int64(1); g != e {
+		t.Errorf("concurrent misses fetched more than once: %d != %d", g, e)
+	}
+	if c.Stats().Shared == 0 {
+		t.Errorf("no miss waited for the concurrent fetch")
+	}
+}
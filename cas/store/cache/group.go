@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// group de-duplicates concurrent fetches of the same chunk: the first
+// caller fetches, the others wait for its result.
+type group struct {
+	mu    sync.Mutex
+	calls map[key]*call
+}
+
+type call struct {
+	done chan struct{}
+	buf  []byte
+	err  error
+}
+
+// do returns the result of fn for k, running it only if no other call
+// for k is in flight. shared reports whether the result came from
+// another caller.
+func (g *group) do(ctx context.Context, k key, fn func(ctx context.Context) ([]byte, error)) (buf []byte, shared bool, err error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = make(map[key]*call)
+		}
+		c, ok := g.calls[k]
+		if !ok {
+			c = &call{done: make(chan struct{})}
+			g.calls[k] = c
+			g.mu.Unlock()
+
+			c.buf, c.err = fn(ctx)
+			g.mu.Lock()
+			delete(g.calls, k)
+			g.mu.Unlock()
+			close(c.done)
+			return c.buf, false, c.err
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		// the fetch ran under the context of whoever started it; if
+		// that one gave up, try again under ours
+		if errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded) {
+			if ctx.Err() == nil {
+				continue
+			}
+		}
+		return c.buf, true, c.err
+	}
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"container/list"
+	"lifs_go/cas"
+	"sync"
+)
+
+type key struct {
+	key   cas.Key
+	typ   string
+	level uint8
+}
+
+// entryOverhead approximates the memory of an entry besides its data,
+// so that many tiny chunks still count against the budget.
+const entryOverhead = 128
+
+type entry struct {
+	key key
+	buf []byte
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.buf)) + int64(len(e.key.typ)) + entryOverhead
+}
+
+// lru is a byte bounded least recently used cache.
+type lru struct {
+	mu        sync.Mutex
+	capacity  int64
+	size      int64
+	evictions uint64
+	order     *list.List // front is most recently used
+	items     map[key]*list.Element
+}
+
+func newLRU(capacity int64) *lru {
+	return &lru{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[key]*list.Element),
+	}
+}
+
+func (l *lru) get(k key) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[k]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*entry).buf, true
+}
+
+func (l *lru) add(k key, buf []byte) {
+	e := &entry{key: k, buf: buf}
+	if e.size() > l.capacity {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[k]; ok {
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[k] = l.order.PushFront(e)
+	l.size += e.size()
+	for l.size > l.capacity {
+		el := l.order.Back()
+		old := el.Value.(*entry)
+		l.order.Remove(el)
+		delete(l.items, old.key)
+		l.size -= old.size()
+		l.evictions++
+	}
+}
+
+func (l *lru) stats() (int64, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size, l.evictions
+}
@@ -11,6 +11,40 @@ type IF interface {
 	Add(ctx context.Context, chunk *chunks.Chunk) (key cas.Key, err error)
 }
 
+// BatchAdder is implemented by stores that can add many chunks at
+// once, so that either all or none of them are stored.
+type BatchAdder interface {
+	// AddBatch adds all chunks atomically and returns their keys in
+	// the same order.
+	AddBatch(ctx context.Context, chunks []*chunks.Chunk) ([]cas.Key, error)
+}
+
+// AddBatch adds chunks to s with its AddBatch if s is a BatchAdder,
+// else one by one, and returns their keys in the same order. Only a
+// BatchAdder makes the whole batch atomic.
+func AddBatch(ctx context.Context, s IF, chunks_ []*chunks.Chunk) ([]cas.Key, error) {
+	if b, ok := s.(BatchAdder); ok {
+		return b.AddBatch(ctx, chunks_)
+	}
+	keys := make([]cas.Key, len(chunks_))
+	for i, chunk := range chunks_ {
+		key, err := s.Add(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// Quarantiner is implemented by stores that can set a corrupt chunk
+// aside, so that it is no longer served but can still be inspected.
+type Quarantiner interface {
+	// Quarantine moves the chunk out of the store. Quarantining a
+	// chunk that is not stored is not an error.
+	Quarantine(ctx context.Context, key cas.Key, type_ string, level uint8) error
+}
+
 type Handler func(ctx context.Context, key cas.Key, typ string, level uint8) ([]byte, error)
 
 func HandleGet(ctx context.Context, fn Handler, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
@@ -39,3 +73,30 @@ func HandleGet(ctx context.Context, fn Handler, key cas.Key, typ string, level u
 	c := chunks.MakeChunk(typ, level, data)
 	return c, nil
 }
+
+// HandleGetVerified is HandleGet that also checks the data against
+// key with Verify.
+func HandleGetVerified(ctx context.Context, fn Handler, key cas.Key, typ string, level uint8) (*chunks.Chunk, error) {
+	c, err := HandleGet(ctx, fn, key, typ, level)
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(key, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Verify re-hashes chunk and returns a cas.CorruptChunkError if it
+// does not match key.
+func Verify(key cas.Key, chunk *chunks.Chunk) error {
+	if actual := chunks.Hash(chunk); actual != key {
+		return cas.CorruptChunkError{
+			Type:   chunk.Type,
+			Level:  chunk.Level,
+			Key:    key,
+			Actual: actual,
+		}
+	}
+	return nil
+}
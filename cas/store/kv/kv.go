@@ -2,19 +2,35 @@ package kv
 
 import (
 	"context"
+	"errors"
 	"lifs_go/cas"
 	"lifs_go/cas/chunks"
 	"lifs_go/cas/store"
 	"lifs_go/kv"
 )
 
+// QuarantinePrefix starts the kv keys of quarantined chunks, followed
+// by the kv key the chunk was stored under. It has the prefix of
+// special cas keys, which are never stored as chunks.
+var QuarantinePrefix = append(make([]byte, cas.SpecialPrefixSize), "quarantine:"...)
+
+type Options struct {
+	// Verify re-hashes every chunk read and fails with a
+	// cas.CorruptChunkError if it does not match its key.
+	Verify bool
+}
+
 type Impl struct {
-	kv kv.IF
+	kv     kv.IF
+	verify bool
 }
 
 var _ store.IF = (*Impl)(nil)
+var _ store.BatchAdder = (*Impl)(nil)
+var _ store.Quarantiner = (*Impl)(nil)
 
-func makeKey(key cas.Key, typ string, level uint8) []byte {
+// MakeKey returns the kv key a chunk is stored under.
+func MakeKey(key cas.Key, typ string, level uint8) []byte {
 	k := make([]byte, 0, cas.KeySize+len(typ)+1)
 	k = append(k, key.Bytes()...)
 	k = append(k, typ...)
@@ -22,11 +38,34 @@ func makeKey(key cas.Key, typ string, level uint8) []byte {
 	return k
 }
 
+// ParseKey reverses MakeKey. It reports false for kv keys that cannot
+// name a stored chunk.
+func ParseKey(k []byte) (key cas.Key, typ string, level uint8, ok bool) {
+	if len(k) < cas.KeySize+1 {
+		return cas.Invalid, "", 0, false
+	}
+	key = cas.NewKey(k[:cas.KeySize])
+	// special keys are never stored
+	if key.IsSpecial() {
+		return cas.Invalid, "", 0, false
+	}
+	return key, string(k[cas.KeySize : len(k)-1]), k[len(k)-1], true
+}
+
 func (k *Impl) get(ctx context.Context, key cas.Key, type_ string, level uint8) ([]byte, error) {
-	return k.kv.Get(ctx, makeKey(key, type_, level))
+	data, err := k.kv.Get(ctx, MakeKey(key, type_, level))
+	var nf kv.NotFoundError
+	if errors.As(err, &nf) {
+		// HandleGet turns this into a cas.NotFoundError
+		return nil, nil
+	}
+	return data, err
 }
 
 func (k *Impl) Get(ctx context.Context, key cas.Key, type_ string, level uint8) (*chunks.Chunk, error) {
+	if k.verify {
+		return store.HandleGetVerified(ctx, k.get, key, type_, level)
+	}
 	return store.HandleGet(ctx, k.get, key, type_, level)
 }
 
@@ -35,13 +74,57 @@ func (k *Impl) Add(ctx context.Context, chunk *chunks.Chunk) (key cas.Key, err e
 	if key.IsSpecial() {
 		return key, nil
 	}
-	key_ := makeKey(key, chunk.Type, chunk.Level)
+	key_ := MakeKey(key, chunk.Type, chunk.Level)
 	if err := k.kv.Put(ctx, key_, chunk.Buf); err != nil {
 		return cas.Invalid, err
 	}
 	return key, nil
 }
 
+// AddBatch writes all chunks in a single kv.Batch, so a crash never
+// leaves only some of them stored.
+func (k *Impl) AddBatch(ctx context.Context, chunks_ []*chunks.Chunk) ([]cas.Key, error) {
+	keys := make([]cas.Key, len(chunks_))
+	var b kv.Batch
+	for i, chunk := range chunks_ {
+		keys[i] = chunks.Hash(chunk)
+		if keys[i].IsSpecial() {
+			continue
+		}
+		b.Put(MakeKey(keys[i], chunk.Type, chunk.Level), chunk.Buf)
+	}
+	if err := k.kv.Write(ctx, &b); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Quarantine moves the chunk to its key under QuarantinePrefix.
+func (k *Impl) Quarantine(ctx context.Context, key cas.Key, type_ string, level uint8) error {
+	key_ := MakeKey(key, type_, level)
+	data, err := k.kv.Get(ctx, key_)
+	var nf kv.NotFoundError
+	if errors.As(err, &nf) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var b kv.Batch
+	q := append(append([]byte(nil), QuarantinePrefix...), key_...)
+	// replaces any older copy quarantined already
+	b.Put(q, data)
+	b.Delete(key_)
+	return k.kv.Write(ctx, &b)
+}
+
+func Wrap(kv kv.IF, opts *Options) *Impl {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &Impl{kv: kv, verify: opts.Verify}
+}
+
 func New(kv kv.IF) store.IF {
-	return &Impl{kv: kv}
+	return Wrap(kv, nil)
 }
@@ -2,6 +2,8 @@ package kv_test
 
 import (
 	"context"
+	"errors"
+	"lifs_go/cas"
 	"lifs_go/cas/chunks"
 	"lifs_go/cas/store"
 	"lifs_go/cas/store/kv"
@@ -33,3 +35,92 @@ func TestBasic(t *testing.T) {
 		t.Errorf("bag Get: %s != %s", c.Buf, value)
 	}
 }
+
+func TestAddBatch(t *testing.T) {
+	target := NewTestTarget().(store.BatchAdder)
+	ctx := context.Background()
+	batch := []*chunks.Chunk{
+		chunks.MakeChunk("type", 0, []byte("one")),
+		chunks.MakeChunk("type", 1, []byte("two")),
+		chunks.MakeChunk("type", 0, nil),
+	}
+	keys, err := target.AddBatch(ctx, batch)
+	if err != nil {
+		t.Fatalf("AddBatch fail: %v", err)
+	}
+	if g, e := len(keys), len(batch); g != e {
+		t.Fatalf("AddBatch gave wrong number of keys: %d != %d", g, e)
+	}
+	if keys[2] != cas.Empty {
+		t.Errorf("empty chunk should have the Empty key: %v", keys[2])
+	}
+	for i, chunk := range batch {
+		if g, e := keys[i], chunks.Hash(chunk); g != e {
+			t.Errorf("bad key %d: %v != %v", i, g, e)
+		}
+		c, err := target.(store.IF).Get(ctx, keys[i], chunk.Type, chunk.Level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(c.Buf) != string(chunk.Buf) {
+			t.Errorf("bad Get: %s != %s", c.Buf, chunk.Buf)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := chunks.Hash(chunks.MakeChunk("type", 3, []byte("value")))
+	k, typ, level, ok := kv.ParseKey(kv.MakeKey(key, "type", 3))
+	if !ok {
+		t.Fatalf("ParseKey failed")
+	}
+	if k != key || typ != "type" || level != 3 {
+		t.Errorf("bad ParseKey: %v %q %d", &k, typ, level)
+	}
+	if _, _, _, ok := kv.ParseKey(kv.MakeKey(cas.NewKeyPrivateNum(1), "type", 0)); ok {
+		t.Errorf("special keys must not parse")
+	}
+	if _, _, _, ok := kv.ParseKey([]byte("short")); ok {
+		t.Errorf("short keys must not parse")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	target := NewTestTarget()
+	key := chunks.Hash(chunks.MakeChunk("type", 0, []byte("value")))
+	_, err := target.Get(context.Background(), key, "type", 0)
+	var nf cas.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("expected cas.NotFoundError: %T: %v", err, err)
+	}
+	if nf.Key != key || nf.Type != "type" {
+		t.Errorf("bad NotFoundError: %v", nf)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	backend := kvmem.New()
+	target := kv.Wrap(backend, &kv.Options{Verify: true})
+	ctx := context.Background()
+	key, err := target.Add(ctx, chunks.MakeChunk("type", 0, []byte("value")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Get(ctx, key, "type", 0); err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	if err := backend.Delete(ctx, kv.MakeKey(key, "type", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Put(ctx, kv.MakeKey(key, "type", 0), []byte("valuf")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = target.Get(ctx, key, "type", 0)
+	var corrupt cas.CorruptChunkError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected CorruptChunkError: %v", err)
+	}
+	if corrupt.Key != key {
+		t.Errorf("wrong key in error: %v", corrupt)
+	}
+}
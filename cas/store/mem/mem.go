@@ -5,6 +5,7 @@ import (
 	"lifs_go/cas"
 	"lifs_go/cas/chunks"
 	"lifs_go/cas/store"
+	"sync"
 )
 
 type Key struct {
@@ -13,12 +14,44 @@ type Key struct {
 	Level uint8
 }
 
-type Impl struct {
+// shardCount spreads chunks over independently locked maps, so
+// parallel readers and writers rarely contend.
+const shardCount = 32
+
+type shard struct {
+	mu   sync.RWMutex
 	data map[Key][]byte
 }
 
+// Impl is safe for concurrent use. The zero value is an empty store.
+type Impl struct {
+	shards [shardCount]shard
+}
+
+// shard picks the shard of k by the FNV-1a hash of its parts, which
+// needs no seed, so the zero value works.
+func (m *Impl) shard(k Key) *shard {
+	h := uint32(2166136261)
+	add := func(c byte) {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	for _, c := range k.Key.Bytes() {
+		add(c)
+	}
+	for i := 0; i < len(k.Type); i++ {
+		add(k.Type[i])
+	}
+	add(k.Level)
+	return &m.shards[h%shardCount]
+}
+
 func (m *Impl) get(ctx context.Context, key cas.Key, type_ string, level uint8) ([]byte, error) {
-	data := m.data[Key{key, type_, level}]
+	k := Key{key, type_, level}
+	s := m.shard(k)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := s.data[k]
 	return data, nil
 }
 
@@ -28,10 +61,14 @@ func (m *Impl) Get(ctx context.Context, key cas.Key, type_ string, level uint8)
 
 func (m *Impl) Add(ctx context.Context, c *chunks.Chunk) (key cas.Key, err error) {
 	key = chunks.Hash(c)
-	if m.data == nil {
-		m.data = make(map[Key][]byte)
+	k := Key{key, c.Type, c.Level}
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		s.data = make(map[Key][]byte)
 	}
-	m.data[Key{key, c.Type, c.Level}] = c.Buf
+	s.data[k] = c.Buf
 	return key, nil
 }
 
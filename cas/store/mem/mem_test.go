@@ -0,0 +1,85 @@
+package mem_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+	"lifs_go/cas/store/mem"
+	"sync"
+	"testing"
+)
+
+func NewTestTarget() store.IF {
+	return mem.New()
+}
+
+func TestBasic(t *testing.T) {
+	value := []byte("value")
+	chunk := chunks.MakeChunk("type", 0, value)
+	target := NewTestTarget()
+	ctx := context.Background()
+	key, err := target.Add(ctx, chunk)
+	if err != nil {
+		t.Fatalf("mem.Add fail %v\n", err)
+	}
+	c, err := target.Get(ctx, key, chunk.Type, chunk.Level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Buf) != string(value) {
+		t.Errorf("bad Get: %s != %s", c.Buf, value)
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var target mem.Impl
+	ctx := context.Background()
+	chunk := chunks.MakeChunk("type", 0, []byte("value"))
+	key, err := target.Add(ctx, chunk)
+	if err != nil {
+		t.Fatalf("mem.Add fail %v\n", err)
+	}
+	c, err := target.Get(ctx, key, chunk.Type, chunk.Level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(c.Buf), "value"; g != e {
+		t.Errorf("bad Get: %q != %q", g, e)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	target := NewTestTarget()
+	ctx := context.Background()
+	const workers = 8
+	const N = 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < N; i++ {
+				// the same chunks are added by every worker
+				value := []byte(fmt.Sprintf("chunk %d", i%(N/2)))
+				chunk := chunks.MakeChunk("type", uint8(i%3), value)
+				key, err := target.Add(ctx, chunk)
+				if err != nil {
+					t.Errorf("mem.Add fail %v\n", err)
+					return
+				}
+				c, err := target.Get(ctx, key, chunk.Type, chunk.Level)
+				if err != nil {
+					t.Errorf("mem.Get fail %v\n", err)
+					return
+				}
+				if !bytes.Equal(c.Buf, value) {
+					t.Errorf("bad Get: %s != %s", c.Buf, value)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,137 @@
+// Package verify is a store.IF that checks every chunk read from
+// another store.IF against its key, sets corrupt chunks aside and
+// reads them from a replica instead.
+package verify
+
+import (
+	"context"
+	"errors"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	"lifs_go/cas/store"
+	"sync/atomic"
+)
+
+type Options struct {
+	// Quarantine moves corrupt chunks out of the inner store, if it
+	// is a store.Quarantiner, so later reads do not hash them again.
+	Quarantine bool
+	// Replica, if set, is read when a chunk is corrupt or missing in
+	// the inner store.
+	Replica store.IF
+	// Repair adds the chunks read from Replica back to the inner
+	// store. Stores may keep an entry that is already there, so
+	// corrupt chunks are only replaced together with Quarantine.
+	Repair bool
+}
+
+// Stats are the counters of a store since it was created.
+type Stats struct {
+	// Corrupt counts the chunks that did not match their key,
+	// including those of the replica.
+	Corrupt     uint64
+	Quarantined uint64
+	// FromReplica counts the chunks served by the replica.
+	FromReplica uint64
+	Repaired    uint64
+}
+
+// Impl is safe for concurrent use if the stores it wraps are.
+type Impl struct {
+	inner   store.IF
+	opts    Options
+	corrupt atomic.Uint64
+	quar    atomic.Uint64
+	replica atomic.Uint64
+	repair  atomic.Uint64
+}
+
+var _ store.BatchAdder = (*Impl)(nil)
+
+// get reads and checks a chunk, counting corruption.
+func (v *Impl) get(ctx context.Context, from store.IF, key cas.Key, type_ string, level uint8) (*chunks.Chunk, error) {
+	chunk, err := from.Get(ctx, key, type_, level)
+	if err == nil {
+		err = store.Verify(key, chunk)
+	}
+	var corrupt cas.CorruptChunkError
+	if errors.As(err, &corrupt) {
+		v.corrupt.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// Get returns a cas.CorruptChunkError if the chunk is corrupt and no
+// replica has a good copy. If the chunk is missing from the replica
+// too, the error of the inner store is returned.
+func (v *Impl) Get(ctx context.Context, key cas.Key, type_ string, level uint8) (*chunks.Chunk, error) {
+	chunk, err := v.get(ctx, v.inner, key, type_, level)
+	if err == nil {
+		return chunk, nil
+	}
+	var corrupt cas.CorruptChunkError
+	isCorrupt := errors.As(err, &corrupt)
+	if isCorrupt && v.opts.Quarantine {
+		if q, ok := v.inner.(store.Quarantiner); ok {
+			if err := q.Quarantine(ctx, key, type_, level); err != nil {
+				return nil, err
+			}
+			v.quar.Add(1)
+		}
+	}
+	var nf cas.NotFoundError
+	if v.opts.Replica == nil || !isCorrupt && !errors.As(err, &nf) {
+		return nil, err
+	}
+
+	chunk, rerr := v.get(ctx, v.opts.Replica, key, type_, level)
+	if rerr != nil {
+		if errors.As(rerr, &nf) {
+			return nil, err
+		}
+		return nil, rerr
+	}
+	v.replica.Add(1)
+	if v.opts.Repair {
+		if _, err := v.inner.Add(ctx, chunk); err != nil {
+			return nil, err
+		}
+		v.repair.Add(1)
+	}
+	return chunk, nil
+}
+
+// Add passes through; chunks are checked when they are read.
+func (v *Impl) Add(ctx context.Context, chunk *chunks.Chunk) (cas.Key, error) {
+	return v.inner.Add(ctx, chunk)
+}
+
+// AddBatch passes through, see store.AddBatch.
+func (v *Impl) AddBatch(ctx context.Context, chunks_ []*chunks.Chunk) ([]cas.Key, error) {
+	return store.AddBatch(ctx, v.inner, chunks_)
+}
+
+func (v *Impl) Stats() Stats {
+	return Stats{
+		Corrupt:     v.corrupt.Load(),
+		Quarantined: v.quar.Load(),
+		FromReplica: v.replica.Load(),
+		Repaired:    v.repair.Load(),
+	}
+}
+
+// Wrap returns a store checking the chunks of inner.
+func Wrap(inner store.IF, opts *Options) *Impl {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &Impl{inner: inner, opts: *opts}
+}
+
+// New returns a store that only checks the chunks of inner.
+func New(inner store.IF) store.IF {
+	return Wrap(inner, nil)
+}
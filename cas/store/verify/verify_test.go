@@ -0,0 +1,144 @@
+package verify_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/cas"
+	"lifs_go/cas/chunks"
+	storekv "lifs_go/cas/store/kv"
+	"lifs_go/cas/store/verify"
+	"lifs_go/kv"
+	kvmem "lifs_go/kv/mem"
+	"testing"
+)
+
+// corrupted returns a store holding a chunk whose data was flipped
+// after it was added.
+func corrupted(t *testing.T) (kv.IF, *storekv.Impl, cas.Key) {
+	t.Helper()
+	ctx := context.Background()
+	backend := kvmem.New()
+	inner := storekv.Wrap(backend, nil)
+	key, err := inner.Add(ctx, chunks.MakeChunk("type", 1, []byte("value")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := storekv.MakeKey(key, "type", 1)
+	var b kv.Batch
+	b.Delete(k)
+	b.Put(k, []byte("valuf"))
+	if err := backend.Write(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+	return backend, inner, key
+}
+
+func TestCorrupt(t *testing.T) {
+	_, inner, key := corrupted(t)
+	ctx := context.Background()
+	target := verify.Wrap(inner, nil)
+
+	_, err := target.Get(ctx, key, "type", 1)
+	var corrupt cas.CorruptChunkError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected CorruptChunkError: %v", err)
+	}
+	if corrupt.Key != key || corrupt.Type != "type" || corrupt.Level != 1 {
+		t.Errorf("bad CorruptChunkError: %v", corrupt)
+	}
+	if g, e := target.Stats().Corrupt, uint64(1); g != e {
+		t.Errorf("wrong corrupt count: %d != %d", g, e)
+	}
+
+	// good chunks and the empty chunk pass
+	good, err := target.Add(ctx, chunks.MakeChunk("type", 0, []byte("good")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []cas.Key{good, cas.Empty} {
+		if _, err := target.Get(ctx, k, "type", 0); err != nil {
+			t.Errorf("Get fail: %v", err)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	backend, inner, key := corrupted(t)
+	ctx := context.Background()
+	target := verify.Wrap(inner, &verify.Options{Quarantine: true})
+
+	_, err := target.Get(ctx, key, "type", 1)
+	var corrupt cas.CorruptChunkError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected CorruptChunkError: %v", err)
+	}
+	_, err = target.Get(ctx, key, "type", 1)
+	var nf cas.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("quarantined chunk should be gone: %v", err)
+	}
+	if g, e := target.Stats().Quarantined, uint64(1); g != e {
+		t.Errorf("wrong quarantined count: %d != %d", g, e)
+	}
+	q := append(append([]byte(nil), storekv.QuarantinePrefix...), storekv.MakeKey(key, "type", 1)...)
+	data, err := backend.Get(ctx, q)
+	if err != nil {
+		t.Fatalf("quarantined data not found: %v", err)
+	}
+	if g, e := string(data), "valuf"; g != e {
+		t.Errorf("wrong quarantined data: %q != %q", g, e)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	_, inner, key := corrupted(t)
+	ctx := context.Background()
+	replica := storekv.New(kvmem.New())
+	if _, err := replica.Add(ctx, chunks.MakeChunk("type", 1, []byte("value"))); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := replica.Add(ctx, chunks.MakeChunk("type", 0, []byte("only in replica")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := verify.Wrap(inner, &verify.Options{
+		Quarantine: true,
+		Replica:    replica,
+		Repair:     true,
+	})
+
+	c, err := target.Get(ctx, key, "type", 1)
+	if err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	if g, e := c.Buf, []byte("value"); !bytes.Equal(g, e) {
+		t.Errorf("wrong data: %q != %q", g, e)
+	}
+	if _, err := target.Get(ctx, missing, "type", 0); err != nil {
+		t.Fatalf("Get of missing chunk fail: %v", err)
+	}
+	s := target.Stats()
+	if s.FromReplica != 2 || s.Repaired != 2 || s.Quarantined != 1 {
+		t.Errorf("wrong stats: %+v", s)
+	}
+
+	// the inner store was repaired
+	for _, k := range []cas.Key{key, missing} {
+		level := uint8(1)
+		if k == missing {
+			level = 0
+		}
+		if _, err := verify.New(inner).Get(ctx, k, "type", level); err != nil {
+			t.Errorf("inner store not repaired: %v", err)
+		}
+	}
+
+	// not found anywhere
+	absent := chunks.Hash(chunks.MakeChunk("type", 0, []byte("absent")))
+	_, err = target.Get(ctx, absent, "type", 0)
+	var nf cas.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Errorf("expected NotFoundError: %v", err)
+	}
+}
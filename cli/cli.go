@@ -10,7 +10,16 @@ func NewApp() *cli.App {
 		Name:     "lifs",
 		HelpName: "lifs",
 		Commands: []*cli.Command{
-			cs.CommandScan()},
+			cs.CommandScan(),
+			cs.CommandCrypt(),
+			cs.CommandCompress(),
+			cs.CommandServe(),
+			cs.CommandGC(),
+			cs.CommandReshard(),
+			cs.CommandSnapshot(),
+			cs.CommandDiff(),
+			cs.CommandMount(),
+		},
 	}
 
 	return app
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"fmt"
+	"lifs_go/kv/compress"
+
+	"github.com/urfave/cli/v2"
+)
+
+func CommandCompress() *cli.Command {
+	return &cli.Command{
+		Name:  "compress",
+		Usage: "manage the compression of a store",
+		Subcommands: []*cli.Command{
+			commandCompressMigrate(),
+		},
+	}
+}
+
+func commandCompressMigrate() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "prepare a store written without compression for it",
+		Description: "A store that holds values written before compression was supported can only " +
+			"store new values raw. This gives every value a header, after which any --compress codec " +
+			"can be used. Nothing else may write to the store meanwhile. An interrupted migration " +
+			"is finished by running it again.",
+		Flags: storeFlags(),
+		Action: func(c *cli.Context) error {
+			target, closer, err := openEncrypted(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+			n, err := compress.Migrate(c.Context, target)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(c.App.Writer, "migrated %d values\n", n)
+			return nil
+		},
+	}
+}
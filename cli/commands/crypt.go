@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"lifs_go/kv/crypt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+func CommandCrypt() *cli.Command {
+	return &cli.Command{
+		Name:  "crypt",
+		Usage: "manage the keys of an encrypted store",
+		Subcommands: []*cli.Command{
+			commandCryptInit(),
+			commandCryptRotate(),
+		},
+	}
+}
+
+func commandCryptInit() *cli.Command {
+	return &cli.Command{
+		Name:  "init",
+		Usage: "create a key file with a new random key",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:      "keyfile",
+				Usage:     "path of the key file to create",
+				EnvVars:   []string{"LIFS_KEYFILE"},
+				Required:  true,
+				TakesFile: true,
+			},
+			&cli.StringFlag{
+				Name:  "kdf",
+				Usage: "passphrase key derivation: scrypt or argon2id",
+				Value: crypt.KDFScrypt,
+			},
+			passphraseFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			path := c.String("keyfile")
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists", path)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			p, err := passphrase(c)
+			if err != nil {
+				return err
+			}
+			keys, err := crypt.NewKeyring()
+			if err != nil {
+				return err
+			}
+			return crypt.SaveKeyFile(path, keys, p, &crypt.KDF{Algorithm: c.String("kdf")})
+		},
+	}
+}
+
+func commandCryptRotate() *cli.Command {
+	flags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "finish an interrupted rotation instead of adding another key",
+		},
+		&cli.BoolFlag{
+			Name:  "drop-old",
+			Usage: "instead of adding a key, re-encrypt what is left and drop the old keys from the key file",
+		},
+	}
+	return &cli.Command{
+		Name:  "rotate",
+		Usage: "add a new key and re-encrypt the store with it",
+		Description: "The old keys stay in the key file, so the store stays readable while it is " +
+			"re-encrypted. Processes that loaded the key file before, such as lifs mount or lifs " +
+			"serve, keep writing with the old key until they are restarted. Once they all have been, " +
+			"run again with --drop-old: it re-encrypts the values written meanwhile, checks that " +
+			"none is left with an old key, and only then drops the old keys. Values still sealed " +
+			"with a dropped key can never be read again. " +
+			"An interrupted rotation is finished by running again with --resume.",
+		Flags: append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			if c.String("keyfile") == "" {
+				return errors.New("--keyfile is required")
+			}
+			p, err := passphrase(c)
+			if err != nil {
+				return err
+			}
+			path := c.String("keyfile")
+			keys, err := crypt.LoadKeyFile(path, p)
+			if err != nil {
+				return err
+			}
+			if c.Bool("resume") && c.Bool("drop-old") {
+				return errors.New("--resume and --drop-old exclude each other")
+			}
+			if !c.Bool("resume") && !c.Bool("drop-old") {
+				if _, err := keys.Rotate(); err != nil {
+					return err
+				}
+				// the new key must be saved before anything is sealed
+				// with it
+				if err := crypt.SaveKeyFile(path, keys, p, nil); err != nil {
+					return err
+				}
+			}
+
+			backend, closer, err := openBackend(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+			target, err := crypt.Wrap(backend, keys, &crypt.Options{HideKeys: c.Bool("hide-keys")})
+			if err != nil {
+				return err
+			}
+			n, err := target.Rotate(c.Context)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(c.App.Writer, "re-encrypted %d values with key %d\n", n, keys.Current())
+
+			if !c.Bool("drop-old") {
+				if len(keys.IDs()) > 1 {
+					fmt.Fprintf(c.App.Writer, "old keys kept; drop them with --drop-old once every process using the store has reloaded the key file\n")
+				}
+				return nil
+			}
+			stale, err := target.Stale(c.Context)
+			if err != nil {
+				return err
+			}
+			if stale > 0 {
+				return fmt.Errorf("%d values were written with an old key meanwhile; restart the processes using the store and run again", stale)
+			}
+			for _, id := range keys.IDs() {
+				keys.Drop(id)
+			}
+			return crypt.SaveKeyFile(path, keys, p, nil)
+		},
+	}
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/diff"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
+	"lifs_go/cas/store"
+
+	"github.com/urfave/cli/v2"
+)
+
+func CommandDiff() *cli.Command {
+	flags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the changes as a JSON array",
+		},
+		&cli.BoolFlag{
+			Name:  "ranges",
+			Usage: "list the changed byte ranges of modified files",
+		},
+	}
+	return &cli.Command{
+		Name:      "diff",
+		Usage:     "list the changes between two trees",
+		ArgsUsage: "A B",
+		Description: "A and B are snapshot refs, tree refs such as the live tree " +
+			"of a mount, snapshot keys or keys of saved trees. " +
+			"Each change is printed as A (added), D (removed), M (modified) " +
+			"or R (renamed) and the path; an added or removed directory is " +
+			"printed alone, without its contents.",
+		Flags: append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return errors.New("expected two trees to compare")
+			}
+			chunkStore, refs, closer, err := openSnapshots(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+
+			var roots [2]*blobs.Manifest
+			for i := range roots {
+				if roots[i], err = resolveTree(c, chunkStore, refs, c.Args().Get(i)); err != nil {
+					return err
+				}
+			}
+			changes, err := diff.Trees(c.Context, chunkStore, roots[0], roots[1], &diff.Options{Ranges: c.Bool("ranges")})
+			if err != nil {
+				return err
+			}
+
+			if c.Bool("json") {
+				if changes == nil {
+					changes = []diff.Change{}
+				}
+				enc := json.NewEncoder(c.App.Writer)
+				enc.SetIndent("", "  ")
+				return enc.Encode(changes)
+			}
+			for _, ch := range changes {
+				switch ch.Kind {
+				case diff.Added:
+					fmt.Fprintf(c.App.Writer, "A %s\n", ch.Path)
+				case diff.Removed:
+					fmt.Fprintf(c.App.Writer, "D %s\n", ch.Path)
+				case diff.Modified:
+					fmt.Fprintf(c.App.Writer, "M %s\n", ch.Path)
+				case diff.Renamed:
+					fmt.Fprintf(c.App.Writer, "R %s -> %s\n", ch.OldPath, ch.Path)
+				}
+				for _, r := range ch.Ranges {
+					fmt.Fprintf(c.App.Writer, "  @ %d-%d\n", r.Start, r.End)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// resolveTree returns the root directory of the tree given by s, which
+// is resolved as by resolveSnapshot, or else as a tree ref.
+func resolveTree(c *cli.Context, chunkStore store.IF, refs *snapshots.Refs, s string) (*blobs.Manifest, error) {
+	key, err := resolveSnapshot(c, refs, s)
+	var kind snapshots.RefKindError
+	if errors.As(err, &kind) && kind.Kind == snapshots.TreeRef {
+		if key, err = refs.Trees().Get(c.Context, s); err != nil {
+			return nil, err
+		}
+		tree, err := dirs.Load(c.Context, chunkStore, key)
+		if err != nil {
+			return nil, err
+		}
+		return tree.Root(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return diff.Resolve(c.Context, chunkStore, key)
+}
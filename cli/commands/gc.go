@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/blobs"
+	"lifs_go/cas/gc"
+	"lifs_go/cas/snapshots"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func CommandGC() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:      "manifest",
+			Usage:     "file of a blob manifest whose chunks are kept, in JSON or binary form; may be repeated",
+			TakesFile: true,
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report what would be deleted",
+		},
+		&cli.DurationFlag{
+			Name:  "grace",
+			Usage: "only delete chunks that earlier runs found unreachable at least this long ago",
+			Value: defaultGrace,
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "allow --grace 0, which deletes chunks that writers have not published yet",
+		},
+		&cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "list every chunk deleted",
+		},
+	}
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "delete the chunks no ref or manifest refers to",
+		Description: "Every chunk not reachable from a ref or from the given manifests is deleted; " +
+			"a snapshot ref keeps its whole history, a tree ref such as the one of a mounted " +
+			"filesystem its tree. " +
+			"Chunks written while gc runs are kept. A chunk is only deleted by a run at least " +
+			"--grace after the run that first found it unreachable, so the first run deletes nothing. " +
+			"The grace period protects writers: a mounted filesystem stores the chunks of files " +
+			"and directories before it moves its tree ref to them, and chunks written before a " +
+			"run but published after it look unreachable. Only use --grace 0, which needs --force, " +
+			"when nothing writes to the store. " +
+			"A run that finds nothing to keep is refused, unless it is a dry run.",
+		Flags: append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			if c.Duration("grace") <= 0 && !c.Bool("force") && !c.Bool("dry-run") {
+				return errors.New("--grace 0 deletes chunks that running writers have not published yet; pass --force if nothing writes to the store")
+			}
+			names := c.StringSlice("manifest")
+			roots := make([]*blobs.Manifest, 0, len(names))
+			for _, name := range names {
+				m, err := readManifest(name)
+				if err != nil {
+					return err
+				}
+				roots = append(roots, m)
+			}
+
+			target, closer, err := openKV(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+			refs := snapshots.NewRefs(target)
+			opts := &gc.Options{
+				DryRun: c.Bool("dry-run"),
+				Grace:  c.Duration("grace"),
+				Roots:  []gc.Roots{refs, refs.Trees()},
+			}
+			if c.Bool("verbose") {
+				opts.OnGarbage = func(key cas.Key, type_ string, level uint8) {
+					fmt.Fprintf(c.App.Writer, "%s %q@%d\n", &key, type_, level)
+				}
+			}
+			report, err := gc.Run(c.Context, target, roots, opts)
+			if err != nil {
+				return err
+			}
+			verb := "deleted"
+			if report.DryRun {
+				verb = "would delete"
+			}
+			fmt.Fprintf(c.App.Writer, "%d roots, %d chunks, %d reachable: %s %d, %d pending, %d other keys\n",
+				report.Roots, report.Scanned, report.Reachable, verb, report.Garbage, report.Pending, report.Foreign)
+			return nil
+		},
+	}
+}
+
+// defaultGrace is far longer than a writer takes between storing
+// chunks and publishing them.
+const defaultGrace = 24 * time.Hour
+
+func readManifest(name string) (*blobs.Manifest, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var m blobs.Manifest
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = json.Unmarshal(data, &m)
+	} else {
+		err = m.UnmarshalBinary(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"errors"
+	"lifs_go/access/fuse"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+func CommandMount() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "root",
+			Usage: "name of the tree ref holding the tree of the filesystem",
+			Value: "live",
+		},
+		refFlag(),
+	}
+	return &cli.Command{
+		Name:      "mount",
+		Usage:     "serve the files of the store as a FUSE filesystem",
+		ArgsUsage: "DIR",
+		Description: "The filesystem starts with the tree of the --root ref, and moves the ref " +
+			"to the new tree whenever a file is closed or synced, and at unmount. " +
+			"The snapshots of --ref are shown read-only in .snapshots. " +
+			"Runs until interrupted.",
+		Flags: append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return errors.New("expected the directory to mount on")
+			}
+			chunkStore, refs, closer, err := openSnapshots(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+
+			fs, err := fuse.Open(chunkStore, &fuse.Options{
+				Refs: refs,
+				Root: c.String("root"),
+				Ref:  c.String("ref"),
+			})
+			if err != nil {
+				return err
+			}
+			unmount, err := fs.Mount(c.Args().First())
+			if err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			<-ctx.Done()
+			unmount()
+			// reports an error of the publish at unmount
+			return fs.Publish(c.Context)
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"fmt"
+	"lifs_go/kv/file"
+
+	"github.com/urfave/cli/v2"
+)
+
+func CommandReshard() *cli.Command {
+	return &cli.Command{
+		Name:  "reshard",
+		Usage: "change the directory levels of a file backend store",
+		Description: "Moves every file of the store to the directory levels given, " +
+			"and records them so the store is opened with them from now on. " +
+			"The store must not be in use; an interrupted run can be repeated.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "store",
+				Usage:    "path of the store",
+				EnvVars:  []string{"LIFS_STORE"},
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:     "levels",
+				Usage:    "number of directory levels, each named by the next two hex digits of the keys",
+				Required: true,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			levels := c.Int("levels")
+			if err := file.Reshard(c.Context, c.String("store"), levels); err != nil {
+				return err
+			}
+			fmt.Fprintf(c.App.Writer, "%s now has %d levels\n", c.String("store"), levels)
+			return nil
+		},
+	}
+}
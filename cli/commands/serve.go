@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"lifs_go/kv/remote"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+// parseAddr splits tcp://host:port or unix:///path into network and
+// address; without a scheme the address is tcp.
+func parseAddr(s string) (string, string) {
+	for _, network := range []string{"tcp", "unix"} {
+		if rest, ok := strings.CutPrefix(s, network+"://"); ok {
+			return network, rest
+		}
+	}
+	return "tcp", s
+}
+
+func clientTLS(caFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+func CommandServe() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "listen",
+			Usage: "address to serve on, tcp://host:port or unix:///path; may be repeated",
+			Value: cli.NewStringSlice("tcp://127.0.0.1:7070"),
+		},
+		&cli.StringFlag{
+			Name:      "tls-cert",
+			Usage:     "serve TLS with this PEM certificate",
+			TakesFile: true,
+		},
+		&cli.StringFlag{
+			Name:      "tls-key",
+			Usage:     "PEM private key of --tls-cert",
+			TakesFile: true,
+		},
+	}
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "share a local store with other hosts",
+		Description: "Serves the bare kv backend of the store; clients open it with " +
+			"--backend remote and apply compression and encryption themselves.",
+		Flags: append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			opts := &remote.ServerOptions{}
+			if cert := c.String("tls-cert"); cert != "" {
+				pair, err := tls.LoadX509KeyPair(cert, c.String("tls-key"))
+				if err != nil {
+					return err
+				}
+				opts.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+			}
+			target, closer, err := openBackend(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+
+			server := remote.NewServer(target, opts)
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			errs := make(chan error, len(c.StringSlice("listen")))
+			for _, listen := range c.StringSlice("listen") {
+				network, addr := parseAddr(listen)
+				if network == "unix" {
+					// a stale socket of an earlier run
+					_ = os.Remove(addr)
+				}
+				go func() { errs <- server.ListenAndServe(network, addr) }()
+			}
+			select {
+			case <-ctx.Done():
+			case err = <-errs:
+			}
+			_ = server.Close()
+			return err
+		},
+	}
+}
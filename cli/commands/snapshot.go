@@ -0,0 +1,253 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"lifs_go/cas"
+	"lifs_go/cas/dirs"
+	"lifs_go/cas/snapshots"
+	"lifs_go/cas/store"
+	storekv "lifs_go/cas/store/kv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func CommandSnapshot() *cli.Command {
+	return &cli.Command{
+		Name:  "snapshot",
+		Usage: "record and restore the history of directories",
+		Subcommands: []*cli.Command{
+			commandSnapshotCreate(),
+			commandSnapshotList(),
+			commandSnapshotShow(),
+			commandSnapshotRestore(),
+		},
+	}
+}
+
+func refFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "ref",
+		Usage: "name of the ref following the snapshots",
+		Value: "main",
+	}
+}
+
+// openSnapshots opens the store as a chunk store and its refs.
+func openSnapshots(c *cli.Context) (store.IF, *snapshots.Refs, func() error, error) {
+	target, closer, err := openKV(c)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return storekv.New(target), snapshots.NewRefs(target), closer, nil
+}
+
+// resolveSnapshot returns the key of a snapshot given by key in hex or
+// by ref name.
+func resolveSnapshot(c *cli.Context, refs *snapshots.Refs, s string) (cas.Key, error) {
+	var key cas.Key
+	if key.UnmarshalText([]byte(s)) == nil {
+		return key, nil
+	}
+	return refs.Get(c.Context, s)
+}
+
+func printSnapshot(c *cli.Context, key cas.Key, s *snapshots.Snapshot) {
+	fmt.Fprintf(c.App.Writer, "snapshot %s\n", &key)
+	for _, p := range s.Parents {
+		fmt.Fprintf(c.App.Writer, "parent   %s\n", &p)
+	}
+	fmt.Fprintf(c.App.Writer, "date     %s\n", s.Time.Format(time.RFC3339))
+	fmt.Fprintf(c.App.Writer, "host     %s\n", s.Host)
+	if s.Message != "" {
+		fmt.Fprintf(c.App.Writer, "\n    %s\n", s.Message)
+	}
+}
+
+func commandSnapshotCreate() *cli.Command {
+	flags := []cli.Flag{
+		refFlag(),
+		&cli.StringFlag{
+			Name:    "message",
+			Aliases: []string{"m"},
+			Usage:   "describe the snapshot",
+		},
+		&cli.BoolFlag{
+			Name:  "cdc",
+			Usage: "store files with content-defined chunking, so edits in large files keep most of their chunks shared",
+		},
+	}
+	return &cli.Command{
+		Name:      "create",
+		Usage:     "store a directory and record it as a new snapshot",
+		ArgsUsage: "DIR",
+		Description: "The snapshot gets the snapshot of the ref as parent, and the ref " +
+			"is moved to the new snapshot. Only files and directories are stored.",
+		Flags: append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return errors.New("expected the directory to snapshot")
+			}
+			chunkStore, refs, closer, err := openSnapshots(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+
+			ref := c.String("ref")
+			var parents []cas.Key
+			parent, err := refs.Get(c.Context, ref)
+			var nf snapshots.RefNotFoundError
+			if err == nil {
+				parents = append(parents, parent)
+			} else if !errors.As(err, &nf) {
+				return err
+			}
+			tree, err := dirs.Import(c.Context, chunkStore, c.Args().First(), &dirs.ImportOptions{
+				ContentDefined: c.Bool("cdc"),
+			})
+			if err != nil {
+				return err
+			}
+			s, err := snapshots.Take(c.Context, tree, parents, c.String("message"))
+			if err != nil {
+				return err
+			}
+			key, err := snapshots.Save(c.Context, chunkStore, s)
+			if err != nil {
+				return err
+			}
+			if err := refs.Set(c.Context, ref, key); err != nil {
+				return err
+			}
+			fmt.Fprintf(c.App.Writer, "%s\n", &key)
+			return nil
+		},
+	}
+}
+
+func commandSnapshotList() *cli.Command {
+	flags := []cli.Flag{
+		refFlag(),
+		&cli.BoolFlag{
+			Name:  "refs",
+			Usage: "list the refs instead",
+		},
+	}
+	return &cli.Command{
+		Name:  "list",
+		Usage: "list the history of a ref, newest first",
+		Flags: append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			chunkStore, refs, closer, err := openSnapshots(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+
+			if c.Bool("refs") {
+				list, err := refs.List(c.Context)
+				if err != nil {
+					return err
+				}
+				for _, r := range list {
+					fmt.Fprintf(c.App.Writer, "%s %s\n", &r.Key, r.Name)
+				}
+				return nil
+			}
+			key, err := refs.Get(c.Context, c.String("ref"))
+			if err != nil {
+				return err
+			}
+			return snapshots.History(c.Context, chunkStore, key, func(key cas.Key, s *snapshots.Snapshot) error {
+				fmt.Fprintf(c.App.Writer, "%s %s %s %s\n", &key, s.Time.Format(time.RFC3339), s.Host, s.Message)
+				return nil
+			})
+		},
+	}
+}
+
+func commandSnapshotShow() *cli.Command {
+	flags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "files",
+			Usage: "also list every file and directory of the snapshot",
+		},
+	}
+	return &cli.Command{
+		Name:      "show",
+		Usage:     "print a snapshot",
+		ArgsUsage: "REF|KEY",
+		Flags:     append(storeFlags(), flags...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return errors.New("expected a ref or snapshot key")
+			}
+			chunkStore, refs, closer, err := openSnapshots(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+
+			key, err := resolveSnapshot(c, refs, c.Args().First())
+			if err != nil {
+				return err
+			}
+			s, err := snapshots.Load(c.Context, chunkStore, key)
+			if err != nil {
+				return err
+			}
+			printSnapshot(c, key, s)
+			if !c.Bool("files") {
+				return nil
+			}
+			tree, err := s.Tree(c.Context, chunkStore)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(c.App.Writer)
+			return tree.Walk(c.Context, func(p string, e dirs.Entry) error {
+				fmt.Fprintf(c.App.Writer, "%s %04o %12d %s %s\n",
+					e.Kind, e.Mode, e.Size, e.Mtime.Format(time.RFC3339), p)
+				return nil
+			})
+		},
+	}
+}
+
+func commandSnapshotRestore() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "write the files of a snapshot into a directory",
+		ArgsUsage: "REF|KEY DIR",
+		Description: "DIR is created if needed. Files of the snapshot replace the files " +
+			"of the same names in DIR; other files in DIR are left alone. " +
+			"The refs are not changed.",
+		Flags: storeFlags(),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return errors.New("expected a ref or snapshot key and a directory")
+			}
+			chunkStore, refs, closer, err := openSnapshots(c)
+			if err != nil {
+				return err
+			}
+			defer closer()
+
+			key, err := resolveSnapshot(c, refs, c.Args().Get(0))
+			if err != nil {
+				return err
+			}
+			s, err := snapshots.Load(c.Context, chunkStore, key)
+			if err != nil {
+				return err
+			}
+			tree, err := s.Tree(c.Context, chunkStore)
+			if err != nil {
+				return err
+			}
+			return tree.Export(c.Context, c.Args().Get(1))
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"lifs_go/kv"
+	"lifs_go/kv/btree"
+	"lifs_go/kv/compress"
+	"lifs_go/kv/crypt"
+	"lifs_go/kv/file"
+	"lifs_go/kv/log"
+	"lifs_go/kv/remote"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// storeFlags are the flags of every command that opens a store.
+func storeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "store",
+			Usage:    "path of the store",
+			EnvVars:  []string{"LIFS_STORE"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "backend",
+			Usage: "kv backend of the store: file, log, btree or remote, where the store is a tcp:// or unix:// address",
+			Value: "file",
+		},
+		&cli.StringFlag{
+			Name:      "tls-ca",
+			Usage:     "connect to a remote store with TLS, trusting the certificates in this PEM file",
+			TakesFile: true,
+		},
+		&cli.StringFlag{
+			Name:  "compress",
+			Usage: "codec for new values: raw, flate, gzip or lz",
+			Value: compress.Raw.String(),
+		},
+		&cli.StringFlag{
+			Name:      "keyfile",
+			Usage:     "encrypt the store with the keys of this key file",
+			EnvVars:   []string{"LIFS_KEYFILE"},
+			TakesFile: true,
+		},
+		&cli.BoolFlag{
+			Name:  "hide-keys",
+			Usage: "encrypt the keys as well as the values, with --keyfile",
+		},
+		passphraseFlag(),
+	}
+}
+
+func passphraseFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:      "passphrase-file",
+		Usage:     "read the key file passphrase from this file instead of $LIFS_PASSPHRASE",
+		TakesFile: true,
+	}
+}
+
+func passphrase(c *cli.Context) ([]byte, error) {
+	if name := c.String("passphrase-file"); name != "" {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	if p, ok := os.LookupEnv("LIFS_PASSPHRASE"); ok {
+		return []byte(p), nil
+	}
+	return nil, errors.New("no passphrase: set LIFS_PASSPHRASE or use --passphrase-file")
+}
+
+func loadKeys(c *cli.Context) (*crypt.Keyring, error) {
+	p, err := passphrase(c)
+	if err != nil {
+		return nil, err
+	}
+	return crypt.LoadKeyFile(c.String("keyfile"), p)
+}
+
+// openBackend opens the bare kv backend of the store. The returned
+// close func must be called when done.
+func openBackend(c *cli.Context) (kv.IF, func() error, error) {
+	path := c.String("store")
+	var target kv.IF
+	var err error
+	switch backend := c.String("backend"); backend {
+	case "file":
+		target, err = file.Open(path, nil)
+	case "log":
+		target, err = log.Open(path, nil)
+	case "btree":
+		target, err = btree.Open(path, nil)
+	case "remote":
+		opts := &remote.Options{}
+		if ca := c.String("tls-ca"); ca != "" {
+			if opts.TLS, err = clientTLS(ca); err != nil {
+				return nil, nil, err
+			}
+		}
+		network, addr := parseAddr(path)
+		target, err = remote.Dial(network, addr, opts)
+	default:
+		return nil, nil, fmt.Errorf("unknown backend: %s", backend)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	closer := func() error { return nil }
+	if cl, ok := target.(io.Closer); ok {
+		closer = cl.Close
+	}
+	return target, closer, nil
+}
+
+// openKV opens the store with the compression and encryption layers
+// selected by the flags.
+func openKV(c *cli.Context) (kv.IF, func() error, error) {
+	codec, err := compress.ParseCodec(c.String("compress"))
+	if err != nil {
+		return nil, nil, err
+	}
+	target, closer, err := openEncrypted(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	// compress before encrypting, ciphertext does not compress
+	compressed, err := compress.Wrap(c.Context, target, &compress.Options{Codec: codec})
+	if err != nil {
+		_ = closer()
+		return nil, nil, err
+	}
+	return compressed, closer, nil
+}
+
+// openEncrypted opens the store with the encryption layer selected by
+// the flags, which is what the compression layer wraps.
+func openEncrypted(c *cli.Context) (kv.IF, func() error, error) {
+	var keys *crypt.Keyring
+	if c.String("keyfile") != "" {
+		var err error
+		if keys, err = loadKeys(c); err != nil {
+			return nil, nil, err
+		}
+	}
+	target, closer, err := openBackend(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keys != nil {
+		encrypted, err := crypt.Wrap(target, keys, &crypt.Options{HideKeys: c.Bool("hide-keys")})
+		if err != nil {
+			_ = closer()
+			return nil, nil, err
+		}
+		target = encrypted
+	}
+	return target, closer, nil
+}
@@ -11,6 +11,8 @@ require (
 	github.com/hanwen/go-fuse/v2 v2.5.1
 	github.com/spf13/afero v1.11.0
 	github.com/urfave/cli/v2 v2.27.1
+	golang.org/x/crypto v0.21.0
+	golang.org/x/sys v0.18.0
 )
 
 require (
@@ -19,6 +21,5 @@ require (
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,43 @@
+package kv
+
+// Op is a single operation of a Batch.
+type Op struct {
+	Key   []byte
+	Value []byte
+	// Delete is set for deletes; Value is then nil.
+	Delete bool
+}
+
+// Batch stages puts and deletes to be applied atomically by IF.Write.
+// Operations are applied in order, so the last one on a key wins.
+//
+// The zero value is an empty batch ready to use. A Batch keeps
+// references to the keys and values passed in, so they must not be
+// modified until the Batch has been written.
+type Batch struct {
+	ops []Op
+}
+
+func (b *Batch) Put(key, value []byte) {
+	b.ops = append(b.ops, Op{Key: key, Value: value})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, Op{Key: key, Delete: true})
+}
+
+// Len returns the number of staged operations.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Ops returns the staged operations in order. The slice must not be
+// modified.
+func (b *Batch) Ops() []Op {
+	return b.ops
+}
+
+// Reset empties the batch so it can be reused.
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
@@ -0,0 +1,451 @@
+package btree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lifs_go/kv"
+	"os"
+	"sync"
+)
+
+// Options tunes a btree file. The zero value is usable.
+type Options struct {
+	// PageSize is used when creating a new file; existing files keep
+	// the page size they were created with. Defaults to the OS page
+	// size.
+	PageSize int
+	// NoSync skips fsync on commit. A crash may then lose recent
+	// writes, but never corrupts the file as long as the OS does not
+	// reorder writes.
+	NoSync bool
+}
+
+// Impl keeps all keys in a single file as a copy-on-write B+tree.
+//
+// Every Put and Delete is a transaction of its own: the modified
+// nodes are written to free pages, and a new meta page pointing at
+// them is written only after the data is on disk. A crash at any
+// point leaves the previous or the new state.
+type Impl struct {
+	mu       sync.RWMutex
+	file     *os.File
+	opts     Options
+	pageSize int
+	meta     meta
+	free     freelist
+	closed   bool
+
+	// open snapshots by txid, and the pages freed by each commit that
+	// may still be referenced by one of them
+	snapshots map[uint64]int
+	pending   map[uint64][]pgid
+}
+
+// Open opens or creates the btree file at path.
+func Open(path string, opts *Options) (*Impl, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	db := &Impl{
+		file:      f,
+		opts:      *opts,
+		snapshots: make(map[uint64]int),
+		pending:   make(map[uint64][]pgid),
+	}
+	if err := db.init(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *Impl) init() error {
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return db.create()
+	}
+
+	if err := db.loadMeta(); err != nil {
+		return err
+	}
+	if db.meta.freelist != 0 {
+		buf, err := db.readPages(db.meta.freelist)
+		if err != nil {
+			return err
+		}
+		ids, err := decodeFreelist(db.meta.freelist, buf)
+		if err != nil {
+			return err
+		}
+		db.free.release(ids...)
+	}
+	return nil
+}
+
+func (db *Impl) create() error {
+	db.pageSize = db.opts.PageSize
+	if db.pageSize == 0 {
+		db.pageSize = os.Getpagesize()
+	}
+	if db.pageSize < minPageSize {
+		return errors.New("btree: page size too small")
+	}
+	m := meta{
+		pageSize: uint32(db.pageSize),
+		pages:    metaPages,
+	}
+	// write both meta pages so either one is valid
+	for i := 0; i < metaPages; i++ {
+		m.txid = uint64(i)
+		if err := db.writeMeta(&m); err != nil {
+			return err
+		}
+	}
+	db.meta = m
+	return db.sync()
+}
+
+// loadMeta picks the newest valid meta page.
+func (db *Impl) loadMeta() error {
+	var metas []meta
+
+	buf := make([]byte, metaSize+8)
+	var m0 meta
+	if _, err := db.file.ReadAt(buf, 0); err == nil && m0.decode(buf) {
+		metas = append(metas, m0)
+	}
+
+	// without the first meta page the page size is unknown, so try
+	// the likely ones
+	sizes := []int{os.Getpagesize(), 4096, 8192, 16384, 65536}
+	if len(metas) > 0 {
+		sizes = []int{int(m0.pageSize)}
+	}
+	for _, sz := range sizes {
+		var m1 meta
+		if _, err := db.file.ReadAt(buf, int64(sz)); err == nil && m1.decode(buf) && int(m1.pageSize) == sz {
+			metas = append(metas, m1)
+			break
+		}
+	}
+
+	if len(metas) == 0 {
+		return CorruptPageError{Page: 0}
+	}
+	db.meta = metas[0]
+	for _, m := range metas[1:] {
+		if m.txid > db.meta.txid {
+			db.meta = m
+		}
+	}
+	db.pageSize = int(db.meta.pageSize)
+	return nil
+}
+
+func (db *Impl) writeMeta(m *meta) error {
+	buf := make([]byte, db.pageSize)
+	m.encode(buf)
+	off := int64(m.txid%metaPages) * int64(len(buf))
+	_, err := db.file.WriteAt(buf, off)
+	return err
+}
+
+func (db *Impl) sync() error {
+	if db.opts.NoSync {
+		return nil
+	}
+	return db.file.Sync()
+}
+
+func (db *Impl) writeAt(buf []byte, id pgid) error {
+	_, err := db.file.WriteAt(buf, int64(id)*int64(db.pageSize))
+	return err
+}
+
+// allocate returns the first of n contiguous free pages, growing the
+// file if the freelist has no such run.
+func (db *Impl) allocate(n int) pgid {
+	if id := db.free.allocate(n); id != 0 {
+		return id
+	}
+	id := db.meta.pages
+	db.meta.pages += pgid(n)
+	return id
+}
+
+func (db *Impl) writePages(buf []byte) (pgid, error) {
+	id := db.allocate(len(buf) / db.pageSize)
+	if err := db.writeAt(buf, id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// readPages reads the page id and its overflow pages.
+func (db *Impl) readPages(id pgid) ([]byte, error) {
+	if id < metaPages || id >= db.meta.pages {
+		return nil, CorruptPageError{Page: uint64(id)}
+	}
+	buf := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(buf, int64(id)*int64(db.pageSize)); err != nil {
+		return nil, err
+	}
+	var h pageHeader
+	h.decode(buf)
+	if h.overflow > 0 {
+		if id+pgid(h.overflow) >= db.meta.pages {
+			return nil, CorruptPageError{Page: uint64(id)}
+		}
+		full := make([]byte, (int(h.overflow)+1)*db.pageSize)
+		copy(full, buf)
+		if _, err := db.file.ReadAt(full[db.pageSize:], int64(id+1)*int64(db.pageSize)); err != nil {
+			return nil, err
+		}
+		buf = full
+	}
+	if !pageChecksumOK(buf) {
+		return nil, CorruptPageError{Page: uint64(id)}
+	}
+	return buf, nil
+}
+
+// pageRun lists the page id and its overflow pages.
+func (db *Impl) pageRun(id pgid) ([]pgid, error) {
+	buf := make([]byte, pageHeaderSize)
+	if _, err := db.file.ReadAt(buf, int64(id)*int64(db.pageSize)); err != nil {
+		return nil, err
+	}
+	var h pageHeader
+	h.decode(buf)
+	ids := make([]pgid, 0, h.overflow+1)
+	for i := uint32(0); i <= h.overflow; i++ {
+		ids = append(ids, id+pgid(i))
+	}
+	return ids, nil
+}
+
+func (db *Impl) readNode(id pgid) (*node, error) {
+	buf, err := db.readPages(id)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{}
+	if err := n.decode(id, buf); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// lookup walks from root down to the leaf that may hold key.
+func (db *Impl) lookup(root pgid, key []byte) ([]byte, bool, error) {
+	if root == 0 {
+		return nil, false, nil
+	}
+	n, err := db.readNode(root)
+	if err != nil {
+		return nil, false, err
+	}
+	for !n.leaf {
+		if len(n.inodes) == 0 {
+			return nil, false, nil
+		}
+		n, err = db.readNode(n.inodes[n.childIndex(key)].id)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	i := n.search(key)
+	if i == len(n.inodes) || !bytes.Equal(n.inodes[i].key, key) {
+		return nil, false, nil
+	}
+	return n.inodes[i].value, true, nil
+}
+
+// keysFrom returns the keys >= start from the first leaf below id
+// that has any.
+func (db *Impl) keysFrom(id pgid, start []byte) ([][]byte, error) {
+	n, err := db.readNode(id)
+	if err != nil {
+		return nil, err
+	}
+	if n.leaf {
+		var keys [][]byte
+		for _, in := range n.inodes[n.search(start):] {
+			keys = append(keys, in.key)
+		}
+		return keys, nil
+	}
+	for i := n.childIndex(start); i < len(n.inodes); i++ {
+		keys, err := db.keysFrom(n.inodes[i].id, start)
+		if err != nil || len(keys) > 0 {
+			return keys, err
+		}
+	}
+	return nil, nil
+}
+
+func (db *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	v, ok, err := db.lookup(db.meta.root, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, kv.NotFoundError{Key: key}
+	}
+	return v, nil
+}
+
+func (db *Impl) update(fn func(t *tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	t, err := db.begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(t); err != nil {
+		return err
+	}
+	if !t.dirty {
+		return nil
+	}
+	return t.commit()
+}
+
+func (db *Impl) Put(ctx context.Context, key, value []byte) error {
+	// the tree keeps references until commit, don't let the caller
+	// change them under us
+	key = append([]byte(nil), key...)
+	value = append([]byte(nil), value...)
+	return db.update(func(t *tx) error {
+		return t.put(key, value)
+	})
+}
+
+func (db *Impl) Delete(ctx context.Context, key []byte) error {
+	return db.update(func(t *tx) error {
+		return t.delete(key)
+	})
+}
+
+func (db *Impl) Has(ctx context.Context, key []byte) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return false, ErrClosed
+	}
+	_, ok, err := db.lookup(db.meta.root, key)
+	return ok, err
+}
+
+// Iterate walks the tree one leaf at a time. The lock is not held
+// while fn runs, so fn may modify the store; keys added or removed
+// behind the current position may or may not be seen.
+func (db *Impl) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return db.iterate(ctx, r, fn, func() pgid { return db.meta.root })
+}
+
+// iterate walks the tree returned by root, which is called with the
+// lock held before reading every leaf.
+func (db *Impl) iterate(ctx context.Context, r kv.Range, fn func(key []byte) error, root func() pgid) error {
+	start := r.Start
+	if start == nil {
+		start = []byte{}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		keys, err := db.nextKeys(root, start)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		for _, key := range keys {
+			if !r.Contains(key) {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		// smallest key after the last one seen
+		start = append(keys[len(keys)-1], 0)
+	}
+}
+
+func (db *Impl) nextKeys(root func() pgid, start []byte) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	id := root()
+	if id == 0 {
+		return nil, nil
+	}
+	return db.keysFrom(id, start)
+}
+
+// Write applies the whole batch in a single transaction.
+func (db *Impl) Write(ctx context.Context, b *kv.Batch) error {
+	ops := make([]kv.Op, 0, b.Len())
+	for _, op := range b.Ops() {
+		op.Key = append([]byte(nil), op.Key...)
+		if !op.Delete {
+			op.Value = append([]byte(nil), op.Value...)
+		}
+		ops = append(ops, op)
+	}
+	return db.update(func(t *tx) error {
+		for _, op := range ops {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var err error
+			if op.Delete {
+				err = t.delete(op.Key)
+			} else {
+				err = t.put(op.Key, op.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close releases the file. The Impl must not be used afterwards.
+func (db *Impl) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.file.Close()
+}
+
+var _ io.Closer = (*Impl)(nil)
@@ -0,0 +1,361 @@
+package btree_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"lifs_go/kv"
+	"lifs_go/kv/btree"
+	"lifs_go/kv/kvtest"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func open(t *testing.T, path string) *btree.Impl {
+	t.Helper()
+	db, err := btree.Open(path, &btree.Options{PageSize: 4096, NoSync: true})
+	if err != nil {
+		t.Fatalf("btree.Open fail: %v", err)
+	}
+	return db
+}
+
+func NewTestTarget(t *testing.T) kv.IF {
+	db := open(t, filepath.Join(t.TempDir(), "kv.db"))
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, NewTestTarget)
+}
+
+func TestGetNotFoundError(t *testing.T) {
+	target := NewTestTarget(t)
+	const KEY = "missing"
+	_, err := target.Get(context.Background(), []byte(KEY))
+	var nf kv.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("Get error is of wrong type: %T: %v", err, err)
+	}
+	if g, w := string(nf.Key), KEY; g != w {
+		t.Errorf("NotFoundError Key is wrong: %x != %x", g, w)
+	}
+}
+
+func TestPutOverwrite(t *testing.T) {
+	target := NewTestTarget(t)
+	ctx := context.Background()
+	for _, v := range []string{"value", "otherValue"} {
+		if err := target.Put(ctx, []byte("key"), []byte(v)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+		got, err := target.Get(ctx, []byte("key"))
+		if err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+		if g, e := string(got), v; g != e {
+			t.Errorf("Get gave wrong content: %q != %q", g, e)
+		}
+	}
+}
+
+func keyN(i int) []byte {
+	return []byte(fmt.Sprintf("key-%06d", i))
+}
+
+func valueN(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 10+i%300)
+}
+
+func TestManyKeysReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	db := open(t, path)
+	ctx := context.Background()
+
+	const N = 3000
+	for _, i := range rand.New(rand.NewSource(1)).Perm(N) {
+		if err := db.Put(ctx, keyN(i), valueN(i)); err != nil {
+			t.Fatalf("Put %d fail: %v", i, err)
+		}
+	}
+	// delete every third key
+	for i := 0; i < N; i += 3 {
+		if err := db.Delete(ctx, keyN(i)); err != nil {
+			t.Fatalf("Delete %d fail: %v", i, err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close fail: %v", err)
+	}
+
+	db = open(t, path)
+	defer db.Close()
+	for i := 0; i < N; i++ {
+		v, err := db.Get(ctx, keyN(i))
+		if i%3 == 0 {
+			var nf kv.NotFoundError
+			if !errors.As(err, &nf) {
+				t.Fatalf("deleted key %d still found: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Get %d fail: %v", i, err)
+		}
+		if !bytes.Equal(v, valueN(i)) {
+			t.Fatalf("Get %d gave wrong content", i)
+		}
+	}
+
+	n := 0
+	var last []byte
+	err := db.Iterate(ctx, kv.All, func(key []byte) error {
+		if last != nil && bytes.Compare(last, key) >= 0 {
+			t.Fatalf("Iterate out of order: %q after %q", key, last)
+		}
+		last = append(last[:0], key...)
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate fail: %v", err)
+	}
+	if g, e := n, N-(N+2)/3; g != e {
+		t.Errorf("Iterate gave wrong count: %d != %d", g, e)
+	}
+}
+
+func TestLargeValues(t *testing.T) {
+	target := NewTestTarget(t)
+	ctx := context.Background()
+	big := bytes.Repeat([]byte("0123456789abcdef"), 4*1024*1024/16)
+	for i := 0; i < 3; i++ {
+		big[i] = 'x'
+		if err := target.Put(ctx, keyN(i), big); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+		if err := target.Put(ctx, keyN(i+100), valueN(i)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		v, err := target.Get(ctx, keyN(i))
+		if err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+		if g, e := len(v), len(big); g != e {
+			t.Fatalf("Get gave wrong length: %d != %d", g, e)
+		}
+		if g, e := v[i], byte('x'); g != e {
+			t.Errorf("Get gave wrong content: %q != %q", g, e)
+		}
+	}
+}
+
+func TestFreePagesReused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	db := open(t, path)
+	defer db.Close()
+	ctx := context.Background()
+
+	value := bytes.Repeat([]byte("v"), 64*1024)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(ctx, keyN(i), value); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := info.Size()
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			if err := db.Put(ctx, keyN(i), value); err != nil {
+				t.Fatalf("Put fail: %v", err)
+			}
+		}
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*before {
+		t.Errorf("file keeps growing on overwrite: %d > 2*%d", info.Size(), before)
+	}
+}
+
+// TestTornMeta simulates a crash while writing the newest meta page:
+// the previous commit must still be readable.
+func TestTornMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	db := open(t, path)
+	ctx := context.Background()
+	if err := db.Put(ctx, []byte("old"), []byte("1")); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	if err := db.Put(ctx, []byte("new"), []byte("2")); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// find the meta page holding "new" by trying both
+	for _, page := range []int64{0, 1} {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig := make([]byte, 64)
+		if _, err := f.ReadAt(orig, page*4096); err != nil {
+			t.Fatal(err)
+		}
+		garbage := bytes.Repeat([]byte{0xAA}, 64)
+		if _, err := f.WriteAt(garbage, page*4096+8); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		db = open(t, path)
+		_, errOld := db.Get(ctx, []byte("old"))
+		_, errNew := db.Get(ctx, []byte("new"))
+		_ = db.Close()
+		if errOld != nil {
+			t.Fatalf("old commit lost after tearing meta %d: %v", page, errOld)
+		}
+		if errNew != nil {
+			// this was the newest meta; the older one was used
+			return
+		}
+
+		f, err = os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(orig, page*4096); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+	}
+	t.Fatalf("tearing either meta page did not fall back")
+}
+
+func TestClosed(t *testing.T) {
+	db := open(t, filepath.Join(t.TempDir(), "kv.db"))
+	var c io.Closer = db
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Get(context.Background(), []byte("key"))
+	if !errors.Is(err, btree.ErrClosed) {
+		t.Errorf("expected ErrClosed: %v", err)
+	}
+}
+
+func TestRandomOps(t *testing.T) {
+	target := NewTestTarget(t)
+	ctx := context.Background()
+	model := make(map[string][]byte)
+	rnd := rand.New(rand.NewSource(42))
+	for op := 0; op < 5000; op++ {
+		k := keyN(rnd.Intn(500))
+		if rnd.Intn(3) == 0 {
+			if err := target.Delete(ctx, k); err != nil {
+				t.Fatalf("Delete fail: %v", err)
+			}
+			delete(model, string(k))
+			continue
+		}
+		v := bytes.Repeat([]byte{byte(op)}, rnd.Intn(3000))
+		if err := target.Put(ctx, k, v); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+		model[string(k)] = v
+	}
+	n := 0
+	err := target.Iterate(ctx, kv.All, func(key []byte) error {
+		want, ok := model[string(key)]
+		if !ok {
+			t.Fatalf("Iterate found deleted key %q", key)
+		}
+		got, err := target.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Get %q gave wrong content", key)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate fail: %v", err)
+	}
+	if g, e := n, len(model); g != e {
+		t.Errorf("Iterate gave wrong count: %d != %d", g, e)
+	}
+}
+
+// TestSnapshotPages checks that pages a snapshot still reads are not
+// reused while it is open, and are once it is released.
+func TestSnapshotPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.db")
+	db := open(t, path)
+	defer db.Close()
+	ctx := context.Background()
+
+	old := bytes.Repeat([]byte("o"), 16*1024)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(ctx, keyN(i), old); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	snap, err := db.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot fail: %v", err)
+	}
+	value := bytes.Repeat([]byte("n"), 16*1024)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			if err := db.Put(ctx, keyN(i), value); err != nil {
+				t.Fatalf("Put fail: %v", err)
+			}
+		}
+	}
+	for i := 0; i < 10; i++ {
+		v, err := snap.Get(ctx, keyN(i))
+		if err != nil {
+			t.Fatalf("Get from snapshot fail: %v", err)
+		}
+		if !bytes.Equal(v, old) {
+			t.Fatalf("snapshot sees overwritten page for key %d", i)
+		}
+	}
+	snap.Release()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := info.Size()
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			if err := db.Put(ctx, keyN(i), value); err != nil {
+				t.Fatalf("Put fail: %v", err)
+			}
+		}
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*before {
+		t.Errorf("pages not reused after Release: %d > 2*%d", info.Size(), before)
+	}
+}
@@ -0,0 +1,22 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrClosed = errors.New("btree is closed")
+)
+
+// CorruptPageError is returned when a page fails validation, e.g. a
+// checksum mismatch or an out of range page id.
+type CorruptPageError struct {
+	Page uint64
+}
+
+var _ error = CorruptPageError{}
+
+func (c CorruptPageError) Error() string {
+	return fmt.Sprintf("[ErrBtree] corrupt page %d", c.Page)
+}
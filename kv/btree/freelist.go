@@ -0,0 +1,81 @@
+package btree
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// freelist tracks pages that are not reachable from the committed
+// meta and may be reused by the next commit.
+type freelist struct {
+	ids []pgid // sorted
+}
+
+// allocate takes n contiguous pages off the list. It returns 0 if
+// there is no such run.
+func (f *freelist) allocate(n int) pgid {
+	start := 0
+	for i := range f.ids {
+		if i > 0 && f.ids[i] != f.ids[i-1]+1 {
+			start = i
+		}
+		if i-start+1 == n {
+			id := f.ids[start]
+			f.ids = append(f.ids[:start], f.ids[i+1:]...)
+			return id
+		}
+	}
+	return 0
+}
+
+// release adds pages back to the list.
+func (f *freelist) release(ids ...pgid) {
+	if len(ids) == 0 {
+		return
+	}
+	f.ids = append(f.ids, ids...)
+	sort.Slice(f.ids, func(i, j int) bool { return f.ids[i] < f.ids[j] })
+}
+
+func (f *freelist) clone() freelist {
+	return freelist{ids: append([]pgid(nil), f.ids...)}
+}
+
+// encodeFreelist serializes ids into a buffer of the given number of
+// pages, which must be at least freelistPages(len(ids), pageSize).
+func encodeFreelist(ids []pgid, pages int, pageSize int) []byte {
+	buf := make([]byte, pages*pageSize)
+	h := pageHeader{
+		flags:    freelistPageFlag,
+		count:    uint32(len(ids)),
+		overflow: uint32(len(buf)/pageSize - 1),
+	}
+	h.encode(buf)
+	off := pageHeaderSize
+	for _, id := range ids {
+		binary.BigEndian.PutUint64(buf[off:], uint64(id))
+		off += 8
+	}
+	sealPage(buf)
+	return buf
+}
+
+func decodeFreelist(id pgid, buf []byte) ([]pgid, error) {
+	var h pageHeader
+	h.decode(buf)
+	if h.flags != freelistPageFlag || pageHeaderSize+int(h.count)*8 > len(buf) {
+		return nil, CorruptPageError{Page: uint64(id)}
+	}
+	ids := make([]pgid, h.count)
+	off := pageHeaderSize
+	for i := range ids {
+		ids[i] = pgid(binary.BigEndian.Uint64(buf[off:]))
+		off += 8
+	}
+	return ids, nil
+}
+
+func freelistPages(count int, pageSize int) int {
+	sz := pageHeaderSize + count*8
+	return (sz + pageSize - 1) / pageSize
+}
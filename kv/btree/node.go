@@ -0,0 +1,186 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// keyLen, valueLen
+	leafElementSize = 8
+	// keyLen, child pgid
+	branchElementSize = 12
+)
+
+// node is the decoded form of a leaf or branch page.
+//
+// Nodes read from the file are private copies, so a write
+// transaction may modify them freely after calling tx.touch.
+type node struct {
+	// page holding this node, 0 while the node is dirty
+	id       pgid
+	overflow uint32
+	leaf     bool
+	inodes   []inode
+}
+
+// inode is a single element of a node. For branch nodes, key is the
+// smallest key in the child subtree.
+type inode struct {
+	key   []byte
+	value []byte
+	id    pgid
+	child *node
+}
+
+// search returns the index of the first inode with key >= key.
+func (n *node) search(key []byte) int {
+	return sort.Search(len(n.inodes), func(i int) bool {
+		return bytes.Compare(n.inodes[i].key, key) >= 0
+	})
+}
+
+// childIndex returns the index of the child subtree that key belongs
+// to. Keys smaller than every separator go to the first child.
+func (n *node) childIndex(key []byte) int {
+	i := sort.Search(len(n.inodes), func(i int) bool {
+		return bytes.Compare(n.inodes[i].key, key) > 0
+	})
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+func (n *node) elementSize(in *inode) int {
+	if n.leaf {
+		return leafElementSize + len(in.key) + len(in.value)
+	}
+	return branchElementSize + len(in.key)
+}
+
+func (n *node) size() int {
+	sz := pageHeaderSize
+	for i := range n.inodes {
+		sz += n.elementSize(&n.inodes[i])
+	}
+	return sz
+}
+
+// split breaks n into nodes of about half a page each. A single
+// element larger than a page is kept alone in a node spanning
+// several pages.
+func (n *node) split(pageSize int) []*node {
+	if len(n.inodes) <= 1 || n.size() <= pageSize {
+		return []*node{n}
+	}
+	threshold := pageSize / 2
+
+	var parts []*node
+	var sizes []int
+	cur := &node{leaf: n.leaf}
+	curSize := pageHeaderSize
+	for i := range n.inodes {
+		sz := n.elementSize(&n.inodes[i])
+		if len(cur.inodes) > 0 && curSize+sz > threshold {
+			parts = append(parts, cur)
+			sizes = append(sizes, curSize)
+			cur = &node{leaf: n.leaf}
+			curSize = pageHeaderSize
+		}
+		cur.inodes = append(cur.inodes, n.inodes[i])
+		curSize += sz
+	}
+	if last := len(parts) - 1; last >= 0 && sizes[last]+curSize-pageHeaderSize <= pageSize {
+		// don't leave a tiny node at the end
+		parts[last].inodes = append(parts[last].inodes, cur.inodes...)
+	} else {
+		parts = append(parts, cur)
+	}
+
+	// keep n itself as the first part
+	n.inodes = parts[0].inodes
+	parts[0] = n
+	return parts
+}
+
+// encode serializes n into a buffer that is a whole number of pages.
+func (n *node) encode(pageSize int) []byte {
+	sz := n.size()
+	count := (sz + pageSize - 1) / pageSize
+	buf := make([]byte, count*pageSize)
+
+	h := pageHeader{
+		flags:    branchPageFlag,
+		count:    uint32(len(n.inodes)),
+		overflow: uint32(count - 1),
+	}
+	if n.leaf {
+		h.flags = leafPageFlag
+	}
+	h.encode(buf)
+
+	off := pageHeaderSize
+	for i := range n.inodes {
+		in := &n.inodes[i]
+		binary.BigEndian.PutUint32(buf[off:], uint32(len(in.key)))
+		if n.leaf {
+			binary.BigEndian.PutUint32(buf[off+4:], uint32(len(in.value)))
+			off += leafElementSize
+		} else {
+			binary.BigEndian.PutUint64(buf[off+4:], uint64(in.id))
+			off += branchElementSize
+		}
+		off += copy(buf[off:], in.key)
+		if n.leaf {
+			off += copy(buf[off:], in.value)
+		}
+	}
+	sealPage(buf)
+	return buf
+}
+
+// decode parses a node from buf, which must hold all the pages of
+// the node.
+func (n *node) decode(id pgid, buf []byte) error {
+	var h pageHeader
+	h.decode(buf)
+	if h.flags != leafPageFlag && h.flags != branchPageFlag {
+		return CorruptPageError{Page: uint64(id)}
+	}
+	n.id = id
+	n.overflow = h.overflow
+	n.leaf = h.flags == leafPageFlag
+	n.inodes = make([]inode, h.count)
+
+	off := pageHeaderSize
+	for i := range n.inodes {
+		in := &n.inodes[i]
+		hdr := leafElementSize
+		if !n.leaf {
+			hdr = branchElementSize
+		}
+		if off+hdr > len(buf) {
+			return CorruptPageError{Page: uint64(id)}
+		}
+		klen := int(binary.BigEndian.Uint32(buf[off:]))
+		vlen := 0
+		if n.leaf {
+			vlen = int(binary.BigEndian.Uint32(buf[off+4:]))
+		} else {
+			in.id = pgid(binary.BigEndian.Uint64(buf[off+4:]))
+		}
+		off += hdr
+		if klen < 0 || vlen < 0 || off+klen+vlen > len(buf) {
+			return CorruptPageError{Page: uint64(id)}
+		}
+		in.key = buf[off : off+klen : off+klen]
+		off += klen
+		if n.leaf {
+			in.value = buf[off : off+vlen : off+vlen]
+			off += vlen
+		}
+	}
+	return nil
+}
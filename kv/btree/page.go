@@ -0,0 +1,119 @@
+package btree
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+)
+
+// pgid is the index of a page in the file. Pages 0 and 1 hold the
+// two meta pages, so 0 is never a valid node page and is used to mean
+// "no page".
+type pgid uint64
+
+const (
+	magic   = 0x6c696673 // "lifs"
+	version = 1
+
+	// number of pages reserved for meta at the start of the file
+	metaPages = 2
+
+	// flags, count, overflow, checksum
+	pageHeaderSize = 16
+
+	minPageSize = 1024
+)
+
+const (
+	leafPageFlag     uint16 = 0x01
+	branchPageFlag   uint16 = 0x02
+	freelistPageFlag uint16 = 0x04
+)
+
+// pageHeader starts every node and freelist page. A node that does
+// not fit in one page spans overflow more pages that directly follow
+// it in the file.
+type pageHeader struct {
+	flags    uint16
+	count    uint32
+	overflow uint32
+	checksum uint32
+}
+
+func (h *pageHeader) encode(buf []byte) {
+	binary.BigEndian.PutUint16(buf[0:2], h.flags)
+	binary.BigEndian.PutUint16(buf[2:4], 0)
+	binary.BigEndian.PutUint32(buf[4:8], h.count)
+	binary.BigEndian.PutUint32(buf[8:12], h.overflow)
+	binary.BigEndian.PutUint32(buf[12:16], h.checksum)
+}
+
+func (h *pageHeader) decode(buf []byte) {
+	h.flags = binary.BigEndian.Uint16(buf[0:2])
+	h.count = binary.BigEndian.Uint32(buf[4:8])
+	h.overflow = binary.BigEndian.Uint32(buf[8:12])
+	h.checksum = binary.BigEndian.Uint32(buf[12:16])
+}
+
+// sealPage fills in the checksum of a fully encoded page buffer.
+func sealPage(buf []byte) {
+	binary.BigEndian.PutUint32(buf[12:16], crc32.ChecksumIEEE(buf[pageHeaderSize:]))
+}
+
+func pageChecksumOK(buf []byte) bool {
+	return binary.BigEndian.Uint32(buf[12:16]) == crc32.ChecksumIEEE(buf[pageHeaderSize:])
+}
+
+// meta is the root of a committed state. Commits alternate between
+// the two meta pages, so a torn meta write leaves the previous one
+// intact.
+type meta struct {
+	pageSize uint32
+	root     pgid
+	freelist pgid
+	// high water mark: number of pages in use, including meta pages
+	pages pgid
+	txid  uint64
+}
+
+const metaSize = 48
+
+func (m *meta) encode(buf []byte) {
+	binary.BigEndian.PutUint32(buf[0:4], magic)
+	binary.BigEndian.PutUint32(buf[4:8], version)
+	binary.BigEndian.PutUint32(buf[8:12], m.pageSize)
+	binary.BigEndian.PutUint32(buf[12:16], 0)
+	binary.BigEndian.PutUint64(buf[16:24], uint64(m.root))
+	binary.BigEndian.PutUint64(buf[24:32], uint64(m.freelist))
+	binary.BigEndian.PutUint64(buf[32:40], uint64(m.pages))
+	binary.BigEndian.PutUint64(buf[40:48], m.txid)
+	binary.BigEndian.PutUint64(buf[48:56], metaChecksum(buf))
+}
+
+// decode reports false if buf does not hold a valid meta page.
+func (m *meta) decode(buf []byte) bool {
+	if len(buf) < metaSize+8 {
+		return false
+	}
+	if binary.BigEndian.Uint32(buf[0:4]) != magic ||
+		binary.BigEndian.Uint32(buf[4:8]) != version ||
+		binary.BigEndian.Uint64(buf[48:56]) != metaChecksum(buf) {
+		return false
+	}
+	m.pageSize = binary.BigEndian.Uint32(buf[8:12])
+	m.root = pgid(binary.BigEndian.Uint64(buf[16:24]))
+	m.freelist = pgid(binary.BigEndian.Uint64(buf[24:32]))
+	m.pages = pgid(binary.BigEndian.Uint64(buf[32:40]))
+	m.txid = binary.BigEndian.Uint64(buf[40:48])
+	if m.pageSize < minPageSize || m.pages < metaPages ||
+		m.root >= m.pages || m.freelist >= m.pages {
+		return false
+	}
+	return true
+}
+
+func metaChecksum(buf []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:metaSize])
+	return h.Sum64()
+}
@@ -0,0 +1,94 @@
+package btree
+
+import (
+	"context"
+	"lifs_go/kv"
+)
+
+// snapshot reads the tree of a committed transaction. Commits made
+// while it is open do not reuse the pages it may read.
+type snapshot struct {
+	db   *Impl
+	txid uint64
+	root pgid
+}
+
+func (db *Impl) Snapshot(ctx context.Context) (kv.Snapshot, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	db.snapshots[db.meta.txid]++
+	return &snapshot{db: db, txid: db.meta.txid, root: db.meta.root}, nil
+}
+
+func (s *snapshot) Get(ctx context.Context, key []byte) ([]byte, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.db.closed {
+		return nil, ErrClosed
+	}
+	v, ok, err := s.db.lookup(s.root, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, kv.NotFoundError{Key: key}
+	}
+	return v, nil
+}
+
+func (s *snapshot) Has(ctx context.Context, key []byte) (bool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.db.closed {
+		return false, ErrClosed
+	}
+	_, ok, err := s.db.lookup(s.root, key)
+	return ok, err
+}
+
+func (s *snapshot) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return s.db.iterate(ctx, r, fn, func() pgid { return s.root })
+}
+
+func (s *snapshot) Release() {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.snapshots[s.txid]--
+	if db.snapshots[s.txid] == 0 {
+		delete(db.snapshots, s.txid)
+	}
+	db.releasePending()
+}
+
+// releasePending frees the pages that no open snapshot can reach
+// anymore. Pages freed by transaction t were last referenced by
+// transaction t-1, so they are safe once every snapshot is at t or
+// later. Caller must hold db.mu.
+func (db *Impl) releasePending() {
+	oldest := ^uint64(0)
+	for txid := range db.snapshots {
+		if txid < oldest {
+			oldest = txid
+		}
+	}
+	for txid, ids := range db.pending {
+		if txid <= oldest {
+			db.free.release(ids...)
+			delete(db.pending, txid)
+		}
+	}
+}
+
+// pendingIDs lists all pages held back for snapshots. Caller must hold
+// db.mu.
+func (db *Impl) pendingIDs() []pgid {
+	var ids []pgid
+	for _, p := range db.pending {
+		ids = append(ids, p...)
+	}
+	return ids
+}
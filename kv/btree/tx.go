@@ -0,0 +1,256 @@
+package btree
+
+import (
+	"bytes"
+)
+
+// tx is a write transaction. Every node it modifies is copied to new
+// pages on commit, so the committed tree stays intact until the new
+// meta page is written.
+type tx struct {
+	db   *Impl
+	root *node
+	// pages of the committed tree that this tx no longer references
+	freed []pgid
+	dirty bool
+}
+
+func (db *Impl) begin() (*tx, error) {
+	t := &tx{db: db}
+	if db.meta.root == 0 {
+		t.root = &node{leaf: true}
+		return t, nil
+	}
+	root, err := db.readNode(db.meta.root)
+	if err != nil {
+		return nil, err
+	}
+	t.root = root
+	return t, nil
+}
+
+// touch marks n dirty, releasing the pages it was read from.
+func (t *tx) touch(n *node) {
+	if n.id == 0 {
+		return
+	}
+	for i := uint32(0); i <= n.overflow; i++ {
+		t.freed = append(t.freed, n.id+pgid(i))
+	}
+	n.id = 0
+	n.overflow = 0
+}
+
+func (t *tx) child(n *node, i int) (*node, error) {
+	in := &n.inodes[i]
+	if in.child == nil {
+		c, err := t.db.readNode(in.id)
+		if err != nil {
+			return nil, err
+		}
+		in.child = c
+	}
+	return in.child, nil
+}
+
+func (t *tx) put(key, value []byte) error {
+	t.dirty = true
+	if err := t.putNode(t.root, key, value); err != nil {
+		return err
+	}
+	for {
+		parts := t.root.split(t.db.pageSize)
+		if len(parts) == 1 {
+			return nil
+		}
+		root := &node{}
+		for _, p := range parts {
+			root.inodes = append(root.inodes, inode{key: p.inodes[0].key, child: p})
+		}
+		t.root = root
+	}
+}
+
+func (t *tx) putNode(n *node, key, value []byte) error {
+	t.touch(n)
+	if n.leaf {
+		i := n.search(key)
+		if i < len(n.inodes) && bytes.Equal(n.inodes[i].key, key) {
+			n.inodes[i].value = value
+			return nil
+		}
+		n.inodes = append(n.inodes, inode{})
+		copy(n.inodes[i+1:], n.inodes[i:])
+		n.inodes[i] = inode{key: key, value: value}
+		return nil
+	}
+
+	i := n.childIndex(key)
+	c, err := t.child(n, i)
+	if err != nil {
+		return err
+	}
+	if err := t.putNode(c, key, value); err != nil {
+		return err
+	}
+	t.replaceChild(n, i, c.split(t.db.pageSize))
+	return nil
+}
+
+func (t *tx) delete(key []byte) error {
+	found, err := t.deleteNode(t.root, key)
+	if err != nil || !found {
+		return err
+	}
+	t.dirty = true
+	// collapse branch roots with a single child
+	for !t.root.leaf && len(t.root.inodes) == 1 {
+		c, err := t.child(t.root, 0)
+		if err != nil {
+			return err
+		}
+		t.touch(t.root)
+		t.root = c
+	}
+	if !t.root.leaf && len(t.root.inodes) == 0 {
+		t.root = &node{leaf: true}
+	}
+	return nil
+}
+
+func (t *tx) deleteNode(n *node, key []byte) (bool, error) {
+	if n.leaf {
+		i := n.search(key)
+		if i == len(n.inodes) || !bytes.Equal(n.inodes[i].key, key) {
+			return false, nil
+		}
+		t.touch(n)
+		n.inodes = append(n.inodes[:i], n.inodes[i+1:]...)
+		return true, nil
+	}
+
+	i := n.childIndex(key)
+	c, err := t.child(n, i)
+	if err != nil {
+		return false, err
+	}
+	found, err := t.deleteNode(c, key)
+	if err != nil || !found {
+		return found, err
+	}
+	t.touch(n)
+	if len(c.inodes) == 0 {
+		t.replaceChild(n, i, nil)
+	} else {
+		t.replaceChild(n, i, []*node{c})
+	}
+	return true, nil
+}
+
+// replaceChild replaces the i-th child of n with parts, updating the
+// separator keys.
+func (t *tx) replaceChild(n *node, i int, parts []*node) {
+	repl := make([]inode, 0, len(parts))
+	for _, p := range parts {
+		repl = append(repl, inode{key: p.inodes[0].key, id: p.id, child: p})
+	}
+	tail := append(repl, n.inodes[i+1:]...)
+	n.inodes = append(n.inodes[:i], tail...)
+}
+
+// write stores all dirty nodes below and including n into newly
+// allocated pages.
+func (t *tx) write(n *node) error {
+	if n.id != 0 {
+		return nil
+	}
+	if !n.leaf {
+		for i := range n.inodes {
+			in := &n.inodes[i]
+			if in.child == nil {
+				continue
+			}
+			if err := t.write(in.child); err != nil {
+				return err
+			}
+			in.id = in.child.id
+		}
+	}
+	buf := n.encode(t.db.pageSize)
+	id, err := t.db.writePages(buf)
+	if err != nil {
+		return err
+	}
+	n.id = id
+	n.overflow = uint32(len(buf)/t.db.pageSize - 1)
+	return nil
+}
+
+// commit writes the dirty nodes and the freelist, then publishes
+// them with a new meta page. On error, the committed state is left
+// unchanged.
+func (t *tx) commit() (err error) {
+	db := t.db
+	saved := db.free.clone()
+	savedMeta := db.meta
+	defer func() {
+		if err != nil {
+			db.free = saved
+			db.meta = savedMeta
+		}
+	}()
+
+	next := db.meta
+	next.txid++
+
+	next.root = 0
+	if !t.root.leaf || len(t.root.inodes) > 0 {
+		if err := t.write(t.root); err != nil {
+			return err
+		}
+		next.root = t.root.id
+	}
+
+	// the old freelist is referenced by the old meta only
+	if db.meta.freelist != 0 {
+		ids, err := db.pageRun(db.meta.freelist)
+		if err != nil {
+			return err
+		}
+		t.freed = append(t.freed, ids...)
+	}
+
+	// pages released here become free only once the new meta is
+	// written, so they must not be allocated for the freelist itself.
+	// Pages pinned by snapshots are free as far as the file is
+	// concerned, since snapshots do not survive a restart.
+	pinned := db.pendingIDs()
+	count := freelistPages(len(db.free.ids)+len(pinned)+len(t.freed), db.pageSize)
+	flID := db.allocate(count)
+	ids := append(append(append([]pgid(nil), db.free.ids...), pinned...), t.freed...)
+	buf := encodeFreelist(ids, count, db.pageSize)
+	if err := db.writeAt(buf, flID); err != nil {
+		return err
+	}
+	next.freelist = flID
+	// allocate may have grown the file
+	next.pages = db.meta.pages
+
+	if err := db.sync(); err != nil {
+		return err
+	}
+	if err := db.writeMeta(&next); err != nil {
+		return err
+	}
+	if err := db.sync(); err != nil {
+		return err
+	}
+
+	db.meta = next
+	if len(db.snapshots) == 0 {
+		db.free.release(t.freed...)
+	} else {
+		db.pending[next.txid] = t.freed
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec identifies a compression algorithm. It is stored in the
+// header of every compressed value, so the values must never change.
+type Codec byte
+
+const (
+	// Raw stores values uncompressed.
+	Raw Codec = 0
+	// Flate is DEFLATE at the default level.
+	Flate Codec = 1
+	// Gzip is DEFLATE in a gzip stream, with its own CRC.
+	Gzip Codec = 2
+	// LZ is a fast LZ77 codec in the style of LZ4, for when speed
+	// matters more than ratio.
+	LZ Codec = 3
+)
+
+func (c Codec) String() string {
+	switch c {
+	case Raw:
+		return "raw"
+	case Flate:
+		return "flate"
+	case Gzip:
+		return "gzip"
+	case LZ:
+		return "lz"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// ParseCodec returns the Codec named by String.
+func ParseCodec(name string) (Codec, error) {
+	for _, c := range []Codec{Raw, Flate, Gzip, LZ} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return 0, UnknownCodecError{Name: name}
+}
+
+var (
+	flateWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	gzipWriters = sync.Pool{New: func() any {
+		return gzip.NewWriter(nil)
+	}}
+)
+
+// encode appends the compressed form of src to dst.
+func (c Codec) encode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	switch c {
+	case Raw:
+		return append(dst, src...), nil
+	case LZ:
+		return lzEncode(dst, src), nil
+	case Flate:
+		w := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(w)
+		w.Reset(buf)
+		if _, err := w.Write(src); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case Gzip:
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(buf)
+		if _, err := w.Write(src); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, UnknownCodecError{Name: c.String()}
+	}
+	return buf.Bytes(), nil
+}
+
+func (c Codec) decode(src []byte, max int) ([]byte, error) {
+	switch c {
+	case Raw:
+		return src, nil
+	case LZ:
+		return lzDecode(src, max)
+	case Flate:
+		return readAll(flate.NewReader(bytes.NewReader(src)), max)
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		return readAll(r, max)
+	}
+	return nil, UnknownCodecError{Name: c.String()}
+}
+
+var errTooLarge = errors.New("decompresses to more than the maximum value size")
+
+// readAll reads r to the end, failing if it holds more than max bytes.
+func readAll(r io.Reader, max int) ([]byte, error) {
+	dst, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(dst) > max {
+		return nil, errTooLarge
+	}
+	return dst, nil
+}
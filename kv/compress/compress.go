@@ -0,0 +1,223 @@
+// Package compress is a kv.IF that compresses the values of another
+// kv.IF.
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/kv"
+)
+
+// Every value a store written by this package holds starts with magic
+// followed by the Codec byte. Such stores are marked by MarkerKey.
+//
+// A store that already holds values but no marker was written without
+// this package. Its values are passed through as they are, and new
+// values can only be stored Raw, as there is no telling a header
+// from data, until Migrate gives every value a header and marks it.
+var magic = []byte{0xf5, 0x7a}
+
+const headerSize = 3
+
+// MarkerKey marks a store whose values all have a header. Its zero
+// byte sorts it before most keys, and it is shorter than any chunk
+// key. Iterate never lists it.
+var MarkerKey = []byte("\x00lifs-compress")
+
+// Options tunes the compression. Zero fields take their defaults.
+type Options struct {
+	// Codec compresses new values. Raw stores new values
+	// uncompressed, while still reading compressed ones.
+	Codec Codec
+	// MinSize is the size below which values are stored raw, as they
+	// rarely shrink. Defaults to 64 bytes.
+	MinSize int
+	// MaxValueSize bounds the size of a decompressed value, so a
+	// corrupt or hostile value cannot take unbounded memory; larger
+	// ones are reported as corrupt. Defaults to 256 MiB.
+	MaxValueSize int
+}
+
+func (o *Options) withDefaults() Options {
+	opts := *o
+	if opts.MinSize <= 0 {
+		opts.MinSize = 64
+	}
+	if opts.MaxValueSize <= 0 {
+		opts.MaxValueSize = 256 << 20
+	}
+	return opts
+}
+
+// Impl compresses values on the way into the wrapped kv.IF and
+// decompresses them on the way out. Keys are stored unchanged, so
+// Has passes straight through.
+type Impl struct {
+	inner kv.IF
+	opts  Options
+	// legacy is set for a store written without this package
+	legacy bool
+}
+
+func (c *Impl) encode(value []byte) ([]byte, error) {
+	if c.legacy {
+		return value, nil
+	}
+	if c.opts.Codec != Raw && len(value) >= c.opts.MinSize {
+		dst := append(append(make([]byte, 0, headerSize+len(value)/2), magic...), byte(c.opts.Codec))
+		enc, err := c.opts.Codec.encode(dst, value)
+		if err != nil {
+			return nil, err
+		}
+		// fall back to raw when compression does not pay for its
+		// header
+		if len(enc) < headerSize+len(value) {
+			return enc, nil
+		}
+	}
+	return append(append(append(make([]byte, 0, headerSize+len(value)), magic...), byte(Raw)), value...), nil
+}
+
+// decode returns the original form of the value stored at key.
+func (c *Impl) decode(key, value []byte) ([]byte, error) {
+	if c.legacy {
+		return value, nil
+	}
+	if len(value) < headerSize || !bytes.HasPrefix(value, magic) {
+		return nil, CorruptValueError{Key: key, Reason: "no header"}
+	}
+	dec, err := Codec(value[2]).decode(value[headerSize:], c.opts.MaxValueSize)
+	if err != nil {
+		return nil, CorruptValueError{Key: key, Reason: err.Error()}
+	}
+	return dec, nil
+}
+
+func (c *Impl) get(ctx context.Context, r kv.Reader, key []byte) ([]byte, error) {
+	value, err := r.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode(key, value)
+}
+
+// iterate lists the keys of r, without MarkerKey and migrateKey.
+func iterate(ctx context.Context, r kv.Reader, rg kv.Range, fn func(key []byte) error) error {
+	return r.Iterate(ctx, rg, func(key []byte) error {
+		if bytes.Equal(key, MarkerKey) || bytes.Equal(key, migrateKey) {
+			return nil
+		}
+		return fn(key)
+	})
+}
+
+func (c *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return c.get(ctx, c.inner, key)
+}
+
+func (c *Impl) Put(ctx context.Context, key, value []byte) error {
+	enc, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+	return c.inner.Put(ctx, key, enc)
+}
+
+func (c *Impl) Delete(ctx context.Context, key []byte) error {
+	return c.inner.Delete(ctx, key)
+}
+
+func (c *Impl) Has(ctx context.Context, key []byte) (bool, error) {
+	return c.inner.Has(ctx, key)
+}
+
+func (c *Impl) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return iterate(ctx, c.inner, r, fn)
+}
+
+func (c *Impl) Write(ctx context.Context, b *kv.Batch) error {
+	var enc kv.Batch
+	for _, op := range b.Ops() {
+		if op.Delete {
+			enc.Delete(op.Key)
+			continue
+		}
+		value, err := c.encode(op.Value)
+		if err != nil {
+			return err
+		}
+		enc.Put(op.Key, value)
+	}
+	return c.inner.Write(ctx, &enc)
+}
+
+type snapshot struct {
+	kv.Snapshot
+	c *Impl
+}
+
+func (s snapshot) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return s.c.get(ctx, s.Snapshot, key)
+}
+
+func (s snapshot) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return iterate(ctx, s.Snapshot, r, fn)
+}
+
+func (c *Impl) Snapshot(ctx context.Context) (kv.Snapshot, error) {
+	s, err := c.inner.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot{s, c}, nil
+}
+
+// Wrap returns a kv.IF that compresses the values stored in inner. An
+// empty inner is marked as written by this package; a store holding
+// values but no marker is legacy, see MarkerKey, and only accepts the
+// Raw codec until it is migrated. A store whose migration was
+// interrupted fails with a MigrationError.
+func Wrap(ctx context.Context, inner kv.IF, opts *Options) (*Impl, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	o := opts.withDefaults()
+	if _, err := ParseCodec(o.Codec.String()); err != nil {
+		return nil, err
+	}
+	c := &Impl{inner: inner, opts: o}
+	marked, err := inner.Has(ctx, MarkerKey)
+	if err != nil {
+		return nil, err
+	}
+	if marked {
+		return c, nil
+	}
+	migrating, err := inner.Has(ctx, migrateKey)
+	if err != nil {
+		return nil, err
+	}
+	if migrating {
+		return nil, MigrationError{}
+	}
+	empty := true
+	err = inner.Iterate(ctx, kv.All, func(key []byte) error {
+		empty = false
+		return errNotEmpty
+	})
+	if err != nil && !errors.Is(err, errNotEmpty) {
+		return nil, err
+	}
+	if !empty {
+		if o.Codec != Raw {
+			return nil, LegacyStoreError{Codec: o.Codec}
+		}
+		c.legacy = true
+		return c, nil
+	}
+	if err := inner.Put(ctx, MarkerKey, []byte{}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
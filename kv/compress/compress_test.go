@@ -0,0 +1,318 @@
+package compress_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"lifs_go/kv"
+	"lifs_go/kv/compress"
+	"lifs_go/kv/kvtest"
+	kvmem "lifs_go/kv/mem"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var codecs = []compress.Codec{compress.Raw, compress.Flate, compress.Gzip, compress.LZ}
+
+func wrap(t *testing.T, inner kv.IF, codec compress.Codec) *compress.Impl {
+	t.Helper()
+	c, err := compress.Wrap(context.Background(), inner, &compress.Options{Codec: codec})
+	if err != nil {
+		t.Fatalf("Wrap fail: %v", err)
+	}
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			kvtest.Run(t, func(t *testing.T) kv.IF {
+				return wrap(t, kvmem.New(), codec)
+			})
+		})
+	}
+}
+
+func testValues() map[string][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 100*1024)
+	rnd.Read(random)
+	var source strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&source, "func f%d(x int) int {\n\treturn x * %d\n}\n", i, rnd.Intn(100))
+	}
+	mixed := append(bytes.Repeat([]byte("abc"), 1000), random[:5000]...)
+	return map[string][]byte{
+		"empty":  {},
+		"short":  []byte("short"),
+		"run":    bytes.Repeat([]byte{'x'}, 70000),
+		"source": []byte(source.String()),
+		"random": random,
+		"mixed":  append(mixed, mixed...),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, codec := range codecs {
+		inner := kvmem.New()
+		target := wrap(t, inner, codec)
+		for name, value := range testValues() {
+			if err := target.Put(ctx, []byte(name), value); err != nil {
+				t.Fatalf("Put fail: %v", err)
+			}
+			got, err := target.Get(ctx, []byte(name))
+			if err != nil {
+				t.Fatalf("Get fail: %v", err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Errorf("%s: %s did not round trip", codec, name)
+			}
+			stored, err := inner.Get(ctx, []byte(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) > len(value)+3 {
+				t.Errorf("%s: %s grew too much: %d > %d", codec, name, len(stored), len(value))
+			}
+			if codec != compress.Raw && name == "source" && len(stored) > len(value)/3 {
+				t.Errorf("%s: source compressed badly: %d of %d", codec, len(stored), len(value))
+			}
+		}
+	}
+}
+
+func TestIncompressibleStoredRaw(t *testing.T) {
+	ctx := context.Background()
+	inner := kvmem.New()
+	target := wrap(t, inner, compress.Flate)
+	value := testValues()["random"]
+	if err := target.Put(ctx, []byte("key"), value); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	stored, err := inner.Get(ctx, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored[3:], value) || stored[2] != byte(compress.Raw) {
+		t.Errorf("incompressible value should be stored raw")
+	}
+}
+
+func TestLegacyData(t *testing.T) {
+	ctx := context.Background()
+	inner := kvmem.New()
+	values := map[string][]byte{
+		"plain":     []byte("stored before compression"),
+		"magic":     []byte("\xf5\x7a\x03 not really compressed"),
+		"raw magic": []byte("\xf5\x7a\x00 looks like a raw header"),
+		"bad codec": []byte("\xf5\x7a\xee"),
+		"truncated": []byte("\xf5\x7a"),
+	}
+	for k, v := range values {
+		if err := inner.Put(ctx, []byte(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var le compress.LegacyStoreError
+	if _, err := compress.Wrap(ctx, inner, &compress.Options{Codec: compress.LZ}); !errors.As(err, &le) {
+		t.Fatalf("compressing a legacy store did not fail: %v", err)
+	}
+	target, err := compress.Wrap(ctx, inner, nil)
+	if err != nil {
+		t.Fatalf("Wrap fail: %v", err)
+	}
+	values["new"] = []byte("\xf5\x7a\x00 written after wrapping")
+	if err := target.Put(ctx, []byte("new"), values["new"]); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	for k, v := range values {
+		got, err := target.Get(ctx, []byte(k))
+		if err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+		if !bytes.Equal(got, v) {
+			t.Errorf("legacy value %s changed: %q != %q", k, got, v)
+		}
+	}
+	if ok, err := inner.Has(ctx, compress.MarkerKey); err != nil || ok {
+		t.Errorf("legacy store was marked: %v, %v", ok, err)
+	}
+}
+
+func TestMarker(t *testing.T) {
+	ctx := context.Background()
+	inner := kvmem.New()
+	target := wrap(t, inner, compress.LZ)
+	if ok, err := inner.Has(ctx, compress.MarkerKey); err != nil || !ok {
+		t.Fatalf("new store was not marked: %v, %v", ok, err)
+	}
+	if err := target.Put(ctx, []byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	// reopened with another codec, the store is still read with
+	// headers
+	target = wrap(t, inner, compress.Raw)
+	got, err := target.Get(ctx, []byte("key"))
+	if err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	if g, e := string(got), "value"; g != e {
+		t.Errorf("Get gave wrong content: %q != %q", g, e)
+	}
+
+	// a value without a header is corrupt, not data
+	if err := inner.Put(ctx, []byte("bad"), []byte("no header")); err != nil {
+		t.Fatal(err)
+	}
+	var ce compress.CorruptValueError
+	if _, err := target.Get(ctx, []byte("bad")); !errors.As(err, &ce) {
+		t.Errorf("value without header did not fail: %v", err)
+	}
+}
+
+func TestMagicPrefix(t *testing.T) {
+	ctx := context.Background()
+	target := wrap(t, kvmem.New(), compress.LZ)
+	value := []byte("\xf5\x7a\x00raw value with the magic")
+	if err := target.Put(ctx, []byte("key"), value); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	got, err := target.Get(ctx, []byte("key"))
+	if err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("value with magic did not round trip: %q != %q", got, value)
+	}
+}
+
+// TestCorruptLZ flips bytes of compressed values; decoding must never
+// panic, and errors must be reported as corrupt values.
+func TestCorruptLZ(t *testing.T) {
+	ctx := context.Background()
+	inner := kvmem.New()
+	target := wrap(t, inner, compress.LZ)
+	value := testValues()["mixed"]
+	if err := target.Put(ctx, []byte("key"), value); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := inner.Get(ctx, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 1000; i++ {
+		bad := append([]byte(nil), stored...)
+		for j := 0; j < 1+rnd.Intn(4); j++ {
+			bad[3+rnd.Intn(len(bad)-3)] = byte(rnd.Intn(256))
+		}
+		bad = bad[:3+rnd.Intn(len(bad)-3)]
+		if err := inner.Put(ctx, []byte("key"), bad); err != nil {
+			t.Fatal(err)
+		}
+		// LZ has no checksum, so not every corruption is detected
+		var ce compress.CorruptValueError
+		if _, err := target.Get(ctx, []byte("key")); err != nil && !errors.As(err, &ce) {
+			t.Fatalf("Get fail: %v", err)
+		}
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for _, codec := range codecs {
+		c, err := compress.ParseCodec(codec.String())
+		if err != nil {
+			t.Fatalf("ParseCodec fail: %v", err)
+		}
+		if g, e := c, codec; g != e {
+			t.Errorf("ParseCodec gave wrong codec: %v != %v", g, e)
+		}
+	}
+	if _, err := compress.ParseCodec("zip"); err == nil {
+		t.Errorf("ParseCodec should fail on unknown codec")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	inner := kvmem.New()
+	values := map[string][]byte{
+		"a": []byte("\xf5\x7a\x03 not really compressed"),
+		"b": []byte("stored before compression"),
+		"c": []byte(strings.Repeat("compressible ", 100)),
+	}
+	for k, v := range values {
+		if err := inner.Put(ctx, []byte(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// as left by a Migrate interrupted after "a"
+	if err := inner.Put(ctx, []byte("a"), append([]byte("\xf5\x7a\x00"), values["a"]...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Put(ctx, []byte("\x00lifs-compress-migrate"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	var me compress.MigrationError
+	if _, err := compress.Wrap(ctx, inner, nil); !errors.As(err, &me) {
+		t.Fatalf("half migrated store did not fail: %v", err)
+	}
+
+	n, err := compress.Migrate(ctx, inner)
+	if err != nil {
+		t.Fatalf("Migrate fail: %v", err)
+	}
+	if g, e := n, 2; g != e {
+		t.Errorf("Migrate rewrote wrong number of values: %d != %d", g, e)
+	}
+	target := wrap(t, inner, compress.LZ)
+	values["new"] = []byte(strings.Repeat("written compressed ", 100))
+	if err := target.Put(ctx, []byte("new"), values["new"]); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	for k, v := range values {
+		got, err := target.Get(ctx, []byte(k))
+		if err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+		if !bytes.Equal(got, v) {
+			t.Errorf("value %s changed: %q != %q", k, got, v)
+		}
+	}
+	var keys []string
+	if err := target.Iterate(ctx, kv.All, func(key []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}); err != nil {
+		t.Fatalf("Iterate fail: %v", err)
+	}
+	if g, e := len(keys), len(values); g != e {
+		t.Errorf("wrong keys after Migrate: %q", keys)
+	}
+	if n, err := compress.Migrate(ctx, inner); err != nil || n != 0 {
+		t.Errorf("second Migrate should do nothing: %d, %v", n, err)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	ctx := context.Background()
+	value := make([]byte, 10000)
+	for _, codec := range codecs[1:] {
+		inner := kvmem.New()
+		target := wrap(t, inner, codec)
+		if err := target.Put(ctx, []byte("key"), value); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+		small, err := compress.Wrap(ctx, inner, &compress.Options{Codec: codec, MaxValueSize: 1000})
+		if err != nil {
+			t.Fatalf("Wrap fail: %v", err)
+		}
+		var ce compress.CorruptValueError
+		if _, err := small.Get(ctx, []byte("key")); !errors.As(err, &ce) {
+			t.Errorf("%v: value above the maximum size did not fail: %v", codec, err)
+		}
+	}
+}
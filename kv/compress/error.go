@@ -0,0 +1,56 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errNotEmpty stops the Iterate of Wrap at the first key.
+var errNotEmpty = errors.New("store is not empty")
+
+// UnknownCodecError is returned when asked for a codec that does not
+// exist.
+type UnknownCodecError struct {
+	Name string
+}
+
+var _ error = UnknownCodecError{}
+
+func (u UnknownCodecError) Error() string {
+	return fmt.Sprintf("[ErrCompress] unknown codec: %s", u.Name)
+}
+
+// CorruptValueError is returned for a stored value that has no valid
+// header or does not decode.
+type CorruptValueError struct {
+	Key    []byte
+	Reason string
+}
+
+var _ error = CorruptValueError{}
+
+func (c CorruptValueError) Error() string {
+	return fmt.Sprintf("[ErrCompress] corrupt value of %x: %s", c.Key, c.Reason)
+}
+
+// LegacyStoreError is returned when asked to compress the values of a
+// store written without this package.
+type LegacyStoreError struct {
+	Codec Codec
+}
+
+var _ error = LegacyStoreError{}
+
+func (l LegacyStoreError) Error() string {
+	return fmt.Sprintf("[ErrCompress] store holds values written without compression, cannot use codec %s before it is migrated", l.Codec)
+}
+
+// MigrationError is returned when wrapping a store whose Migrate was
+// interrupted: some of its values have a header and some do not.
+type MigrationError struct{}
+
+var _ error = MigrationError{}
+
+func (MigrationError) Error() string {
+	return "[ErrCompress] store migration was interrupted, run it again"
+}
@@ -0,0 +1,172 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The lz codec is a byte oriented LZ77 in the style of LZ4: much
+// faster than flate at a somewhat worse ratio. A block is the uvarint
+// length of the decoded data followed by sequences of
+//
+//	token | [literal length] | literals | offset | [match length]
+//
+// The high nibble of the token is the literal length and the low one
+// the match length minus lzMinMatch; a nibble of 15 is continued by
+// bytes that are added up to the first one below 255. The offset is
+// two bytes little endian. The last sequence stops after its literals.
+const (
+	lzMinMatch  = 4
+	lzHashBits  = 14
+	lzMaxOffset = 1<<16 - 1
+	// lengths beyond this can only come from corrupt input
+	lzMaxLen = 1 << 30
+)
+
+var errLZCorrupt = errors.New("corrupt lz block")
+
+func lzHash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - lzHashBits)
+}
+
+func lzAppendLen(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+// lzAppendSequence appends literals lit followed by a match of length
+// n at distance off. An off of 0 ends the block.
+func lzAppendSequence(dst, lit []byte, off, n int) []byte {
+	var token byte
+	l, m := len(lit), n-lzMinMatch
+	token = byte(min(l, 15)) << 4
+	if off > 0 {
+		token |= byte(min(m, 15))
+	}
+	dst = append(dst, token)
+	if l >= 15 {
+		dst = lzAppendLen(dst, l-15)
+	}
+	dst = append(dst, lit...)
+	if off > 0 {
+		dst = append(dst, byte(off), byte(off>>8))
+		if m >= 15 {
+			dst = lzAppendLen(dst, m-15)
+		}
+	}
+	return dst
+}
+
+// lzEncode appends the compressed form of src to dst.
+func lzEncode(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	// positions plus one, so the zero value means empty
+	var table [1 << lzHashBits]int32
+	anchor, i := 0, 0
+	for i+lzMinMatch <= len(src) {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := lzHash(v)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > lzMaxOffset || binary.LittleEndian.Uint32(src[cand:]) != v {
+			// skip faster through data that does not compress
+			i += 1 + (i-anchor)>>6
+			continue
+		}
+		n := lzMinMatch
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		dst = lzAppendSequence(dst, src[anchor:i], i-cand, n)
+		i += n
+		anchor = i
+	}
+	return lzAppendSequence(dst, src[anchor:], 0, 0)
+}
+
+func lzReadLen(src []byte, n int) (int, []byte, error) {
+	for {
+		if len(src) == 0 || n > lzMaxLen {
+			return 0, nil, errLZCorrupt
+		}
+		b := src[0]
+		src = src[1:]
+		n += int(b)
+		if b != 255 {
+			return n, src, nil
+		}
+	}
+}
+
+// lzDecode returns the data of the block src. It never panics on
+// corrupt input.
+func lzDecode(src []byte, max int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errLZCorrupt
+	}
+	if size > uint64(max) {
+		return nil, errTooLarge
+	}
+	src = src[n:]
+	// a continuation byte adds at most 255 bytes of output
+	if size > uint64(len(src))*255+64 {
+		return nil, errLZCorrupt
+	}
+	dst := make([]byte, 0, size)
+	var err error
+	for {
+		if len(src) == 0 {
+			return nil, errLZCorrupt
+		}
+		token := src[0]
+		src = src[1:]
+
+		l := int(token >> 4)
+		if l == 15 {
+			if l, src, err = lzReadLen(src, l); err != nil {
+				return nil, err
+			}
+		}
+		if l > len(src) || uint64(len(dst)+l) > size {
+			return nil, errLZCorrupt
+		}
+		dst = append(dst, src[:l]...)
+		src = src[l:]
+		if len(src) == 0 {
+			break
+		}
+
+		if len(src) < 2 {
+			return nil, errLZCorrupt
+		}
+		off := int(src[0]) | int(src[1])<<8
+		src = src[2:]
+		m := int(token & 0x0f)
+		if m == 15 {
+			if m, src, err = lzReadLen(src, m); err != nil {
+				return nil, err
+			}
+		}
+		m += lzMinMatch
+		if off == 0 || off > len(dst) || uint64(len(dst)+m) > size {
+			return nil, errLZCorrupt
+		}
+		start := len(dst) - off
+		if off >= m {
+			dst = append(dst, dst[start:start+m]...)
+			continue
+		}
+		// overlapping match, repeats the last off bytes
+		for k := 0; k < m; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, errLZCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,87 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"lifs_go/kv"
+)
+
+// migrateKey holds the last key Migrate gave a header to, while it
+// runs. Keys sort after it up to the next one, so an interrupted
+// migration resumes where it stopped.
+var migrateKey = []byte("\x00lifs-compress-migrate")
+
+// migrateBatch is the number of values given a header at once.
+const migrateBatch = 256
+
+// Migrate gives every value of a legacy store a Raw header and marks
+// the store, so that any codec can be used for new values; see
+// MarkerKey. It returns how many values it rewrote. A store that is
+// already marked is left alone.
+//
+// Values written meanwhile would be left without a header, so nothing
+// else may write to inner while Migrate runs. An interrupted Migrate
+// is finished by running it again; until then Wrap refuses the store.
+func Migrate(ctx context.Context, inner kv.IF) (int, error) {
+	marked, err := inner.Has(ctx, MarkerKey)
+	if err != nil || marked {
+		return 0, err
+	}
+	rg := kv.All
+	last, err := inner.Get(ctx, migrateKey)
+	if err == nil {
+		// resume after the last key done
+		rg.Start = append(last, 0)
+	} else if !isNotFound(err) {
+		return 0, err
+	}
+
+	n := 0
+	var b kv.Batch
+	var done []byte
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+		b.Put(migrateKey, done)
+		if err := inner.Write(ctx, &b); err != nil {
+			return err
+		}
+		b.Reset()
+		return nil
+	}
+	err = inner.Iterate(ctx, rg, func(key []byte) error {
+		if bytes.Equal(key, migrateKey) {
+			return nil
+		}
+		value, err := inner.Get(ctx, key)
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		done = append([]byte(nil), key...)
+		b.Put(done, append(append(append(make([]byte, 0, headerSize+len(value)), magic...), byte(Raw)), value...))
+		n++
+		if b.Len() >= migrateBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return n, err
+	}
+	b.Put(MarkerKey, []byte{})
+	b.Delete(migrateKey)
+	return n, inner.Write(ctx, &b)
+}
+
+func isNotFound(err error) bool {
+	var nf kv.NotFoundError
+	return errors.As(err, &nf)
+}
@@ -0,0 +1,236 @@
+// Package crypt is a kv.IF that encrypts the values, and optionally
+// the keys, of another kv.IF.
+package crypt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"lifs_go/kv"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// A sealed value is
+//
+//	version | key id | nonce | ciphertext
+//
+// sealed with XChaCha20-Poly1305 under the data key id. Its 24 byte
+// nonces are random, which is safe for any practical number of
+// values. The header and the key are authenticated as well, so values
+// cannot be swapped between keys unnoticed.
+const (
+	sealVersion    byte = 1
+	sealHeaderSize      = 1 + 4
+	sealNonceAt         = sealHeaderSize
+	sealDataAt          = sealNonceAt + chacha20poly1305.NonceSizeX
+)
+
+// Options tunes the encryption.
+type Options struct {
+	// HideKeys encrypts the keys as well, so the inner store does not
+	// learn them: for the cas those are content hashes, which would
+	// tell whether a known file is stored. Iterate then has to read
+	// and sort every key of the inner store.
+	HideKeys bool
+}
+
+// Impl seals values on the way into the wrapped kv.IF and opens them
+// on the way out.
+type Impl struct {
+	inner kv.IF
+	keys  *Keyring
+	// nil unless keys are hidden
+	names *names
+
+	// Rotate holds mu exclusively while re-encrypting a value, so it
+	// never overwrites a newer one; writers hold it shared.
+	mu sync.RWMutex
+}
+
+func (c *Impl) storedKey(key []byte) []byte {
+	if c.names == nil {
+		return key
+	}
+	return c.names.hide(key)
+}
+
+func additionalData(header, key []byte) []byte {
+	return append(append(make([]byte, 0, len(header)+len(key)), header...), key...)
+}
+
+func (c *Impl) seal(key, value []byte) ([]byte, error) {
+	aead, id, err := c.keys.aead(0)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, sealDataAt, sealDataAt+len(value)+aead.Overhead())
+	out[0] = sealVersion
+	binary.BigEndian.PutUint32(out[1:sealHeaderSize], id)
+	if _, err := rand.Read(out[sealNonceAt:sealDataAt]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[sealNonceAt:sealDataAt], value, additionalData(out[:sealHeaderSize], key)), nil
+}
+
+// sealedWith returns the key id a value was sealed with.
+func sealedWith(key, sealed []byte) (uint32, error) {
+	if len(sealed) < sealDataAt || sealed[0] != sealVersion {
+		return 0, DecryptError{Key: key}
+	}
+	return binary.BigEndian.Uint32(sealed[1:sealHeaderSize]), nil
+}
+
+func (c *Impl) open(key, sealed []byte) ([]byte, error) {
+	id, err := sealedWith(key, sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, _, err := c.keys.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	value, err := aead.Open(nil, sealed[sealNonceAt:sealDataAt], sealed[sealDataAt:], additionalData(sealed[:sealHeaderSize], key))
+	if err != nil {
+		return nil, DecryptError{Key: key}
+	}
+	return value, nil
+}
+
+func (c *Impl) get(ctx context.Context, r kv.Reader, key []byte) ([]byte, error) {
+	sealed, err := r.Get(ctx, c.storedKey(key))
+	if err != nil {
+		var nf kv.NotFoundError
+		if errors.As(err, &nf) {
+			return nil, kv.NotFoundError{Key: key}
+		}
+		return nil, err
+	}
+	return c.open(key, sealed)
+}
+
+func (c *Impl) iterate(ctx context.Context, r kv.Reader, rng kv.Range, fn func(key []byte) error) error {
+	if c.names == nil {
+		return r.Iterate(ctx, rng, fn)
+	}
+	// stored keys are in no useful order, so collect and sort them
+	var keys []string
+	err := r.Iterate(ctx, kv.All, func(stored []byte) error {
+		key, err := c.names.reveal(stored)
+		if err != nil {
+			return err
+		}
+		if rng.Contains(key) {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return c.get(ctx, c.inner, key)
+}
+
+func (c *Impl) Put(ctx context.Context, key, value []byte) error {
+	sealed, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.inner.Put(ctx, c.storedKey(key), sealed)
+}
+
+func (c *Impl) Delete(ctx context.Context, key []byte) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.inner.Delete(ctx, c.storedKey(key))
+}
+
+func (c *Impl) Has(ctx context.Context, key []byte) (bool, error) {
+	return c.inner.Has(ctx, c.storedKey(key))
+}
+
+func (c *Impl) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return c.iterate(ctx, c.inner, r, fn)
+}
+
+func (c *Impl) Write(ctx context.Context, b *kv.Batch) error {
+	var sealed kv.Batch
+	for _, op := range b.Ops() {
+		if op.Delete {
+			sealed.Delete(c.storedKey(op.Key))
+			continue
+		}
+		value, err := c.seal(op.Key, op.Value)
+		if err != nil {
+			return err
+		}
+		sealed.Put(c.storedKey(op.Key), value)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.inner.Write(ctx, &sealed)
+}
+
+type snapshot struct {
+	c *Impl
+	s kv.Snapshot
+}
+
+func (s snapshot) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return s.c.get(ctx, s.s, key)
+}
+
+func (s snapshot) Has(ctx context.Context, key []byte) (bool, error) {
+	return s.s.Has(ctx, s.c.storedKey(key))
+}
+
+func (s snapshot) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return s.c.iterate(ctx, s.s, r, fn)
+}
+
+func (s snapshot) Release() {
+	s.s.Release()
+}
+
+func (c *Impl) Snapshot(ctx context.Context) (kv.Snapshot, error) {
+	s, err := c.inner.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot{c: c, s: s}, nil
+}
+
+// Wrap returns a kv.IF that encrypts what it stores in inner with the
+// keys of keys.
+func Wrap(inner kv.IF, keys *Keyring, opts *Options) (*Impl, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	c := &Impl{inner: inner, keys: keys}
+	if opts.HideKeys {
+		n, err := newNames(keys.names)
+		if err != nil {
+			return nil, err
+		}
+		c.names = n
+	}
+	return c, nil
+}
@@ -0,0 +1,190 @@
+package crypt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"lifs_go/kv"
+	"lifs_go/kv/crypt"
+	"lifs_go/kv/file"
+	"lifs_go/kv/kvtest"
+	kvmem "lifs_go/kv/mem"
+	"path/filepath"
+	"testing"
+)
+
+func newKeyring(t *testing.T) *crypt.Keyring {
+	t.Helper()
+	keys, err := crypt.NewKeyring()
+	if err != nil {
+		t.Fatalf("NewKeyring fail: %v", err)
+	}
+	return keys
+}
+
+func wrap(t *testing.T, inner kv.IF, keys *crypt.Keyring, hide bool) *crypt.Impl {
+	t.Helper()
+	c, err := crypt.Wrap(inner, keys, &crypt.Options{HideKeys: hide})
+	if err != nil {
+		t.Fatalf("Wrap fail: %v", err)
+	}
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		return wrap(t, kvmem.New(), newKeyring(t), false)
+	})
+}
+
+func TestConformanceHideKeys(t *testing.T) {
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		return wrap(t, kvmem.New(), newKeyring(t), true)
+	})
+}
+
+func TestNothingLeaks(t *testing.T) {
+	ctx := context.Background()
+	inner := kvmem.New()
+	target := wrap(t, inner, newKeyring(t), true)
+	key, value := []byte("secret key"), []byte("secret value")
+	if err := target.Put(ctx, key, value); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	err := inner.Iterate(ctx, kv.All, func(stored []byte) error {
+		if bytes.Contains(stored, key) {
+			t.Errorf("stored key contains the key: %q", stored)
+		}
+		sealed, err := inner.Get(ctx, stored)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(sealed, value) {
+			t.Errorf("stored value contains the value: %q", sealed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := target.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("Get gave wrong content: %q != %q", got, value)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	ctx := context.Background()
+	inner := kvmem.New()
+	target := wrap(t, inner, newKeyring(t), false)
+	for _, k := range []string{"a", "b"} {
+		if err := target.Put(ctx, []byte(k), []byte("value of "+k)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+
+	// a value moved to another key
+	sealed, err := inner.Get(ctx, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Put(ctx, []byte("b"), sealed); err != nil {
+		t.Fatal(err)
+	}
+	var d crypt.DecryptError
+	if _, err := target.Get(ctx, []byte("b")); !errors.As(err, &d) {
+		t.Errorf("swapped value should be DecryptError: %T: %v", err, err)
+	}
+
+	// a flipped bit
+	sealed = append([]byte(nil), sealed...)
+	sealed[len(sealed)-1] ^= 1
+	if err := inner.Put(ctx, []byte("a"), sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Get(ctx, []byte("a")); !errors.As(err, &d) {
+		t.Errorf("tampered value should be DecryptError: %T: %v", err, err)
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	ctx := context.Background()
+	for _, kdf := range []*crypt.KDF{
+		{Algorithm: crypt.KDFScrypt, N: 1 << 10},
+		{Algorithm: crypt.KDFArgon2id, Memory: 1024},
+	} {
+		path := filepath.Join(t.TempDir(), "keys")
+		keys := newKeyring(t)
+		inner := kvmem.New()
+		if err := wrap(t, inner, keys, true).Put(ctx, []byte("key"), []byte("value")); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+		if err := crypt.SaveKeyFile(path, keys, []byte("passphrase"), kdf); err != nil {
+			t.Fatalf("SaveKeyFile %s fail: %v", kdf.Algorithm, err)
+		}
+
+		if _, err := crypt.LoadKeyFile(path, []byte("wrong")); !errors.Is(err, crypt.ErrPassphrase) {
+			t.Errorf("LoadKeyFile with wrong passphrase should fail: %v", err)
+		}
+		loaded, err := crypt.LoadKeyFile(path, []byte("passphrase"))
+		if err != nil {
+			t.Fatalf("LoadKeyFile %s fail: %v", kdf.Algorithm, err)
+		}
+		got, err := wrap(t, inner, loaded, true).Get(ctx, []byte("key"))
+		if err != nil {
+			t.Fatalf("Get with loaded keys fail: %v", err)
+		}
+		if g, e := string(got), "value"; g != e {
+			t.Errorf("Get gave wrong content: %q != %q", g, e)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ctx := context.Background()
+	for _, hide := range []bool{false, true} {
+		keys := newKeyring(t)
+		target := wrap(t, file.New(t.TempDir()), keys, hide)
+		const N = 20
+		for i := 0; i < N; i++ {
+			if err := target.Put(ctx, []byte(fmt.Sprint(i)), []byte(fmt.Sprint("value ", i))); err != nil {
+				t.Fatalf("Put fail: %v", err)
+			}
+		}
+		old := keys.Current()
+		if _, err := keys.Rotate(); err != nil {
+			t.Fatalf("Keyring.Rotate fail: %v", err)
+		}
+		if g, err := target.Stale(ctx); err != nil || g != N {
+			t.Errorf("wrong number of stale values: %d != %d, %v", g, N, err)
+		}
+		n, err := target.Rotate(ctx)
+		if err != nil {
+			t.Fatalf("Rotate fail: %v", err)
+		}
+		if g, e := n, N; g != e {
+			t.Errorf("Rotate rewrote wrong number of values: %d != %d", g, e)
+		}
+
+		if n, err := target.Stale(ctx); err != nil || n != 0 {
+			t.Errorf("values left with the old key: %d, %v", n, err)
+		}
+		keys.Drop(old)
+		for i := 0; i < N; i++ {
+			got, err := target.Get(ctx, []byte(fmt.Sprint(i)))
+			if err != nil {
+				t.Fatalf("Get after Rotate fail: %v", err)
+			}
+			if g, e := string(got), fmt.Sprint("value ", i); g != e {
+				t.Errorf("Get gave wrong content: %q != %q", g, e)
+			}
+		}
+		if n, err := target.Rotate(ctx); err != nil || n != 0 {
+			t.Errorf("second Rotate should do nothing: %d, %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package crypt
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPassphrase is returned when a key file cannot be opened with
+	// the given passphrase.
+	ErrPassphrase = errors.New("[ErrCrypt] wrong passphrase or corrupt key file")
+)
+
+// DecryptError is returned when a stored value or key fails
+// authentication, i.e. it was corrupted, tampered with or written
+// under a different keyring.
+type DecryptError struct {
+	Key []byte
+}
+
+var _ error = DecryptError{}
+
+func (d DecryptError) Error() string {
+	return fmt.Sprintf("[ErrCrypt] cannot decrypt %x", d.Key)
+}
+
+// UnknownKeyError is returned when a value was sealed with a key id
+// that is not in the keyring.
+type UnknownKeyError struct {
+	ID uint32
+}
+
+var _ error = UnknownKeyError{}
+
+func (u UnknownKeyError) Error() string {
+	return fmt.Sprintf("[ErrCrypt] unknown key id %d", u.ID)
+}
+
+// UnknownKDFError is returned for a key file using an unsupported key
+// derivation function.
+type UnknownKDFError struct {
+	Name string
+}
+
+var _ error = UnknownKDFError{}
+
+func (u UnknownKDFError) Error() string {
+	return fmt.Sprintf("[ErrCrypt] unknown kdf: %s", u.Name)
+}
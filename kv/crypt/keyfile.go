@@ -0,0 +1,219 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+// KDF selects how the key file key is derived from the passphrase.
+// Zero fields take their defaults; the parameters used are stored in
+// the key file, so they can be changed for new files at any time.
+type KDF struct {
+	// Algorithm is KDFScrypt or KDFArgon2id. Defaults to KDFScrypt.
+	Algorithm string `json:"kdf"`
+	Salt      []byte `json:"salt"`
+	// scrypt cost parameters, default N=32768, r=8, p=1
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+	// argon2id parameters, default 1 pass over 64 MiB with 4 threads
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+func (k *KDF) withDefaults() (KDF, error) {
+	kdf := *k
+	if kdf.Algorithm == "" {
+		kdf.Algorithm = KDFScrypt
+	}
+	switch kdf.Algorithm {
+	case KDFScrypt:
+		if kdf.N == 0 {
+			kdf.N = 1 << 15
+		}
+		if kdf.R == 0 {
+			kdf.R = 8
+		}
+		if kdf.P == 0 {
+			kdf.P = 1
+		}
+	case KDFArgon2id:
+		if kdf.Time == 0 {
+			kdf.Time = 1
+		}
+		if kdf.Memory == 0 {
+			kdf.Memory = 64 * 1024
+		}
+		if kdf.Threads == 0 {
+			kdf.Threads = 4
+		}
+	default:
+		return kdf, UnknownKDFError{Name: kdf.Algorithm}
+	}
+	if kdf.Salt == nil {
+		salt, err := randomKey(16)
+		if err != nil {
+			return kdf, err
+		}
+		kdf.Salt = salt
+	}
+	return kdf, nil
+}
+
+func (k *KDF) derive(passphrase []byte) (cipher.AEAD, error) {
+	var key []byte
+	switch k.Algorithm {
+	case KDFScrypt:
+		var err error
+		key, err = scrypt.Key(passphrase, k.Salt, k.N, k.R, k.P, chacha20poly1305.KeySize)
+		if err != nil {
+			return nil, err
+		}
+	case KDFArgon2id:
+		key = argon2.IDKey(passphrase, k.Salt, k.Time, k.Memory, k.Threads, chacha20poly1305.KeySize)
+	default:
+		return nil, UnknownKDFError{Name: k.Algorithm}
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// keyFile is the on-disk form of a Keyring: the keys are sealed with
+// a key derived from the passphrase, the KDF parameters are not
+// secret.
+type keyFile struct {
+	KDF
+	Nonce  []byte `json:"nonce"`
+	Sealed []byte `json:"sealed"`
+}
+
+type keyFileContent struct {
+	Current uint32            `json:"current"`
+	Keys    map[uint32][]byte `json:"keys"`
+	Names   []byte            `json:"names"`
+}
+
+// LoadKeyFile reads a keyring saved by SaveKeyFile.
+func LoadKeyFile(path string, passphrase []byte) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	aead, err := f.KDF.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Sealed, nil)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	var content keyFileContent
+	if err := json.Unmarshal(plain, &content); err != nil {
+		return nil, err
+	}
+	k := &Keyring{
+		current: content.Current,
+		keys:    make(map[uint32][]byte),
+		aeads:   make(map[uint32]cipher.AEAD),
+		names:   content.Names,
+		kdf:     f.KDF,
+	}
+	if len(k.names) != nameKeySize {
+		return nil, ErrPassphrase
+	}
+	for id, key := range content.Keys {
+		if err := k.add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := k.keys[k.current]; !ok {
+		return nil, UnknownKeyError{ID: k.current}
+	}
+	return k, nil
+}
+
+// SaveKeyFile writes the keyring to path, sealed with a key derived
+// from passphrase. A nil kdf keeps the algorithm and parameters the
+// keyring was loaded with, or uses the defaults. The file is replaced
+// atomically.
+func SaveKeyFile(path string, k *Keyring, passphrase []byte, kdf *KDF) error {
+	if kdf == nil {
+		k.mu.RLock()
+		last := k.kdf
+		k.mu.RUnlock()
+		last.Salt = nil
+		kdf = &last
+	}
+	params, err := kdf.withDefaults()
+	if err != nil {
+		return err
+	}
+	aead, err := params.derive(passphrase)
+	if err != nil {
+		return err
+	}
+
+	k.mu.RLock()
+	plain, err := json.Marshal(keyFileContent{Current: k.current, Keys: k.keys, Names: k.names})
+	k.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keyFile{
+		KDF:    params,
+		Nonce:  nonce,
+		Sealed: aead.Seal(nil, nonce, plain, nil),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), ".keyfile-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	// CreateTemp already uses 0600
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.kdf = params
+	k.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,129 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// nameKeySize is the size of the key that hides kv keys: half of it
+// authenticates, the other half encrypts.
+const nameKeySize = 64
+
+// Keyring holds the data keys values are sealed with. New values use
+// the current key; older keys are kept so values sealed with them stay
+// readable until Impl.Rotate has re-encrypted them.
+//
+// A Keyring is safe for concurrent use.
+type Keyring struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+	aeads   map[uint32]cipher.AEAD
+	// names hides kv keys. It never changes, as that would move every
+	// entry of the store.
+	names []byte
+	// kdf is what the keyring was last loaded or saved with
+	kdf KDF
+}
+
+func randomKey(size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewKeyring returns a keyring with one fresh random data key.
+func NewKeyring() (*Keyring, error) {
+	names, err := randomKey(nameKeySize)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{
+		keys:  make(map[uint32][]byte),
+		aeads: make(map[uint32]cipher.AEAD),
+		names: names,
+	}
+	if _, err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Keyring) add(id uint32, key []byte) error {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	k.keys[id] = key
+	k.aeads[id] = aead
+	return nil
+}
+
+// Rotate adds a fresh random data key and makes it current. It
+// returns the id of the new key.
+func (k *Keyring) Rotate() (uint32, error) {
+	key, err := randomKey(chacha20poly1305.KeySize)
+	if err != nil {
+		return 0, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := k.current + 1
+	if err := k.add(id, key); err != nil {
+		return 0, err
+	}
+	k.current = id
+	return id, nil
+}
+
+// Current returns the id of the key new values are sealed with.
+func (k *Keyring) Current() uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Drop removes the key id, so values still sealed with it can no
+// longer be read. The current key cannot be dropped.
+func (k *Keyring) Drop(id uint32) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return
+	}
+	delete(k.keys, id)
+	delete(k.aeads, id)
+}
+
+// IDs returns the ids of all keys in the keyring, oldest first.
+func (k *Keyring) IDs() []uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]uint32, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// aead returns the cipher of key id, and of the current key if id is
+// zero, along with its id.
+func (k *Keyring) aead(id uint32) (cipher.AEAD, uint32, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if id == 0 {
+		id = k.current
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, 0, UnknownKeyError{ID: id}
+	}
+	return aead, id, nil
+}
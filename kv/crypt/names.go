@@ -0,0 +1,58 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Hidden keys are encrypted deterministically, so the same key always
+// maps to the same stored key, in the style of SIV:
+//
+//	iv = HMAC-SHA256(macKey, key)[:16]
+//	stored = iv | AES-256-CTR(encKey, iv, key)
+//
+// The stored key reveals nothing of the key but its length, yet can
+// be decrypted again for Iterate, and the iv authenticates it.
+const ivSize = 16
+
+type names struct {
+	mac   []byte
+	block cipher.Block
+}
+
+func newNames(key []byte) (*names, error) {
+	block, err := aes.NewCipher(key[nameKeySize/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &names{mac: key[:nameKeySize/2], block: block}, nil
+}
+
+func (n *names) iv(key []byte) []byte {
+	h := hmac.New(sha256.New, n.mac)
+	h.Write(key)
+	return h.Sum(nil)[:ivSize]
+}
+
+func (n *names) hide(key []byte) []byte {
+	iv := n.iv(key)
+	stored := make([]byte, ivSize+len(key))
+	copy(stored, iv)
+	cipher.NewCTR(n.block, iv).XORKeyStream(stored[ivSize:], key)
+	return stored
+}
+
+func (n *names) reveal(stored []byte) ([]byte, error) {
+	if len(stored) < ivSize {
+		return nil, DecryptError{Key: stored}
+	}
+	iv := stored[:ivSize]
+	key := make([]byte, len(stored)-ivSize)
+	cipher.NewCTR(n.block, iv).XORKeyStream(key, stored[ivSize:])
+	if !hmac.Equal(iv, n.iv(key)) {
+		return nil, DecryptError{Key: stored}
+	}
+	return key, nil
+}
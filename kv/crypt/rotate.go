@@ -0,0 +1,92 @@
+package crypt
+
+import (
+	"context"
+	"errors"
+	"lifs_go/kv"
+)
+
+// Rotate re-encrypts every value that is not sealed with the current
+// key of the keyring, typically after Keyring.Rotate, and returns how
+// many it rewrote.
+//
+// Rotate works one value at a time and may run in the background
+// while the store is in use; values written meanwhile through c
+// already use the current key. Other processes that loaded the
+// keyring before its rotation keep sealing with their current key,
+// which is now an old one, so old keys must only be dropped once they
+// have all reloaded it and Stale finds nothing. An interrupted Rotate
+// can simply be run again.
+func (c *Impl) Rotate(ctx context.Context) (int, error) {
+	current := c.keys.Current()
+	n := 0
+	err := c.inner.Iterate(ctx, kv.All, func(stored []byte) error {
+		done, err := c.reseal(ctx, append([]byte(nil), stored...), current)
+		if done {
+			n++
+		}
+		return err
+	})
+	return n, err
+}
+
+func (c *Impl) reseal(ctx context.Context, stored []byte, current uint32) (bool, error) {
+	key := stored
+	if c.names != nil {
+		var err error
+		if key, err = c.names.reveal(stored); err != nil {
+			return false, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sealed, err := c.inner.Get(ctx, stored)
+	if err != nil {
+		var nf kv.NotFoundError
+		if errors.As(err, &nf) {
+			// deleted since Iterate listed it
+			return false, nil
+		}
+		return false, err
+	}
+	id, err := sealedWith(key, sealed)
+	if err != nil || id == current {
+		return false, err
+	}
+	value, err := c.open(key, sealed)
+	if err != nil {
+		return false, err
+	}
+	if sealed, err = c.seal(key, value); err != nil {
+		return false, err
+	}
+	return true, c.inner.Put(ctx, stored, sealed)
+}
+
+// Stale returns how many values are not sealed with the current key
+// of the keyring.
+func (c *Impl) Stale(ctx context.Context) (int, error) {
+	current := c.keys.Current()
+	n := 0
+	err := c.inner.Iterate(ctx, kv.All, func(stored []byte) error {
+		sealed, err := c.inner.Get(ctx, stored)
+		var nf kv.NotFoundError
+		if errors.As(err, &nf) {
+			// deleted since Iterate listed it
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		id, err := sealedWith(stored, sealed)
+		if err != nil {
+			return err
+		}
+		if id != current {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
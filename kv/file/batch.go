@@ -0,0 +1,198 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"lifs_go/kv"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// A batch is staged in its own directory below batchDir: the values
+// are written to numbered files, then a journal listing all
+// operations is renamed into place. The rename is the commit point;
+// a journal found on startup is replayed, a staging directory
+// without one is discarded.
+const (
+	batchDir    = ".batch"
+	journalName = "journal"
+)
+
+const (
+	journalPut    byte = 0x00
+	journalDelete byte = 0x01
+)
+
+func encodeJournal(ops []kv.Op) []byte {
+	var buf bytes.Buffer
+	var n [4]byte
+	for _, op := range ops {
+		flag := journalPut
+		if op.Delete {
+			flag = journalDelete
+		}
+		buf.WriteByte(flag)
+		binary.BigEndian.PutUint32(n[:], uint32(len(op.Key)))
+		buf.Write(n[:])
+		buf.Write(op.Key)
+	}
+	return buf.Bytes()
+}
+
+// decodeJournal returns the operations of a journal. Put operations
+// have no value; it is in the staged file named by their index.
+func decodeJournal(data []byte) ([]kv.Op, error) {
+	var ops []kv.Op
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		flag := data[0]
+		klen := int(binary.BigEndian.Uint32(data[1:5]))
+		data = data[5:]
+		if len(data) < klen {
+			return nil, io.ErrUnexpectedEOF
+		}
+		ops = append(ops, kv.Op{Key: data[:klen], Delete: flag == journalDelete})
+		data = data[klen:]
+	}
+	return ops, nil
+}
+
+func writeFileSync(name string, data []byte, sync bool) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (k *Impl) Write(ctx context.Context, b *kv.Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	root := filepath.Join(k.path, batchDir)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	stage, err := os.MkdirTemp(root, "b-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(stage)
+	}()
+
+	ops := b.Ops()
+	for i, op := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if op.Delete {
+			continue
+		}
+		if err := writeFileSync(filepath.Join(stage, strconv.Itoa(i)), op.Value, !k.noSync); err != nil {
+			return err
+		}
+	}
+	tmp := filepath.Join(stage, journalName+".tmp")
+	if err := writeFileSync(tmp, encodeJournal(ops), !k.noSync); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(stage, journalName)); err != nil {
+		return err
+	}
+	if !k.noSync {
+		if err := syncDir(stage); err != nil {
+			return err
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, op := range ops {
+		k.changed(op.Key)
+	}
+	if err := k.apply(stage); err != nil {
+		return err
+	}
+	// without its journal the stage is discarded on recovery, so its
+	// deletes are never replayed over later writes
+	return os.Remove(filepath.Join(stage, journalName))
+}
+
+// apply replays the committed journal in stage. Each staged value is
+// renamed over its key, so a value already missing was applied before
+// a crash and is skipped; this makes apply safe to run again.
+func (k *Impl) apply(stage string) error {
+	data, err := os.ReadFile(filepath.Join(stage, journalName))
+	if err != nil {
+		return err
+	}
+	ops, err := decodeJournal(data)
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for i, op := range ops {
+		name := k.key2FileName(op.Key)
+		dir := filepath.Dir(name)
+		dirs[dir] = true
+		if op.Delete {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := k.mkdirs(dir); err != nil {
+			return err
+		}
+		err := os.Rename(filepath.Join(stage, strconv.Itoa(i)), name)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if !k.noSync {
+		for dir := range dirs {
+			if err := syncDir(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recoverBatches finishes the batches committed before a crash and
+// discards the ones that were not.
+func (k *Impl) recoverBatches() error {
+	root := filepath.Join(k.path, batchDir)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		stage := filepath.Join(root, e.Name())
+		err := k.apply(stage)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.RemoveAll(stage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
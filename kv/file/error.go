@@ -0,0 +1,30 @@
+package file
+
+import "fmt"
+
+// LevelsError is returned by Open when the store was created or
+// resharded with a different number of levels than requested.
+type LevelsError struct {
+	Path      string
+	Stored    int
+	Requested int
+}
+
+var _ error = LevelsError{}
+
+func (l LevelsError) Error() string {
+	return fmt.Sprintf("[ErrFile] %s has %d levels, not %d; use Reshard to change them", l.Path, l.Stored, l.Requested)
+}
+
+// BadLevelsError is returned for a negative number of levels, or a
+// levels marker that cannot be parsed.
+type BadLevelsError struct {
+	Path string
+	Data string
+}
+
+var _ error = BadLevelsError{}
+
+func (b BadLevelsError) Error() string {
+	return fmt.Sprintf("[ErrFile] bad number of levels for %s: %q", b.Path, b.Data)
+}
@@ -3,20 +3,152 @@ package file
 import (
 	"context"
 	"encoding/hex"
+	"io/fs"
 	"lifs_go/kv"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+const (
+	fileNamePrefix = "."
+	fileNameSuffix = ".data"
+)
+
+// Options tunes a file store. The zero value keeps every key of a new
+// store in one flat directory.
+type Options struct {
+	// Levels is the number of directory levels the files of a new
+	// store are spread over. Each level is named by the next two hex
+	// digits of the key, so with 2 levels key ab cd ef.. is stored as
+	// ab/cd/.abcdef...data
+	//
+	// The levels are recorded in the store, and later opens use the
+	// recorded ones; a different non-zero Levels is an error. Reshard
+	// changes them.
+	Levels int
+	// NoSync skips the fsync of files and directories on Put and
+	// Delete. A power loss may then leave empty or missing files.
+	NoSync bool
+}
+
 type Impl struct {
-	path string
+	// Write and Snapshot take mu exclusively, so readers never see
+	// part of a batch; single operations only need it shared.
+	mu     sync.RWMutex
+	path   string
+	levels int
+	noSync bool
+
+	// buildMu guards builds, the snapshots being linked
+	buildMu sync.Mutex
+	builds  map[*building]struct{}
+}
+
+func key2FileName(root string, levels int, key []byte) string {
+	name := hex.EncodeToString(key)
+	parts := make([]string, 0, levels+2)
+	parts = append(parts, root)
+	for i := 0; i < levels && 2*i+2 <= len(name); i++ {
+		parts = append(parts, name[2*i:2*i+2])
+	}
+	parts = append(parts, fileNamePrefix+name+fileNameSuffix)
+	return filepath.Join(parts...)
 }
 
 func (k *Impl) key2FileName(key []byte) string {
-	return filepath.Join(k.path, "."+hex.EncodeToString(key)+".data")
+	return key2FileName(k.path, k.levels, key)
+}
+
+// fileName2Key is the inverse of key2FileName. It reports false for
+// names that were not created by key2FileName, such as temp files.
+func fileName2Key(name string) ([]byte, bool) {
+	if !strings.HasPrefix(name, fileNamePrefix) || !strings.HasSuffix(name, fileNameSuffix) {
+		return nil, false
+	}
+	key, err := hex.DecodeString(name[len(fileNamePrefix) : len(name)-len(fileNameSuffix)])
+	if err != nil {
+		return nil, false
+	}
+	return key, true
+}
+
+// isShardDir reports whether name may be a directory level created by
+// key2FileName.
+func isShardDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// walk calls fn with the path and key of every data file below root,
+// at any shard depth.
+func walk(root string, fn func(path string, key []byte) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && !isShardDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		key, ok := fileName2Key(d.Name())
+		if !ok {
+			return nil
+		}
+		return fn(path, key)
+	})
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// mkdirs creates the shard directories of dir below the store root,
+// syncing each parent so the new entries survive a crash.
+func (k *Impl) mkdirs(dir string) error {
+	rel, err := filepath.Rel(k.path, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+	cur := k.path
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		parent := cur
+		cur = filepath.Join(cur, part)
+		err := os.Mkdir(cur, 0755)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !k.noSync {
+			if err := syncDir(parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (k *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
 	file, err := os.ReadFile(k.key2FileName(key))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -28,7 +160,18 @@ func (k *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
 }
 
 func (k *Impl) Put(ctx context.Context, key, value []byte) (err error) {
-	temp, err := os.CreateTemp(k.path, "put-")
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	k.changed(key)
+	name := k.key2FileName(key)
+	dir := filepath.Dir(name)
+	temp, err := os.CreateTemp(dir, "put-")
+	if os.IsNotExist(err) {
+		if err := k.mkdirs(dir); err != nil {
+			return err
+		}
+		temp, err = os.CreateTemp(dir, "put-")
+	}
 	if err != nil {
 		return err
 	}
@@ -41,13 +184,123 @@ func (k *Impl) Put(ctx context.Context, key, value []byte) (err error) {
 	if err != nil {
 		return err
 	}
-	err = os.Link(temp.Name(), k.key2FileName(key))
-	if err != nil && !os.IsExist(err) {
+	// the data must be on disk before the name is, or a crash may
+	// leave a valid looking empty file
+	if !k.noSync {
+		if err := temp.Sync(); err != nil {
+			return err
+		}
+	}
+	// rename replaces any older value atomically
+	if err := os.Rename(temp.Name(), name); err != nil {
+		return err
+	}
+	if !k.noSync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+func (k *Impl) Delete(ctx context.Context, key []byte) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	k.changed(key)
+	name := k.key2FileName(key)
+	err := os.Remove(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !k.noSync {
+		return syncDir(filepath.Dir(name))
+	}
+	return nil
+}
+
+func (k *Impl) Has(ctx context.Context, key []byte) (bool, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	_, err := os.Stat(k.key2FileName(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (k *Impl) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	// shard directories and the suffix break byte order, so sort on
+	// the decoded keys
+	var keys []string
+	k.mu.RLock()
+	err := walk(k.path, func(path string, key []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if r.Contains(key) {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	k.mu.RUnlock()
+	if err != nil {
 		return err
 	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn([]byte(key)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Open returns a file store rooted at path, creating the directory if
+// needed, and finishes any batch interrupted by a crash.
+func Open(path string, opts *Options) (*Impl, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Levels < 0 {
+		return nil, BadLevelsError{Path: path, Data: strconv.Itoa(opts.Levels)}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	levels, ok, err := readLevels(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !ok:
+		// a new store, or one from before levels were recorded
+		levels = opts.Levels
+		if err := writeLevels(path, levels, !opts.NoSync); err != nil {
+			return nil, err
+		}
+	case opts.Levels != 0 && opts.Levels != levels:
+		return nil, LevelsError{Path: path, Stored: levels, Requested: opts.Levels}
+	}
+	k := &Impl{path: path, levels: levels, noSync: opts.NoSync}
+	if err := k.recoverBatches(); err != nil {
+		return nil, err
+	}
+	if err := cleanSnapshots(path); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// New returns a flat file store rooted at the existing directory path.
+// Unlike Open it does no I/O: it neither recovers interrupted batches
+// nor reads the recorded levels, so sharded stores need Open.
 func New(path string) kv.IF {
 	return &Impl{path: path}
 }
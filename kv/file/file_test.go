@@ -5,7 +5,9 @@ import (
 	"errors"
 	"lifs_go/kv"
 	"lifs_go/kv/file"
+	"lifs_go/kv/kvtest"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -75,3 +77,217 @@ func TestGetNotFoundError(t *testing.T) {
 		t.Errorf("NotFoundError Key is wrong: %x != %x", g, w)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		return file.New(t.TempDir())
+	})
+}
+
+func TestConformanceSharded(t *testing.T) {
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		k, err := file.Open(t.TempDir(), &file.Options{Levels: 2})
+		if err != nil {
+			t.Fatalf("file.Open fail: %v", err)
+		}
+		return k
+	})
+}
+
+func TestShardedLayout(t *testing.T) {
+	temp := t.TempDir()
+	k, err := file.Open(temp, &file.Options{Levels: 2})
+	if err != nil {
+		t.Fatalf("file.Open fail: %v", err)
+	}
+	ctx := context.Background()
+	if err := k.Put(ctx, []byte("\xab\xcd\xef"), []byte("foobar")); err != nil {
+		t.Fatalf("k.Put fail: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(temp, "ab", "cd", ".abcdef.data")); err != nil {
+		t.Fatalf("file not stored in shard directory: %v", err)
+	}
+	// keys shorter than the shard levels stay as deep as they can
+	if err := k.Put(ctx, []byte("\x01"), []byte("short")); err != nil {
+		t.Fatalf("k.Put fail: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(temp, "01", ".01.data")); err != nil {
+		t.Fatalf("short key not stored in shard directory: %v", err)
+	}
+}
+
+func TestReshard(t *testing.T) {
+	temp := t.TempDir()
+	ctx := context.Background()
+	flat := file.New(temp)
+	keys := []string{"\x00\x01\x02", "\xab\xcd\xef", "\xab\xcd\x00", "\xab\x00\x00", "\x42"}
+	for _, key := range keys {
+		if err := flat.Put(ctx, []byte(key), []byte("value "+key)); err != nil {
+			t.Fatalf("k.Put fail: %v", err)
+		}
+	}
+
+	for _, levels := range []int{2, 1, 0} {
+		if err := file.Reshard(ctx, temp, levels); err != nil {
+			t.Fatalf("Reshard to %d fail: %v", levels, err)
+		}
+		k, err := file.Open(temp, &file.Options{Levels: levels})
+		if err != nil {
+			t.Fatalf("file.Open fail: %v", err)
+		}
+		for _, key := range keys {
+			data, err := k.Get(ctx, []byte(key))
+			if err != nil {
+				t.Fatalf("k.Get after Reshard to %d failed: %v", levels, err)
+			}
+			if g, e := string(data), "value "+key; g != e {
+				t.Fatalf("k.Get gave wrong content: %q != %q", g, e)
+			}
+		}
+	}
+
+	// back to flat, no shard directories should be left
+	entries, err := os.ReadDir(temp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			t.Errorf("empty shard directory left behind: %s", e.Name())
+		}
+	}
+}
+
+// TestRecoverBatch stages batches by hand as a crash would leave them:
+// one with its journal committed, one without.
+func TestRecoverBatch(t *testing.T) {
+	temp := t.TempDir()
+	ctx := context.Background()
+	if err := file.New(temp).Put(ctx, []byte("k2"), []byte("old")); err != nil {
+		t.Fatalf("k.Put fail: %v", err)
+	}
+	committed := filepath.Join(temp, ".batch", "b-1")
+	pending := filepath.Join(temp, ".batch", "b-2")
+	for _, dir := range []string{committed, pending} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "0"), []byte("value of "+filepath.Base(dir)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one put of key "k1", then a delete of key "k2"
+	journal := []byte("\x00\x00\x00\x00\x02k1\x01\x00\x00\x00\x02k2")
+	if err := os.WriteFile(filepath.Join(committed, "journal"), journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pending, "journal.tmp"), journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := file.Open(temp, nil)
+	if err != nil {
+		t.Fatalf("file.Open fail: %v", err)
+	}
+	data, err := k.Get(ctx, []byte("k1"))
+	if err != nil {
+		t.Fatalf("committed batch not applied: %v", err)
+	}
+	if g, e := string(data), "value of b-1"; g != e {
+		t.Errorf("k.Get gave wrong content: %q != %q", g, e)
+	}
+	ok, err := k.Has(ctx, []byte("k2"))
+	if err != nil {
+		t.Fatalf("k.Has fail: %v", err)
+	}
+	if ok {
+		t.Errorf("committed delete not applied")
+	}
+	entries, err := os.ReadDir(filepath.Join(temp, ".batch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("batch staging directories left behind: %d", len(entries))
+	}
+}
+
+func TestLevelsRecorded(t *testing.T) {
+	temp := t.TempDir()
+	ctx := context.Background()
+	k, err := file.Open(temp, &file.Options{Levels: 2})
+	if err != nil {
+		t.Fatalf("file.Open fail: %v", err)
+	}
+	if err := k.Put(ctx, []byte("\xab\xcd\xef"), []byte("foobar")); err != nil {
+		t.Fatalf("k.Put fail: %v", err)
+	}
+
+	// reopened without options, the recorded levels are used
+	k, err = file.Open(temp, nil)
+	if err != nil {
+		t.Fatalf("file.Open fail: %v", err)
+	}
+	if _, err := k.Get(ctx, []byte("\xab\xcd\xef")); err != nil {
+		t.Fatalf("k.Get with recorded levels fail: %v", err)
+	}
+	var le file.LevelsError
+	if _, err := file.Open(temp, &file.Options{Levels: 1}); !errors.As(err, &le) {
+		t.Fatalf("file.Open with other levels did not fail: %v", err)
+	}
+
+	if err := file.Reshard(ctx, temp, 1); err != nil {
+		t.Fatalf("Reshard fail: %v", err)
+	}
+	k, err = file.Open(temp, nil)
+	if err != nil {
+		t.Fatalf("file.Open fail: %v", err)
+	}
+	if err := k.Put(ctx, []byte("\x01\x02"), []byte("new")); err != nil {
+		t.Fatalf("k.Put fail: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(temp, "01", ".0102.data")); err != nil {
+		t.Fatalf("file not stored with resharded levels: %v", err)
+	}
+}
+
+func TestSnapshotCleanup(t *testing.T) {
+	temp := t.TempDir()
+	ctx := context.Background()
+	k, err := file.Open(temp, nil)
+	if err != nil {
+		t.Fatalf("file.Open fail: %v", err)
+	}
+	if err := k.Put(ctx, []byte("k1"), []byte("value")); err != nil {
+		t.Fatalf("k.Put fail: %v", err)
+	}
+	snap, err := k.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("k.Snapshot fail: %v", err)
+	}
+	defer snap.Release()
+	if _, ok := snap.(kv.IF); ok {
+		t.Errorf("snapshot can be written to")
+	}
+	// as left by a gc killed before releasing its snapshot
+	stale := filepath.Join(temp, ".snapshots", "s-stale")
+	if err := os.MkdirAll(stale, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stale, "lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Open(temp, nil); err != nil {
+		t.Fatalf("file.Open fail: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale snapshot not cleaned up: %v", err)
+	}
+	if err := k.Delete(ctx, []byte("k1")); err != nil {
+		t.Fatalf("k.Delete fail: %v", err)
+	}
+	if _, err := snap.Get(ctx, []byte("k1")); err != nil {
+		t.Errorf("snapshot in use was cleaned up: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// levelsName is the file recording the number of levels of a store, in
+// decimal. It is not a data file name, so walk never lists it.
+const levelsName = ".levels"
+
+// readLevels returns the number of levels recorded in the store at
+// path, or false if there is no marker yet.
+func readLevels(path string) (int, bool, error) {
+	data, err := os.ReadFile(filepath.Join(path, levelsName))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	levels, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || levels < 0 {
+		return 0, false, BadLevelsError{Path: path, Data: string(data)}
+	}
+	return levels, true, nil
+}
+
+// writeLevels records levels in the store at path, replacing the
+// marker atomically.
+func writeLevels(path string, levels int, sync bool) error {
+	tmp := filepath.Join(path, levelsName+".tmp")
+	if err := writeFileSync(tmp, []byte(strconv.Itoa(levels)+"\n"), sync); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(path, levelsName)); err != nil {
+		return err
+	}
+	if sync {
+		return syncDir(path)
+	}
+	return nil
+}
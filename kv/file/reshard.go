@@ -0,0 +1,74 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reshard moves every data file below path to where a store opened
+// with the given number of levels expects it, then removes the shard
+// directories left empty.
+//
+// The new levels are recorded first, so later opens use them. Files
+// are then moved one at a time with rename, and an interrupted Reshard
+// can simply be run again. The store must not be open while Reshard
+// runs.
+func Reshard(ctx context.Context, path string, levels int) error {
+	if levels < 0 {
+		return BadLevelsError{Path: path, Data: strconv.Itoa(levels)}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	if err := writeLevels(path, levels, true); err != nil {
+		return err
+	}
+	k := &Impl{path: path, levels: levels}
+	var dirs []string
+	err := walk(path, func(old string, key []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := k.key2FileName(key)
+		if name == old {
+			return nil
+		}
+		dir := filepath.Dir(name)
+		if err := k.mkdirs(dir); err != nil {
+			return err
+		}
+		if err := os.Rename(old, name); err != nil {
+			return err
+		}
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+		oldDir := filepath.Dir(old)
+		if oldDir != path {
+			dirs = append(dirs, oldDir)
+		}
+		return syncDir(oldDir)
+	})
+	if err != nil {
+		return err
+	}
+
+	// deepest first, so parents are empty by the time we get to them
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+	for _, dir := range dirs {
+		for dir != path {
+			// fails harmlessly if the directory is still in use
+			if os.Remove(dir) != nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package file
+
+import (
+	"context"
+	"lifs_go/kv"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	snapshotDir = ".snapshots"
+	// snapshotLock is held by the process using a snapshot directory,
+	// so that Open only cleans up the ones left behind by a crash.
+	snapshotLock = "lock"
+)
+
+// snapshot only exposes the reads of the store of links.
+type snapshot struct {
+	kv.Reader
+	dir  string
+	lock *os.File
+}
+
+func (s snapshot) Release() {
+	_ = os.RemoveAll(s.dir)
+	_ = s.lock.Close()
+}
+
+// building collects the keys changed while the links of a snapshot
+// are made.
+type building struct {
+	changed map[string]struct{}
+}
+
+// changed records that key is being changed; it is called with mu
+// held, shared or exclusively.
+func (k *Impl) changed(key []byte) {
+	k.buildMu.Lock()
+	defer k.buildMu.Unlock()
+	for b := range k.builds {
+		b.changed[string(key)] = struct{}{}
+	}
+}
+
+// Snapshot hard links every data file into a private directory. This
+// costs one link per key, but the files are never modified in place,
+// so the links keep the contents as of the snapshot.
+//
+// The links are made while the store is in use; the keys changed
+// meanwhile are linked again at the end, with writes blocked only for
+// that short pass.
+func (k *Impl) Snapshot(ctx context.Context) (kv.Snapshot, error) {
+	root := filepath.Join(k.path, snapshotDir)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(root, "s-")
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockSnapshot(dir)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	s := snapshot{dir: dir, lock: lock}
+	snap := &Impl{path: dir, levels: k.levels, noSync: true}
+	s.Reader = snap
+
+	// taking mu waits for the writes already under way, which would
+	// not be recorded
+	b := &building{changed: make(map[string]struct{})}
+	k.mu.Lock()
+	k.buildMu.Lock()
+	if k.builds == nil {
+		k.builds = make(map[*building]struct{})
+	}
+	k.builds[b] = struct{}{}
+	k.buildMu.Unlock()
+	k.mu.Unlock()
+	defer func() {
+		k.buildMu.Lock()
+		delete(k.builds, b)
+		k.buildMu.Unlock()
+	}()
+
+	err = walk(k.path, func(path string, key []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return link(snap, path, key)
+	})
+	if err == nil {
+		err = k.relink(snap, b)
+	}
+	if err != nil {
+		s.Release()
+		return nil, err
+	}
+	return s, nil
+}
+
+// relink brings the links of the keys changed during the first pass up
+// to date, with every write blocked.
+func (k *Impl) relink(snap *Impl, b *building) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key := range b.changed {
+		name := snap.key2FileName([]byte(key))
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := link(snap, k.key2FileName([]byte(key)), []byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// link links the data file at path into snap as key. A file deleted
+// since it was listed is skipped.
+func link(snap *Impl, path string, key []byte) error {
+	name := snap.key2FileName(key)
+	if err := snap.mkdirs(filepath.Dir(name)); err != nil {
+		return err
+	}
+	err := os.Link(path, name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// lockSnapshot creates and locks the lock file of the snapshot
+// directory dir.
+func lockSnapshot(dir string) (*os.File, error) {
+	f, err := os.Create(filepath.Join(dir, snapshotLock))
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	// Open may have cleaned the directory up before it was locked
+	if _, err := os.Stat(f.Name()); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// cleanSnapshots removes the snapshot directories whose process is
+// gone, such as a gc killed before it released its snapshot; their
+// links would keep deleted data on disk for good.
+func cleanSnapshots(path string) error {
+	root := filepath.Join(path, snapshotDir)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "s-") {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		f, err := os.Open(filepath.Join(dir, snapshotLock))
+		if os.IsNotExist(err) {
+			// still being created, or crashed before: nothing is
+			// linked yet either way
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ok, err := tryLockFile(f)
+		if err == nil && ok {
+			err = os.RemoveAll(dir)
+		}
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,82 @@
 package kv
 
 import (
+	"bytes"
 	"context"
 )
 
 type IF interface {
-	Get(ctx context.Context, key []byte) ([]byte, error)
+	Reader
 	Put(ctx context.Context, key, value []byte) error
+	// Delete removes key. Deleting a key that does not exist is not
+	// an error.
+	Delete(ctx context.Context, key []byte) error
+	// Write applies all operations of b atomically: even across a
+	// crash, either all or none of them take effect.
+	Write(ctx context.Context, b *Batch) error
+	// Snapshot returns a read-only view of the current contents that
+	// is not affected by later writes. It must be released when no
+	// longer needed.
+	Snapshot(ctx context.Context) (Snapshot, error)
+}
+
+// Reader is the read-only part of IF.
+type Reader interface {
+	Get(ctx context.Context, key []byte) ([]byte, error)
+	Has(ctx context.Context, key []byte) (bool, error)
+	// Iterate calls fn for every key in r, in ascending byte order.
+	//
+	// Iteration stops at the first error returned by fn, or when ctx
+	// is done, and that error is returned. The key passed to fn must
+	// not be retained or modified after fn returns.
+	Iterate(ctx context.Context, r Range, fn func(key []byte) error) error
+}
+
+// Snapshot is a consistent read-only view of an IF.
+type Snapshot interface {
+	Reader
+	// Release frees the resources held by the snapshot. The snapshot
+	// must not be used afterwards.
+	Release()
+}
+
+// Range is a half-open key interval [Start, End).
+//
+// A nil Start means no lower bound, a nil End means no upper bound.
+type Range struct {
+	Start []byte
+	End   []byte
+}
+
+// All is the Range covering every key.
+var All = Range{}
+
+// Prefix returns the Range of all keys starting with prefix.
+func Prefix(prefix []byte) Range {
+	if len(prefix) == 0 {
+		return All
+	}
+	start := append([]byte(nil), prefix...)
+	// the smallest key greater than every key with this prefix is the
+	// prefix with its last non-0xff byte incremented
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return Range{Start: start, End: end[:i+1]}
+		}
+	}
+	// prefix is all 0xff, no upper bound
+	return Range{Start: start}
+}
+
+// Contains reports whether key lies within r.
+func (r Range) Contains(key []byte) bool {
+	if r.Start != nil && bytes.Compare(key, r.Start) < 0 {
+		return false
+	}
+	if r.End != nil && bytes.Compare(key, r.End) >= 0 {
+		return false
+	}
+	return true
 }
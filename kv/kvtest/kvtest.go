@@ -0,0 +1,291 @@
+// Package kvtest holds conformance tests shared by all kv.IF
+// implementations.
+package kvtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lifs_go/kv"
+	"testing"
+)
+
+// Factory returns a new, empty kv.IF. It is called once per test.
+type Factory func(t *testing.T) kv.IF
+
+// Run runs the conformance tests against the kv.IF returned by f.
+func Run(t *testing.T, f Factory) {
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, f(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, f(t)) })
+	t.Run("DeleteNotFound", func(t *testing.T) { testDeleteNotFound(t, f(t)) })
+	t.Run("Has", func(t *testing.T) { testHas(t, f(t)) })
+	t.Run("IterateAll", func(t *testing.T) { testIterateAll(t, f(t)) })
+	t.Run("IteratePrefix", func(t *testing.T) { testIteratePrefix(t, f(t)) })
+	t.Run("IterateRange", func(t *testing.T) { testIterateRange(t, f(t)) })
+	t.Run("IterateStop", func(t *testing.T) { testIterateStop(t, f(t)) })
+	t.Run("IterateCancel", func(t *testing.T) { testIterateCancel(t, f(t)) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, f(t)) })
+	t.Run("BatchOrder", func(t *testing.T) { testBatchOrder(t, f(t)) })
+	t.Run("BatchOverwrite", func(t *testing.T) { testBatchOverwrite(t, f(t)) })
+	t.Run("BatchEmpty", func(t *testing.T) { testBatchEmpty(t, f(t)) })
+	t.Run("Snapshot", func(t *testing.T) { testSnapshot(t, f(t)) })
+}
+
+func put(t *testing.T, target kv.IF, keys ...string) {
+	t.Helper()
+	ctx := context.Background()
+	for _, k := range keys {
+		if err := target.Put(ctx, []byte(k), []byte("value of "+k)); err != nil {
+			t.Fatalf("Put %q fail: %v", k, err)
+		}
+	}
+}
+
+func collect(t *testing.T, target kv.Reader, r kv.Range) []string {
+	t.Helper()
+	var keys []string
+	err := target.Iterate(context.Background(), r, func(key []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate fail: %v", err)
+	}
+	return keys
+}
+
+func checkKeys(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if g, e := fmt.Sprintf("%q", got), fmt.Sprintf("%q", want); g != e {
+		t.Errorf("Iterate gave wrong keys: %s != %s", g, e)
+	}
+}
+
+func checkValue(t *testing.T, target kv.IF, key, want string) {
+	t.Helper()
+	v, err := target.Get(context.Background(), []byte(key))
+	if err != nil {
+		t.Fatalf("Get %q fail: %v", key, err)
+	}
+	if g, e := string(v), want; g != e {
+		t.Errorf("Get %q gave wrong content: %q != %q", key, g, e)
+	}
+}
+
+func testOverwrite(t *testing.T, target kv.IF) {
+	ctx := context.Background()
+	put(t, target, "a")
+	if err := target.Put(ctx, []byte("a"), []byte("new value")); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	checkValue(t, target, "a", "new value")
+	checkKeys(t, collect(t, target, kv.All), "a")
+}
+
+func testDelete(t *testing.T, target kv.IF) {
+	ctx := context.Background()
+	put(t, target, "key", "other")
+	if err := target.Delete(ctx, []byte("key")); err != nil {
+		t.Fatalf("Delete fail: %v", err)
+	}
+	_, err := target.Get(ctx, []byte("key"))
+	var nf kv.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("Get after Delete should be NotFoundError: %T: %v", err, err)
+	}
+	v, err := target.Get(ctx, []byte("other"))
+	if err != nil {
+		t.Fatalf("Delete removed the wrong key: %v", err)
+	}
+	if g, e := string(v), "value of other"; g != e {
+		t.Errorf("Get gave wrong content: %q != %q", g, e)
+	}
+}
+
+func testDeleteNotFound(t *testing.T, target kv.IF) {
+	if err := target.Delete(context.Background(), []byte("missing")); err != nil {
+		t.Fatalf("Delete of missing key should succeed: %v", err)
+	}
+}
+
+func testHas(t *testing.T, target kv.IF) {
+	ctx := context.Background()
+	put(t, target, "key")
+	ok, err := target.Has(ctx, []byte("key"))
+	if err != nil {
+		t.Fatalf("Has fail: %v", err)
+	}
+	if !ok {
+		t.Errorf("Has should find key")
+	}
+	ok, err = target.Has(ctx, []byte("missing"))
+	if err != nil {
+		t.Fatalf("Has fail: %v", err)
+	}
+	if ok {
+		t.Errorf("Has should not find missing key")
+	}
+}
+
+func testIterateAll(t *testing.T, target kv.IF) {
+	checkKeys(t, collect(t, target, kv.All))
+	put(t, target, "b", "a", "c\xff", "c")
+	checkKeys(t, collect(t, target, kv.All), "a", "b", "c", "c\xff")
+}
+
+func testIteratePrefix(t *testing.T, target kv.IF) {
+	put(t, target, "ab", "a", "abc", "b", "aa\xff", "a\xff\xff", "\xff\xff")
+	checkKeys(t, collect(t, target, kv.Prefix([]byte("a"))), "a", "aa\xff", "ab", "abc", "a\xff\xff")
+	checkKeys(t, collect(t, target, kv.Prefix([]byte("ab"))), "ab", "abc")
+	checkKeys(t, collect(t, target, kv.Prefix([]byte("a\xff"))), "a\xff\xff")
+	checkKeys(t, collect(t, target, kv.Prefix([]byte("\xff"))), "\xff\xff")
+	checkKeys(t, collect(t, target, kv.Prefix([]byte("x"))))
+}
+
+func testIterateRange(t *testing.T, target kv.IF) {
+	put(t, target, "a", "b", "c", "d")
+	checkKeys(t, collect(t, target, kv.Range{Start: []byte("b"), End: []byte("d")}), "b", "c")
+	checkKeys(t, collect(t, target, kv.Range{Start: []byte("bb")}), "c", "d")
+	checkKeys(t, collect(t, target, kv.Range{End: []byte("b")}), "a")
+}
+
+func testIterateStop(t *testing.T, target kv.IF) {
+	put(t, target, "a", "b", "c")
+	stop := errors.New("stop")
+	var keys []string
+	err := target.Iterate(context.Background(), kv.All, func(key []byte) error {
+		keys = append(keys, string(key))
+		if len(keys) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("Iterate should return the callback error: %v", err)
+	}
+	checkKeys(t, keys, "a", "b")
+}
+
+func testIterateCancel(t *testing.T, target kv.IF) {
+	put(t, target, "a", "b", "c")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	err := target.Iterate(ctx, kv.All, func(key []byte) error {
+		n++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Iterate should return context.Canceled: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Iterate continued after cancel: %d keys", n)
+	}
+}
+
+func testBatch(t *testing.T, target kv.IF) {
+	ctx := context.Background()
+	put(t, target, "a", "c")
+	var b kv.Batch
+	b.Put([]byte("b"), []byte("value of b"))
+	b.Delete([]byte("c"))
+	b.Put([]byte("d"), []byte("value of d"))
+	if g, e := b.Len(), 3; g != e {
+		t.Errorf("Batch has wrong length: %d != %d", g, e)
+	}
+	if err := target.Write(ctx, &b); err != nil {
+		t.Fatalf("Write fail: %v", err)
+	}
+	checkKeys(t, collect(t, target, kv.All), "a", "b", "d")
+	v, err := target.Get(ctx, []byte("d"))
+	if err != nil {
+		t.Fatalf("Get fail: %v", err)
+	}
+	if g, e := string(v), "value of d"; g != e {
+		t.Errorf("Get gave wrong content: %q != %q", g, e)
+	}
+
+	b.Reset()
+	if g, e := b.Len(), 0; g != e {
+		t.Errorf("Reset left operations: %d != %d", g, e)
+	}
+}
+
+func testBatchOrder(t *testing.T, target kv.IF) {
+	ctx := context.Background()
+	var b kv.Batch
+	b.Put([]byte("gone"), []byte("value of gone"))
+	b.Delete([]byte("gone"))
+	b.Delete([]byte("back"))
+	b.Put([]byte("back"), []byte("value of back"))
+	if err := target.Write(ctx, &b); err != nil {
+		t.Fatalf("Write fail: %v", err)
+	}
+	checkKeys(t, collect(t, target, kv.All), "back")
+}
+
+func testBatchOverwrite(t *testing.T, target kv.IF) {
+	ctx := context.Background()
+	put(t, target, "a")
+	var b kv.Batch
+	b.Put([]byte("a"), []byte("first"))
+	b.Put([]byte("a"), []byte("last"))
+	b.Put([]byte("b"), []byte("first"))
+	b.Put([]byte("b"), []byte("last"))
+	if err := target.Write(ctx, &b); err != nil {
+		t.Fatalf("Write fail: %v", err)
+	}
+	checkValue(t, target, "a", "last")
+	checkValue(t, target, "b", "last")
+}
+
+func testBatchEmpty(t *testing.T, target kv.IF) {
+	if err := target.Write(context.Background(), &kv.Batch{}); err != nil {
+		t.Fatalf("Write of empty batch fail: %v", err)
+	}
+	checkKeys(t, collect(t, target, kv.All))
+}
+
+func testSnapshot(t *testing.T, target kv.IF) {
+	ctx := context.Background()
+	put(t, target, "a", "b")
+	snap, err := target.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot fail: %v", err)
+	}
+	defer snap.Release()
+
+	if err := target.Delete(ctx, []byte("a")); err != nil {
+		t.Fatalf("Delete fail: %v", err)
+	}
+	put(t, target, "c")
+	var b kv.Batch
+	b.Delete([]byte("b"))
+	b.Put([]byte("d"), []byte("value of d"))
+	if err := target.Write(ctx, &b); err != nil {
+		t.Fatalf("Write fail: %v", err)
+	}
+
+	checkKeys(t, collect(t, snap, kv.All), "a", "b")
+	checkKeys(t, collect(t, target, kv.All), "c", "d")
+	v, err := snap.Get(ctx, []byte("a"))
+	if err != nil {
+		t.Fatalf("Get from snapshot fail: %v", err)
+	}
+	if g, e := string(v), "value of a"; g != e {
+		t.Errorf("Get from snapshot gave wrong content: %q != %q", g, e)
+	}
+	ok, err := snap.Has(ctx, []byte("c"))
+	if err != nil {
+		t.Fatalf("Has fail: %v", err)
+	}
+	if ok {
+		t.Errorf("snapshot should not see later writes")
+	}
+	_, err = snap.Get(ctx, []byte("d"))
+	var nf kv.NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("Get of later key should be NotFoundError: %T: %v", err, err)
+	}
+}
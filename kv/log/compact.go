@@ -0,0 +1,130 @@
+package log
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+)
+
+func (l *Impl) compactLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.opts.CompactInterval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-l.stop
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		case <-l.kick:
+		}
+		// errors are retried on the next round; a failed compaction
+		// leaves both copies of a record, which replay handles
+		_ = l.Compact(ctx)
+	}
+}
+
+// Compact rewrites every sealed segment whose fraction of dead bytes
+// is at least Options.CompactRatio, then removes it.
+func (l *Impl) Compact(ctx context.Context) error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return ErrClosed
+	}
+	var victims []*segment
+	for _, s := range l.segments {
+		if s == l.active || s.size == 0 {
+			continue
+		}
+		if float64(s.size-s.live)/float64(s.size) >= l.opts.CompactRatio {
+			victims = append(victims, s)
+		}
+	}
+	l.mu.RUnlock()
+
+	// oldest first, so tombstones can be dropped as soon as nothing
+	// older remains
+	sort.Slice(victims, func(i, j int) bool { return victims[i].id < victims[j].id })
+	for _, s := range victims {
+		if err := l.compactSegment(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Impl) compactSegment(ctx context.Context, s *segment) error {
+	// s is sealed, so it can be read without holding the lock
+	err := s.scan(false, func(off int64, rec *record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.closed {
+			return ErrClosed
+		}
+
+		if rec.tombstone() {
+			if _, ok := l.index[string(rec.key)]; ok {
+				// superseded by a newer put
+				return nil
+			}
+			if !l.hasOlder(s.id) {
+				// nothing left for it to shadow
+				return nil
+			}
+			return l.write(rec)
+		}
+
+		loc, ok := l.index[string(rec.key)]
+		if !ok || loc.segment != s.id || loc.offset != off {
+			return nil
+		}
+		return l.write(rec)
+	})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	// the copies must be durable before the originals go away
+	if err := l.active.file.Sync(); err != nil {
+		return err
+	}
+	delete(l.segments, s.id)
+	if l.snapshots > 0 {
+		// the open file stays readable after the name is gone
+		l.retired = append(l.retired, s)
+	} else {
+		_ = s.file.Close()
+	}
+	return os.Remove(segmentName(l.dir, s.id))
+}
+
+// hasOlder reports whether any segment older than id remains. Caller
+// must hold l.mu.
+func (l *Impl) hasOlder(id uint64) bool {
+	for other := range l.segments {
+		if other < id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrClosed = errors.New("log is closed")
+
+	errBadChecksum = errors.New("record checksum mismatch")
+)
+
+// CorruptRecordError is returned when a record fails its checksum
+// anywhere but at the tail of the newest segment.
+type CorruptRecordError struct {
+	Segment uint64
+	Offset  int64
+}
+
+var _ error = CorruptRecordError{}
+
+func (c CorruptRecordError) Error() string {
+	return fmt.Sprintf("[ErrLog] corrupt record in segment %016x at %d", c.Segment, c.Offset)
+}
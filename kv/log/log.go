@@ -0,0 +1,362 @@
+package log
+
+import (
+	"context"
+	"io"
+	"lifs_go/kv"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options tunes a log store. Zero fields take their defaults.
+type Options struct {
+	// SegmentSize is the size after which the active segment is
+	// sealed and a new one started. Defaults to 64 MiB.
+	SegmentSize int64
+	// CompactRatio is the fraction of dead bytes above which a sealed
+	// segment is compacted. Defaults to 0.5.
+	CompactRatio float64
+	// CompactInterval is how often the background compactor looks for
+	// work. Negative disables background compaction. Defaults to one
+	// minute.
+	CompactInterval time.Duration
+	// Sync fsyncs the active segment after every write.
+	Sync bool
+}
+
+func (o *Options) withDefaults() Options {
+	opts := *o
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 64 << 20
+	}
+	if opts.CompactRatio <= 0 {
+		opts.CompactRatio = 0.5
+	}
+	if opts.CompactInterval == 0 {
+		opts.CompactInterval = time.Minute
+	}
+	return opts
+}
+
+// location is where the newest record of a key lives.
+type location struct {
+	segment uint64
+	offset  int64
+	size    int64
+}
+
+// Impl is an append-only log of records split in segment files, with
+// an in-memory index rebuilt on startup by scanning the segments.
+//
+// Values are never overwritten in place: Put and Delete append a
+// record, and compaction copies the live records of mostly-dead
+// sealed segments to the active one before removing them.
+type Impl struct {
+	dir  string
+	opts Options
+
+	mu       sync.RWMutex
+	index    map[string]location
+	segments map[uint64]*segment
+	active   *segment
+	closed   bool
+
+	// segments removed by compaction stay open while snapshots that
+	// may read them are alive
+	snapshots int
+	retired   []*segment
+
+	// compaction
+	compactMu sync.Mutex
+	kick      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Open opens or creates a log store in the directory dir.
+func Open(dir string, opts *Options) (*Impl, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &Impl{
+		dir:      dir,
+		opts:     opts.withDefaults(),
+		index:    make(map[string]location),
+		segments: make(map[uint64]*segment),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := l.load(); err != nil {
+		l.closeFiles()
+		return nil, err
+	}
+	if l.opts.CompactInterval > 0 {
+		go l.compactLoop()
+	} else {
+		close(l.done)
+	}
+	return l, nil
+}
+
+// load rebuilds the index by replaying all segments in order.
+func (l *Impl) load() error {
+	ids, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		ids = []uint64{1}
+	}
+	type entry struct {
+		rec *record
+		loc location
+	}
+	for i, id := range ids {
+		s, err := openSegment(l.dir, id)
+		if err != nil {
+			return err
+		}
+		l.segments[id] = s
+		last := i == len(ids)-1
+		var batch []entry
+		err = s.scan(last, func(off int64, rec *record) error {
+			batch = append(batch, entry{rec, location{segment: id, offset: off, size: rec.size()}})
+			if rec.flags&recordBatch != 0 {
+				return nil
+			}
+			for _, e := range batch {
+				l.apply(e.rec, e.loc)
+			}
+			batch = batch[:0]
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			// batches never span segments, so this is an interrupted
+			// write that must not be completed by later appends
+			if !last {
+				return CorruptRecordError{Segment: id, Offset: batch[0].loc.offset}
+			}
+			if err := s.file.Truncate(batch[0].loc.offset); err != nil {
+				return err
+			}
+			s.size = batch[0].loc.offset
+		}
+		if last {
+			l.active = s
+		}
+	}
+	return nil
+}
+
+// apply updates the index for a record that was just appended or
+// replayed.
+func (l *Impl) apply(rec *record, loc location) {
+	if old, ok := l.index[string(rec.key)]; ok {
+		l.segments[old.segment].live -= old.size
+	}
+	if rec.tombstone() {
+		delete(l.index, string(rec.key))
+		return
+	}
+	l.index[string(rec.key)] = loc
+	l.segments[loc.segment].live += loc.size
+}
+
+// write appends recs to the active segment in one go, flagging all
+// but the last as part of a batch. Caller must hold l.mu.
+func (l *Impl) write(recs ...*record) error {
+	if l.closed {
+		return ErrClosed
+	}
+	var buf []byte
+	for i, rec := range recs {
+		rec.flags &^= recordBatch
+		if i < len(recs)-1 {
+			rec.flags |= recordBatch
+		}
+		buf = append(buf, rec.encode()...)
+	}
+	off, err := l.active.append(buf)
+	if err != nil {
+		return err
+	}
+	if l.opts.Sync {
+		if err := l.active.file.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, rec := range recs {
+		l.apply(rec, location{segment: l.active.id, offset: off, size: rec.size()})
+		off += rec.size()
+	}
+	if l.active.size >= l.opts.SegmentSize {
+		return l.rotate()
+	}
+	return nil
+}
+
+// rotate seals the active segment and starts a new one.
+func (l *Impl) rotate() error {
+	if err := l.active.file.Sync(); err != nil {
+		return err
+	}
+	s, err := openSegment(l.dir, l.active.id+1)
+	if err != nil {
+		return err
+	}
+	l.segments[s.id] = s
+	l.active = s
+	select {
+	case l.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (l *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	loc, ok := l.index[string(key)]
+	if !ok {
+		return nil, kv.NotFoundError{Key: key}
+	}
+	return read(l.segments, loc)
+}
+
+// read fetches the value at loc from one of segments. Caller must
+// hold l.mu.
+func read(segments map[uint64]*segment, loc location) ([]byte, error) {
+	s := segments[loc.segment]
+	rec, err := readRecord(s.file, loc.offset, loc.offset+loc.size)
+	if err != nil {
+		return nil, CorruptRecordError{Segment: loc.segment, Offset: loc.offset}
+	}
+	return rec.value, nil
+}
+
+func (l *Impl) Put(ctx context.Context, key, value []byte) error {
+	rec := &record{flags: recordPut, key: key, value: value}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.write(rec)
+}
+
+func (l *Impl) Delete(ctx context.Context, key []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if _, ok := l.index[string(key)]; !ok {
+		return nil
+	}
+	return l.write(&record{flags: recordDelete, key: key})
+}
+
+func (l *Impl) Has(ctx context.Context, key []byte) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return false, ErrClosed
+	}
+	_, ok := l.index[string(key)]
+	return ok, nil
+}
+
+// Iterate sorts the matching keys of the index up front, so fn sees
+// the keys present when Iterate was called and may modify the store.
+func (l *Impl) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return ErrClosed
+	}
+	var keys []string
+	for k := range l.index {
+		if r.Contains([]byte(k)) {
+			keys = append(keys, k)
+		}
+	}
+	l.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write appends all records of the batch at once. On replay, a batch
+// whose last record is missing is dropped entirely.
+func (l *Impl) Write(ctx context.Context, b *kv.Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	recs := make([]*record, 0, b.Len())
+	for _, op := range b.Ops() {
+		rec := &record{flags: recordPut, key: op.Key, value: op.Value}
+		if op.Delete {
+			rec = &record{flags: recordDelete, key: op.Key}
+		}
+		recs = append(recs, rec)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.write(recs...)
+}
+
+// Close stops background compaction and closes all segments. The
+// Impl must not be used afterwards.
+func (l *Impl) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.active.file.Sync()
+	if cerr := l.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (l *Impl) closeFiles() error {
+	var err error
+	for _, s := range l.segments {
+		if cerr := s.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, s := range l.retired {
+		_ = s.file.Close()
+	}
+	l.retired = nil
+	return err
+}
+
+var _ io.Closer = (*Impl)(nil)
@@ -0,0 +1,63 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"lifs_go/kv/log"
+	"os/signal"
+	"syscall"
+	"testing"
+)
+
+func TestTornAppend(t *testing.T) {
+	dir := t.TempDir()
+	opts := &log.Options{SegmentSize: 1024}
+	l := open(t, dir, opts)
+	ctx := context.Background()
+	if err := l.Put(ctx, keyN(0), valueN(0, 0)); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+
+	// cap the file size, so a big record is only partly written
+	var old syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &old); err != nil {
+		t.Fatal(err)
+	}
+	signal.Ignore(syscall.SIGXFSZ)
+	defer signal.Reset(syscall.SIGXFSZ)
+	limit := old
+	limit.Cur = 4096
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Skipf("cannot limit the file size: %v", err)
+	}
+	err := l.Put(ctx, []byte("big"), bytes.Repeat([]byte("x"), 8192))
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &old); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatalf("Put past the file size limit did not fail")
+	}
+
+	// fill the segment until it is sealed; no torn bytes may be left
+	// after its records
+	const N = 20
+	for i := 1; i < N; i++ {
+		if err := l.Put(ctx, keyN(i), valueN(i, 0)); err != nil {
+			t.Fatalf("Put after failure fail: %v", err)
+		}
+	}
+	if g := len(segments(t, dir)); g < 2 {
+		t.Fatalf("segment was not sealed: %d segments", g)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close fail: %v", err)
+	}
+	l = open(t, dir, opts)
+	defer l.Close()
+	for i := 0; i < N; i++ {
+		check(t, l, i, valueN(i, 0))
+	}
+	if ok, err := l.Has(ctx, []byte("big")); err != nil || ok {
+		t.Errorf("failed Put left its key: %v, %v", ok, err)
+	}
+}
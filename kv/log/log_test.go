@@ -0,0 +1,348 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"lifs_go/kv"
+	"lifs_go/kv/kvtest"
+	"lifs_go/kv/log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func open(t *testing.T, dir string, opts *log.Options) *log.Impl {
+	t.Helper()
+	if opts == nil {
+		opts = &log.Options{}
+	}
+	if opts.CompactInterval == 0 {
+		opts.CompactInterval = -1
+	}
+	l, err := log.Open(dir, opts)
+	if err != nil {
+		t.Fatalf("log.Open fail: %v", err)
+	}
+	return l
+}
+
+func NewTestTarget(t *testing.T) kv.IF {
+	l := open(t, t.TempDir(), nil)
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, NewTestTarget)
+}
+
+func keyN(i int) []byte {
+	return []byte(fmt.Sprintf("key-%04d", i))
+}
+
+func valueN(i, round int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("%d/%d;", i, round)), 20)
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func check(t *testing.T, target kv.IF, i int, want []byte) {
+	t.Helper()
+	v, err := target.Get(context.Background(), keyN(i))
+	if want == nil {
+		var nf kv.NotFoundError
+		if !errors.As(err, &nf) {
+			t.Fatalf("key %d should be deleted: %v", i, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Get %d fail: %v", i, err)
+	}
+	if !bytes.Equal(v, want) {
+		t.Fatalf("Get %d gave wrong content: %q != %q", i, v, want)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, &log.Options{SegmentSize: 4096})
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		if err := l.Put(ctx, keyN(i), valueN(i, 0)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		if err := l.Delete(ctx, keyN(i)); err != nil {
+			t.Fatalf("Delete fail: %v", err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(segments(t, dir)) < 2 {
+		t.Fatalf("expected several segments: %v", segments(t, dir))
+	}
+
+	l = open(t, dir, nil)
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			check(t, l, i, nil)
+		} else {
+			check(t, l, i, valueN(i, 0))
+		}
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, nil)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := l.Put(ctx, keyN(i), valueN(i, 0)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the last record in half
+	segs := segments(t, dir)
+	last := segs[len(segs)-1]
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(last, info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir, nil)
+	defer l.Close()
+	check(t, l, 0, valueN(0, 0))
+	check(t, l, 1, valueN(1, 0))
+	check(t, l, 2, nil)
+
+	// appending after the cut must work and survive
+	if err := l.Put(ctx, keyN(2), valueN(2, 1)); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	check(t, l, 2, valueN(2, 1))
+}
+
+func TestCorruptSealedSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, &log.Options{SegmentSize: 1024})
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		if err := l.Put(ctx, keyN(i), valueN(i, 0)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := segments(t, dir)[0]
+	f, err := os.OpenFile(first, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("garbage"), 20); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	_, err = log.Open(dir, &log.Options{CompactInterval: -1})
+	var c log.CorruptRecordError
+	if !errors.As(err, &c) {
+		t.Fatalf("expected CorruptRecordError: %T: %v", err, err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	opts := &log.Options{SegmentSize: 4096}
+	l := open(t, dir, opts)
+	ctx := context.Background()
+
+	const N = 50
+	for round := 0; round < 10; round++ {
+		for i := 0; i < N; i++ {
+			if err := l.Put(ctx, keyN(i), valueN(i, round)); err != nil {
+				t.Fatalf("Put fail: %v", err)
+			}
+		}
+	}
+	for i := 0; i < N; i += 5 {
+		if err := l.Delete(ctx, keyN(i)); err != nil {
+			t.Fatalf("Delete fail: %v", err)
+		}
+	}
+	before := len(segments(t, dir))
+	if err := l.Compact(ctx); err != nil {
+		t.Fatalf("Compact fail: %v", err)
+	}
+	after := len(segments(t, dir))
+	if after >= before {
+		t.Errorf("Compact did not remove segments: %d >= %d", after, before)
+	}
+
+	want := func(i int) []byte {
+		if i%5 == 0 {
+			return nil
+		}
+		return valueN(i, 9)
+	}
+	for i := 0; i < N; i++ {
+		check(t, l, i, want(i))
+	}
+
+	// deleted keys must not come back from older records on replay
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l = open(t, dir, opts)
+	defer l.Close()
+	for i := 0; i < N; i++ {
+		check(t, l, i, want(i))
+	}
+}
+
+func TestBackgroundCompact(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, &log.Options{SegmentSize: 2048, CompactInterval: 1})
+	ctx := context.Background()
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			if err := l.Put(ctx, keyN(i), valueN(i, round)); err != nil {
+				t.Fatalf("Put fail: %v", err)
+			}
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close fail: %v", err)
+	}
+	l = open(t, dir, nil)
+	defer l.Close()
+	for i := 0; i < 10; i++ {
+		check(t, l, i, valueN(i, 19))
+	}
+}
+
+func TestTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, nil)
+	ctx := context.Background()
+	if err := l.Put(ctx, keyN(0), valueN(0, 0)); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	var b kv.Batch
+	for i := 1; i < 4; i++ {
+		b.Put(keyN(i), valueN(i, 0))
+	}
+	b.Delete(keyN(0))
+	if err := l.Write(ctx, &b); err != nil {
+		t.Fatalf("Write fail: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// cut into the last record, leaving the first ones of the batch
+	// intact
+	segs := segments(t, dir)
+	last := segs[len(segs)-1]
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(last, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir, nil)
+	defer l.Close()
+	check(t, l, 0, valueN(0, 0))
+	for i := 1; i < 4; i++ {
+		check(t, l, i, nil)
+	}
+
+	// the rest of the batch must not be completed by later records
+	if err := l.Put(ctx, keyN(4), valueN(4, 0)); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l = open(t, dir, nil)
+	defer l.Close()
+	check(t, l, 1, nil)
+	check(t, l, 4, valueN(4, 0))
+}
+
+func TestSnapshotCompact(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, &log.Options{SegmentSize: 1024})
+	defer l.Close()
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		if err := l.Put(ctx, keyN(i), valueN(i, 0)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	snap, err := l.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot fail: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := l.Put(ctx, keyN(i), valueN(i, 1)); err != nil {
+			t.Fatalf("Put fail: %v", err)
+		}
+	}
+	before := len(segments(t, dir))
+	if err := l.Compact(ctx); err != nil {
+		t.Fatalf("Compact fail: %v", err)
+	}
+	if after := len(segments(t, dir)); after >= before {
+		t.Errorf("Compact did not remove segments: %d >= %d", after, before)
+	}
+
+	// the snapshot still reads the records of the removed segments
+	for i := 0; i < 20; i++ {
+		v, err := snap.Get(ctx, keyN(i))
+		if err != nil {
+			t.Fatalf("Get from snapshot fail: %v", err)
+		}
+		if !bytes.Equal(v, valueN(i, 0)) {
+			t.Fatalf("Get %d from snapshot gave wrong content: %q", i, v)
+		}
+	}
+	snap.Release()
+	for i := 0; i < 20; i++ {
+		check(t, l, i, valueN(i, 1))
+	}
+
+	// a released snapshot fails every read
+	if _, err := snap.Get(ctx, keyN(0)); err != log.ErrClosed {
+		t.Errorf("Get from released snapshot should fail: %v", err)
+	}
+	if _, err := snap.Has(ctx, keyN(0)); err != log.ErrClosed {
+		t.Errorf("Has from released snapshot should fail: %v", err)
+	}
+	if err := snap.Iterate(ctx, kv.All, func(key []byte) error { return nil }); err != log.ErrClosed {
+		t.Errorf("Iterate of released snapshot should fail: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package log
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// A record on disk is
+//
+//	crc32 | flags | keyLen | valueLen | key | value
+//
+// where the crc32 (Castagnoli) covers everything after itself.
+const recordHeaderSize = 4 + 1 + 4 + 4
+
+const (
+	recordPut    byte = 0x00
+	recordDelete byte = 0x01
+	// recordBatch marks all but the last record of a batch. Replay
+	// only applies a batch once its last record has been read.
+	recordBatch byte = 0x02
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type record struct {
+	flags byte
+	key   []byte
+	value []byte
+}
+
+func (r *record) size() int64 {
+	return recordHeaderSize + int64(len(r.key)) + int64(len(r.value))
+}
+
+func (r *record) tombstone() bool {
+	return r.flags&recordDelete != 0
+}
+
+func (r *record) encode() []byte {
+	buf := make([]byte, r.size())
+	buf[4] = r.flags
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(r.key)))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(r.value)))
+	n := copy(buf[recordHeaderSize:], r.key)
+	copy(buf[recordHeaderSize+n:], r.value)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// readRecord reads the record at off from a segment of size end. A
+// short read is reported as io.ErrUnexpectedEOF, a bad checksum as
+// errBadChecksum; both mean a torn write when they happen at the tail
+// of the active segment.
+func readRecord(r io.ReaderAt, off int64, end int64) (*record, error) {
+	var hdr [recordHeaderSize]byte
+	if _, err := r.ReadAt(hdr[:], off); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	klen := binary.BigEndian.Uint32(hdr[5:9])
+	vlen := binary.BigEndian.Uint32(hdr[9:13])
+	if off+recordHeaderSize+int64(klen)+int64(vlen) > end {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, recordHeaderSize+int64(klen)+int64(vlen))
+	copy(buf, hdr[:])
+	if _, err := r.ReadAt(buf[recordHeaderSize:], off+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(buf[0:4]) != crc32.Checksum(buf[4:], crcTable) {
+		return nil, errBadChecksum
+	}
+	rec := &record{
+		flags: buf[4],
+		key:   buf[recordHeaderSize : recordHeaderSize+klen : recordHeaderSize+klen],
+		value: buf[recordHeaderSize+klen:],
+	}
+	return rec, nil
+}
@@ -0,0 +1,108 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const segmentSuffix = ".seg"
+
+// segment is one append-only file of records. Only the newest
+// segment is written to; older ones are sealed and only ever read or
+// removed by compaction.
+type segment struct {
+	id   uint64
+	file *os.File
+	size int64
+	// bytes of records that the index still points to
+	live int64
+}
+
+func segmentName(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016x%s", id, segmentSuffix))
+}
+
+// listSegments returns the ids of the segment files in dir, oldest
+// first.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func openSegment(dir string, id uint64) (*segment, error) {
+	f, err := os.OpenFile(segmentName(dir, id), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &segment{id: id, file: f, size: info.Size()}, nil
+}
+
+// scan calls fn for every record in the segment, in order. If tail is
+// true, a torn record at the end is cut off instead of being reported
+// as corruption.
+func (s *segment) scan(tail bool, fn func(off int64, rec *record) error) error {
+	var off int64
+	for off < s.size {
+		rec, err := readRecord(s.file, off, s.size)
+		if err != nil {
+			if tail && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errBadChecksum)) {
+				if err := s.file.Truncate(off); err != nil {
+					return err
+				}
+				s.size = off
+				return nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errBadChecksum) {
+				return CorruptRecordError{Segment: s.id, Offset: off}
+			}
+			return err
+		}
+		if err := fn(off, rec); err != nil {
+			return err
+		}
+		off += rec.size()
+	}
+	return nil
+}
+
+// append writes buf at the end of the segment and returns its offset.
+// A failed write may still have stored part of buf, so it is cut off
+// again; otherwise the torn bytes would follow the next records and
+// corrupt the segment once it is sealed.
+func (s *segment) append(buf []byte) (int64, error) {
+	off := s.size
+	if _, err := s.file.WriteAt(buf, off); err != nil {
+		// should this fail too, the next append overwrites the rest
+		_ = s.file.Truncate(off)
+		return 0, err
+	}
+	s.size += int64(len(buf))
+	return off, nil
+}
@@ -0,0 +1,115 @@
+package log
+
+import (
+	"context"
+	"lifs_go/kv"
+	"sort"
+)
+
+// snapshot is a copy of the index taken under the lock. Records are
+// never modified once written, so the copy stays valid as long as the
+// segments it points to are kept open.
+type snapshot struct {
+	l        *Impl
+	index    map[string]location
+	segments map[uint64]*segment
+	released bool
+}
+
+// Snapshot copies the index. Compaction keeps the segments it removes
+// open until every snapshot is released.
+func (l *Impl) Snapshot(ctx context.Context) (kv.Snapshot, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	s := &snapshot{
+		l:        l,
+		index:    make(map[string]location, len(l.index)),
+		segments: make(map[uint64]*segment, len(l.segments)),
+	}
+	for k, loc := range l.index {
+		s.index[k] = loc
+	}
+	for id, seg := range l.segments {
+		s.segments[id] = seg
+	}
+	l.snapshots++
+	return s, nil
+}
+
+// check fails once the snapshot or its store is gone. Must hold
+// l.mu.
+func (s *snapshot) check() error {
+	if s.l.closed || s.released {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (s *snapshot) Get(ctx context.Context, key []byte) ([]byte, error) {
+	s.l.mu.RLock()
+	defer s.l.mu.RUnlock()
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	loc, ok := s.index[string(key)]
+	if !ok {
+		return nil, kv.NotFoundError{Key: key}
+	}
+	return read(s.segments, loc)
+}
+
+func (s *snapshot) Has(ctx context.Context, key []byte) (bool, error) {
+	s.l.mu.RLock()
+	defer s.l.mu.RUnlock()
+	if err := s.check(); err != nil {
+		return false, err
+	}
+	_, ok := s.index[string(key)]
+	return ok, nil
+}
+
+func (s *snapshot) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	s.l.mu.RLock()
+	err := s.check()
+	var keys []string
+	if err == nil {
+		for k := range s.index {
+			if r.Contains([]byte(k)) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	s.l.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *snapshot) Release() {
+	s.l.mu.Lock()
+	defer s.l.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.l.snapshots--
+	if s.l.snapshots == 0 {
+		for _, seg := range s.l.retired {
+			_ = seg.file.Close()
+		}
+		s.l.retired = nil
+	}
+}
@@ -3,28 +3,151 @@ package mem
 import (
 	"context"
 	"lifs_go/kv"
+	"sort"
+	"sync"
 )
 
-type Impl struct {
+// shardCount spreads keys over independently locked maps, so parallel
+// readers and writers of different keys rarely contend.
+const shardCount = 32
+
+type shard struct {
+	mu   sync.RWMutex
 	data map[string][]byte
 }
 
+// Impl is safe for concurrent use. The zero value is an empty store.
+type Impl struct {
+	shards [shardCount]shard
+}
+
+// shard picks the shard of key by its FNV-1a hash, which needs no
+// seed, so the zero value works.
+func (m *Impl) shard(key []byte) *shard {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return &m.shards[h%shardCount]
+}
+
 func (m *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
-	v, found := m.data[string(key)]
+	s := m.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, found := s.data[string(key)]
 	if !found {
-		return nil, kv.NotFoundError{key}
+		return nil, kv.NotFoundError{Key: key}
 	}
 	return v, nil
 }
 
 func (m *Impl) Put(ctx context.Context, key, value []byte) error {
-	if m.data == nil {
-		m.data = make(map[string][]byte)
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		s.data = make(map[string][]byte)
+	}
+	s.data[string(key)] = value
+	return nil
+}
+
+func (m *Impl) Delete(ctx context.Context, key []byte) error {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, string(key))
+	return nil
+}
+
+func (m *Impl) Has(ctx context.Context, key []byte) (bool, error) {
+	s := m.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, found := s.data[string(key)]
+	return found, nil
+}
+
+// Iterate collects the matching keys up front, so fn may modify the
+// store.
+func (m *Impl) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	var keys []string
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		for k := range s.data {
+			if r.Contains([]byte(k)) {
+				keys = append(keys, k)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write holds every shard lock while applying b, so no reader sees
+// part of it.
+func (m *Impl) Write(ctx context.Context, b *kv.Batch) error {
+	for i := range m.shards {
+		m.shards[i].mu.Lock()
+	}
+	defer func() {
+		for i := range m.shards {
+			m.shards[i].mu.Unlock()
+		}
+	}()
+	for _, op := range b.Ops() {
+		s := m.shard(op.Key)
+		if op.Delete {
+			delete(s.data, string(op.Key))
+			continue
+		}
+		if s.data == nil {
+			s.data = make(map[string][]byte)
+		}
+		s.data[string(op.Key)] = op.Value
 	}
-	m.data[string(key)] = value
 	return nil
 }
 
+type snapshot struct {
+	*Impl
+}
+
+func (s snapshot) Release() {}
+
+// Snapshot copies the maps; values are shared, as they are never
+// modified in place.
+func (m *Impl) Snapshot(ctx context.Context) (kv.Snapshot, error) {
+	for i := range m.shards {
+		m.shards[i].mu.RLock()
+	}
+	defer func() {
+		for i := range m.shards {
+			m.shards[i].mu.RUnlock()
+		}
+	}()
+	c := &Impl{}
+	for i := range m.shards {
+		c.shards[i].data = make(map[string][]byte, len(m.shards[i].data))
+		for k, v := range m.shards[i].data {
+			c.shards[i].data[k] = v
+		}
+	}
+	return snapshot{c}, nil
+}
+
 func New() kv.IF {
 	return &Impl{}
 }
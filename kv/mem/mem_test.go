@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"lifs_go/kv"
+	"lifs_go/kv/kvtest"
 	"lifs_go/kv/mem"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -96,3 +98,82 @@ func TestGetNotFoundError(t *testing.T) {
 		t.Errorf("NotFoundError not contain Key: (%x) [NotFoundError is: %s]", KEY, nf.Error())
 	}
 }
+
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		return NewTestTarget()
+	})
+}
+
+func TestZeroValue(t *testing.T) {
+	var target mem.Impl
+	ctx := context.Background()
+	if err := target.Put(ctx, []byte("key"), []byte("value")); err != nil {
+		t.Fatalf("kvmem.Put fail %v\n", err)
+	}
+	v, err := target.Get(ctx, []byte("key"))
+	if err != nil {
+		t.Fatalf("kvmem.Get fail %v\n", err)
+	}
+	if g, e := string(v), "value"; g != e {
+		t.Errorf("bad Get: %q != %q", g, e)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	target := NewTestTarget()
+	ctx := context.Background()
+	const workers = 8
+	const N = 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < N; i++ {
+				// every worker shares half of its keys with the others
+				key := []byte(fmt.Sprintf("key-%d", i))
+				if i%2 == 0 {
+					key = []byte(fmt.Sprintf("key-%d-%d", w, i))
+				}
+				if err := target.Put(ctx, key, key); err != nil {
+					t.Errorf("kvmem.Put fail %v\n", err)
+					return
+				}
+				v, err := target.Get(ctx, key)
+				if err != nil {
+					t.Errorf("kvmem.Get fail %v\n", err)
+					return
+				}
+				if string(v) != string(key) {
+					t.Errorf("kvmem.Get gave wrong content: %q != %q", v, key)
+					return
+				}
+				if _, err := target.Has(ctx, key); err != nil {
+					t.Errorf("kvmem.Has fail %v\n", err)
+					return
+				}
+				if i%50 == 0 {
+					err := target.Iterate(ctx, kv.All, func(key []byte) error { return nil })
+					if err != nil {
+						t.Errorf("kvmem.Iterate fail %v\n", err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	n := 0
+	err := target.Iterate(ctx, kv.All, func(key []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("kvmem.Iterate fail %v\n", err)
+	}
+	if g, e := n, workers*N/2+N/2; g != e {
+		t.Errorf("wrong number of keys: %d != %d", g, e)
+	}
+}
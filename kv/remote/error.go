@@ -0,0 +1,36 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrClosed is returned by a client after Close, or once its
+	// connection is lost.
+	ErrClosed = errors.New("[ErrRemote] connection closed")
+)
+
+// ProtocolError is returned when a peer sends a malformed frame. The
+// connection is closed afterwards.
+type ProtocolError struct {
+	Reason string
+}
+
+var _ error = ProtocolError{}
+
+func (p ProtocolError) Error() string {
+	return fmt.Sprintf("[ErrRemote] protocol error: %s", p.Reason)
+}
+
+// RemoteError is an error returned by the kv.IF of the server, other
+// than kv.NotFoundError which is passed through as is.
+type RemoteError struct {
+	Message string
+}
+
+var _ error = RemoteError{}
+
+func (r RemoteError) Error() string {
+	return fmt.Sprintf("[ErrRemote] %s", r.Message)
+}
@@ -0,0 +1,207 @@
+package remote
+
+import (
+	"encoding/binary"
+	"io"
+	"lifs_go/kv"
+)
+
+// Every message is a frame
+//
+//	size | id | op | payload
+//
+// where size is the number of bytes after itself. Requests carry a
+// client chosen id that the response repeats, so a connection can
+// have many requests in flight and answers may come in any order. A
+// cancel frame reuses the id of the request to abort and has no
+// response.
+const (
+	frameHeaderSize = 4 + 4 + 1
+	maxFrameSize    = 256 << 20
+)
+
+// request ops
+const (
+	opGet byte = iota + 1
+	opHas
+	opPut
+	opDelete
+	opIterate
+	opWrite
+	opSnapshot
+	opRelease
+	opCancel
+	opIterateNext
+	opIterateClose
+)
+
+// response ops
+const (
+	respOK byte = iota + 0x80
+	respNotFound
+	respError
+)
+
+// iteratePageSize is the number of keys the server returns per Iterate
+// round trip. The server runs one Iterate of its store per request,
+// and keeps it open as a cursor between the pages.
+const iteratePageSize = 1000
+
+type frame struct {
+	id      uint32
+	op      byte
+	payload []byte
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+len(f.payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(4+1+len(f.payload)))
+	binary.BigEndian.PutUint32(buf[4:8], f.id)
+	buf[8] = f.op
+	_, err := w.Write(append(buf, f.payload...))
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	size := binary.BigEndian.Uint32(hdr[0:4])
+	if size < 5 || size > maxFrameSize {
+		return frame{}, ProtocolError{Reason: "bad frame size"}
+	}
+	f := frame{
+		id:      binary.BigEndian.Uint32(hdr[4:8]),
+		op:      hdr[8],
+		payload: make([]byte, size-5),
+	}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return frame{}, err
+	}
+	return f, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	return binary.AppendUvarint(buf, v)
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// decoder reads the fields of a payload. The first error sticks, so
+// callers only need to check err once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ProtocolError{Reason: "bad varint"}
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) flag() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.err = ProtocolError{Reason: "short payload"}
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = ProtocolError{Reason: "short payload"}
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// bounds of a Range are optional, a flag byte says which are present
+const (
+	rangeStart byte = 1 << iota
+	rangeEnd
+)
+
+func appendRange(buf []byte, r kv.Range) []byte {
+	var flags byte
+	if r.Start != nil {
+		flags |= rangeStart
+	}
+	if r.End != nil {
+		flags |= rangeEnd
+	}
+	buf = append(buf, flags)
+	if r.Start != nil {
+		buf = appendBytes(buf, r.Start)
+	}
+	if r.End != nil {
+		buf = appendBytes(buf, r.End)
+	}
+	return buf
+}
+
+func (d *decoder) kvRange() kv.Range {
+	var r kv.Range
+	flags := d.flag()
+	if flags&rangeStart != 0 {
+		r.Start = append([]byte{}, d.bytes()...)
+	}
+	if flags&rangeEnd != 0 {
+		r.End = append([]byte{}, d.bytes()...)
+	}
+	return r
+}
+
+func appendBatch(buf []byte, b *kv.Batch) []byte {
+	buf = appendUvarint(buf, uint64(b.Len()))
+	for _, op := range b.Ops() {
+		if op.Delete {
+			buf = append(buf, 1)
+			buf = appendBytes(buf, op.Key)
+			continue
+		}
+		buf = append(buf, 0)
+		buf = appendBytes(buf, op.Key)
+		buf = appendBytes(buf, op.Value)
+	}
+	return buf
+}
+
+func (d *decoder) batch() *kv.Batch {
+	var b kv.Batch
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		if d.flag() == 1 {
+			b.Delete(d.bytes())
+			continue
+		}
+		key := d.bytes()
+		b.Put(key, d.bytes())
+	}
+	return &b
+}
@@ -0,0 +1,334 @@
+// Package remote is a kv.IF served over the network by a Server that
+// exposes another kv.IF.
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"lifs_go/kv"
+	"net"
+	"sync"
+	"time"
+)
+
+// Options tunes a client connection. Zero fields take their defaults.
+type Options struct {
+	// TLS, if set, makes the client connect with TLS. An empty
+	// ServerName is taken from the address.
+	TLS *tls.Config
+	// DialTimeout bounds connection setup. Defaults to 10 seconds.
+	DialTimeout time.Duration
+}
+
+// Impl is a client of a Server. It is safe for concurrent use: calls
+// from several goroutines are pipelined over the one connection.
+//
+// When a context is done before its response arrives, the call
+// returns at once and the server is told to abort the request.
+type Impl struct {
+	conn net.Conn
+	wmu  sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint32]chan frame
+	nextID  uint32
+	closed  bool
+	done    chan struct{}
+}
+
+// Dial connects to a Server listening on the network ("tcp" or
+// "unix") address addr.
+func Dial(network, addr string, opts *Options) (*Impl, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	timeout := opts.DialTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLS != nil {
+		cfg := opts.TLS
+		if cfg.ServerName == "" && network == "tcp" {
+			cfg = cfg.Clone()
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(conn, cfg)
+		_ = tc.SetDeadline(time.Now().Add(timeout))
+		if err := tc.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		_ = tc.SetDeadline(time.Time{})
+		conn = tc
+	}
+	c := &Impl{
+		conn:    conn,
+		pending: make(map[uint32]chan frame),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *Impl) readLoop() {
+	defer close(c.done)
+	for {
+		f, err := readFrame(c.conn)
+		if err != nil {
+			break
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[f.id]
+		delete(c.pending, f.id)
+		c.mu.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+	// fail every call still waiting
+	c.mu.Lock()
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	_ = c.conn.Close()
+}
+
+func (c *Impl) send(f frame) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return writeFrame(c.conn, f)
+}
+
+// call sends a request and waits for its response.
+func (c *Impl) call(ctx context.Context, op byte, payload []byte) (frame, error) {
+	if err := ctx.Err(); err != nil {
+		return frame{}, err
+	}
+	ch := make(chan frame, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return frame{}, ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.send(frame{id: id, op: op, payload: payload}); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		_ = c.conn.Close()
+		return frame{}, ErrClosed
+	}
+
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			return frame{}, ErrClosed
+		}
+		return f, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		_, waiting := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if waiting {
+			_ = c.send(frame{id: id, op: opCancel})
+		}
+		return frame{}, ctx.Err()
+	}
+}
+
+// result maps the status of a response to an error.
+func result(f frame, key []byte) error {
+	switch f.op {
+	case respOK:
+		return nil
+	case respNotFound:
+		return kv.NotFoundError{Key: key}
+	case respError:
+		return RemoteError{Message: string(f.payload)}
+	}
+	return ProtocolError{Reason: "unknown response"}
+}
+
+func (c *Impl) get(ctx context.Context, snap uint64, key []byte) ([]byte, error) {
+	f, err := c.call(ctx, opGet, appendBytes(appendUvarint(nil, snap), key))
+	if err != nil {
+		return nil, err
+	}
+	if err := result(f, key); err != nil {
+		return nil, err
+	}
+	return f.payload, nil
+}
+
+func (c *Impl) has(ctx context.Context, snap uint64, key []byte) (bool, error) {
+	f, err := c.call(ctx, opHas, appendBytes(appendUvarint(nil, snap), key))
+	if err != nil {
+		return false, err
+	}
+	if err := result(f, key); err != nil {
+		return false, err
+	}
+	return len(f.payload) == 1 && f.payload[0] == 1, nil
+}
+
+// iterate fetches the keys of r one page at a time and calls fn
+// between round trips, so fn may use the client. The pages after the
+// first come from the cursor the server keeps open for the request,
+// which is closed if fn stops early.
+func (c *Impl) iterate(ctx context.Context, snap uint64, r kv.Range, fn func(key []byte) error) error {
+	op := byte(opIterate)
+	payload := appendUvarint(nil, snap)
+	payload = appendRange(payload, r)
+	payload = appendUvarint(payload, iteratePageSize)
+	for {
+		f, err := c.call(ctx, op, payload)
+		if err != nil {
+			return err
+		}
+		if err := result(f, nil); err != nil {
+			return err
+		}
+		d := &decoder{buf: f.payload}
+		more := d.flag() == 1
+		n := d.uvarint()
+		keys := make([][]byte, 0, min(n, iteratePageSize))
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			keys = append(keys, d.bytes())
+		}
+		var cursor uint64
+		if more {
+			cursor = d.uvarint()
+		}
+		if d.err != nil {
+			return d.err
+		}
+		for _, key := range keys {
+			err := ctx.Err()
+			if err == nil {
+				err = fn(key)
+			}
+			if err != nil {
+				if more {
+					c.closeCursor(cursor)
+				}
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+		op = opIterateNext
+		payload = appendUvarint(appendUvarint(nil, cursor), iteratePageSize)
+	}
+}
+
+// closeCursor tells the server to stop an iteration early. It does
+// not wait for the response.
+func (c *Impl) closeCursor(cursor uint64) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.nextID++
+	id := c.nextID
+	c.mu.Unlock()
+	_ = c.send(frame{id: id, op: opIterateClose, payload: appendUvarint(nil, cursor)})
+}
+
+func (c *Impl) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return c.get(ctx, 0, key)
+}
+
+func (c *Impl) Has(ctx context.Context, key []byte) (bool, error) {
+	return c.has(ctx, 0, key)
+}
+
+func (c *Impl) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return c.iterate(ctx, 0, r, fn)
+}
+
+func (c *Impl) Put(ctx context.Context, key, value []byte) error {
+	f, err := c.call(ctx, opPut, appendBytes(appendBytes(nil, key), value))
+	if err != nil {
+		return err
+	}
+	return result(f, key)
+}
+
+func (c *Impl) Delete(ctx context.Context, key []byte) error {
+	f, err := c.call(ctx, opDelete, appendBytes(nil, key))
+	if err != nil {
+		return err
+	}
+	return result(f, key)
+}
+
+func (c *Impl) Write(ctx context.Context, b *kv.Batch) error {
+	f, err := c.call(ctx, opWrite, appendBatch(nil, b))
+	if err != nil {
+		return err
+	}
+	return result(f, nil)
+}
+
+// snapshot lives on the server and is released there when the
+// connection closes, if not before.
+type snapshot struct {
+	c  *Impl
+	id uint64
+}
+
+func (s snapshot) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return s.c.get(ctx, s.id, key)
+}
+
+func (s snapshot) Has(ctx context.Context, key []byte) (bool, error) {
+	return s.c.has(ctx, s.id, key)
+}
+
+func (s snapshot) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	return s.c.iterate(ctx, s.id, r, fn)
+}
+
+func (s snapshot) Release() {
+	_, _ = s.c.call(context.Background(), opRelease, appendUvarint(nil, s.id))
+}
+
+func (c *Impl) Snapshot(ctx context.Context) (kv.Snapshot, error) {
+	f, err := c.call(ctx, opSnapshot, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := result(f, nil); err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: f.payload}
+	id := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if id == 0 {
+		return nil, ProtocolError{Reason: "bad snapshot id"}
+	}
+	return snapshot{c: c, id: id}, nil
+}
+
+// Close closes the connection; calls in flight fail with ErrClosed.
+func (c *Impl) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
@@ -0,0 +1,266 @@
+package remote_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"lifs_go/kv"
+	"lifs_go/kv/kvtest"
+	kvmem "lifs_go/kv/mem"
+	"lifs_go/kv/remote"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve starts a server for target on l and returns a client of it.
+func serve(t *testing.T, target kv.IF, l net.Listener, sopts *remote.ServerOptions, copts *remote.Options) *remote.Impl {
+	t.Helper()
+	s := remote.NewServer(target, sopts)
+	go func() { _ = s.Serve(l) }()
+	c, err := remote.Dial(l.Addr().Network(), l.Addr().String(), copts)
+	if err != nil {
+		t.Fatalf("Dial fail: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = s.Close()
+	})
+	return c
+}
+
+func listen(t *testing.T, network string) net.Listener {
+	t.Helper()
+	addr := "127.0.0.1:0"
+	if network == "unix" {
+		addr = filepath.Join(t.TempDir(), "kv.sock")
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatalf("Listen fail: %v", err)
+	}
+	return l
+}
+
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		return serve(t, kvmem.New(), listen(t, "tcp"), nil, nil)
+	})
+}
+
+func TestConformanceUnix(t *testing.T) {
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		return serve(t, kvmem.New(), listen(t, "unix"), nil, nil)
+	})
+}
+
+func selfSigned(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "lifs test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}}}
+	client := &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+func TestTLS(t *testing.T) {
+	server, client := selfSigned(t)
+	kvtest.Run(t, func(t *testing.T) kv.IF {
+		return serve(t, kvmem.New(), listen(t, "tcp"), &remote.ServerOptions{TLS: server}, &remote.Options{TLS: client})
+	})
+
+	// a client without the certificate must not connect
+	l := listen(t, "tcp")
+	s := remote.NewServer(kvmem.New(), &remote.ServerOptions{TLS: server})
+	go func() { _ = s.Serve(l) }()
+	defer s.Close()
+	if _, err := remote.Dial("tcp", l.Addr().String(), &remote.Options{TLS: &tls.Config{}}); err == nil {
+		t.Errorf("Dial with untrusted certificate should fail")
+	}
+}
+
+// counting is a kv.IF that counts the Iterates of its store.
+type counting struct {
+	kv.IF
+	iterates atomic.Int32
+}
+
+func (c *counting) Iterate(ctx context.Context, r kv.Range, fn func(key []byte) error) error {
+	c.iterates.Add(1)
+	return c.IF.Iterate(ctx, r, fn)
+}
+
+func TestIterateManyPages(t *testing.T) {
+	inner := &counting{IF: kvmem.New()}
+	target := serve(t, inner, listen(t, "tcp"), nil, nil)
+	ctx := context.Background()
+	const N = 2500
+	var b kv.Batch
+	for i := 0; i < N; i++ {
+		b.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value"))
+	}
+	if err := target.Write(ctx, &b); err != nil {
+		t.Fatalf("Write fail: %v", err)
+	}
+	n := 0
+	err := target.Iterate(ctx, kv.All, func(key []byte) error {
+		if g, e := string(key), fmt.Sprintf("key-%05d", n); g != e {
+			t.Fatalf("Iterate gave wrong key: %q != %q", g, e)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate fail: %v", err)
+	}
+	if g, e := n, N; g != e {
+		t.Errorf("Iterate gave wrong number of keys: %d != %d", g, e)
+	}
+	// the pages come from one Iterate of the store
+	if g, e := inner.iterates.Load(), int32(1); g != e {
+		t.Errorf("wrong number of store Iterates: %d != %d", g, e)
+	}
+
+	// stopping early, in a later page
+	stop := errors.New("stop")
+	n = 0
+	err = target.Iterate(ctx, kv.All, func(key []byte) error {
+		if n++; n == 1500 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("Iterate did not stop: %v", err)
+	}
+	if err := target.Put(ctx, []byte("after"), []byte("value")); err != nil {
+		t.Fatalf("Put after early stop fail: %v", err)
+	}
+}
+
+// blocking is a kv.IF whose Get of "slow" waits for its context.
+type blocking struct {
+	kv.IF
+	aborted chan struct{}
+}
+
+func (b *blocking) Get(ctx context.Context, key []byte) ([]byte, error) {
+	if string(key) == "slow" {
+		<-ctx.Done()
+		close(b.aborted)
+		return nil, ctx.Err()
+	}
+	return b.IF.Get(ctx, key)
+}
+
+func TestCancel(t *testing.T) {
+	inner := &blocking{IF: kvmem.New(), aborted: make(chan struct{})}
+	target := serve(t, inner, listen(t, "tcp"), nil, nil)
+	ctx := context.Background()
+	if err := target.Put(ctx, []byte("fast"), []byte("value")); err != nil {
+		t.Fatalf("Put fail: %v", err)
+	}
+
+	slow, cancel := context.WithCancel(ctx)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := target.Get(slow, []byte("slow"))
+		errs <- err
+	}()
+
+	// the blocked request must not hold up the others
+	for i := 0; i < 10; i++ {
+		if _, err := target.Get(ctx, []byte("fast")); err != nil {
+			t.Fatalf("Get fail: %v", err)
+		}
+	}
+
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled Get should return context.Canceled: %v", err)
+	}
+	select {
+	case <-inner.aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not abort the canceled request")
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	target := serve(t, kvmem.New(), listen(t, "tcp"), nil, nil)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := []byte(fmt.Sprintf("%d/%d", g, i))
+				if err := target.Put(ctx, key, key); err != nil {
+					t.Errorf("Put fail: %v", err)
+					return
+				}
+				v, err := target.Get(ctx, key)
+				if err != nil {
+					t.Errorf("Get fail: %v", err)
+					return
+				}
+				if string(v) != string(key) {
+					t.Errorf("Get gave wrong content: %q != %q", v, key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestServerClose(t *testing.T) {
+	l := listen(t, "tcp")
+	s := remote.NewServer(kvmem.New(), nil)
+	go func() { _ = s.Serve(l) }()
+	c, err := remote.Dial("tcp", l.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Dial fail: %v", err)
+	}
+	defer c.Close()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close fail: %v", err)
+	}
+	if _, err := c.Get(context.Background(), []byte("key")); !errors.Is(err, remote.ErrClosed) {
+		t.Errorf("Get after server Close should be ErrClosed: %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"lifs_go/kv"
+	"net"
+	"sync"
+)
+
+// ServerOptions tunes a Server. Zero fields take their defaults.
+type ServerOptions struct {
+	// TLS, if set, makes the server accept TLS connections only.
+	TLS *tls.Config
+	// MaxInflight is the number of requests of one connection served
+	// at the same time; further requests wait to be read. Defaults to
+	// 64.
+	MaxInflight int
+}
+
+// Server exposes a kv.IF to remote clients.
+type Server struct {
+	kv   kv.IF
+	opts ServerOptions
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+func NewServer(target kv.IF, opts *ServerOptions) *Server {
+	if opts == nil {
+		opts = &ServerOptions{}
+	}
+	s := &Server{
+		kv:        target,
+		opts:      *opts,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+	}
+	if s.opts.MaxInflight <= 0 {
+		s.opts.MaxInflight = 64
+	}
+	return s
+}
+
+// ListenAndServe listens on the network ("tcp" or "unix") address
+// addr and serves it until Close.
+func (s *Server) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called, and then
+// returns nil.
+func (s *Server) Serve(l net.Listener) error {
+	if s.opts.TLS != nil {
+		l = tls.NewListener(l, s.opts.TLS)
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return nil
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		c := &serverConn{
+			s:         s,
+			conn:      conn,
+			sem:       make(chan struct{}, s.opts.MaxInflight),
+			inflight:  make(map[uint32]context.CancelFunc),
+			snapshots: make(map[uint64]kv.Snapshot),
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Close stops all listeners, aborts the requests in flight and waits
+// for the connections to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for c := range s.conns {
+		_ = c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+type serverConn struct {
+	s    *Server
+	conn net.Conn
+	wmu  sync.Mutex
+	sem  chan struct{}
+
+	// ctx ends with the connection
+	ctx context.Context
+
+	mu         sync.Mutex
+	inflight   map[uint32]context.CancelFunc
+	snapshots  map[uint64]kv.Snapshot
+	nextSnap   uint64
+	cursors    map[uint64]*cursor
+	nextCursor uint64
+	// iterating counts the cursors whose Iterate still runs
+	iterating sync.WaitGroup
+}
+
+func (c *serverConn) serve() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.ctx = ctx
+	var handlers sync.WaitGroup
+	defer func() {
+		cancel()
+		_ = c.conn.Close()
+		handlers.Wait()
+		c.iterating.Wait()
+		for _, snap := range c.snapshots {
+			snap.Release()
+		}
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+		c.s.wg.Done()
+	}()
+
+	for {
+		f, err := readFrame(c.conn)
+		if err != nil {
+			return
+		}
+		if f.op == opCancel {
+			c.mu.Lock()
+			if abort, ok := c.inflight[f.id]; ok {
+				abort()
+			}
+			c.mu.Unlock()
+			continue
+		}
+
+		select {
+		case c.sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		rctx, rcancel := context.WithCancel(ctx)
+		c.mu.Lock()
+		c.inflight[f.id] = rcancel
+		c.mu.Unlock()
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			resp := c.handle(rctx, f)
+			c.mu.Lock()
+			delete(c.inflight, f.id)
+			c.mu.Unlock()
+			rcancel()
+			<-c.sem
+
+			c.wmu.Lock()
+			err := writeFrame(c.conn, resp)
+			c.wmu.Unlock()
+			if err != nil {
+				_ = c.conn.Close()
+			}
+		}()
+	}
+}
+
+// reader returns the live store for id 0, else the snapshot id.
+func (c *serverConn) reader(id uint64) (kv.Reader, error) {
+	if id == 0 {
+		return c.s.kv, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap, ok := c.snapshots[id]
+	if !ok {
+		return nil, errors.New("unknown snapshot")
+	}
+	return snap, nil
+}
+
+func (c *serverConn) handle(ctx context.Context, f frame) frame {
+	d := &decoder{buf: f.payload}
+	var payload []byte
+	var err error
+	switch f.op {
+	case opGet, opHas:
+		snap, key := d.uvarint(), d.bytes()
+		if d.err != nil {
+			break
+		}
+		var r kv.Reader
+		if r, err = c.reader(snap); err != nil {
+			break
+		}
+		if f.op == opGet {
+			var value []byte
+			if value, err = r.Get(ctx, key); err == nil {
+				payload = value
+			}
+			break
+		}
+		var ok bool
+		if ok, err = r.Has(ctx, key); err == nil && ok {
+			payload = []byte{1}
+		}
+	case opPut:
+		key, value := d.bytes(), d.bytes()
+		if d.err == nil {
+			err = c.s.kv.Put(ctx, key, value)
+		}
+	case opDelete:
+		key := d.bytes()
+		if d.err == nil {
+			err = c.s.kv.Delete(ctx, key)
+		}
+	case opWrite:
+		b := d.batch()
+		if d.err == nil {
+			err = c.s.kv.Write(ctx, b)
+		}
+	case opIterate:
+		snap, rng, limit := d.uvarint(), d.kvRange(), d.uvarint()
+		if d.err != nil {
+			break
+		}
+		var r kv.Reader
+		if r, err = c.reader(snap); err != nil {
+			break
+		}
+		cur := c.newCursor(r, rng)
+		payload, err = c.page(ctx, cur, limit)
+	case opIterateNext:
+		id, limit := d.uvarint(), d.uvarint()
+		if d.err != nil {
+			break
+		}
+		c.mu.Lock()
+		cur, ok := c.cursors[id]
+		c.mu.Unlock()
+		if !ok {
+			err = errors.New("unknown cursor")
+			break
+		}
+		payload, err = c.page(ctx, cur, limit)
+	case opIterateClose:
+		c.closeCursor(d.uvarint())
+	case opSnapshot:
+		var snap kv.Snapshot
+		if snap, err = c.s.kv.Snapshot(ctx); err != nil {
+			break
+		}
+		c.mu.Lock()
+		c.nextSnap++
+		id := c.nextSnap
+		c.snapshots[id] = snap
+		c.mu.Unlock()
+		payload = appendUvarint(nil, id)
+	case opRelease:
+		id := d.uvarint()
+		c.mu.Lock()
+		snap, ok := c.snapshots[id]
+		delete(c.snapshots, id)
+		c.mu.Unlock()
+		if ok {
+			snap.Release()
+		}
+	default:
+		d.err = ProtocolError{Reason: "unknown op"}
+	}
+	if d.err != nil {
+		err = d.err
+	}
+
+	var nf kv.NotFoundError
+	switch {
+	case err == nil:
+		return frame{id: f.id, op: respOK, payload: payload}
+	case errors.As(err, &nf):
+		return frame{id: f.id, op: respNotFound}
+	default:
+		return frame{id: f.id, op: respError, payload: []byte(err.Error())}
+	}
+}
+
+// cursor is an Iterate of the store run for one request, whose keys
+// are sent a page at a time. Iterating once costs the store far less
+// than starting over after every page, which the file and mem stores
+// could only do by listing all keys again.
+type cursor struct {
+	id     uint64
+	keys   chan []byte
+	cancel context.CancelFunc
+	// err is the result of the Iterate, set before keys is closed
+	err error
+	// mu serializes the pages, next is a key read ahead to tell
+	// whether there are more
+	mu   sync.Mutex
+	next []byte
+}
+
+func (c *serverConn) newCursor(r kv.Reader, rng kv.Range) *cursor {
+	ctx, cancel := context.WithCancel(c.ctx)
+	cur := &cursor{keys: make(chan []byte, iteratePageSize), cancel: cancel}
+	c.mu.Lock()
+	if c.cursors == nil {
+		c.cursors = make(map[uint64]*cursor)
+	}
+	c.nextCursor++
+	cur.id = c.nextCursor
+	c.cursors[cur.id] = cur
+	c.mu.Unlock()
+
+	c.iterating.Add(1)
+	go func() {
+		defer c.iterating.Done()
+		defer close(cur.keys)
+		cur.err = r.Iterate(ctx, rng, func(key []byte) error {
+			select {
+			case cur.keys <- append([]byte(nil), key...):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return cur
+}
+
+// page encodes up to limit keys of cur as
+//
+//	more | count | keys [| cursor]
+//
+// where the cursor id follows when there are more. A cursor that is
+// done or failed is dropped.
+func (c *serverConn) page(ctx context.Context, cur *cursor, limit uint64) ([]byte, error) {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	limit = max(1, min(limit, iteratePageSize))
+	var keys [][]byte
+	if cur.next != nil {
+		keys = append(keys, cur.next)
+		cur.next = nil
+	}
+	more := false
+	for {
+		var key []byte
+		var ok bool
+		select {
+		case key, ok = <-cur.keys:
+		case <-ctx.Done():
+			c.closeCursor(cur.id)
+			return nil, ctx.Err()
+		}
+		if !ok {
+			break
+		}
+		if uint64(len(keys)) == limit {
+			cur.next, more = key, true
+			break
+		}
+		keys = append(keys, key)
+	}
+	if !more {
+		c.closeCursor(cur.id)
+		if cur.err != nil {
+			return nil, cur.err
+		}
+	}
+	payload := []byte{0}
+	if more {
+		payload[0] = 1
+	}
+	payload = appendUvarint(payload, uint64(len(keys)))
+	for _, key := range keys {
+		payload = appendBytes(payload, key)
+	}
+	if more {
+		payload = appendUvarint(payload, cur.id)
+	}
+	return payload, nil
+}
+
+// closeCursor stops the Iterate of cursor id, if still open.
+func (c *serverConn) closeCursor(id uint64) {
+	c.mu.Lock()
+	cur, ok := c.cursors[id]
+	delete(c.cursors, id)
+	c.mu.Unlock()
+	if ok {
+		cur.cancel()
+	}
+}